@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/amartani/oci-extract/internal/extractor"
+	"github.com/spf13/cobra"
+)
+
+// compareCmd represents the compare command
+var compareCmd = &cobra.Command{
+	Use:   "compare <image> <file-path>",
+	Short: "Time every extraction method applicable to a file",
+	Long: `Extract a file once with each method that applies to the layer it is in
+(eStargz, SOCI, zstd:chunked, and the full zstd or gzip download), print how
+long each took, and check that they all produced identical bytes.
+
+The format detected for the layer and the method extract would pick are
+shown above the table. Methods that don't apply to the layer show as failed.
+Nothing is written to the current directory.
+
+Examples:
+  # See how much an eStargz image saves over a full layer download
+  oci-extract compare ghcr.io/myorg/myimage:estargz /usr/bin/app`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeImagePath,
+	RunE:              runCompare,
+}
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+}
+
+func runCompare(cmd *cobra.Command, args []string) error {
+	imageRef, err := imageReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	filePath := args[1]
+	ctx := cmd.Context()
+
+	orch, err := newOrchestrator(cmd)
+	if err != nil {
+		return err
+	}
+
+	report, err := orch.Compare(ctx, extractor.CompareOptions{
+		ImageRef: imageRef,
+		FilePath: filePath,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeCompareReport(os.Stdout, report); err != nil {
+		return err
+	}
+
+	if !report.Identical() {
+		return errors.New("extraction methods did not produce identical bytes")
+	}
+	return nil
+}
+
+// writeCompareReport prints the detected format and a row per method
+func writeCompareReport(out io.Writer, report *extractor.CompareReport) error {
+	fmt.Fprintf(out, "Layer:           %s\n", report.Layer)
+	fmt.Fprintf(out, "Detected format: %s\n", report.Detected)
+	if selected, ok := report.Selected(); ok {
+		fmt.Fprintf(out, "Extract uses:    %s\n", selected)
+	}
+	fmt.Fprintln(out)
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tTIME\tSIZE\tSHA256")
+	for _, result := range report.Results {
+		if result.Err != nil {
+			fmt.Fprintf(tw, "%s\t%s\t-\tfailed: %v\n", result.Format, result.Duration.Round(time.Millisecond), result.Err)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", result.Format, result.Duration.Round(time.Millisecond), result.Size, result.Digest[:12])
+	}
+	return tw.Flush()
+}
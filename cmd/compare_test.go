@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amartani/oci-extract/internal/detector"
+	"github.com/amartani/oci-extract/internal/extractor"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestWriteCompareReport(t *testing.T) {
+	report := &extractor.CompareReport{
+		Layer:    v1.Hash{Algorithm: "sha256", Hex: "abc123"},
+		Detected: detector.FormatEStargz,
+		Results: []extractor.CompareResult{
+			{Format: detector.FormatEStargz, Duration: 1500 * time.Millisecond, Size: 5, Digest: "2cf24dba5fb0a30e26e83b2ac5b9e29e"},
+			{Format: detector.FormatSOCI, Duration: 10 * time.Millisecond, Err: errors.New("no zTOC")},
+			{Format: detector.FormatStandard, Duration: 12 * time.Second, Size: 5, Digest: "2cf24dba5fb0a30e26e83b2ac5b9e29e"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeCompareReport(&buf, report); err != nil {
+		t.Fatalf("writeCompareReport() error = %v", err)
+	}
+
+	want := "Layer:           sha256:abc123\n" +
+		"Detected format: estargz\n" +
+		"Extract uses:    estargz\n\n" +
+		"METHOD    TIME  SIZE  SHA256\n" +
+		"estargz   1.5s  5     2cf24dba5fb0\n" +
+		"soci      10ms  -     failed: no zTOC\n" +
+		"standard  12s   5     2cf24dba5fb0\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
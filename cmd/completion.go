@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/amartani/oci-extract/internal/extractor"
+	"github.com/amartani/oci-extract/internal/registry"
+	"github.com/spf13/cobra"
+)
+
+// Shell completion scripts come from cobra's default completion command:
+// oci-extract completion bash|zsh|fish|powershell. This file adds the
+// completions those scripts ask for.
+
+// formatValues are the values --format accepts
+var formatValues = []string{"auto", "estargz", "soci", "standard"}
+
+const (
+	// completionListTimeout bounds listing an image to complete a path, so a
+	// slow registry doesn't hang the shell
+	completionListTimeout = 20 * time.Second
+
+	// completionCacheTTL is how long an image's listing is reused for
+	// completion. Tags can move, so it's kept short.
+	completionCacheTTL = 10 * time.Minute
+)
+
+// registerFormatCompletion completes the values of cmd's --format flag
+func registerFormatCompletion(cmd *cobra.Command) {
+	_ = cmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(formatValues, cobra.ShellCompDirectiveNoFileComp))
+}
+
+// completeImagePath completes the file path argument following an image by
+// listing the image, best-effort: failures complete nothing. Listings are
+// cached, as listing a layer without a TOC downloads it in full.
+func completeImagePath(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 1 || (toComplete != "" && !strings.HasPrefix(toComplete, "/")) {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	paths, err := imagePaths(cmd, args)
+	if err != nil {
+		cobra.CompDebugln(err.Error(), true)
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	candidates := completePaths(paths, toComplete)
+	directive := cobra.ShellCompDirectiveNoFileComp
+	if slices.ContainsFunc(candidates, func(c string) bool { return strings.HasSuffix(c, "/") }) {
+		// Let directories be completed further
+		directive |= cobra.ShellCompDirectiveNoSpace
+	}
+	return candidates, directive
+}
+
+// completePaths returns the completions of toComplete among paths, one path
+// component at a time: deeper paths complete to their directory, with a
+// trailing slash, so large images don't flood the shell
+func completePaths(paths []string, toComplete string) []string {
+	if toComplete == "" {
+		toComplete = "/"
+	}
+	dir := toComplete[:strings.LastIndex(toComplete, "/")+1]
+
+	var candidates []string
+	for _, p := range paths {
+		if !strings.HasPrefix(p, toComplete) {
+			continue
+		}
+		rest := p[len(dir):]
+		if i := strings.Index(rest, "/"); i >= 0 {
+			p = dir + rest[:i+1]
+		}
+		candidates = append(candidates, p)
+	}
+
+	slices.Sort(candidates)
+	return slices.Compact(candidates)
+}
+
+// imagePaths returns the regular files in the image named by args[0], from
+// the completion cache when it was listed recently
+func imagePaths(cmd *cobra.Command, args []string) ([]string, error) {
+	// An archive would be unpacked again on every completion, and stdin is
+	// the shell's
+	if registry.IsArchiveReference(args[0]) {
+		return nil, fmt.Errorf("not completing paths in image archive %s", args[0])
+	}
+
+	// Completion skips the pre-run hooks, so apply config defaults here
+	if err := loadConfigDefaults(cmd, args); err != nil {
+		return nil, err
+	}
+	imageRef, err := imageReference(cmd, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	platform, _ := cmd.Flags().GetString("platform")
+	cachePath := completionCachePath(imageRef, platform)
+	if cachePath != "" {
+		if info, err := os.Stat(cachePath); err == nil && time.Since(info.ModTime()) < completionCacheTTL {
+			if data, err := os.ReadFile(cachePath); err == nil {
+				return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+			}
+		}
+	}
+
+	// Verbose output would end up among the completions
+	if err := cmd.Flags().Set("verbose", "false"); err != nil {
+		return nil, err
+	}
+	orch, err := newOrchestrator(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), completionListTimeout)
+	defer cancel()
+	result, err := orch.List(ctx, extractor.ListOptions{ImageRef: imageRef})
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		paths = append(paths, entry.Path)
+	}
+
+	if cachePath != "" {
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err == nil {
+			_ = os.WriteFile(cachePath, []byte(strings.Join(paths, "\n")+"\n"), 0644)
+		}
+	}
+	return paths, nil
+}
+
+// completionCachePath returns where the listing of imageRef for platform is
+// cached, or "" without a user cache directory
+func completionCachePath(imageRef, platform string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(imageRef + "\x00" + platform))
+	return filepath.Join(dir, "oci-extract", "completion", hex.EncodeToString(sum[:]))
+}
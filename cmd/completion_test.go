@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCompletePaths(t *testing.T) {
+	paths := []string{"/bin/sh", "/etc/hosts", "/etc/nginx/nginx.conf", "/etc/nginx/mime.types", "/etc/network/interfaces"}
+
+	tests := []struct {
+		toComplete string
+		want       []string
+	}{
+		{toComplete: "", want: []string{"/bin/", "/etc/"}},
+		{toComplete: "/e", want: []string{"/etc/"}},
+		{toComplete: "/etc/", want: []string{"/etc/hosts", "/etc/network/", "/etc/nginx/"}},
+		{toComplete: "/etc/ng", want: []string{"/etc/nginx/"}},
+		{toComplete: "/etc/nginx/n", want: []string{"/etc/nginx/nginx.conf"}},
+		{toComplete: "/usr", want: nil},
+	}
+
+	for _, tt := range tests {
+		if got := completePaths(paths, tt.toComplete); !slices.Equal(got, tt.want) {
+			t.Errorf("completePaths(%q) = %v, want %v", tt.toComplete, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is the path given via --config
+var configFile string
+
+// defaultConfigPath returns the per-user config file location:
+// $XDG_CONFIG_HOME/oci-extract/config.yaml, or ~/.config/oci-extract/config.yaml
+// when it's unset, on macOS too rather than under ~/Library. Windows keeps
+// its own location, %AppData%.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if !filepath.IsAbs(dir) {
+		var err error
+		if runtime.GOOS == "windows" {
+			dir, err = os.UserConfigDir()
+		} else {
+			dir, err = os.UserHomeDir()
+			dir = filepath.Join(dir, ".config")
+		}
+		if err != nil {
+			return ""
+		}
+	}
+	return filepath.Join(dir, "oci-extract", "config.yaml")
+}
+
+// loadConfig reads a YAML file mapping flag names to default values.
+// A missing file is only an error when it was requested explicitly.
+func loadConfig(path string, explicit bool) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	values := make(map[string]any)
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+// applyConfig uses config values as defaults for flags that were not set on
+// the command line. Keys that don't match a flag of the running command are
+// ignored, so one file can hold options for every subcommand.
+func applyConfig(cmd *cobra.Command, values map[string]any) error {
+	for key, value := range values {
+		flag := cmd.Flags().Lookup(key)
+		if flag == nil || flag.Changed {
+			continue
+		}
+
+		var str string
+		switch v := value.(type) {
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			str = strings.Join(items, ",")
+		default:
+			str = fmt.Sprint(v)
+		}
+
+		if err := flag.Value.Set(str); err != nil {
+			return fmt.Errorf("invalid value for %s in config file: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// loadConfigDefaults is the root PersistentPreRunE hook that applies the
+// config file before any subcommand runs
+func loadConfigDefaults(cmd *cobra.Command, args []string) error {
+	path := configFile
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+		if path == "" {
+			return nil
+		}
+	}
+
+	values, err := loadConfig(path, explicit)
+	if err != nil {
+		return err
+	}
+
+	return applyConfig(cmd, values)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestApplyConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "format: estargz\nverbose: true\noutput: ./from-config\nunknown-flag: ignored\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	var formatValue, outputValue string
+	var verboseValue bool
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().StringVar(&formatValue, "format", "auto", "")
+	cmd.Flags().StringVar(&outputValue, "output", "", "")
+	cmd.Flags().BoolVar(&verboseValue, "verbose", false, "")
+
+	// Flags given on the command line take precedence over the config file
+	if err := cmd.Flags().Parse([]string{"--output", "./from-cli"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+
+	values, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if err := applyConfig(cmd, values); err != nil {
+		t.Fatalf("applyConfig() error = %v", err)
+	}
+
+	if formatValue != "estargz" {
+		t.Errorf("format = %q, want %q", formatValue, "estargz")
+	}
+	if !verboseValue {
+		t.Error("verbose = false, want true")
+	}
+	if outputValue != "./from-cli" {
+		t.Errorf("output = %q, want %q", outputValue, "./from-cli")
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.yaml")
+
+	if _, err := loadConfig(path, false); err != nil {
+		t.Errorf("loadConfig() with missing default file error = %v, want nil", err)
+	}
+	if _, err := loadConfig(path, true); err == nil {
+		t.Error("loadConfig() with missing explicit file expected error, got nil")
+	}
+}
+
+func TestDefaultConfigPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows keeps its config under %AppData%")
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	if got, want := defaultConfigPath(), filepath.Join(home, ".config", "oci-extract", "config.yaml"); got != want {
+		t.Errorf("defaultConfigPath() = %q, want %q", got, want)
+	}
+
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	if got, want := defaultConfigPath(), filepath.Join(xdg, "oci-extract", "config.yaml"); got != want {
+		t.Errorf("defaultConfigPath() with XDG_CONFIG_HOME = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/amartani/oci-extract/internal/extractor"
+	"github.com/spf13/cobra"
+)
+
+// detectCmd represents the detect command
+var detectCmd = &cobra.Command{
+	Use:   "detect <image>",
+	Short: "Print the format of each layer of an image",
+	Long: `Print the digest and detected format of each layer of an image, bottom
+to top, one per line, followed by the format shared by all layers ("mixed" if
+they differ).
+
+Formats are standard, estargz, soci, zstd, zstd:chunked, or unknown. Seekable
+formats are only reported once the layer's TOC or zTOC has been read. Layers
+holding no files, such as the OCI empty descriptor, are reported as empty and
+don't count towards the overall format.
+
+Examples:
+  # Check that an image was built as eStargz
+  oci-extract detect ghcr.io/myorg/myimage:estargz
+
+  # Fail a CI job unless every layer is zstd:chunked
+  oci-extract detect myimage:latest | tail -n1 | grep -qx 'overall: zstd:chunked'`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDetect,
+}
+
+func init() {
+	rootCmd.AddCommand(detectCmd)
+}
+
+func runDetect(cmd *cobra.Command, args []string) error {
+	imageRef, err := imageReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+
+	orch, err := newOrchestrator(cmd)
+	if err != nil {
+		return err
+	}
+
+	report, err := orch.Detect(ctx, imageRef)
+	if err != nil {
+		return err
+	}
+
+	return writeDetectReport(os.Stdout, report)
+}
+
+// writeDetectReport prints a line per layer and the overall format
+func writeDetectReport(out io.Writer, report *extractor.DetectReport) error {
+	for _, layer := range report.Layers {
+		format := layer.Format.String()
+		if layer.Empty {
+			format = "empty"
+		}
+		if _, err := fmt.Fprintf(out, "%s %s\n", layer.Digest, format); err != nil {
+			return err
+		}
+	}
+
+	overall := "mixed"
+	if format, ok := report.Uniform(); ok {
+		overall = format.String()
+	}
+	_, err := fmt.Fprintf(out, "overall: %s\n", overall)
+	return err
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/amartani/oci-extract/internal/detector"
+	"github.com/amartani/oci-extract/internal/extractor"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestWriteDetectReport(t *testing.T) {
+	base := extractor.LayerFormat{Digest: v1.Hash{Algorithm: "sha256", Hex: "abc123"}, Format: detector.FormatStandard}
+	top := extractor.LayerFormat{Digest: v1.Hash{Algorithm: "sha256", Hex: "def456"}, Format: detector.FormatEStargz}
+	empty := extractor.LayerFormat{Digest: v1.Hash{Algorithm: "sha256", Hex: "44136f"}, Empty: true}
+
+	tests := []struct {
+		name   string
+		layers []extractor.LayerFormat
+		want   string
+	}{
+		{
+			name:   "uniform",
+			layers: []extractor.LayerFormat{top, top},
+			want:   "sha256:def456 estargz\nsha256:def456 estargz\noverall: estargz\n",
+		},
+		{
+			name:   "mixed",
+			layers: []extractor.LayerFormat{base, top},
+			want:   "sha256:abc123 standard\nsha256:def456 estargz\noverall: mixed\n",
+		},
+		{
+			name:   "empty layer",
+			layers: []extractor.LayerFormat{top, empty},
+			want:   "sha256:def456 estargz\nsha256:44136f empty\noverall: estargz\n",
+		},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeDetectReport(&buf, &extractor.DetectReport{Layers: tt.layers}); err != nil {
+			t.Fatalf("%s: writeDetectReport() error = %v", tt.name, err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: output = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
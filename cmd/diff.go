@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/amartani/oci-extract/internal/extractor"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffLayer string
+	diffSince string
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <image>",
+	Short: "List the files a layer changed in an OCI image",
+	Long: `List the files a layer added, modified, or deleted relative to the layers
+below it, without downloading the rest of the image.
+
+Use --layer to see what a single layer contributed, or --since to see the
+combined changes of a layer and every layer above it.
+
+Each line is prefixed with A (added), M (modified), or D (deleted by a whiteout).
+
+Examples:
+  # Show what one layer changed
+  oci-extract diff myimage:latest --layer sha256:4f4fb700ef54...
+
+  # Show everything changed from a layer up to the top of the image
+  oci-extract diff myimage:latest --since sha256:4f4fb700ef54...`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDiff,
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVar(&diffLayer, "layer", "", "Digest of the layer to compare with the layers below it")
+	diffCmd.Flags().StringVar(&diffSince, "since", "", "Digest of the first layer to compare; layers above it are included")
+	diffCmd.Flags().StringVar(&format, "format", "auto", "Force format: auto, estargz, soci, standard")
+	registerFormatCompletion(diffCmd)
+	diffCmd.MarkFlagsMutuallyExclusive("layer", "since")
+	diffCmd.MarkFlagsOneRequired("layer", "since")
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	imageRef, err := imageReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	if verbose {
+		fmt.Fprintf(logOutput, "Comparing layers in %s\n", imageRef)
+	}
+
+	formatHint, err := parseFormat(format)
+	if err != nil {
+		return err
+	}
+
+	opts := extractor.DiffOptions{
+		ImageRef:    imageRef,
+		Layer:       diffLayer,
+		ForceFormat: formatHint,
+	}
+	if diffSince != "" {
+		opts.Layer = diffSince
+		opts.Since = true
+	}
+
+	orch, err := newOrchestrator(cmd)
+	if err != nil {
+		return err
+	}
+
+	changes, err := orch.Diff(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		fmt.Printf("%s %s\n", change.Kind, change.Path)
+	}
+
+	return nil
+}
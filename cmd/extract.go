@@ -2,28 +2,80 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/amartani/oci-extract/internal/detector"
 	"github.com/amartani/oci-extract/internal/extractor"
+	"github.com/amartani/oci-extract/internal/metrics"
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/amartani/oci-extract/internal/pathutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/spf13/cobra"
 )
 
 var (
-	outputPath string
-	format     string
+	outputPath      string
+	format          string
+	xattrs          bool
+	preserveOwner   bool
+	includes        []string
+	excludes        []string
+	withMetadata    bool
+	allLayers       bool
+	preflight       bool
+	skipUnsafePaths bool
+	manifestOut     string
+	resume          bool
+	copyBuffer      int
+	rangeOffset     int64
+	rangeLength     int64
+	byName          bool
+	byDigest        bool
+	hashFiles       bool
+	preserveMode    bool
+	fileMode        string
+	parallelFiles   int
+	summary         bool
+	outputTmpl      string
+	unpack          bool
+	grepPattern     string
+	pathsFrom       string
+	strictPaths     bool
+	allPlatforms    bool
 )
 
 // extractCmd represents the extract command
 var extractCmd = &cobra.Command{
-	Use:   "extract <image> <file-path>",
+	Use:   "extract <image> <file-path>...",
 	Short: "Extract a file from an OCI image",
 	Long: `Extract a specific file or directory from an OCI image without mounting it.
 
 The command automatically detects the image format (standard, eStargz, or SOCI)
 and uses the most efficient method to extract the requested file.
 
+Paths are matched leniently: etc/hostname and ./etc/hostname both mean
+/etc/hostname. --strict-paths rejects anything but the absolute, clean form,
+for scripts that would rather fail than guess.
+
+A path ending with "/" extracts that directory: every layer is replayed from
+bottom to top into the output directory, applying whiteouts, so the result
+matches the directory as seen in a running container.
+
+Several file paths extract each of them into the --output directory, at
+their path in the image. A file that fails doesn't stop the others; the
+command fails at the end if any did. --paths-from reads more paths from a
+file, one per line, each optionally followed by a tab and the path to write
+it to; blank lines and lines starting with # are skipped.
+
 Examples:
   # Extract a binary from an image
   oci-extract extract alpine:latest /bin/sh -o ./sh
@@ -31,63 +83,664 @@ Examples:
   # Extract a config file
   oci-extract extract nginx:latest /etc/nginx/nginx.conf -o ./nginx.conf
 
+  # Extract a whole directory (note the trailing slash)
+  oci-extract extract nginx:latest /etc/nginx/ -o ./nginx-conf
+
+  # Extract a directory, skipping some of its contents
+  oci-extract extract node:latest /usr/local/lib/ --exclude 'node_modules/**/test' -o ./lib
+
+  # Extract a binary wherever it is in the image
+  oci-extract extract alpine:latest sh --by-name -o ./sh
+
+  # Extract a file by the sha256 of its content, wherever it moved to
+  oci-extract extract myimage:latest sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae --by-digest -o ./data
+
+  # Extract a script and make it executable by its owner only
+  oci-extract extract myimage:latest /app/run.sh --file-mode 0700 -o ./run.sh
+
+  # Extract several files, four at a time, into ./out
+  oci-extract extract myimage:latest /etc/passwd /etc/group /etc/hosts -o ./out --parallel-files 4
+
+  # Extract the files listed in list.txt, in addition to /etc/hosts
+  oci-extract extract myimage:latest /etc/hosts --paths-from list.txt -o ./out
+
+  # Extract a binary for every platform: ./out/linux-amd64/busybox, ...
+  oci-extract extract busybox:latest /bin/busybox --all-platforms -o ./out
+
+  # Extract every layer's version of a file
+  oci-extract extract myimage:latest /etc/passwd --all-layers -o ./passwd
+
+  # Name each layer's version by layer position instead: ./passwd/<n>/passwd
+  oci-extract extract myimage:latest /etc/passwd --all-layers -o ./passwd --output-template '{{.Layer}}/{{.Base}}'
+
+  # Record what a directory extraction wrote, for auditing
+  oci-extract extract node:latest /usr/local/lib/ -o ./lib --manifest-out ./lib.json
+
+  # Pick an interrupted directory extraction up where it stopped
+  oci-extract extract node:latest /usr/local/lib/ -o ./lib --manifest-out ./lib.json --resume
+
+  # Extract a release tarball and unpack it into ./app
+  oci-extract extract myimage:latest /opt/app.tar.gz --unpack -o ./app
+
+  # Sample the first 4 KiB of a large log file
+  oci-extract extract myimage:latest /var/log/app.log --length 4096 -o ./app.log.head
+
+  # See which format and layer a file came from, and what it cost
+  oci-extract extract myimage:latest /app/data --summary
+
+  # Fail, without writing anything, unless the image is alpine-based
+  oci-extract extract myimage:latest /etc/os-release --grep 'ID=alpine'
+
+  # Fail fast if the file isn't in the image's TOCs
+  oci-extract extract myimage:latest /app/data --preflight
+
   # Force using a specific format
-  oci-extract extract myimage:latest /app/data --format estargz -o ./data`,
-	Args: cobra.ExactArgs(2),
-	RunE: runExtract,
+  oci-extract extract myimage:latest /app/data --format estargz -o ./data
+
+  # Extract from a local OCI layout (skopeo's oci: transport)
+  oci-extract extract oci:./alpine-layout:latest /etc/os-release`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		// The paths may all come from the list
+		if pathsFrom != "" {
+			return cobra.MinimumNArgs(1)(cmd, args)
+		}
+		return cobra.MinimumNArgs(2)(cmd, args)
+	},
+	ValidArgsFunction: completeImagePath,
+	RunE:              runExtract,
 }
 
 func init() {
 	rootCmd.AddCommand(extractCmd)
 
 	extractCmd.Flags().StringVarP(&outputPath, "output", "o", "", "Output path (default: current directory + filename)")
+	extractCmd.Flags().StringVar(&outputTmpl, "output-template", "", "Name extracted files below --output with a Go template over {{.Path}}, {{.Dir}}, {{.Base}}, {{.Layer}} (layer position, 0 at the bottom) and {{.Digest}} (layer digest)")
 	extractCmd.Flags().StringVar(&format, "format", "auto", "Force format: auto, estargz, soci, standard")
+	registerFormatCompletion(extractCmd)
+	extractCmd.Flags().BoolVar(&xattrs, "xattrs", false, "Apply extended attributes recorded in the layer (best-effort)")
+	extractCmd.Flags().StringArrayVar(&includes, "include", nil, "Only extract directory entries matching this glob (repeatable)")
+	extractCmd.Flags().StringArrayVar(&excludes, "exclude", nil, "Skip directory entries matching this glob; wins over --include (repeatable)")
+	extractCmd.Flags().BoolVar(&skipUnsafePaths, "skip-unsafe-paths", false, "Skip directory entries that would be written outside the output directory instead of failing")
+	extractCmd.Flags().BoolVar(&preserveOwner, "preserve-owner", false, "Apply uid/gid recorded in the layer (best-effort, usually requires root)")
+	extractCmd.Flags().BoolVar(&preserveMode, "preserve-permissions", false, "Apply the permission bits recorded in the layer instead of the umask default")
+	extractCmd.Flags().StringVar(&fileMode, "file-mode", "", "Give every extracted file these octal permission bits, e.g. 0644")
+	extractCmd.Flags().BoolVar(&allLayers, "all-layers", false, "Extract every layer's version of the file to <output>.<layer index>.<digest>")
+	extractCmd.Flags().BoolVar(&allPlatforms, "all-platforms", false, "Extract the file from every platform's image of a multi-platform index, to <output>/<os>-<arch>/")
+	extractCmd.Flags().BoolVar(&byName, "by-name", false, "Treat the path as a file name and extract the file with that name from the topmost layer holding one")
+	extractCmd.Flags().BoolVar(&byDigest, "by-digest", false, "Treat the path as a content digest (sha256:...) and extract the file with that content, found through eStargz TOCs")
+	extractCmd.Flags().BoolVar(&hashFiles, "hash-files", false, "With --by-digest, also search layers without a TOC by downloading them and hashing every file (slow)")
+	extractCmd.MarkFlagsMutuallyExclusive("by-name", "by-digest")
+	extractCmd.Flags().BoolVar(&preflight, "preflight", false, "Check the layers' TOCs and zTOCs for the file before downloading any layer in full")
+	extractCmd.Flags().BoolVar(&withMetadata, "with-metadata", false, "Also write the file's source metadata to <output>.json")
+	extractCmd.Flags().Int64Var(&rangeOffset, "offset", 0, "Only extract the file's contents from this byte offset")
+	extractCmd.Flags().Int64Var(&rangeLength, "length", -1, "Only extract this many bytes of the file (default: to the end)")
+	extractCmd.Flags().StringVar(&manifestOut, "manifest-out", "", "Write a JSON summary of every extracted file (output path, source path, size, sha256, layer) to this path")
+	extractCmd.Flags().IntVar(&copyBuffer, "copy-buffer", output.DefaultCopyBuffer, "Size in bytes of the buffer each file is written through; larger buffers mean fewer writes at the cost of memory")
+	extractCmd.Flags().IntVar(&parallelFiles, "parallel-files", 1, "With several file paths, extract up to this many files at a time")
+	extractCmd.Flags().BoolVar(&strictPaths, "strict-paths", false, "Require absolute, clean file paths such as /etc/hostname, failing on relative ones like etc/hostname")
+	extractCmd.Flags().StringVar(&pathsFrom, "paths-from", "", "Also extract the files listed in this file, one path per line, optionally followed by a tab and the output path")
+	extractCmd.Flags().BoolVar(&unpack, "unpack", false, "Unpack the extracted file into the --output directory when it's a tar, gzip or zip archive")
+	extractCmd.Flags().StringVar(&grepPattern, "grep", "", "Only write the file if its content matches this regular expression; otherwise fail with exit code 3")
+	extractCmd.Flags().BoolVar(&summary, "summary", false, "After a successful extraction, print one line with the format used, the source layer, the bytes fetched and the duration")
+	extractCmd.Flags().BoolVar(&resume, "resume", false, "Keep the files of a directory extraction that the --manifest-out manifest of an earlier run lists and that are still intact")
+}
+
+// fileMetadata is the sidecar written by --with-metadata
+type fileMetadata struct {
+	Path    string    `json:"path"`
+	Type    string    `json:"type"`
+	Mode    string    `json:"mode"`
+	UID     int       `json:"uid"`
+	GID     int       `json:"gid"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Layer   string    `json:"layer"`
+}
+
+// writeMetadataSidecar writes the source metadata of an extracted file as JSON
+func writeMetadataSidecar(path string, layer v1.Hash, md output.Metadata) error {
+	data, err := json.MarshalIndent(fileMetadata{
+		Path:    pathutil.NormalizeForDisplay(md.Path),
+		Type:    md.Type,
+		Mode:    fmt.Sprintf("%04o", md.Mode),
+		UID:     md.UID,
+		GID:     md.GID,
+		Size:    md.Size,
+		ModTime: md.ModTime,
+		Layer:   layer.String(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	return nil
+}
+
+// formatSummary renders the line printed by --summary
+func formatSummary(result *extractor.ExtractResult) string {
+	layer := "-"
+	if result.Layer != (v1.Hash{}) {
+		layer = result.Layer.String()
+	}
+	return fmt.Sprintf("format=%s layer=%s bytes_fetched=%s duration=%s",
+		result.Format, layer, formatBytes(result.BytesFetched), result.Duration.Round(time.Millisecond))
+}
+
+// formatBytes renders n in the largest unit that keeps it at least 1, with
+// KB meaning 1024 bytes as in the verbose cache stats, e.g. 12.3KB
+func formatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	value := float64(n) / 1024
+	for _, suffix := range []string{"KB", "MB"} {
+		if value < 1024 {
+			return fmt.Sprintf("%.1f%s", value, suffix)
+		}
+		value /= 1024
+	}
+	return fmt.Sprintf("%.1fGB", value)
+}
+
+// extractRange builds the byte range selected by --offset and --length, or
+// nil when neither is given
+func extractRange(cmd *cobra.Command, filePath string) (*output.ByteRange, error) {
+	if !cmd.Flags().Changed("offset") && !cmd.Flags().Changed("length") {
+		return nil, nil
+	}
+
+	if output.IsDirTarget(filePath) {
+		return nil, fmt.Errorf("--offset and --length only apply to single file extraction")
+	}
+	if rangeOffset < 0 {
+		return nil, fmt.Errorf("--offset must not be negative")
+	}
+	if cmd.Flags().Changed("length") && rangeLength < 0 {
+		return nil, fmt.Errorf("--length must not be negative")
+	}
+
+	return &output.ByteRange{Offset: rangeOffset, Length: rangeLength}, nil
+}
+
+// extractPermissions builds the permission policy selected by
+// --preserve-permissions and --file-mode
+func extractPermissions() (output.Permissions, error) {
+	if preserveMode && fileMode != "" {
+		return output.UseUmask, fmt.Errorf("--preserve-permissions and --file-mode are mutually exclusive")
+	}
+	if preserveMode {
+		return output.PreserveFromTar, nil
+	}
+	if fileMode == "" {
+		return output.UseUmask, nil
+	}
+
+	mode, err := strconv.ParseUint(fileMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return output.UseUmask, fmt.Errorf("--file-mode must be octal permission bits, e.g. 0644, got %q", fileMode)
+	}
+	return output.FixedMode(os.FileMode(mode)), nil
+}
+
+// extractFiles extracts several files below opts.OutputPath, at their paths
+// in the image or the output paths listed for them, reporting each that
+// succeeds and then how many did. Every failure is returned, once all files
+// were tried.
+func extractFiles(ctx context.Context, orch *extractor.Orchestrator, opts extractor.ExtractOptions, entries []pathEntry) error {
+	var (
+		targets []extractor.FileTarget
+		sources []string
+	)
+	seen := make(map[extractor.FileTarget]bool)
+	written := make(map[string]string) // Output path to the file written there
+	for _, entry := range entries {
+		// Cleaning below the root keeps outputs inside the directory
+		clean := path.Clean(pathutil.NormalizeForDisplay(entry.filePath))
+
+		target := extractor.FileTarget{FilePath: clean}
+		switch {
+		case entry.outputPath != "":
+			target.OutputPath = entry.outputPath
+			if !filepath.IsAbs(target.OutputPath) {
+				target.OutputPath = filepath.Join(opts.OutputPath, target.OutputPath)
+			}
+		case opts.OutputTemplate != nil:
+			// A template names the file below the output directory itself
+			target.OutputPath = opts.OutputPath
+		default:
+			target.OutputPath = filepath.Join(opts.OutputPath, filepath.FromSlash(strings.TrimPrefix(clean, "/")))
+		}
+		if seen[target] {
+			continue
+		}
+		seen[target] = true
+
+		// Two files written to one path would race and keep either
+		if other, ok := written[target.OutputPath]; ok && opts.OutputTemplate == nil {
+			err := fmt.Errorf("%s and %s would both be written to %s", other, clean, target.OutputPath)
+			if entry.source != "" {
+				err = fmt.Errorf("%s: %w", entry.source, err)
+			}
+			return err
+		}
+		written[target.OutputPath] = clean
+
+		targets = append(targets, target)
+		sources = append(sources, entry.source)
+	}
+
+	if opts.OutputTemplate == nil {
+		for _, target := range targets {
+			if err := os.MkdirAll(filepath.Dir(target.OutputPath), 0755); err != nil {
+				return fmt.Errorf("failed to create output directory: %w", err)
+			}
+		}
+	}
+
+	results, errs, err := orch.ExtractFiles(ctx, opts, targets, parallelFiles)
+	if err != nil {
+		return err
+	}
+
+	var failed []error
+	for i, target := range targets {
+		if errs[i] != nil {
+			err := fmt.Errorf("%s: %w", target.FilePath, errs[i])
+			// Listed paths are reported by their line in the list
+			if sources[i] != "" {
+				err = fmt.Errorf("%s: %w", sources[i], err)
+			}
+			failed = append(failed, err)
+			continue
+		}
+		fmt.Printf("Successfully extracted %s to %s\n", target.FilePath, results[i].OutputPath)
+	}
+	fmt.Printf("Extracted %d of %d files\n", len(targets)-len(failed), len(targets))
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to extract %d of %d files:\n%w", len(failed), len(targets), errors.Join(failed...))
+	}
+	return nil
+}
+
+// extractPlatforms extracts opts.FilePath from every platform's image of an
+// index, reporting each platform that succeeds. A platform whose image lacks
+// the file is only warned about, unless every platform does; other failures
+// are returned once all platforms were tried.
+func extractPlatforms(ctx context.Context, orch *extractor.Orchestrator, opts extractor.ExtractOptions) error {
+	results, err := orch.ExtractPlatforms(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	var failed []error
+	missing := 0
+	for _, r := range results {
+		switch {
+		case errors.Is(r.Err, extractor.ErrNotFound):
+			missing++
+			fmt.Fprintf(logOutput, "Warning: %s: %v\n", r.Platform, r.Err)
+		case r.Err != nil:
+			failed = append(failed, fmt.Errorf("%s: %w", r.Platform, r.Err))
+		default:
+			fmt.Printf("Successfully extracted %s for %s to %s\n", opts.FilePath, r.Platform, r.Result.OutputPath)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to extract %s for %d of %d platforms:\n%w", opts.FilePath, len(failed), len(results), errors.Join(failed...))
+	}
+	if missing == len(results) {
+		return fmt.Errorf("file %s %w for any platform", opts.FilePath, extractor.ErrNotFound)
+	}
+	return nil
+}
+
+// extractUnpacked extracts the file opts.FilePath to a temporary file and
+// unpacks it into the directory opts.OutputPath. A file that isn't an
+// archive is copied into the directory as is.
+func extractUnpacked(ctx context.Context, orch *extractor.Orchestrator, opts extractor.ExtractOptions) (*extractor.ExtractResult, error) {
+	tmpDir, err := os.MkdirTemp("", "oci-extract-unpack-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// The archive keeps its name, which tells Unpack e.g. what a gzip
+	// stream decompresses to
+	outputDir := opts.OutputPath
+	opts.OutputPath = filepath.Join(tmpDir, filepath.Base(opts.FilePath))
+
+	result, err := orch.Extract(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = output.Unpack(result.OutputPath, outputDir, opts.Output)
+	if errors.Is(err, output.ErrNotArchive) {
+		err = copyInto(result.OutputPath, outputDir, opts.Output)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack %s: %w", opts.FilePath, err)
+	}
+
+	result.OutputPath = outputDir
+	return result, nil
+}
+
+// copyInto copies the file at src into the directory dir
+func copyInto(src, dir string, opts output.Options) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	return opts.WriteFile(filepath.Join(dir, filepath.Base(src)), f)
 }
 
 func runExtract(cmd *cobra.Command, args []string) error {
-	imageRef := args[0]
-	filePath := args[1]
+	imageRef, err := imageReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	var entries []pathEntry
+	for _, p := range args[1:] {
+		entries = append(entries, pathEntry{filePath: p})
+	}
+	if pathsFrom != "" {
+		listed, err := readPathList(pathsFrom)
+		if err != nil {
+			return err
+		}
+		if len(args) < 2 && len(listed) == 0 {
+			return fmt.Errorf("%s lists no paths to extract", pathsFrom)
+		}
+		entries = append(entries, listed...)
+	}
+	filePath := entries[0].filePath
+
+	// File names and digests aren't paths
+	if strictPaths && !byName && !byDigest {
+		for _, entry := range entries {
+			if err := pathutil.CheckStrict(entry.filePath); err != nil {
+				if entry.source != "" {
+					return fmt.Errorf("%s: %w", entry.source, err)
+				}
+				return err
+			}
+		}
+	}
+	// Listed paths are extracted together, even when there's only one
+	multiFile := len(entries) > 1 || pathsFrom != ""
+
+	ctx := cmd.Context()
+
+	if multiFile {
+		for _, entry := range entries {
+			if output.IsDirTarget(entry.filePath) {
+				return fmt.Errorf("only files can be extracted together, extract directory %s on its own", entry.filePath)
+			}
+			if entry.outputPath != "" && outputTmpl != "" {
+				return fmt.Errorf("%s: output paths can't be listed with --output-template", entry.source)
+			}
+		}
+		if allLayers || byName || byDigest || cmd.Flags().Changed("offset") || cmd.Flags().Changed("length") {
+			return fmt.Errorf("--all-layers, --by-name, --by-digest, --offset and --length only apply to a single file")
+		}
+		// Files are written below the output directory
+		if outputPath == "" {
+			outputPath = "."
+		}
+	}
+	if parallelFiles < 1 {
+		return fmt.Errorf("--parallel-files must be at least 1")
+	}
+
+	filter := output.Filter{Include: includes, Exclude: excludes}
+	if len(includes) > 0 || len(excludes) > 0 {
+		if !output.IsDirTarget(filePath) {
+			return fmt.Errorf("--include and --exclude only apply to directory extraction (path ending with /)")
+		}
+		if err := filter.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if withMetadata && output.IsDirTarget(filePath) {
+		return fmt.Errorf("--with-metadata only applies to single file extraction")
+	}
+	if allLayers && output.IsDirTarget(filePath) {
+		return fmt.Errorf("--all-layers only applies to single file extraction")
+	}
+	if allPlatforms {
+		platform, _ := cmd.Flags().GetString("platform")
+		annotations, _ := cmd.Flags().GetStringArray("manifest-annotation")
+		if multiFile || allLayers || unpack || outputTmpl != "" || withMetadata || manifestOut != "" || summary ||
+			platform != "" || len(annotations) > 0 {
+			return fmt.Errorf("--all-platforms only applies to extracting a single file or directory, without --all-layers, --unpack, --output-template, --with-metadata, --manifest-out, --summary, --platform or --manifest-annotation")
+		}
+		// Each platform gets a directory below the output directory
+		if outputPath == "" {
+			outputPath = "."
+		}
+	}
+	if resume && (manifestOut == "" || !output.IsDirTarget(filePath)) {
+		return fmt.Errorf("--resume requires --manifest-out and a directory extraction (path ending with /)")
+	}
+	if byName && strings.Contains(filePath, "/") {
+		return fmt.Errorf("--by-name takes a file name without slashes, e.g. sh")
+	}
+	if byDigest && output.IsDirTarget(filePath) {
+		return fmt.Errorf("--by-digest takes a file digest, e.g. sha256:<hex>")
+	}
+	if hashFiles && !byDigest {
+		return fmt.Errorf("--hash-files only applies with --by-digest")
+	}
+	if summary && (multiFile || allLayers) {
+		return fmt.Errorf("--summary only applies to extracting a single file or directory")
+	}
 
-	ctx := context.Background()
+	if unpack && (multiFile || output.IsDirTarget(filePath) || allLayers || outputTmpl != "" ||
+		withMetadata || manifestOut != "" || cmd.Flags().Changed("offset") || cmd.Flags().Changed("length")) {
+		return fmt.Errorf("--unpack only applies to extracting a single whole file, without --all-layers, --output-template, --with-metadata or --manifest-out")
+	}
+
+	var match *regexp.Regexp
+	if grepPattern != "" {
+		if output.IsDirTarget(filePath) || allLayers || unpack {
+			return fmt.Errorf("--grep only applies to file extraction, without --all-layers or --unpack")
+		}
+		if match, err = regexp.Compile(grepPattern); err != nil {
+			return fmt.Errorf("invalid --grep pattern: %w", err)
+		}
+	}
+
+	var nameTemplate *output.NameTemplate
+	if outputTmpl != "" {
+		if output.IsDirTarget(filePath) {
+			return fmt.Errorf("--output-template only applies to file extraction")
+		}
+		if nameTemplate, err = output.ParseNameTemplate(outputTmpl); err != nil {
+			return fmt.Errorf("invalid --output-template: %w", err)
+		}
+		// Files are named below the output directory
+		if outputPath == "" {
+			outputPath = "."
+		}
+	}
+
+	byteRange, err := extractRange(cmd, filePath)
+	if err != nil {
+		return err
+	}
+
+	permissions, err := extractPermissions()
+	if err != nil {
+		return err
+	}
+	if copyBuffer <= 0 {
+		return fmt.Errorf("--copy-buffer must be positive")
+	}
 
 	// Determine output path
+	if outputPath == "" && byDigest {
+		// The file's name is only known once it's found
+		_, encoded, _ := strings.Cut(filePath, ":")
+		outputPath = encoded
+	}
 	if outputPath == "" {
-		outputPath = filepath.Base(filePath)
+		outputPath = filepath.Base(strings.TrimSuffix(filePath, "/"))
+		if outputPath == "/" || outputPath == "." {
+			return fmt.Errorf("--output is required when extracting %s", filePath)
+		}
+		// An archive is unpacked into a directory named after it
+		if unpack {
+			outputPath = output.ArchiveBase(outputPath)
+		}
+	}
+
+	// The manifest hashes what was written, which a FIFO can't give back
+	if manifestOut != "" && output.IsSpecialFile(outputPath) {
+		return fmt.Errorf("--manifest-out can't hash files written to FIFO or device %s", outputPath)
 	}
 
 	verbose, _ := cmd.Flags().GetBool("verbose")
 	if verbose {
-		fmt.Printf("Extracting %s from %s\n", filePath, imageRef)
-		fmt.Printf("Output: %s\n", outputPath)
-	}
-
-	// Parse format hint
-	var formatHint detector.Format
-	switch format {
-	case "estargz":
-		formatHint = detector.FormatEStargz
-	case "soci":
-		formatHint = detector.FormatSOCI
-	case "standard":
-		formatHint = detector.FormatStandard
-	default:
-		formatHint = detector.FormatUnknown // Auto-detect
+		fmt.Fprintf(logOutput, "Extracting %s from %s\n", filePath, imageRef)
+		fmt.Fprintf(logOutput, "Output: %s\n", outputPath)
+	}
+
+	formatHint, err := parseFormat(format)
+	if err != nil {
+		return err
 	}
 
 	// Create orchestrator
-	orch := extractor.NewOrchestrator(verbose)
+	orch, err := newOrchestrator(cmd)
+	if err != nil {
+		return err
+	}
+	if summary && runMetrics == nil {
+		// Fetched bytes are only counted while metrics are recorded
+		orch.SetMetrics(metrics.New())
+	}
+
+	// Files extracted together often come from the same layers, so layers
+	// downloaded in full are kept for the run even without --keep-layer
+	if keepLayer, _ := cmd.Flags().GetString("keep-layer"); multiFile && keepLayer == "" {
+		dir, err := os.MkdirTemp("", "oci-extract-layers-")
+		if err != nil {
+			return fmt.Errorf("failed to create layer cache directory: %w", err)
+		}
+		cleanups = append(cleanups, func() { _ = os.RemoveAll(dir) })
+		orch.KeepLayers(dir)
+	}
+
+	opts := extractor.ExtractOptions{
+		ImageRef:       imageRef,
+		FilePath:       filePath,
+		OutputPath:     outputPath,
+		OutputTemplate: nameTemplate,
+		ForceFormat:    formatHint,
+		Preflight:      preflight,
+		ByName:         byName,
+		ByDigest:       byDigest,
+		HashFiles:      hashFiles,
+		Output: output.Options{
+			Xattrs:          xattrs,
+			PreserveOwner:   preserveOwner,
+			Filter:          filter,
+			SkipUnsafePaths: skipUnsafePaths,
+			Range:           byteRange,
+			Permissions:     permissions,
+			CopyBuffer:      copyBuffer,
+			Match:           match,
+		},
+	}
+
+	if resume {
+		manifest, err := readExtractManifest(manifestOut)
+		if err != nil {
+			return err
+		}
+		opts.Resume = resumeFrom(manifest)
+	}
+
+	// Remember the written files for the metadata sidecars and manifest
+	var (
+		extractedMu sync.Mutex
+		extracted   []extractedFile
+	)
+	if withMetadata || manifestOut != "" {
+		opts.OnExtracted = func(path string, layer v1.Hash, md output.Metadata) {
+			extractedMu.Lock()
+			defer extractedMu.Unlock()
+			extracted = append(extracted, extractedFile{path: path, layer: layer, md: md})
+		}
+	}
 
 	// Extract the file
-	err := orch.Extract(ctx, extractor.ExtractOptions{
-		ImageRef:    imageRef,
-		FilePath:    filePath,
-		OutputPath:  outputPath,
-		ForceFormat: formatHint,
-	})
+	written := []string{outputPath}
+	var result *extractor.ExtractResult
+	switch {
+	case multiFile:
+		written = nil
+		err = extractFiles(ctx, orch, opts, entries)
+	case allPlatforms:
+		written = nil
+		err = extractPlatforms(ctx, orch, opts)
+	case allLayers:
+		written, err = orch.ExtractAll(ctx, opts)
+	case unpack:
+		result, err = extractUnpacked(ctx, orch, opts)
+	default:
+		result, err = orch.Extract(ctx, opts)
+		if err == nil {
+			written = []string{result.OutputPath}
+		}
+	}
 	if err != nil {
+		// Files extracted before the failure still get their sidecars
+		if withMetadata {
+			for _, f := range extracted {
+				if sidecarErr := writeMetadataSidecar(f.path+".json", f.layer, f.md); sidecarErr != nil {
+					fmt.Fprintf(logOutput, "Warning: %v\n", sidecarErr)
+				}
+			}
+		}
+		// A failed or interrupted extraction still writes the manifest of
+		// the files written so far, for a later run to --resume from
+		if manifestOut != "" && len(extracted) > 0 {
+			if manifestErr := writeExtractManifest(manifestOut, imageRef, extracted); manifestErr != nil {
+				fmt.Fprintf(logOutput, "Warning: %v\n", manifestErr)
+			}
+		}
 		return err
 	}
 
-	fmt.Printf("Successfully extracted %s to %s\n", filePath, outputPath)
+	if withMetadata {
+		for _, f := range extracted {
+			if err := writeMetadataSidecar(f.path+".json", f.layer, f.md); err != nil {
+				return err
+			}
+		}
+	}
+
+	if manifestOut != "" {
+		if err := writeExtractManifest(manifestOut, imageRef, extracted); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range written {
+		fmt.Printf("Successfully extracted %s to %s\n", filePath, path)
+	}
+	if summary {
+		fmt.Println(formatSummary(result))
+	}
 	return nil
 }
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amartani/oci-extract/internal/extractor"
+)
+
+// TestExtractFilesOutputCollision tests that different files written to the
+// same output path are rejected before anything is extracted
+func TestExtractFilesOutputCollision(t *testing.T) {
+	dir := t.TempDir()
+	opts := extractor.ExtractOptions{OutputPath: dir}
+
+	entries := []pathEntry{
+		{filePath: "/etc/passwd"},
+		{filePath: "/etc/passwd"},
+		{filePath: "/etc/group", outputPath: "etc/passwd", source: "list.txt:3"},
+	}
+	err := extractFiles(context.Background(), nil, opts, entries)
+	if err == nil {
+		t.Fatal("extractFiles() expected error for colliding output paths, got nil")
+	}
+	want := "list.txt:3: /etc/passwd and /etc/group would both be written to " + filepath.Join(dir, "etc", "passwd")
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("extractFiles() error = %v, want %q", err, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "etc")); !os.IsNotExist(err) {
+		t.Errorf("output directory was created before the collision was reported: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/amartani/oci-extract/internal/extractor"
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var fetchOutput string
+
+// fetchCmd represents the fetch command
+var fetchCmd = &cobra.Command{
+	Use:   "fetch [plan]",
+	Short: "Extract a file planned by prepare",
+	Long: `Extract the file a plan written by prepare describes, fetching only the
+byte ranges the plan lists from the layer's blob URL. The image's manifest
+and the layer's TOC or zTOC aren't fetched; they come from the plan.
+
+The plan defaults to ` + defaultPlanPath + ` in the current directory.
+Registry credentials are looked up as for extract.
+
+Examples:
+  # Fetch the file planned by prepare
+  oci-extract fetch
+
+  # Fetch from a plan elsewhere, to a given path
+  oci-extract fetch config-plan.json -o ./config.yaml`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runFetch,
+}
+
+func init() {
+	rootCmd.AddCommand(fetchCmd)
+
+	fetchCmd.Flags().StringVarP(&fetchOutput, "output", "o", "", "Output path (default: current directory + filename)")
+}
+
+func runFetch(cmd *cobra.Command, args []string) error {
+	planPath := defaultPlanPath
+	if len(args) > 0 {
+		planPath = args[0]
+	}
+	ctx := cmd.Context()
+
+	data, err := os.ReadFile(planPath)
+	if err != nil {
+		return fmt.Errorf("failed to read plan: %w", err)
+	}
+	var plan extractor.Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return fmt.Errorf("failed to parse plan %s: %w", planPath, err)
+	}
+
+	outputPath := fetchOutput
+	if outputPath == "" {
+		outputPath = filepath.Base(plan.FilePath)
+	}
+
+	orch, err := newOrchestrator(cmd)
+	if err != nil {
+		return err
+	}
+
+	result, err := orch.ExtractPlan(ctx, &plan, outputPath, output.Options{})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully extracted %s to %s\n", plan.FilePath, result.OutputPath)
+	return nil
+}
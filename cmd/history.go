@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/amartani/oci-extract/internal/extractor"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyTags   []string
+	historyOutput string
+)
+
+// historyCmd represents the extract-history command
+var historyCmd = &cobra.Command{
+	Use:   "extract-history <repository> <file-path>",
+	Short: "Extract a file from the image of each of several tags",
+	Long: `Extract a file from the image each tag given with --tags points to, to see
+how it changed across releases. Each tag's copy is written to a directory
+named by the tag below the output directory: <output>/<tag>/<name>, or
+<output>/<tag> itself for a directory (path ending with /).
+
+Tags whose image lacks the file are reported and skipped. The command fails
+if any other tag fails, or if no tag's image has the file.
+
+Examples:
+  # Extract a config file as it was in three releases, to ./v1/app.conf etc.
+  oci-extract extract-history ghcr.io/myorg/app /etc/app.conf --tags v1,v2,v3
+
+  # Compare the copies
+  oci-extract extract-history ghcr.io/myorg/app /etc/app.conf --tags v1,v2 -o history
+  diff history/v1/app.conf history/v2/app.conf`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeImagePath,
+	RunE:              runHistory,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().StringSliceVar(&historyTags, "tags", nil, "Comma-separated tags to extract the file from, e.g. v1,v2,v3")
+	historyCmd.Flags().StringVarP(&historyOutput, "output", "o", ".", "Directory to write each tag's directory to")
+	_ = historyCmd.MarkFlagRequired("tags")
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	repository, filePath := args[0], args[1]
+	ctx := cmd.Context()
+
+	if err := checkHistoryTags(historyTags); err != nil {
+		return err
+	}
+
+	orch, err := newOrchestrator(cmd)
+	if err != nil {
+		return err
+	}
+
+	results, err := orch.ExtractTags(ctx, extractor.ExtractOptions{
+		ImageRef:   repository,
+		FilePath:   filePath,
+		OutputPath: historyOutput,
+	}, historyTags)
+	if err != nil {
+		return err
+	}
+
+	var failed []error
+	missing := 0
+	for _, r := range results {
+		switch {
+		case errors.Is(r.Err, extractor.ErrNotFound):
+			missing++
+			fmt.Fprintf(logOutput, "Warning: %s: %v\n", r.Tag, r.Err)
+		case r.Err != nil:
+			failed = append(failed, fmt.Errorf("%s: %w", r.Tag, r.Err))
+		default:
+			fmt.Printf("Successfully extracted %s from %s to %s\n", filePath, r.Tag, r.Result.OutputPath)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to extract %s from %d of %d tags:\n%w", filePath, len(failed), len(results), errors.Join(failed...))
+	}
+	if missing == len(results) {
+		return fmt.Errorf("file %s %w in any tag", filePath, extractor.ErrNotFound)
+	}
+	return nil
+}
+
+// checkHistoryTags rejects empty and repeated tags, which would be written
+// to the output directory itself or overwrite each other
+func checkHistoryTags(tags []string) error {
+	if len(tags) == 0 {
+		return fmt.Errorf("--tags needs at least one tag")
+	}
+	for i, tag := range tags {
+		if tag == "" {
+			return fmt.Errorf("--tags has an empty tag")
+		}
+		if slices.Contains(tags[:i], tag) {
+			return fmt.Errorf("--tags has %s more than once", tag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package cmd
+
+import "testing"
+
+func TestCheckHistoryTags(t *testing.T) {
+	tests := []struct {
+		tags    []string
+		wantErr bool
+	}{
+		{tags: []string{"v1", "v2", "v3"}},
+		{tags: []string{"latest"}},
+		{tags: nil, wantErr: true},
+		{tags: []string{"v1", ""}, wantErr: true},
+		{tags: []string{"v1", "v2", "v1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if err := checkHistoryTags(tt.tags); (err != nil) != tt.wantErr {
+			t.Errorf("checkHistoryTags(%q) error = %v, wantErr %v", tt.tags, err, tt.wantErr)
+		}
+	}
+}
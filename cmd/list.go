@@ -2,13 +2,36 @@ package cmd
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"time"
 
-	"github.com/amartani/oci-extract/internal/detector"
 	"github.com/amartani/oci-extract/internal/extractor"
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/amartani/oci-extract/internal/ratelimit"
+	"github.com/amartani/oci-extract/internal/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/spf13/cobra"
 )
 
+var (
+	print0       bool
+	outputFormat string
+	allTypes     bool
+	resolveLinks bool
+	tocOnly      bool
+	minSize      string
+	maxSize      string
+	excludeEmpty bool
+	unsorted     bool
+)
+
 // listCmd represents the list command
 var listCmd = &cobra.Command{
 	Use:   "list <image>",
@@ -26,7 +49,31 @@ Examples:
   oci-extract list alpine:latest --verbose
 
   # Force using a specific format
-  oci-extract list myimage:latest --format estargz`,
+  oci-extract list myimage:latest --format estargz
+
+  # Pipe paths safely into xargs
+  oci-extract list alpine:latest --print0 | xargs -0 -n1 echo
+
+  # Include directories (shown with a trailing /) and symlinks (path -> target)
+  oci-extract list alpine:latest --all-types
+
+  # Show the real path each symlink leads to, marking broken ones
+  oci-extract list alpine:latest --resolve-links
+
+  # List an eStargz image from its TOCs alone, never downloading a layer
+  oci-extract list myimage:esgz --estargz-toc-only
+
+  # Find the files of 1MB or more
+  oci-extract list myimage:latest --min-size 1MB
+
+  # Export paths with size, mode, mtime and layer for a spreadsheet
+  oci-extract list alpine:latest --output-format csv > files.csv
+
+  # Print entries as each layer is listed instead of sorted by path
+  oci-extract list alpine:latest --unsorted
+
+  # List the image of every platform of a multi-platform index
+  oci-extract list alpine:latest --platform all`,
 	Args: cobra.ExactArgs(1),
 	RunE: runList,
 }
@@ -35,50 +82,431 @@ func init() {
 	rootCmd.AddCommand(listCmd)
 
 	listCmd.Flags().StringVar(&format, "format", "auto", "Force format: auto, estargz, soci, standard")
+	registerFormatCompletion(listCmd)
+	listCmd.Flags().BoolVar(&print0, "print0", false, "Separate entries with NUL instead of newline (for xargs -0)")
+	listCmd.Flags().StringVar(&outputFormat, "output-format", "text", "Output format: text, json, csv, tsv")
+	_ = listCmd.RegisterFlagCompletionFunc("output-format", cobra.FixedCompletions([]string{"text", "json", "csv", "tsv"}, cobra.ShellCompDirectiveNoFileComp))
+	listCmd.Flags().BoolVar(&allTypes, "all-types", false, "Also list directories, symlinks and other entry types, not only regular files")
+	listCmd.Flags().BoolVar(&resolveLinks, "resolve-links", false, "Show the real path each symlink leads to, or whether it dangles or loops (implies --all-types)")
+	listCmd.Flags().StringVar(&minSize, "min-size", "", "Only list files of at least this size, e.g. 1MB or 512KiB")
+	listCmd.Flags().StringVar(&maxSize, "max-size", "", "Only list files of at most this size, e.g. 1MB or 512KiB")
+	listCmd.Flags().BoolVar(&excludeEmpty, "exclude-empty-files", false, "Leave out empty files")
+	listCmd.Flags().BoolVar(&unsorted, "unsorted", false, "Print entries as each layer is listed, in layer order, instead of sorted by path once every layer is")
+	listCmd.Flags().BoolVar(&tocOnly, "estargz-toc-only", false, "Fail eStargz layers whose TOC can't be read instead of downloading them to list them")
+}
+
+// sizeFilter selects listed files by size. Size only means something for
+// regular files, so while a bound is set, entries of other types are left
+// out.
+type sizeFilter struct {
+	min, max int64 // 0 for no bound
+	active   bool
+}
+
+// newSizeFilter parses the --min-size and --max-size flags. excludeEmpty
+// is the same as a minimum size of one byte.
+func newSizeFilter(minSize, maxSize string, excludeEmpty bool) (sizeFilter, error) {
+	var f sizeFilter
+	if minSize != "" {
+		n, err := ratelimit.ParseSize(minSize)
+		if err != nil {
+			return f, fmt.Errorf("invalid --min-size: %w", err)
+		}
+		f.min = n
+	}
+	if maxSize != "" {
+		n, err := ratelimit.ParseSize(maxSize)
+		if err != nil {
+			return f, fmt.Errorf("invalid --max-size: %w", err)
+		}
+		f.max = n
+	}
+	if excludeEmpty {
+		f.min = max(f.min, 1)
+	}
+	if f.max > 0 && f.min > f.max {
+		return f, fmt.Errorf("--min-size %d is larger than --max-size %d", f.min, f.max)
+	}
+
+	f.active = f.min > 0 || f.max > 0
+	return f, nil
+}
+
+// keep reports whether entry passes the filter
+func (f sizeFilter) keep(entry extractor.FileEntry) bool {
+	if !f.active {
+		return true
+	}
+	return entry.Type == output.TypeRegular && entry.Size >= f.min && (f.max == 0 || entry.Size <= f.max)
+}
+
+// listColumns are the fields written by the json, csv and tsv output formats
+var listColumns = []string{"path", "size", "mode", "mtime", "layer"}
+
+// typeColumns are the extra fields written with --all-types
+var typeColumns = []string{"type", "link"}
+
+// resolveColumns are the extra fields written with --resolve-links
+var resolveColumns = []string{"resolved", "broken"}
+
+// listEntry is a listed file as written by --output-format json
+type listEntry struct {
+	// Set with --platform all: the platform of the image the file is in
+	Platform string `json:"platform,omitempty"`
+
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+	Layer   string    `json:"layer"`
+	Type    string    `json:"type,omitempty"`
+	Link    string    `json:"link,omitempty"`
+
+	// Set for symlinks with --resolve-links: the real path the link leads
+	// to, or why it leads nowhere ("dangling" or "loop")
+	Resolved string `json:"resolved,omitempty"`
+	Broken   string `json:"broken,omitempty"`
+}
+
+// listWriter writes listed files in one of the --output-format formats.
+// Text, CSV and TSV are written as entries arrive; JSON is written as a
+// single array by Flush.
+type listWriter struct {
+	out       io.Writer
+	format    string
+	separator string
+	allTypes  bool // Entries of any type are written, so include their type
+	csv       *csv.Writer
+	entries   []listEntry
+
+	// With platforms, entries are labeled with the platform passed to
+	// StartPlatform, and sections counts the platforms started so far
+	platforms bool
+	platform  string
+	sections  int
+
+	// links resolves symlinks for --resolve-links, nil to show their targets
+	links *extractor.LinkResolver
+}
+
+// newListWriter creates a listWriter for format, writing text entries
+// followed by separator. With links, symlinks are shown resolved. With
+// platforms, entries are labeled with their platform (see StartPlatform).
+func newListWriter(out io.Writer, format, separator string, allTypes bool, links *extractor.LinkResolver, platforms bool) (*listWriter, error) {
+	w := &listWriter{out: out, format: format, separator: separator, allTypes: allTypes, links: links, platforms: platforms}
+
+	switch format {
+	case "text":
+	case "json":
+		w.entries = []listEntry{}
+	case "csv", "tsv":
+		w.csv = csv.NewWriter(out)
+		if format == "tsv" {
+			w.csv.Comma = '\t'
+		}
+		columns := listColumns
+		if allTypes {
+			columns = append(slices.Clone(listColumns), typeColumns...)
+		}
+		if links != nil {
+			columns = append(slices.Clone(columns), resolveColumns...)
+		}
+		if platforms {
+			columns = append([]string{"platform"}, columns...)
+		}
+		if err := w.csv.Write(columns); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid --output-format %q: must be text, json, csv, or tsv", format)
+	}
+
+	return w, nil
+}
+
+// Write outputs a single listed file
+func (w *listWriter) Write(entry extractor.FileEntry) error {
+	le := listEntry{
+		Platform: w.platform,
+		Path:     entry.Path,
+		Size:     entry.Size,
+		Mode:     fmt.Sprintf("%04o", entry.Mode),
+		ModTime:  entry.ModTime.UTC(),
+		Layer:    entry.Layer.String(),
+	}
+	if w.allTypes {
+		le.Type = entry.Type
+		le.Link = entry.Linkname
+	}
+	if w.links != nil && entry.Type == "symlink" {
+		le.Resolved, le.Broken = resolveLink(w.links, entry.Path)
+	}
+
+	switch w.format {
+	case "json":
+		w.entries = append(w.entries, le)
+		return nil
+	case "csv", "tsv":
+		record := []string{
+			le.Path,
+			strconv.FormatInt(le.Size, 10),
+			le.Mode,
+			le.ModTime.Format(time.RFC3339),
+			le.Layer,
+		}
+		if w.allTypes {
+			record = append(record, le.Type, le.Link)
+		}
+		if w.links != nil {
+			record = append(record, le.Resolved, le.Broken)
+		}
+		if w.platforms {
+			record = append([]string{le.Platform}, record...)
+		}
+		return w.csv.Write(record)
+	default:
+		text := textEntry(entry)
+		switch {
+		case le.Broken != "":
+			text += " (" + le.Broken + ")"
+		case le.Resolved != "":
+			text = entry.Path + " -> " + le.Resolved
+		}
+		_, err := fmt.Fprint(w.out, text+w.separator)
+		return err
+	}
+}
+
+// StartPlatform labels the entries written next as being in the image of
+// platform: text output gets a "platform:" header line before them, the
+// other formats a platform field on each
+func (w *listWriter) StartPlatform(platform string) error {
+	w.platform = platform
+	if w.format != "text" {
+		return nil
+	}
+
+	header := platform + ":\n"
+	if w.sections > 0 {
+		header = "\n" + header
+	}
+	w.sections++
+	_, err := fmt.Fprint(w.out, header)
+	return err
+}
+
+// resolveLink returns the real path a symlink leads to, or why it can't be
+// resolved
+func resolveLink(links *extractor.LinkResolver, path string) (resolved, broken string) {
+	resolved, err := links.Resolve(path)
+	switch {
+	case errors.Is(err, extractor.ErrLinkLoop):
+		return "", "loop"
+	case err != nil:
+		return "", "dangling"
+	default:
+		return resolved, ""
+	}
+}
+
+// textEntry formats an entry for the text output format: directories get a
+// trailing slash and symlinks show their target
+func textEntry(entry extractor.FileEntry) string {
+	switch entry.Type {
+	case "dir":
+		return entry.Path + "/"
+	case "symlink":
+		return entry.Path + " -> " + entry.Linkname
+	default:
+		return entry.Path
+	}
+}
+
+// Flush writes out anything buffered by Write
+func (w *listWriter) Flush() error {
+	switch w.format {
+	case "json":
+		data, err := json.MarshalIndent(w.entries, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode listing: %w", err)
+		}
+		_, err = w.out.Write(append(data, '\n'))
+		return err
+	case "csv", "tsv":
+		w.csv.Flush()
+		return w.csv.Error()
+	default:
+		return nil
+	}
 }
 
 func runList(cmd *cobra.Command, args []string) error {
-	imageRef := args[0]
-	ctx := context.Background()
+	imageRef, err := imageReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	ctx := cmd.Context()
 
 	verbose, _ := cmd.Flags().GetBool("verbose")
 	if verbose {
-		fmt.Printf("Listing files in %s\n", imageRef)
+		fmt.Fprintf(logOutput, "Listing files in %s\n", imageRef)
 	}
 
-	// Parse format hint
-	var formatHint detector.Format
-	switch format {
-	case "estargz":
-		formatHint = detector.FormatEStargz
-	case "soci":
-		formatHint = detector.FormatSOCI
-	case "standard":
-		formatHint = detector.FormatStandard
-	default:
-		formatHint = detector.FormatUnknown // Auto-detect
+	formatHint, err := parseFormat(format)
+	if err != nil {
+		return err
+	}
+
+	sizes, err := newSizeFilter(minSize, maxSize, excludeEmpty)
+	if err != nil {
+		return err
+	}
+
+	if print0 && outputFormat != "text" {
+		return fmt.Errorf("--print0 only applies to --output-format text")
+	}
+
+	platform, _ := cmd.Flags().GetString("platform")
+	allPlatforms := platform == allPlatformsValue
+	if allPlatforms {
+		annotations, _ := cmd.Flags().GetStringArray("manifest-annotation")
+		if print0 || len(annotations) > 0 {
+			return fmt.Errorf("--platform all can't be combined with --print0 or --manifest-annotation")
+		}
+	}
+
+	// Print entries once they are sorted, or as each layer is enumerated
+	separator := "\n"
+	if print0 {
+		separator = "\x00"
+	}
+	var links *extractor.LinkResolver
+	if resolveLinks {
+		links = extractor.NewLinkResolver(nil)
+	}
+	writer, err := newListWriter(os.Stdout, outputFormat, separator, allTypes || resolveLinks, links, allPlatforms)
+	if err != nil {
+		return err
 	}
 
 	// Create orchestrator
-	orch := extractor.NewOrchestrator(verbose)
+	orch, err := newOrchestrator(cmd)
+	if err != nil {
+		return err
+	}
 
-	// List files
-	files, err := orch.List(ctx, extractor.ListOptions{
+	listOpts := extractor.ListOptions{
 		ImageRef:    imageRef,
 		ForceFormat: formatHint,
-	})
-	if err != nil {
-		return err
+		AllTypes:    allTypes || resolveLinks,
+
+		EStargzTOCOnly: tocOnly,
+	}
+
+	// With --platform all, the image of each platform of an index is listed
+	// in turn. An image that isn't an index is listed once, unlabeled.
+	platforms := []*v1.Platform{nil}
+	if allPlatforms {
+		found, err := orch.Platforms(ctx, imageRef)
+		switch {
+		case errors.Is(err, registry.ErrNotIndex):
+		case err != nil:
+			return err
+		default:
+			platforms = platforms[:0]
+			for i := range found {
+				platforms = append(platforms, &found[i])
+			}
+		}
 	}
 
-	// Print the list of files
-	for _, file := range files {
-		fmt.Println(file)
+	count := 0
+	for _, p := range platforms {
+		if p != nil {
+			orch.SelectManifest(registry.ManifestSelector{Platform: p})
+			if err := writer.StartPlatform(p.String()); err != nil {
+				return err
+			}
+		}
+		// Symlinks resolve within the image they are in
+		if links != nil {
+			links = extractor.NewLinkResolver(nil)
+			writer.links = links
+		}
+
+		n, err := listImage(ctx, orch, listOpts, writer, sizes, links, verbose)
+		if err != nil {
+			if p != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			return err
+		}
+		count += n
+	}
+	if err := writer.Flush(); err != nil {
+		return err
 	}
 
 	if verbose {
-		fmt.Printf("\nTotal files: %d\n", len(files))
+		fmt.Fprintf(logOutput, "\nTotal files: %d\n", count)
 	}
 
 	return nil
 }
+
+// listImage writes the entries of the image listOpts names that pass sizes
+// to writer, returning how many it wrote. With links, every entry listed is
+// added to it, so symlinks can be resolved through the image.
+func listImage(ctx context.Context, orch *extractor.Orchestrator, listOpts extractor.ListOptions, writer *listWriter, sizes sizeFilter, links *extractor.LinkResolver, verbose bool) (int, error) {
+	emit := writer.Write
+
+	// Show which layer is being listed, unless stderr is redirected or the
+	// output is meant for another program
+	if !verbose && !print0 && outputFormat != "json" && isTerminal(os.Stderr) {
+		progress := newLayerProgress(os.Stderr)
+		defer progress.Stop()
+
+		listOpts.OnLayer = progress.Layer
+		emit = func(entry extractor.FileEntry) error {
+			return progress.Suspend(func() error { return writer.Write(entry) })
+		}
+	}
+
+	// Entries are sorted by path so output is the same across runs and
+	// formats, and a symlink may lead through entries of any layer, so
+	// either way nothing is written until every layer is listed
+	write := emit
+	var pending []extractor.FileEntry
+	if !unsorted || links != nil {
+		write = func(entry extractor.FileEntry) error {
+			pending = append(pending, entry)
+			return nil
+		}
+	}
+
+	count := 0
+	_, err := orch.ListStream(ctx, listOpts, func(entry extractor.FileEntry) error {
+		// Entries filtered out may still be on the way to a symlink's target
+		if links != nil {
+			links.Add(entry)
+		}
+		if !sizes.keep(entry) {
+			return nil
+		}
+		count++
+		return write(entry)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !unsorted {
+		slices.SortFunc(pending, extractor.CompareEntryPaths)
+	}
+	for _, entry := range pending {
+		if err := emit(entry); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
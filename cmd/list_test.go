@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amartani/oci-extract/internal/extractor"
+	"github.com/amartani/oci-extract/internal/output"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestListWriter(t *testing.T) {
+	layer := v1.Hash{Algorithm: "sha256", Hex: "abc123"}
+	entries := []extractor.FileEntry{
+		{Metadata: output.Metadata{Path: "/etc/passwd", Size: 12, Mode: 0644, ModTime: time.Unix(0, 0)}, Layer: layer},
+		{Metadata: output.Metadata{Path: `/data/a,b "c".txt`, Size: 3, Mode: 0600, ModTime: time.Unix(60, 0)}, Layer: layer},
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: "text",
+			want:   "/etc/passwd\n/data/a,b \"c\".txt\n",
+		},
+		{
+			format: "csv",
+			want: "path,size,mode,mtime,layer\n" +
+				"/etc/passwd,12,0644,1970-01-01T00:00:00Z,sha256:abc123\n" +
+				"\"/data/a,b \"\"c\"\".txt\",3,0600,1970-01-01T00:01:00Z,sha256:abc123\n",
+		},
+		{
+			format: "tsv",
+			want: "path\tsize\tmode\tmtime\tlayer\n" +
+				"/etc/passwd\t12\t0644\t1970-01-01T00:00:00Z\tsha256:abc123\n" +
+				"\"/data/a,b \"\"c\"\".txt\"\t3\t0600\t1970-01-01T00:01:00Z\tsha256:abc123\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newListWriter(&buf, tt.format, "\n", false, nil, false)
+			if err != nil {
+				t.Fatalf("newListWriter() error = %v", err)
+			}
+			for _, entry := range entries {
+				if err := w.Write(entry); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListWriterAllTypes(t *testing.T) {
+	layer := v1.Hash{Algorithm: "sha256", Hex: "abc123"}
+	entries := []extractor.FileEntry{
+		{Metadata: output.Metadata{Path: "/etc", Type: "dir", Mode: 0755, ModTime: time.Unix(0, 0)}, Layer: layer},
+		{Metadata: output.Metadata{Path: "/bin/sh", Type: "symlink", Linkname: "busybox", Mode: 0777, ModTime: time.Unix(0, 0)}, Layer: layer},
+		{Metadata: output.Metadata{Path: "/bin/busybox", Type: "reg", Size: 5, Mode: 0755, ModTime: time.Unix(0, 0)}, Layer: layer},
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: "text",
+			want:   "/etc/\n/bin/sh -> busybox\n/bin/busybox\n",
+		},
+		{
+			format: "csv",
+			want: "path,size,mode,mtime,layer,type,link\n" +
+				"/etc,0,0755,1970-01-01T00:00:00Z,sha256:abc123,dir,\n" +
+				"/bin/sh,0,0777,1970-01-01T00:00:00Z,sha256:abc123,symlink,busybox\n" +
+				"/bin/busybox,5,0755,1970-01-01T00:00:00Z,sha256:abc123,reg,\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newListWriter(&buf, tt.format, "\n", true, nil, false)
+			if err != nil {
+				t.Fatalf("newListWriter() error = %v", err)
+			}
+			for _, entry := range entries {
+				if err := w.Write(entry); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListWriterResolveLinks(t *testing.T) {
+	layer := v1.Hash{Algorithm: "sha256", Hex: "abc123"}
+	entries := []extractor.FileEntry{
+		{Metadata: output.Metadata{Path: "/bin/sh", Type: "symlink", Linkname: "busybox", Mode: 0777, ModTime: time.Unix(0, 0)}, Layer: layer},
+		{Metadata: output.Metadata{Path: "/bin/busybox", Type: "reg", Size: 5, Mode: 0755, ModTime: time.Unix(0, 0)}, Layer: layer},
+		{Metadata: output.Metadata{Path: "/bin/vi", Type: "symlink", Linkname: "/usr/bin/vim", Mode: 0777, ModTime: time.Unix(0, 0)}, Layer: layer},
+	}
+	links := extractor.NewLinkResolver(entries)
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: "text",
+			want:   "/bin/sh -> /bin/busybox\n/bin/busybox\n/bin/vi -> /usr/bin/vim (dangling)\n",
+		},
+		{
+			format: "csv",
+			want: "path,size,mode,mtime,layer,type,link,resolved,broken\n" +
+				"/bin/sh,0,0777,1970-01-01T00:00:00Z,sha256:abc123,symlink,busybox,/bin/busybox,\n" +
+				"/bin/busybox,5,0755,1970-01-01T00:00:00Z,sha256:abc123,reg,,,\n" +
+				"/bin/vi,0,0777,1970-01-01T00:00:00Z,sha256:abc123,symlink,/usr/bin/vim,,dangling\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newListWriter(&buf, tt.format, "\n", true, links, false)
+			if err != nil {
+				t.Fatalf("newListWriter() error = %v", err)
+			}
+			for _, entry := range entries {
+				if err := w.Write(entry); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListWriterPlatforms(t *testing.T) {
+	layer := v1.Hash{Algorithm: "sha256", Hex: "abc123"}
+	entry := extractor.FileEntry{Metadata: output.Metadata{Path: "/bin/sh", Size: 3, Mode: 0755, ModTime: time.Unix(0, 0)}, Layer: layer}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: "text",
+			want:   "linux/amd64:\n/bin/sh\n\nlinux/arm/v7:\n/bin/sh\n",
+		},
+		{
+			format: "csv",
+			want: "platform,path,size,mode,mtime,layer\n" +
+				"linux/amd64,/bin/sh,3,0755,1970-01-01T00:00:00Z,sha256:abc123\n" +
+				"linux/arm/v7,/bin/sh,3,0755,1970-01-01T00:00:00Z,sha256:abc123\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newListWriter(&buf, tt.format, "\n", false, nil, true)
+			if err != nil {
+				t.Fatalf("newListWriter() error = %v", err)
+			}
+			for _, platform := range []string{"linux/amd64", "linux/arm/v7"} {
+				if err := w.StartPlatform(platform); err != nil {
+					t.Fatalf("StartPlatform() error = %v", err)
+				}
+				if err := w.Write(entry); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListWriterJSON(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newListWriter(&buf, "json", "\n", false, nil, false)
+	if err != nil {
+		t.Fatalf("newListWriter() error = %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	// An empty listing is still a valid array
+	if got := strings.TrimSpace(buf.String()); got != "[]" {
+		t.Errorf("output = %q, want []", got)
+	}
+}
+
+func TestListWriterInvalidFormat(t *testing.T) {
+	if _, err := newListWriter(&bytes.Buffer{}, "xml", "\n", false, nil, false); err == nil {
+		t.Error("newListWriter() expected error for unknown format")
+	}
+}
+
+func TestSizeFilter(t *testing.T) {
+	entries := []extractor.FileEntry{
+		{Metadata: output.Metadata{Path: "/empty", Type: "reg", Size: 0}},
+		{Metadata: output.Metadata{Path: "/small", Type: "reg", Size: 100}},
+		{Metadata: output.Metadata{Path: "/medium", Type: "reg", Size: 2000}},
+		{Metadata: output.Metadata{Path: "/large", Type: "reg", Size: 3 << 20}},
+		{Metadata: output.Metadata{Path: "/etc", Type: "dir"}},
+	}
+
+	tests := []struct {
+		name         string
+		minSize      string
+		maxSize      string
+		excludeEmpty bool
+		want         []string
+	}{
+		{name: "no filter", want: []string{"/empty", "/small", "/medium", "/large", "/etc"}},
+		{name: "exclude empty", excludeEmpty: true, want: []string{"/small", "/medium", "/large"}},
+		{name: "min size", minSize: "1KB", want: []string{"/medium", "/large"}},
+		{name: "max size", maxSize: "1KB", want: []string{"/empty", "/small"}},
+		{name: "max size without empty", maxSize: "1KB", excludeEmpty: true, want: []string{"/small"}},
+		{name: "range", minSize: "1KB", maxSize: "1MiB", want: []string{"/medium"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newSizeFilter(tt.minSize, tt.maxSize, tt.excludeEmpty)
+			if err != nil {
+				t.Fatalf("newSizeFilter() error = %v", err)
+			}
+			var got []string
+			for _, entry := range entries {
+				if f.keep(entry) {
+					got = append(got, entry.Path)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := newSizeFilter("2MB", "1MB", false); err == nil {
+		t.Error("newSizeFilter() with --min-size above --max-size expected error, got nil")
+	}
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/amartani/oci-extract/internal/pathutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// extractedFile is a file written by an extraction, as reported by the
+// orchestrator
+type extractedFile struct {
+	path  string
+	layer v1.Hash
+	md    output.Metadata
+}
+
+// extractManifest is the summary written by --manifest-out
+type extractManifest struct {
+	Image string          `json:"image"`
+	Files []manifestEntry `json:"files"`
+}
+
+// manifestEntry describes one written file in an extractManifest
+type manifestEntry struct {
+	Output string `json:"output"`
+	Source string `json:"source"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Layer  string `json:"layer"`
+}
+
+// buildExtractManifest summarizes the files left on disk by an extraction.
+// For a path written more than once (an upper layer replacing a lower one in
+// a directory) the last write wins, and files later removed by a whiteout
+// are left out.
+func buildExtractManifest(imageRef string, files []extractedFile) (extractManifest, error) {
+	manifest := extractManifest{Image: imageRef, Files: []manifestEntry{}}
+
+	latest := make(map[string]int)
+	var order []string
+	for i, f := range files {
+		if _, ok := latest[f.path]; !ok {
+			order = append(order, f.path)
+		}
+		latest[f.path] = i
+	}
+
+	for _, path := range order {
+		f := files[latest[path]]
+
+		size, digest, err := output.HashFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return manifest, err
+		}
+
+		manifest.Files = append(manifest.Files, manifestEntry{
+			Output: path,
+			Source: pathutil.NormalizeForDisplay(f.md.Path),
+			Size:   size,
+			SHA256: digest,
+			Layer:  f.layer.String(),
+		})
+	}
+
+	return manifest, nil
+}
+
+// readExtractManifest reads a summary written by --manifest-out. A missing
+// file reads as an empty manifest, as on the first run with --resume.
+func readExtractManifest(path string) (extractManifest, error) {
+	var manifest extractManifest
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+// resumeFrom returns an ExtractOptions.Resume callback reporting the files
+// of a prior manifest that are still intact: written from the same layer,
+// with the recorded size and sha256. Each file is hashed at most once.
+func resumeFrom(manifest extractManifest) func(outputPath string, layer v1.Hash) bool {
+	entries := make(map[string]manifestEntry, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		entries[filepath.Clean(entry.Output)] = entry
+	}
+
+	intact := make(map[string]bool)
+	return func(outputPath string, layer v1.Hash) bool {
+		outputPath = filepath.Clean(outputPath)
+
+		entry, ok := entries[outputPath]
+		if !ok || entry.Layer != layer.String() {
+			return false
+		}
+
+		if done, checked := intact[outputPath]; checked {
+			return done
+		}
+
+		// A file being written when the previous run stopped differs in
+		// size or contents, so it is written again
+		size, digest, err := output.HashFile(outputPath)
+		intact[outputPath] = err == nil && size == entry.Size && digest == entry.SHA256
+		return intact[outputPath]
+	}
+}
+
+// writeExtractManifest writes the --manifest-out summary as JSON
+func writeExtractManifest(path, imageRef string, files []extractedFile) error {
+	manifest, err := buildExtractManifest(imageRef, files)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amartani/oci-extract/internal/output"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestBuildExtractManifest(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config")
+	if err := os.WriteFile(config, []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	lower := v1.Hash{Algorithm: "sha256", Hex: "aaa"}
+	upper := v1.Hash{Algorithm: "sha256", Hex: "bbb"}
+	files := []extractedFile{
+		{path: config, layer: lower, md: output.Metadata{Path: "etc/app/config"}},
+		{path: filepath.Join(dir, "deleted"), layer: lower, md: output.Metadata{Path: "etc/app/deleted"}},
+		{path: config, layer: upper, md: output.Metadata{Path: "etc/app/config"}},
+	}
+
+	manifest, err := buildExtractManifest("myimage:latest", files)
+	if err != nil {
+		t.Fatalf("buildExtractManifest() error = %v", err)
+	}
+
+	// The upper layer's copy replaced the lower one, and the deleted file is gone
+	want := manifestEntry{
+		Output: config,
+		Source: "/etc/app/config",
+		Size:   5,
+		SHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		Layer:  "sha256:bbb",
+	}
+	if len(manifest.Files) != 1 || manifest.Files[0] != want {
+		t.Errorf("buildExtractManifest() files = %+v, want [%+v]", manifest.Files, want)
+	}
+	if manifest.Image != "myimage:latest" {
+		t.Errorf("buildExtractManifest() image = %q, want myimage:latest", manifest.Image)
+	}
+}
+
+func TestResumeFrom(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config")
+	partial := filepath.Join(dir, "partial")
+	if err := os.WriteFile(config, []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.WriteFile(partial, []byte("hel"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	layer := v1.Hash{Algorithm: "sha256", Hex: "aaa"}
+	other := v1.Hash{Algorithm: "sha256", Hex: "bbb"}
+	const sum = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	resume := resumeFrom(extractManifest{Files: []manifestEntry{
+		{Output: config, Size: 5, SHA256: sum, Layer: layer.String()},
+		{Output: partial, Size: 5, SHA256: sum, Layer: layer.String()},
+	}})
+
+	tests := []struct {
+		name  string
+		path  string
+		layer v1.Hash
+		want  bool
+	}{
+		{name: "intact", path: config, layer: layer, want: true},
+		{name: "other layer", path: config, layer: other, want: false},
+		{name: "partially written", path: partial, layer: layer, want: false},
+		{name: "not in manifest", path: filepath.Join(dir, "new"), layer: layer, want: false},
+	}
+	for _, tt := range tests {
+		if got := resume(tt.path, tt.layer); got != tt.want {
+			t.Errorf("%s: resume(%s) = %v, want %v", tt.name, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestReadExtractManifestMissing(t *testing.T) {
+	manifest, err := readExtractManifest(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("readExtractManifest() error = %v", err)
+	}
+	if len(manifest.Files) != 0 {
+		t.Errorf("readExtractManifest() files = %+v, want none", manifest.Files)
+	}
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/amartani/oci-extract/internal/metrics"
+)
+
+// runMetrics collects what --metrics-out writes, nil unless it is given
+var runMetrics *metrics.Metrics
+
+// writeMetrics writes runMetrics to path. The file is replaced in one step,
+// as the Prometheus node exporter's textfile collector expects, so a
+// scrape never sees a partial file.
+func writeMetrics(path string, success bool) error {
+	var buf bytes.Buffer
+	if err := runMetrics.WriteText(&buf, success); err != nil {
+		return fmt.Errorf("failed to encode metrics: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".metrics-*")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/amartani/oci-extract/internal/output"
+)
+
+// pathEntry is a file to extract, given on the command line or listed in a
+// --paths-from file
+type pathEntry struct {
+	filePath   string
+	outputPath string // Where to write the file, empty for its path in the image
+	source     string // file:line of a listed path, empty for an argument
+}
+
+// readPathList reads the --paths-from file at name: a path per line,
+// optionally followed by a tab and the path to write the file to. Blank
+// lines and lines starting with # are skipped.
+func readPathList(name string) ([]pathEntry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open path list: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var entries []pathEntry
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		filePath, outputPath, _ := strings.Cut(text, "\t")
+		entry := pathEntry{
+			filePath:   strings.TrimSpace(filePath),
+			outputPath: strings.TrimSpace(outputPath),
+			source:     fmt.Sprintf("%s:%d", name, line),
+		}
+		if output.IsDirTarget(entry.filePath) {
+			return nil, fmt.Errorf("%s: only files can be listed, not directory %s", entry.source, entry.filePath)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read path list: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadPathList(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "list.txt")
+	content := "# config files\n/etc/passwd\n\n  /etc/group\t./group.txt  \r\n#/etc/shadow\n/etc/hosts\n"
+	if err := os.WriteFile(list, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write list: %v", err)
+	}
+
+	entries, err := readPathList(list)
+	if err != nil {
+		t.Fatalf("readPathList() error = %v", err)
+	}
+	want := []pathEntry{
+		{filePath: "/etc/passwd", source: list + ":2"},
+		{filePath: "/etc/group", outputPath: "./group.txt", source: list + ":4"},
+		{filePath: "/etc/hosts", source: list + ":6"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("readPathList() = %+v, want %+v", entries, want)
+	}
+
+	if err := os.WriteFile(list, []byte("/etc/passwd\n/etc/nginx/\n"), 0644); err != nil {
+		t.Fatalf("failed to write list: %v", err)
+	}
+	if _, err := readPathList(list); err == nil {
+		t.Error("readPathList() expected error for a directory, got nil")
+	}
+}
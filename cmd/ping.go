@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/tabwriter"
+
+	"github.com/amartani/oci-extract/internal/registry"
+	"github.com/spf13/cobra"
+)
+
+// pingCmd represents the ping command
+var pingCmd = &cobra.Command{
+	Use:   "ping <registry-or-image>",
+	Short: "Check connectivity and authentication with a registry",
+	Long: `Check that a registry answers on its /v2/ endpoint and accepts your
+credentials, before running an extraction.
+
+Given an image reference, the image's manifest is fetched as well, and its
+smallest layer is probed for range request support. Without range requests,
+eStargz, SOCI and zstd:chunked layers have to be downloaded in full. The host
+that serves the blob, after any redirect to a CDN, is also shown.
+
+Examples:
+  # Check a registry and the credentials configured for it
+  oci-extract ping ghcr.io
+
+  # Check that seekable extraction will work for an image
+  oci-extract ping ghcr.io/myorg/myimage:latest`,
+	Args: cobra.ExactArgs(1),
+	RunE: runPing,
+}
+
+func init() {
+	rootCmd.AddCommand(pingCmd)
+}
+
+func runPing(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	orch, err := newOrchestrator(cmd)
+	if err != nil {
+		return err
+	}
+
+	report, err := orch.Ping(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := writePingReport(os.Stdout, report); err != nil {
+		return err
+	}
+
+	if !report.OK() {
+		return errors.New("ping failed")
+	}
+	return nil
+}
+
+// writePingReport prints a line per check made by Ping
+func writePingReport(out io.Writer, report *registry.PingReport) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Registry:\t%s\n", report.Registry)
+
+	switch report.V2Status {
+	case http.StatusOK:
+		fmt.Fprintf(tw, "/v2/ endpoint:\tok, open to anonymous requests\n")
+	case http.StatusUnauthorized:
+		fmt.Fprintf(tw, "/v2/ endpoint:\tok, authentication required\n")
+	default:
+		fmt.Fprintf(tw, "/v2/ endpoint:\tunexpected status %d\n", report.V2Status)
+	}
+
+	credentials := "from the docker config or a credential helper"
+	if report.Anonymous {
+		credentials = "none found, using anonymous access"
+	}
+	fmt.Fprintf(tw, "Credentials:\t%s\n", credentials)
+	fmt.Fprintf(tw, "Authentication:\t%s\n", checkResult(report.AuthErr))
+
+	if report.Image != "" && report.AuthErr == nil {
+		fmt.Fprintf(tw, "Image:\t%s\n", report.Image)
+		fmt.Fprintf(tw, "Manifest:\t%s\n", checkResult(report.ImageErr))
+	}
+
+	if report.Blob != nil {
+		fmt.Fprintf(tw, "Sample blob:\t%s (%d bytes)\n", report.Blob.Digest, report.Blob.Size)
+		if report.BlobErr != nil {
+			fmt.Fprintf(tw, "Range requests:\t%s\n", checkResult(report.BlobErr))
+		} else {
+			fmt.Fprintf(tw, "Blob host:\t%s\n", report.BlobProbe.Host)
+			if report.BlobProbe.RangeSupported {
+				fmt.Fprintf(tw, "Range requests:\tsupported, seekable extraction will work\n")
+			} else {
+				fmt.Fprintf(tw, "Range requests:\tnot supported, layers will be downloaded in full\n")
+			}
+		}
+	}
+
+	return tw.Flush()
+}
+
+// checkResult describes the outcome of a single check
+func checkResult(err error) string {
+	if err != nil {
+		return fmt.Sprintf("failed: %v", err)
+	}
+	return "ok"
+}
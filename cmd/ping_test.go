@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/amartani/oci-extract/internal/registry"
+	"github.com/amartani/oci-extract/internal/remote"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestWritePingReport(t *testing.T) {
+	report := &registry.PingReport{
+		Registry: "ghcr.io",
+		V2Status: http.StatusUnauthorized,
+		Image:    "ghcr.io/myorg/myimage:latest",
+		Blob: &registry.EnhancedLayerInfo{
+			Digest: v1.Hash{Algorithm: "sha256", Hex: "abc123"},
+			Size:   1024,
+		},
+		BlobProbe: &remote.BlobProbe{Size: 1024, RangeSupported: true, Host: "pkg-containers.githubusercontent.com"},
+	}
+
+	var buf bytes.Buffer
+	if err := writePingReport(&buf, report); err != nil {
+		t.Fatalf("writePingReport() error = %v", err)
+	}
+
+	want := "Registry:        ghcr.io\n" +
+		"/v2/ endpoint:   ok, authentication required\n" +
+		"Credentials:     from the docker config or a credential helper\n" +
+		"Authentication:  ok\n" +
+		"Image:           ghcr.io/myorg/myimage:latest\n" +
+		"Manifest:        ok\n" +
+		"Sample blob:     sha256:abc123 (1024 bytes)\n" +
+		"Blob host:       pkg-containers.githubusercontent.com\n" +
+		"Range requests:  supported, seekable extraction will work\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if !report.OK() {
+		t.Error("OK() = false, want true")
+	}
+}
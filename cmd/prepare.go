@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/amartani/oci-extract/internal/detector"
+	"github.com/amartani/oci-extract/internal/extractor"
+	"github.com/spf13/cobra"
+)
+
+// defaultPlanPath is where prepare writes a plan and fetch reads it from
+const defaultPlanPath = ".ociextract-plan.json"
+
+var planOutput string
+
+// prepareCmd represents the prepare command
+var prepareCmd = &cobra.Command{
+	Use:   "prepare <image> <file-path>",
+	Short: "Plan extracting a file, to fetch it later with fetch",
+	Long: `Work out what extracting a file needs from the registry, and write it to a
+plan: the layer holding the file, its blob URL, and the byte ranges of the
+layer holding the file's content. The layer's eStargz TOC or SOCI zTOC is
+bundled into the plan.
+
+Running fetch with the plan then pulls only those ranges, without reading the
+image's manifest or index again. The plan can be reviewed, or prepared where
+the image's metadata is reachable and fetched through a narrower allowlist.
+
+Only eStargz and SOCI layers can be planned, as other layers can't be read
+in part. Every layer above the one holding the file needs a TOC or zTOC too.
+
+Examples:
+  # Plan, then fetch
+  oci-extract prepare ghcr.io/myorg/myimage:estargz /usr/bin/app
+  oci-extract fetch
+
+  # Write the plan elsewhere
+  oci-extract prepare myimage:latest /etc/config.yaml -o config-plan.json`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeImagePath,
+	RunE:              runPrepare,
+}
+
+func init() {
+	rootCmd.AddCommand(prepareCmd)
+
+	prepareCmd.Flags().StringVarP(&planOutput, "output", "o", defaultPlanPath, "Path to write the plan to")
+	prepareCmd.Flags().StringVar(&format, "format", "auto", "Force format: auto, estargz, soci")
+}
+
+func runPrepare(cmd *cobra.Command, args []string) error {
+	imageRef, err := imageReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	filePath := args[1]
+	ctx := cmd.Context()
+
+	var formatHint detector.Format
+	switch format {
+	case "estargz":
+		formatHint = detector.FormatEStargz
+	case "soci":
+		formatHint = detector.FormatSOCI
+	case "auto":
+		formatHint = detector.FormatUnknown
+	default:
+		return fmt.Errorf("invalid --format %q: only auto, estargz and soci can be planned", format)
+	}
+
+	orch, err := newOrchestrator(cmd)
+	if err != nil {
+		return err
+	}
+
+	plan, err := orch.Prepare(ctx, extractor.PrepareOptions{
+		ImageRef:    imageRef,
+		FilePath:    filePath,
+		ForceFormat: formatHint,
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	if err := os.WriteFile(planOutput, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+
+	fmt.Printf("Planned %s from layer %s (%s): %d ranges, %s to fetch\n",
+		filePath, plan.Layer, plan.Format, len(plan.Spans), formatBytes(plan.FetchSize()))
+	fmt.Printf("Wrote plan to %s\n", planOutput)
+	return nil
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// spinnerFrames are drawn in turn in front of the progress status
+var spinnerFrames = []string{"|", "/", "-", `\`}
+
+// spinnerInterval is how often the spinner advances
+const spinnerInterval = 100 * time.Millisecond
+
+// layerProgress draws a spinner on a single, continuously rewritten line
+// showing which layer is being processed
+type layerProgress struct {
+	out io.Writer
+
+	mu     sync.Mutex
+	status string
+	frame  int
+	drawn  bool // The line currently holds a status that must be cleared
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// newLayerProgress starts drawing progress to out until Stop is called
+func newLayerProgress(out io.Writer) *layerProgress {
+	p := &layerProgress{
+		out:     out,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *layerProgress) run() {
+	defer close(p.stopped)
+
+	ticker := time.NewTicker(spinnerInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			p.frame++
+			p.draw()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// Layer updates the status to the layer being processed, n out of total
+func (p *layerProgress) Layer(n, total int, digest v1.Hash) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.status = fmt.Sprintf("layer %d/%d, %s", n, total, digest)
+	p.draw()
+}
+
+// Suspend clears the progress line while fn runs, so output written to the
+// same terminal isn't interleaved with it. It is redrawn on the next tick.
+func (p *layerProgress) Suspend(fn func() error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clear()
+	return fn()
+}
+
+// Stop stops drawing and clears the progress line
+func (p *layerProgress) Stop() {
+	close(p.stop)
+	<-p.stopped
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+}
+
+// draw rewrites the progress line; p.mu must be held
+func (p *layerProgress) draw() {
+	if p.status == "" {
+		return
+	}
+	fmt.Fprintf(p.out, "\r\033[K%s %s", spinnerFrames[p.frame%len(spinnerFrames)], p.status)
+	p.drawn = true
+}
+
+// clear erases the progress line if anything is drawn on it; p.mu must be held
+func (p *layerProgress) clear() {
+	if !p.drawn {
+		return
+	}
+	fmt.Fprint(p.out, "\r\033[K")
+	p.drawn = false
+}
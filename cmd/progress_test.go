@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestLayerProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := newLayerProgress(&buf)
+
+	p.Layer(3, 12, v1.Hash{Algorithm: "sha256", Hex: "abc123"})
+	written := false
+	if err := p.Suspend(func() error { written = true; return nil }); err != nil {
+		t.Fatalf("Suspend() error = %v", err)
+	}
+	p.Stop()
+
+	if !written {
+		t.Error("Suspend() did not run its function")
+	}
+
+	got := buf.String()
+	if !strings.Contains(got, "layer 3/12, sha256:abc123") {
+		t.Errorf("output = %q, want the layer status", got)
+	}
+	if !strings.HasSuffix(got, "\r\033[K") {
+		t.Errorf("output = %q, want the line cleared after Stop", got)
+	}
+}
+
+func TestLayerProgressNothingDrawn(t *testing.T) {
+	var buf bytes.Buffer
+	p := newLayerProgress(&buf)
+	p.Stop()
+
+	if buf.Len() != 0 {
+		t.Errorf("output = %q, want nothing before the first layer", buf.String())
+	}
+}
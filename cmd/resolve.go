@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// resolveCmd represents the resolve command
+var resolveCmd = &cobra.Command{
+	Use:   "resolve <image>",
+	Short: "Print the digest reference an image tag points to",
+	Long: `Resolve an image reference to its immutable digest form, e.g.
+alpine:latest to alpine@sha256:..., without downloading any layers.
+
+By default the digest of the manifest the tag points to is printed, which is
+the multi-platform index for multi-arch images. Use --platform to get the
+digest of a single platform's manifest instead, or --manifest-annotation to
+pick a manifest by its annotations.
+
+Examples:
+  # Pin a tag for a reproducible pipeline
+  oci-extract resolve alpine:latest
+
+  # Resolve the arm64 image of a multi-arch tag
+  oci-extract resolve alpine:latest --platform linux/arm64
+
+  # Resolve the variant of an index annotated with a ref name
+  oci-extract resolve myimage:latest --manifest-annotation org.opencontainers.image.ref.name=slim`,
+	Args: cobra.ExactArgs(1),
+	RunE: runResolve,
+}
+
+func init() {
+	rootCmd.AddCommand(resolveCmd)
+}
+
+func runResolve(cmd *cobra.Command, args []string) error {
+	imageRef, err := imageReference(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+
+	orch, err := newOrchestrator(cmd)
+	if err != nil {
+		return err
+	}
+
+	pinned, err := orch.Resolve(ctx, imageRef)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(pinned)
+	return nil
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amartani/oci-extract/internal/detector"
+	"github.com/amartani/oci-extract/internal/extractor"
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{err: fmt.Errorf("file /etc/missing %w", extractor.ErrNotFound), want: exitNotFound},
+		{err: fmt.Errorf("file /etc/os-release: %w", output.ErrNoMatch), want: exitNoMatch},
+		{err: fmt.Errorf("failed to get image layers: %w", errors.New("unauthorized")), want: exitError},
+		{
+			err:  fmt.Errorf("failed to extract 2 of 3 files:\n%w", errors.Join(fmt.Errorf("file /a %w", extractor.ErrNotFound), fmt.Errorf("file /b: %w", output.ErrNoMatch))),
+			want: exitNotFound,
+		},
+		{
+			err:  fmt.Errorf("failed to extract 2 of 3 files:\n%w", errors.Join(fmt.Errorf("file /a %w", extractor.ErrNotFound), errors.New("unauthorized"))),
+			want: exitError,
+		},
+		{
+			err:  fmt.Errorf("%w: %w", errInterrupted, fmt.Errorf("failed to extract 1 of 2 files:\n%w", errors.Join(fmt.Errorf("file /a %w", extractor.ErrNotFound)))),
+			want: exitInterrupted,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestExitCodeLayerFetchFailure tests that a layer that can't be fetched
+// isn't reported as the file missing from the image
+func TestExitCodeLayerFetchFailure(t *testing.T) {
+	handler := ggcrregistry.New()
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("failed to build image: %v", err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatalf("failed to get layers: %v", err)
+	}
+	layerDigest, err := layers[0].Digest()
+	if err != nil {
+		t.Fatalf("failed to get layer digest: %v", err)
+	}
+
+	// Every request for the layer blob is refused
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/blobs/"+layerDigest.String()) && r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	_, err = extractor.NewOrchestrator(false).Extract(context.Background(), extractor.ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "/etc/missing",
+		OutputPath: filepath.Join(t.TempDir(), "missing"),
+	})
+	if err == nil {
+		t.Fatal("Extract() expected error, got nil")
+	}
+	if got := exitCode(err); got == exitNotFound {
+		t.Errorf("exitCode(%v) = %d, want anything but %d", err, got, exitNotFound)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    detector.Format
+		wantErr bool
+	}{
+		{name: "auto", want: detector.FormatUnknown},
+		{name: "estargz", want: detector.FormatEStargz},
+		{name: "soci", want: detector.FormatSOCI},
+		{name: "standard", want: detector.FormatStandard},
+		{name: "esgz", wantErr: true},
+		{name: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseFormat(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFormat(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseFormat(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
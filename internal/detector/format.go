@@ -1,6 +1,7 @@
 package detector
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 
@@ -48,71 +49,70 @@ func (f Format) String() string {
 	}
 }
 
-// DetectFormat determines the format of an OCI layer
+// DetectFormat determines the format of an OCI layer from its media type,
+// without reading the layer. Media types tell zstd from gzip layers, but not
+// whether a zstd layer is zstd:chunked or a gzip layer is eStargz, so zstd
+// layers detect as FormatZstd and gzip layers as FormatStandard; Candidates
+// lists the formats such a layer may actually be in.
 func DetectFormat(ctx context.Context, layer v1.Layer) (Format, error) {
-	// Check media type first
 	mediaType, err := layer.MediaType()
 	if err != nil {
 		return FormatUnknown, fmt.Errorf("failed to get media type: %w", err)
 	}
 
-	mt := string(mediaType)
-
-	// Check for zstd compression based on media type
-	if mt == "application/vnd.oci.image.layer.v1.tar+zstd" ||
-		mt == "application/vnd.docker.image.rootfs.diff.tar.zstd" {
-		// Could be either standard zstd or zstd:chunked
-		// Try to detect if it has a chunked footer (similar to eStargz)
-		// For now, return FormatZstd and let the orchestrator try chunked first
+	switch string(mediaType) {
+	case "application/vnd.oci.image.layer.v1.tar+zstd",
+		"application/vnd.docker.image.rootfs.diff.tar.zstd":
 		return FormatZstd, nil
-	}
-
-	// Check for eStargz footer
-	// eStargz layers have a magic footer at the end
-	hasEStargzFooter, err := checkEStargzFooter(layer)
-	if err == nil && hasEStargzFooter {
-		return FormatEStargz, nil
-	}
-
-	// Check annotations for SOCI
-	// SOCI layers are typically standard layers but with associated indices
-	// We'd need to check if a SOCI index exists for this layer
-	// For now, we'll check media type hints
-	if mt == "application/vnd.oci.image.layer.v1.tar+gzip" ||
-		mt == "application/vnd.docker.image.rootfs.diff.tar.gzip" {
-		// Could be either eStargz or standard
-		// Default to standard if no eStargz footer
+	case "application/vnd.oci.image.layer.v1.tar+gzip",
+		"application/vnd.docker.image.rootfs.diff.tar.gzip":
 		return FormatStandard, nil
 	}
 
 	return FormatUnknown, nil
 }
 
-// checkEStargzFooter checks if a layer has the eStargz magic footer
-func checkEStargzFooter(layer v1.Layer) (bool, error) {
-	// Get compressed reader
-	rc, err := layer.Compressed()
-	if err != nil {
-		return false, err
-	}
-	defer func() { _ = rc.Close() }()
+// MagicLen is how many leading bytes of a layer Sniff needs
+const MagicLen = 4
 
-	// The eStargz footer is in the last 47 bytes
-	// We'd need to seek to the end, but rc is just an io.ReadCloser
-	// In a real implementation, we'd use a ReaderAt or convert to one
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
 
-	// For now, let's check the size and attempt to read
-	size, err := layer.Size()
-	if err != nil {
-		return false, err
+// Sniff identifies a layer's compression from its first bytes, for layers
+// whose media type doesn't match their content. Like DetectFormat, it
+// returns FormatStandard for gzip and FormatZstd for zstd, and FormatUnknown
+// for anything else, such as an uncompressed tar.
+func Sniff(header []byte) Format {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return FormatStandard
+	case bytes.HasPrefix(header, zstdMagic):
+		return FormatZstd
+	default:
+		return FormatUnknown
 	}
+}
 
-	// If layer is too small, it can't have an eStargz footer
-	if size < 47 {
-		return false, nil
-	}
+// Seekable reports whether layers in format f can be read in part, through
+// a TOC or zTOC, rather than downloaded in full
+func (f Format) Seekable() bool {
+	return f == FormatEStargz || f == FormatSOCI || f == FormatZstdChunked
+}
 
-	// We'd need to read the last 47 bytes and check for the magic number
-	// This is a simplified check - real implementation would need proper seeking
-	return false, nil
+// Candidates returns the formats a layer detected as f may be in, in the
+// order extraction tries them: seekable formats first, then downloading the
+// whole layer. Formats the layer's compression rules out are left out, so
+// e.g. eStargz is never attempted on a zstd layer.
+func Candidates(f Format) []Format {
+	switch f {
+	case FormatStandard, FormatEStargz, FormatSOCI:
+		// gzip: eStargz and SOCI-indexed layers are valid plain gzip layers
+		return []Format{FormatEStargz, FormatSOCI, FormatStandard}
+	case FormatZstd, FormatZstdChunked:
+		return []Format{FormatZstdChunked, FormatZstd}
+	default:
+		return []Format{FormatEStargz, FormatSOCI, FormatZstdChunked, FormatZstd, FormatStandard}
+	}
 }
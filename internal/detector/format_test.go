@@ -0,0 +1,66 @@
+package detector
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		mediaType types.MediaType
+		want      Format
+	}{
+		{mediaType: types.OCILayerZStd, want: FormatZstd},
+		{mediaType: types.OCILayer, want: FormatStandard},
+		{mediaType: types.DockerLayer, want: FormatStandard},
+		{mediaType: types.OCIUncompressedLayer, want: FormatUnknown},
+	}
+
+	for _, tt := range tests {
+		got, err := DetectFormat(context.Background(), static.NewLayer([]byte("data"), tt.mediaType))
+		if err != nil {
+			t.Errorf("DetectFormat(%s) error = %v", tt.mediaType, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("DetectFormat(%s) = %s, want %s", tt.mediaType, got, tt.want)
+		}
+	}
+}
+
+// TestCandidates tests that formats the compression rules out aren't tried
+func TestCandidates(t *testing.T) {
+	if got := Candidates(FormatZstd); slices.Contains(got, FormatEStargz) || slices.Contains(got, FormatStandard) {
+		t.Errorf("Candidates(zstd) = %v, want only zstd formats", got)
+	}
+	if got := Candidates(FormatStandard); slices.Contains(got, FormatZstd) || got[0] != FormatEStargz {
+		t.Errorf("Candidates(standard) = %v, want gzip formats starting with eStargz", got)
+	}
+	if got := Candidates(FormatUnknown); len(got) != 5 || got[len(got)-1] != FormatStandard {
+		t.Errorf("Candidates(unknown) = %v, want every format ending with standard", got)
+	}
+}
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   Format
+	}{
+		{name: "gzip", header: []byte{0x1f, 0x8b, 0x08, 0x00}, want: FormatStandard},
+		{name: "zstd", header: []byte{0x28, 0xb5, 0x2f, 0xfd}, want: FormatZstd},
+		{name: "tar", header: []byte("etc/"), want: FormatUnknown},
+		{name: "short", header: []byte{0x28, 0xb5}, want: FormatUnknown},
+		{name: "empty", header: nil, want: FormatUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := Sniff(tt.header); got != tt.want {
+			t.Errorf("Sniff(%s) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,21 +2,31 @@ package estargz
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"time"
 
-	"github.com/amartani/oci-extract/internal/pathutil"
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/amartani/oci-extract/internal/remote"
 	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/opencontainers/go-digest"
 )
 
+// TOCDigestAnnotation is the layer descriptor annotation recording the digest
+// of an eStargz layer's TOC
+const TOCDigestAnnotation = estargz.TOCJSONDigestAnnotation
+
 // Extractor handles file extraction from eStargz layers
 type Extractor struct {
-	reader io.ReaderAt
-	size   int64
+	reader     io.ReaderAt
+	size       int64
+	outputOpts output.Options
+	tocDigest  digest.Digest
+	tocOnly    bool
 }
 
 // NewExtractor creates a new eStargz extractor
@@ -27,21 +37,57 @@ func NewExtractor(reader io.ReaderAt, size int64) *Extractor {
 	}
 }
 
-// ExtractFile extracts a specific file from an eStargz layer
-func (e *Extractor) ExtractFile(ctx context.Context, targetPath string, outputPath string) error {
+// SetOutputOptions configures how extracted files are written
+func (e *Extractor) SetOutputOptions(opts output.Options) {
+	e.outputOpts = opts
+}
+
+// SetTOCDigest makes ExtractFile check the layer's TOC against tocDigest, as
+// recorded in its TOCDigestAnnotation, before trusting it. An empty digest
+// skips the check.
+func (e *Extractor) SetTOCDigest(tocDigest string) {
+	e.tocDigest = digest.Digest(tocDigest)
+}
+
+// SetTOCOnly makes ListEntries fail when the layer's TOC can't be read,
+// rather than falling back to reading the whole layer as a tar.gz
+func (e *Extractor) SetTOCOnly(tocOnly bool) {
+	e.tocOnly = tocOnly
+}
+
+// open opens the layer's TOC, verified when a TOC digest is set
+func (e *Extractor) open() (*estargz.Reader, error) {
 	// Convert ReaderAt to SectionReader
 	sr := io.NewSectionReader(e.reader, 0, e.size)
 
 	// Open the eStargz reader
 	r, err := estargz.Open(sr)
 	if err != nil {
-		return fmt.Errorf("failed to open estargz: %w", err)
+		return nil, fmt.Errorf("failed to open estargz: %w", err)
+	}
+	if e.tocDigest != "" {
+		if _, err := r.VerifyTOC(e.tocDigest); err != nil {
+			return nil, fmt.Errorf("failed to verify estargz TOC: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// ExtractFile extracts a specific file from an eStargz layer
+func (e *Extractor) ExtractFile(ctx context.Context, targetPath string, outputPath string) error {
+	r, err := e.open()
+	if err != nil {
+		return err
 	}
 
 	// Lookup the file in the TOC
-	_, ok := r.Lookup(targetPath)
+	entry, ok := r.Lookup(targetPath)
 	if !ok {
-		return fmt.Errorf("file %s not found in layer TOC", targetPath)
+		return fmt.Errorf("file %s not found in layer TOC: %w", targetPath, fs.ErrNotExist)
+	}
+	md := output.MetadataFromTOCEntry(entry)
+	if err := output.CheckRegularFile(targetPath, md); err != nil {
+		return err
 	}
 
 	// Open the file from the eStargz layer
@@ -50,36 +96,121 @@ func (e *Extractor) ExtractFile(ctx context.Context, targetPath string, outputPa
 		return fmt.Errorf("failed to open file %s: %w", targetPath, err)
 	}
 
-	// Create output directory if needed
-	outputDir := filepath.Dir(outputPath)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	// Write the file contents
+	if err := e.outputOpts.WriteFile(outputPath, e.outputOpts.Section(fileReader, fileReader.Size())); err != nil {
+		return err
+	}
+
+	output.ApplyMetadata(outputPath, md, e.outputOpts)
+	return nil
+}
+
+// Ranges returns the compressed range of the layer ExtractFile reads for
+// targetPath besides the TOC, so it can be fetched ahead of the extraction.
+// The file's chunks are read as one range, since every read of the file
+// buffers ahead up to the end of its last chunk. A file the TOC doesn't list
+// returns an error wrapping fs.ErrNotExist.
+func (e *Extractor) Ranges(targetPath string) ([]remote.Range, error) {
+	r, err := e.open()
+	if err != nil {
+		return nil, err
+	}
+
+	entry, ok := r.Lookup(targetPath)
+	if !ok {
+		return nil, fmt.Errorf("file %s not found in layer TOC: %w", targetPath, fs.ErrNotExist)
+	}
+	if err := output.CheckRegularFile(targetPath, output.MetadataFromTOCEntry(entry)); err != nil {
+		return nil, err
+	}
+
+	start, length := int64(0), entry.Size
+	if e.outputOpts.Range != nil {
+		start, length = e.outputOpts.Range.Clamp(entry.Size)
+	}
+	if length == 0 {
+		return nil, nil
+	}
+
+	first, ok := r.ChunkEntryForOffset(targetPath, start)
+	if !ok {
+		return nil, fmt.Errorf("estargz TOC has no chunk of %s at offset %d", targetPath, start)
+	}
+	last, ok := r.ChunkEntryForOffset(targetPath, entry.Size-1)
+	if !ok {
+		return nil, fmt.Errorf("estargz TOC has no chunk of %s at offset %d", targetPath, entry.Size-1)
 	}
 
-	// Create output file
-	outFile, err := os.Create(outputPath)
+	return []remote.Range{{Offset: first.Offset, Length: last.NextOffset() - first.Offset}}, nil
+}
+
+// TOCRange returns the range at the end of the layer holding its TOC and
+// footer, which is all ExtractFile reads besides the file's Ranges
+func (e *Extractor) TOCRange() (remote.Range, error) {
+	tocOffset, _, err := estargz.OpenFooter(io.NewSectionReader(e.reader, 0, e.size))
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return remote.Range{}, fmt.Errorf("failed to read estargz footer: %w", err)
 	}
-	defer func() { _ = outFile.Close() }()
 
-	// Copy the file contents
-	_, err = io.Copy(outFile, fileReader)
+	// estargz.Open reads the footer of the largest format it knows first,
+	// which may start before a small TOC
+	start := max(min(tocOffset, e.size-estargz.FooterSize), 0)
+	return remote.Range{Offset: start, Length: e.size - start}, nil
+}
+
+// FindByDigest returns the paths, normalized for display, of the regular
+// files whose content has digest d, from the per-file digests in the TOC.
+// Nothing but the TOC is read.
+func (e *Extractor) FindByDigest(ctx context.Context, d digest.Digest) ([]string, error) {
+	r, err := e.open()
 	if err != nil {
-		return fmt.Errorf("failed to copy file contents: %w", err)
+		return nil, err
 	}
 
-	return nil
+	root, ok := r.Lookup("")
+	if !ok {
+		return nil, fmt.Errorf("layer TOC has no root directory")
+	}
+
+	var paths []string
+	var walk func(dir *estargz.TOCEntry)
+	walk = func(dir *estargz.TOCEntry) {
+		dir.ForeachChild(func(_ string, entry *estargz.TOCEntry) bool {
+			switch {
+			case entry.Type == "dir":
+				walk(entry)
+			case entry.Type == "reg" && entry.Digest == d.String():
+				paths = append(paths, output.DisplayPath(entry.Name))
+			}
+			return true
+		})
+	}
+	walk(root)
+
+	return paths, nil
 }
 
 // ListFiles lists all files in an eStargz layer
 func (e *Extractor) ListFiles(ctx context.Context) ([]string, error) {
-	// eStargz TOC doesn't expose a public API to iterate all entries
-	// (the children field is unexported). Since eStargz is backward-compatible
-	// with tar.gz, we fall back to reading it as a standard tar archive.
-	// This is less efficient than using the TOC but works correctly.
+	entries, err := e.ListEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return output.Paths(output.RegularFiles(entries)), nil
+}
 
-	// Convert ReaderAt to SectionReader
+// ListEntries lists the metadata of all entries in an eStargz layer, of any
+// type, from its TOC. Only the footer and the TOC are read. A layer whose TOC
+// can't be read is listed as a standard tar.gz instead, unless SetTOCOnly
+// was set.
+func (e *Extractor) ListEntries(ctx context.Context) ([]output.Metadata, error) {
+	entries, err := e.listTOC()
+	if err == nil || e.tocOnly {
+		return entries, err
+	}
+
+	// eStargz is backward-compatible with tar.gz, so the tar stream still
+	// lists the layer, at the cost of reading all of it
 	sr := io.NewSectionReader(e.reader, 0, e.size)
 
 	// Create gzip reader
@@ -87,29 +218,50 @@ func (e *Extractor) ListFiles(ctx context.Context) ([]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
 	}
+	// Every chunk of an eStargz layer is its own gzip member
+	gzipReader.Multistream(true)
 	defer func() { _ = gzipReader.Close() }()
 
-	// Create tar reader
-	tarReader := tar.NewReader(gzipReader)
+	return output.ListEntries(tar.NewReader(gzipReader))
+}
+
+// listTOC lists the entries of the layer's TOC, found through the offset
+// in its footer and fetched in a single read
+func (e *Extractor) listTOC() ([]output.Metadata, error) {
+	tocOffset, footerSize, err := estargz.OpenFooter(io.NewSectionReader(e.reader, 0, e.size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read estargz footer: %w", err)
+	}
+	tocEnd := e.size - footerSize
+	if tocOffset < 0 || tocOffset > tocEnd {
+		return nil, fmt.Errorf("estargz TOC offset %d is outside the layer", tocOffset)
+	}
 
-	var files []string
+	tocBlob := make([]byte, tocEnd-tocOffset)
+	if _, err := e.reader.ReadAt(tocBlob, tocOffset); err != nil {
+		return nil, fmt.Errorf("failed to read estargz TOC: %w", err)
+	}
+	toc, tocDigest, err := new(estargz.GzipDecompressor).ParseTOC(bytes.NewReader(tocBlob))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse estargz TOC: %w", err)
+	}
+	if e.tocDigest != "" && tocDigest != e.tocDigest {
+		return nil, fmt.Errorf("estargz TOC digest %s doesn't match %s", tocDigest, e.tocDigest)
+	}
 
-	// Iterate through tar archive
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to read tar entry: %w", err)
+	var entries []output.Metadata
+	for _, entry := range toc.Entries {
+		// Chunks are further pieces of the regular file before them
+		if entry.Type == "chunk" || output.IsRootEntry(entry.Name) || output.IsStargzInternal(entry.Name) {
+			continue
 		}
 
-		// Only include regular files
-		if header.Typeflag == tar.TypeReg {
-			// Normalize path for consistent display (ensure leading slash)
-			files = append(files, pathutil.NormalizeForDisplay(header.Name))
-		}
+		md := output.MetadataFromTOCEntry(entry)
+		md.Path = output.DisplayPath(entry.Name)
+		// estargz.Open parses modification times, but the raw TOC only has
+		// them as text
+		md.ModTime, _ = time.Parse(time.RFC3339, entry.ModTime3339)
+		entries = append(entries, md)
 	}
-
-	return files, nil
+	return entries, nil
 }
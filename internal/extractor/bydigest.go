@@ -0,0 +1,120 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/amartani/oci-extract/internal/detector"
+	"github.com/amartani/oci-extract/internal/estargz"
+	"github.com/amartani/oci-extract/internal/registry"
+	"github.com/amartani/oci-extract/internal/standard"
+	"github.com/amartani/oci-extract/internal/zstd"
+	"github.com/opencontainers/go-digest"
+)
+
+// errNoFileDigests is returned for layers whose metadata records no file
+// digests, when hashing their files wasn't allowed
+var errNoFileDigests = errors.New("layer records no file digests")
+
+// resolveDigest finds the file opts.FilePath identifies by the digest of its
+// content: a regular file with that digest in the topmost layer holding one.
+// eStargz TOCs record the digest of every file; other layers are only
+// searched with HashFiles, by reading them in full and hashing each file.
+func (o *Orchestrator) resolveDigest(ctx context.Context, enhancedLayers []*registry.EnhancedLayerInfo, opts ExtractOptions) (string, error) {
+	d, err := digest.Parse(opts.FilePath)
+	if err != nil {
+		return "", fmt.Errorf("invalid file digest %q: %w", opts.FilePath, err)
+	}
+
+	unsearched := 0
+	for i := len(enhancedLayers) - 1; i >= 0; i-- {
+		layerInfo := enhancedLayers[i]
+		if o.skipEmptyLayer(layerInfo) {
+			continue
+		}
+
+		paths, err := o.findByDigest(ctx, layerInfo, d, opts)
+		if abortsLayerSearch(err) {
+			return "", err
+		}
+		if errors.Is(err, errNoFileDigests) {
+			unsearched++
+		}
+		if err != nil {
+			if o.verbose {
+				fmt.Fprintf(o.log, "  Failed to search layer %s: %v\n", layerInfo.Digest, err)
+			}
+			continue
+		}
+		if len(paths) == 0 {
+			continue
+		}
+
+		// Files with the same content are interchangeable, so any will do
+		slices.Sort(paths)
+		if o.verbose {
+			fmt.Fprintf(o.log, "Found %s at %s in layer %s\n", d, paths[0], layerInfo.Digest)
+		}
+		return paths[0], nil
+	}
+
+	if unsearched > 0 {
+		return "", fmt.Errorf("file with digest %s %w with a TOC (%d layers without one weren't searched; pass --hash-files to search them)", d, ErrNotFound, unsearched)
+	}
+	return "", fmt.Errorf("file with digest %s %w", d, ErrNotFound)
+}
+
+// findByDigest returns the paths of the regular files in a layer whose
+// content has digest d: from its eStargz TOC when it has one, otherwise by
+// hashing every file if opts.HashFiles allows it
+func (o *Orchestrator) findByDigest(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, d digest.Digest, opts ExtractOptions) ([]string, error) {
+	format := opts.ForceFormat
+	formats := []detector.Format{format}
+	if format == detector.FormatUnknown {
+		var err error
+		format, err = o.detectFormat(ctx, layerInfo)
+		if err != nil && o.verbose {
+			fmt.Fprintf(o.log, "  Format detection failed: %v, trying every format\n", err)
+		}
+		formats = o.candidates(format)
+	}
+
+	if slices.Contains(formats, detector.FormatEStargz) {
+		paths, err := o.findEStargzDigest(ctx, layerInfo, d)
+		if err == nil || errors.Is(err, ErrLayerDigestMismatch) {
+			return paths, err
+		}
+		if o.verbose {
+			fmt.Fprintf(o.log, "  eStargz TOC search failed: %v\n", err)
+		}
+	}
+
+	if !opts.HashFiles {
+		return nil, errNoFileDigests
+	}
+
+	if o.verbose {
+		fmt.Fprintf(o.log, "  Hashing the files of layer %s...\n", layerInfo.Digest)
+	}
+	if slices.Contains(formats, detector.FormatZstd) {
+		extractor := zstd.NewExtractor(o.wholeLayer(layerInfo))
+		extractor.SetWindowLogMax(o.zstdWindowLogMax)
+		return extractor.FindByDigest(ctx, d)
+	}
+	return standard.NewExtractor(o.wholeLayer(layerInfo)).FindByDigest(ctx, d)
+}
+
+// findEStargzDigest looks d up in the per-file digests of an eStargz TOC
+func (o *Orchestrator) findEStargzDigest(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, d digest.Digest) ([]string, error) {
+	reader, err := o.newLayerReader(ctx, layerInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open layer blob: %w", err)
+	}
+	defer o.closeLayerReader(reader)
+
+	extractor := estargz.NewExtractor(reader, layerInfo.Size)
+	extractor.SetTOCDigest(layerInfo.Annotations[estargz.TOCDigestAnnotation])
+	return extractor.FindByDigest(ctx, d)
+}
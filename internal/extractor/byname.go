@@ -0,0 +1,67 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/amartani/oci-extract/internal/registry"
+	"github.com/amartani/oci-extract/internal/soci"
+)
+
+// ErrAmbiguousName is returned when a file name given with ByName matches
+// several files in the topmost layer holding any of them
+var ErrAmbiguousName = errors.New("matches several files; give the full path instead")
+
+// resolveName finds the file opts.FilePath names: the regular file with that
+// base name in the topmost layer holding one. Layers are listed to find it,
+// which for layers without a TOC or zTOC means reading them in full.
+func (o *Orchestrator) resolveName(ctx context.Context, enhancedLayers []*registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo, opts ExtractOptions) (string, error) {
+	name := opts.FilePath
+	if name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid file name %q: matching by name takes a base name without slashes", name)
+	}
+
+	for i := len(enhancedLayers) - 1; i >= 0; i-- {
+		layerInfo := enhancedLayers[i]
+		if o.skipEmptyLayer(layerInfo) {
+			continue
+		}
+
+		entries, err := o.listFromLayer(ctx, layerInfo, sociIndex, ListOptions{ForceFormat: opts.ForceFormat})
+		if abortsLayerSearch(err) {
+			return "", err
+		}
+		if err != nil {
+			if o.verbose {
+				fmt.Fprintf(o.log, "  Failed to list files in layer %s: %v\n", layerInfo.Digest, err)
+			}
+			continue
+		}
+
+		var matches []string
+		for _, md := range entries {
+			if path.Base(md.Path) == name {
+				matches = append(matches, md.Path)
+			}
+		}
+
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			if o.verbose {
+				fmt.Fprintf(o.log, "Found %s at %s in layer %s\n", name, matches[0], layerInfo.Digest)
+			}
+			return matches[0], nil
+		default:
+			slices.Sort(matches)
+			return "", fmt.Errorf("%s %w: %s", name, ErrAmbiguousName, strings.Join(matches, ", "))
+		}
+	}
+
+	return "", fmt.Errorf("file named %s %w", name, ErrNotFound)
+}
@@ -0,0 +1,192 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/amartani/oci-extract/internal/detector"
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/amartani/oci-extract/internal/registry"
+	"github.com/amartani/oci-extract/internal/soci"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// CompareOptions contains options for comparing extraction methods
+type CompareOptions struct {
+	ImageRef string
+	FilePath string
+}
+
+// CompareResult is the outcome of extracting a file with a single format's method
+type CompareResult struct {
+	Format   detector.Format
+	Duration time.Duration
+	Size     int64
+
+	// Digest is the sha256 of the extracted bytes, empty when Err is set
+	Digest string
+	Err    error
+}
+
+// CompareReport holds the timing of every method applicable to the layer a
+// file was found in
+type CompareReport struct {
+	Layer    v1.Hash
+	Detected detector.Format
+	Results  []CompareResult
+}
+
+// Identical reports whether at least one method succeeded and every
+// successful method produced the same bytes
+func (r *CompareReport) Identical() bool {
+	digest := ""
+	for _, result := range r.Results {
+		if result.Err != nil {
+			continue
+		}
+		if digest != "" && result.Digest != digest {
+			return false
+		}
+		digest = result.Digest
+	}
+	return digest != ""
+}
+
+// Selected returns the method extract uses for the file: the first one that
+// succeeded, since methods are tried in the same order
+func (r *CompareReport) Selected() (detector.Format, bool) {
+	for _, result := range r.Results {
+		if result.Err == nil {
+			return result.Format, true
+		}
+	}
+	return detector.FormatUnknown, false
+}
+
+// Compare finds the topmost layer containing a file, then extracts the file
+// from that layer once with each applicable format's method, timing each run
+// and hashing its output
+func (o *Orchestrator) Compare(ctx context.Context, opts CompareOptions) (*CompareReport, error) {
+	if output.IsDirTarget(opts.FilePath) {
+		return nil, fmt.Errorf("comparing extraction methods is only supported for files")
+	}
+
+	enhancedLayers, err := o.getLayers(ctx, opts.ImageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	sociIndex, err := o.discoverSOCIIndex(ctx, opts.ImageRef, detector.FormatUnknown)
+	if err != nil {
+		return nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "oci-extract-compare-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tempDir) }()
+
+	// Locate the file the same way extract does
+	var layerInfo *registry.EnhancedLayerInfo
+	var lastErr error
+	for i := len(enhancedLayers) - 1; i >= 0; i-- {
+		if o.skipEmptyLayer(enhancedLayers[i]) {
+			continue
+		}
+
+		if o.verbose {
+			fmt.Fprintf(o.log, "Checking layer %s...\n", enhancedLayers[i].Digest)
+		}
+
+		format, err := o.extractFromLayer(ctx, enhancedLayers[i], sociIndex, ExtractOptions{
+			FilePath:   opts.FilePath,
+			OutputPath: filepath.Join(tempDir, "auto"),
+		})
+		if abortsLayerSearch(err) {
+			return nil, err
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			lastErr = err
+		}
+		if err == nil && format != detector.FormatUnknown {
+			layerInfo = enhancedLayers[i]
+			break
+		}
+	}
+	if layerInfo == nil && lastErr != nil {
+		return nil, fmt.Errorf("failed to extract %s: %w", opts.FilePath, lastErr)
+	}
+	if layerInfo == nil {
+		return nil, fmt.Errorf("file %s %w", opts.FilePath, ErrNotFound)
+	}
+
+	detected, err := o.detectFormat(ctx, layerInfo)
+	if err != nil {
+		detected = detector.FormatUnknown
+	}
+
+	report := &CompareReport{Layer: layerInfo.Digest, Detected: detected}
+	for _, format := range compareFormats(o.candidates(detected), sociIndex != nil) {
+		if o.verbose {
+			fmt.Fprintf(o.log, "Extracting with %s...\n", format)
+		}
+
+		outputPath := filepath.Join(tempDir, strings.ReplaceAll(format.String(), ":", "-"))
+		extractOpts := ExtractOptions{FilePath: opts.FilePath, OutputPath: outputPath}
+
+		result := CompareResult{Format: format}
+		start := time.Now()
+		err := o.extractWithFormat(ctx, format, layerInfo, sociIndex, extractOpts)
+		result.Duration = time.Since(start)
+		if err == nil {
+			result.Size, result.Digest, err = output.HashFile(outputPath)
+		}
+		result.Err = err
+
+		report.Results = append(report.Results, result)
+	}
+
+	return report, nil
+}
+
+// compareFormats returns the methods to time out of the candidate formats
+// for a layer, in the order extract tries them. SOCI only applies when the
+// image has an index.
+func compareFormats(candidates []detector.Format, hasSOCI bool) []detector.Format {
+	var formats []detector.Format
+	for _, format := range candidates {
+		if format == detector.FormatSOCI && !hasSOCI {
+			continue
+		}
+		formats = append(formats, format)
+	}
+	return formats
+}
+
+// extractWithFormat extracts a file from a layer using only the method for
+// format, without falling back to other methods
+func (o *Orchestrator) extractWithFormat(ctx context.Context, format detector.Format, layerInfo *registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo, opts ExtractOptions) error {
+	var err error
+	switch format {
+	case detector.FormatEStargz:
+		_, err = o.extractEStargz(ctx, layerInfo, opts)
+	case detector.FormatSOCI:
+		_, err = o.extractSOCI(ctx, layerInfo, sociIndex, opts)
+	case detector.FormatZstdChunked:
+		_, err = o.extractZstdChunked(ctx, layerInfo, opts)
+	case detector.FormatZstd:
+		_, err = o.extractZstd(ctx, layerInfo, opts)
+	case detector.FormatStandard:
+		_, err = o.extractStandard(ctx, layerInfo, opts)
+	default:
+		err = fmt.Errorf("unsupported format: %s", format)
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amartani/oci-extract/internal/detector"
+	"github.com/amartani/oci-extract/internal/registry"
+	"github.com/amartani/oci-extract/internal/soci"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// LayerFormat is the format detected for a single layer
+type LayerFormat struct {
+	Digest v1.Hash
+	Format detector.Format
+
+	// Empty is whether the layer holds no files, such as the OCI empty
+	// descriptor. Empty layers aren't read, so their format is unknown.
+	Empty bool
+}
+
+// DetectReport holds the format of every layer of an image, bottom to top
+type DetectReport struct {
+	Layers []LayerFormat
+}
+
+// Uniform returns the format shared by every layer, or false if layers are
+// in different formats. Empty layers don't count, and an image without other
+// layers is uniformly unknown.
+func (r *DetectReport) Uniform() (detector.Format, bool) {
+	format, found := detector.FormatUnknown, false
+	for _, layer := range r.Layers {
+		switch {
+		case layer.Empty:
+		case !found:
+			format, found = layer.Format, true
+		case layer.Format != format:
+			return detector.FormatUnknown, false
+		}
+	}
+	return format, true
+}
+
+// Detect determines the format of every layer of an image. The compression
+// comes from detector.DetectFormat; seekable formats are then confirmed by
+// reading the layer's TOC or zTOC, since compression alone can't tell e.g.
+// eStargz from plain gzip.
+func (o *Orchestrator) Detect(ctx context.Context, imageRef string) (*DetectReport, error) {
+	enhancedLayers, err := o.getLayers(ctx, imageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	sociIndex, err := o.discoverSOCIIndex(ctx, imageRef, detector.FormatUnknown)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DetectReport{}
+	for _, layerInfo := range enhancedLayers {
+		if o.skipEmptyLayer(layerInfo) {
+			report.Layers = append(report.Layers, LayerFormat{Digest: layerInfo.Digest, Empty: true})
+			continue
+		}
+
+		if o.verbose {
+			fmt.Fprintf(o.log, "Detecting format of layer %s...\n", layerInfo.Digest)
+		}
+
+		format, err := o.detectFormat(ctx, layerInfo)
+		if err != nil && o.verbose {
+			fmt.Fprintf(o.log, "  Format detection failed: %v\n", err)
+		}
+
+		report.Layers = append(report.Layers, LayerFormat{
+			Digest: layerInfo.Digest,
+			Format: o.seekableFormat(ctx, layerInfo, sociIndex, format),
+		})
+	}
+
+	return report, nil
+}
+
+// seekableFormat returns the first seekable format, among the candidates for
+// a layer detected as format, whose index the layer has. Layers without one
+// keep the detected format.
+func (o *Orchestrator) seekableFormat(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo, format detector.Format) detector.Format {
+	for _, candidate := range o.candidates(format) {
+		var err error
+		switch candidate {
+		case detector.FormatEStargz:
+			_, err = o.listEStargz(ctx, layerInfo, true)
+		case detector.FormatSOCI:
+			if sociIndex == nil {
+				continue
+			}
+			_, err = soci.GetZtocForLayer(ctx, sociIndex, layerInfo.Digest)
+		case detector.FormatZstdChunked:
+			_, err = o.listZstdChunked(ctx, layerInfo)
+		default:
+			continue
+		}
+
+		if err == nil {
+			return candidate
+		}
+		if o.verbose {
+			fmt.Fprintf(o.log, "  Not %s: %v\n", candidate, err)
+		}
+	}
+
+	return format
+}
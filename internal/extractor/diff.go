@@ -0,0 +1,156 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/amartani/oci-extract/internal/detector"
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/amartani/oci-extract/internal/registry"
+)
+
+// ChangeKind describes how a layer changed a path
+type ChangeKind string
+
+const (
+	// ChangeAdded marks a file that doesn't exist in the lower layers
+	ChangeAdded ChangeKind = "A"
+
+	// ChangeModified marks a file that replaces one from the lower layers
+	ChangeModified ChangeKind = "M"
+
+	// ChangeDeleted marks a lower-layer file removed by a whiteout
+	ChangeDeleted ChangeKind = "D"
+)
+
+// Change is a single path changed by the compared layers
+type Change struct {
+	Path string
+	Kind ChangeKind
+}
+
+// DiffOptions contains options for comparing layers with those below them
+type DiffOptions struct {
+	ImageRef    string
+	Layer       string // Digest of the first compared layer
+	Since       bool   // Also compare every layer above Layer
+	ForceFormat detector.Format
+}
+
+// Diff reports the files a layer (or, with Since, that layer and every layer
+// above it) added, modified, or deleted relative to the layers below it
+func (o *Orchestrator) Diff(ctx context.Context, opts DiffOptions) ([]Change, error) {
+	enhancedLayers, err := o.getLayers(ctx, opts.ImageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	start := slices.IndexFunc(enhancedLayers, func(l *registry.EnhancedLayerInfo) bool {
+		return l.Digest.String() == opts.Layer || l.Digest.Hex == opts.Layer
+	})
+	if start < 0 {
+		return nil, fmt.Errorf("layer %s not found in image", opts.Layer)
+	}
+
+	end := start + 1
+	if opts.Since {
+		end = len(enhancedLayers)
+	}
+
+	sociIndex, err := o.discoverSOCIIndex(ctx, opts.ImageRef, opts.ForceFormat)
+	if err != nil {
+		return nil, err
+	}
+	listOpts := ListOptions{
+		ImageRef:    opts.ImageRef,
+		ForceFormat: opts.ForceFormat,
+	}
+
+	// A partial listing would report bogus changes, so every layer must list
+	listings := make([][]string, end)
+	for i, layerInfo := range enhancedLayers[:end] {
+		if o.skipEmptyLayer(layerInfo) {
+			continue
+		}
+
+		if o.verbose {
+			fmt.Fprintf(o.log, "Listing files in layer %s...\n", layerInfo.Digest)
+		}
+
+		entries, err := o.listFromLayer(ctx, layerInfo, sociIndex, listOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list layer %s: %w", layerInfo.Digest, err)
+		}
+		listings[i] = output.Paths(entries)
+	}
+
+	return diffLayers(listings[:start], listings[start:]), nil
+}
+
+// diffLayers compares the files produced by stacking upper on top of lower
+// with the files lower produces alone. Listings are ordered bottom first.
+func diffLayers(lower, upper [][]string) []Change {
+	base := make(map[string]bool)
+	for _, listing := range lower {
+		applyListing(base, listing, nil)
+	}
+
+	merged := maps.Clone(base)
+	touched := make(map[string]bool)
+	for _, listing := range upper {
+		applyListing(merged, listing, touched)
+	}
+
+	var changes []Change
+	for p := range merged {
+		switch {
+		case !base[p]:
+			changes = append(changes, Change{Path: p, Kind: ChangeAdded})
+		case touched[p]:
+			changes = append(changes, Change{Path: p, Kind: ChangeModified})
+		}
+	}
+	for p := range base {
+		if !merged[p] {
+			changes = append(changes, Change{Path: p, Kind: ChangeDeleted})
+		}
+	}
+
+	slices.SortFunc(changes, func(a, b Change) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return changes
+}
+
+// applyListing merges one layer's listing into files. The layer's whiteouts
+// only hide entries from lower layers, so they are applied first. Paths the
+// layer writes are recorded in touched when it is non-nil.
+func applyListing(files map[string]bool, listing []string, touched map[string]bool) {
+	for _, entry := range listing {
+		target, opaque, ok := output.ParseWhiteout(entry)
+		if !ok {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(target, "/") + "/"
+		for p := range files {
+			if (!opaque && p == target) || strings.HasPrefix(p, prefix) {
+				delete(files, p)
+			}
+		}
+	}
+
+	for _, entry := range listing {
+		if _, _, ok := output.ParseWhiteout(entry); ok {
+			continue
+		}
+
+		files[entry] = true
+		if touched != nil {
+			touched[entry] = true
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package extractor
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestDiffLayers tests change detection across whiteouts and layer ranges
+func TestDiffLayers(t *testing.T) {
+	lower := [][]string{
+		{"/etc/passwd", "/etc/hosts", "/app/old.js", "/app/lib/a.js"},
+		{"/etc/motd"},
+	}
+
+	tests := []struct {
+		name  string
+		upper [][]string
+		want  []Change
+	}{
+		{
+			name:  "added and modified",
+			upper: [][]string{{"/etc/hosts", "/usr/bin/tool"}},
+			want: []Change{
+				{Path: "/etc/hosts", Kind: ChangeModified},
+				{Path: "/usr/bin/tool", Kind: ChangeAdded},
+			},
+		},
+		{
+			name:  "whiteouts",
+			upper: [][]string{{"/etc/.wh.motd", "/.wh.app"}},
+			want: []Change{
+				{Path: "/app/lib/a.js", Kind: ChangeDeleted},
+				{Path: "/app/old.js", Kind: ChangeDeleted},
+				{Path: "/etc/motd", Kind: ChangeDeleted},
+			},
+		},
+		{
+			name:  "opaque directory keeps same-layer entries",
+			upper: [][]string{{"/app/.wh..wh..opq", "/app/new.js"}},
+			want: []Change{
+				{Path: "/app/lib/a.js", Kind: ChangeDeleted},
+				{Path: "/app/new.js", Kind: ChangeAdded},
+				{Path: "/app/old.js", Kind: ChangeDeleted},
+			},
+		},
+		{
+			name: "changes across several layers",
+			upper: [][]string{
+				{"/tmp/build.log", "/etc/passwd"},
+				{"/tmp/.wh.build.log", "/etc/.wh.passwd"},
+			},
+			want: []Change{
+				{Path: "/etc/passwd", Kind: ChangeDeleted},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := diffLayers(lower, tt.upper)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: diffLayers() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package extractor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/amartani/oci-extract/internal/metrics"
+	"github.com/amartani/oci-extract/internal/registry"
+	"github.com/amartani/oci-extract/internal/remote"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// layerCache keeps compressed layer blobs in a directory, named after their
+// digests, so a layer is downloaded in full at most once across extractions
+type layerCache struct {
+	dir string
+}
+
+// path returns where the blob with digest h is kept
+func (c *layerCache) path(h v1.Hash) string {
+	return filepath.Join(c.dir, h.Algorithm+"-"+h.Hex)
+}
+
+// open opens the cached blob with digest h for random access
+func (c *layerCache) open(h v1.Hash) (remote.BlobReader, error) {
+	return remote.OpenLocalFile(c.path(h))
+}
+
+// compressed returns a reader of layer's compressed blob that copies it into the
+// cache as it's read, or the cached blob when it's already there. Downloads
+// are checked against digest and renamed into place once complete, so an
+// interrupted run never leaves a partial blob behind. Lookups are counted in
+// m.
+func (c *layerCache) compressed(layer v1.Layer, digest v1.Hash, m *metrics.Metrics) (io.ReadCloser, error) {
+	path := c.path(digest)
+	if f, err := os.Open(path); err == nil {
+		m.CacheHit("layer")
+		return f, nil
+	}
+	m.CacheMiss("layer")
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create layer cache directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(c.dir, ".download-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create layer cache file: %w", err)
+	}
+
+	rc, err := layer.Compressed()
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to get compressed layer: %w", err)
+	}
+
+	return &cacheWriter{rc: rc, tmp: tmp, hasher: sha256.New(), path: path, digest: digest}, nil
+}
+
+// cacheWriter reads a layer's compressed blob while writing it to a
+// temporary file in the cache, which is renamed into place once the whole
+// blob was read and matched its digest
+type cacheWriter struct {
+	rc     io.ReadCloser
+	tmp    *os.File
+	hasher hash.Hash
+	path   string
+	digest v1.Hash
+	done   bool
+}
+
+func (w *cacheWriter) Read(p []byte) (int, error) {
+	n, err := w.rc.Read(p)
+	if n > 0 {
+		if _, werr := io.MultiWriter(w.tmp, w.hasher).Write(p[:n]); werr != nil {
+			return n, fmt.Errorf("failed to write layer cache file: %w", werr)
+		}
+	}
+	if err == io.EOF {
+		if cerr := w.commit(); cerr != nil {
+			return n, cerr
+		}
+	}
+	return n, err
+}
+
+// commit checks the downloaded blob against its digest and moves it into
+// place
+func (w *cacheWriter) commit() error {
+	w.done = true
+	got := v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(w.hasher.Sum(nil))}
+	if w.digest.Algorithm == got.Algorithm && got != w.digest {
+		return fmt.Errorf("downloaded layer has digest %s, want %s", got, w.digest)
+	}
+
+	if err := w.tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write layer cache file: %w", err)
+	}
+	if err := os.Rename(w.tmp.Name(), w.path); err != nil {
+		return fmt.Errorf("failed to save layer to cache: %w", err)
+	}
+	return nil
+}
+
+// Close finishes downloading the blob into the cache when the reader stopped
+// early, e.g. once the file it looked for was found, so the next extraction
+// from the layer doesn't download it again
+func (w *cacheWriter) Close() error {
+	if !w.done {
+		_, _ = io.Copy(io.Discard, w)
+	}
+	_ = w.tmp.Close()
+	_ = os.Remove(w.tmp.Name())
+	return w.rc.Close()
+}
+
+// cachedLayer is a layer whose compressed blob is read from a layerCache,
+// copied there from the registry on first use
+type cachedLayer struct {
+	v1.Layer
+	cache   *layerCache
+	digest  v1.Hash
+	metrics *metrics.Metrics
+}
+
+func (l *cachedLayer) Compressed() (io.ReadCloser, error) {
+	return l.cache.compressed(l.Layer, l.digest, l.metrics)
+}
+
+// KeepLayers saves the blobs of layers downloaded in full below dir, and
+// reads them from there in later extractions instead of the registry, for
+// seekable formats as well. The directory is never cleaned up.
+func (o *Orchestrator) KeepLayers(dir string) {
+	o.layerCache = &layerCache{dir: dir}
+}
+
+// wholeLayer returns the layer to stream for a whole-layer extraction,
+// reading it through the layer cache when one is set. Local layers are
+// already on disk, so they aren't cached.
+func (o *Orchestrator) wholeLayer(layerInfo *registry.EnhancedLayerInfo) v1.Layer {
+	if o.layerCache == nil || layerInfo.BlobURL == "" {
+		return layerInfo.Layer
+	}
+
+	return &cachedLayer{Layer: layerInfo.Layer, cache: o.layerCache, digest: layerInfo.Digest, metrics: o.metrics}
+}
@@ -0,0 +1,121 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amartani/oci-extract/internal/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// offlineLayer is a layer whose blob can no longer be downloaded
+type offlineLayer struct {
+	v1.Layer
+}
+
+func (l *offlineLayer) Compressed() (io.ReadCloser, error) {
+	return nil, errors.New("registry unreachable")
+}
+
+// TestKeepLayers tests that a layer downloaded in full is kept on disk and
+// reused, both for whole-layer and seekable reads
+func TestKeepLayers(t *testing.T) {
+	layer := gzipTarLayer(t, map[string]string{"etc/passwd": "root:x:0:0"})
+	digest, err := layer.Digest()
+	if err != nil {
+		t.Fatalf("failed to get layer digest: %v", err)
+	}
+	size, err := layer.Size()
+	if err != nil {
+		t.Fatalf("failed to get layer size: %v", err)
+	}
+
+	cacheDir := filepath.Join(t.TempDir(), "layers")
+	o := NewOrchestrator(false)
+	o.KeepLayers(cacheDir)
+
+	layerInfo := &registry.EnhancedLayerInfo{
+		Layer:   layer,
+		Digest:  digest,
+		Size:    size,
+		BlobURL: "https://registry.invalid/v2/test/blobs/" + digest.String(),
+	}
+	opts := ExtractOptions{FilePath: "/etc/passwd", OutputPath: filepath.Join(t.TempDir(), "passwd")}
+	if _, err := o.extractStandard(context.Background(), layerInfo, opts); err != nil {
+		t.Fatalf("extractStandard() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(cacheDir, "sha256-"+digest.Hex)); err != nil {
+		t.Fatalf("layer not kept: %v", err)
+	}
+
+	// Later reads must not need the registry
+	layerInfo.Layer = &offlineLayer{Layer: layer}
+	opts.OutputPath = filepath.Join(t.TempDir(), "passwd")
+	if _, err := o.extractStandard(context.Background(), layerInfo, opts); err != nil {
+		t.Fatalf("extractStandard() from the kept layer error = %v", err)
+	}
+	data, err := os.ReadFile(opts.OutputPath)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if string(data) != "root:x:0:0" {
+		t.Errorf("extracted %q, want %q", data, "root:x:0:0")
+	}
+
+	reader, err := o.newLayerReader(context.Background(), layerInfo)
+	if err != nil {
+		t.Fatalf("newLayerReader() error = %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+	if reader.Size() != size {
+		t.Errorf("newLayerReader() size = %d, want %d", reader.Size(), size)
+	}
+}
+
+// TestLayerCacheEarlyClose tests that a layer read only partly is still
+// saved whole, and that a download not matching its digest isn't kept
+func TestLayerCacheEarlyClose(t *testing.T) {
+	layer := gzipTarLayer(t, map[string]string{"etc/passwd": "root:x:0:0", "etc/group": "root:x:0:"})
+	digest, err := layer.Digest()
+	if err != nil {
+		t.Fatalf("failed to get layer digest: %v", err)
+	}
+
+	cache := &layerCache{dir: t.TempDir()}
+	rc, err := cache.compressed(layer, digest, nil)
+	if err != nil {
+		t.Fatalf("compressed() error = %v", err)
+	}
+	if _, err := rc.Read(make([]byte, 10)); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if err := rc.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := os.Stat(cache.path(digest)); err != nil {
+		t.Errorf("layer not kept after an early close: %v", err)
+	}
+
+	wrong := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("0", 64)}
+	rc, err = cache.compressed(layer, wrong, nil)
+	if err != nil {
+		t.Fatalf("compressed() error = %v", err)
+	}
+	if _, err := io.ReadAll(rc); err == nil {
+		t.Error("reading a layer that doesn't match its digest succeeded")
+	}
+	_ = rc.Close()
+	entries, err := os.ReadDir(cache.dir)
+	if err != nil {
+		t.Fatalf("failed to read cache directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("cache holds %d files, want only the first layer", len(entries))
+	}
+}
@@ -0,0 +1,104 @@
+package extractor
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/amartani/oci-extract/internal/output"
+)
+
+var (
+	// ErrDanglingLink is returned when a symlink leads to a path that isn't
+	// in the image
+	ErrDanglingLink = errors.New("dangling symlink")
+
+	// ErrLinkLoop is returned when following symlinks never ends on a real
+	// path, as with links pointing at each other
+	ErrLinkLoop = errors.New("symlink loop")
+)
+
+// maxLinkHops bounds how many symlinks resolving a path follows, as
+// Linux's MAXSYMLINKS does
+const maxLinkHops = 40
+
+// LinkResolver resolves symlinks within the entries listed from an image
+type LinkResolver struct {
+	entries map[string]output.Metadata
+	dirs    map[string]bool // Directories, including those only implied by entries below them
+}
+
+// NewLinkResolver indexes entries, as listed by ListStream with AllTypes, for
+// resolving symlinks. Entries hold display paths, starting with "/".
+func NewLinkResolver(entries []FileEntry) *LinkResolver {
+	r := &LinkResolver{
+		entries: make(map[string]output.Metadata, len(entries)),
+		dirs:    map[string]bool{"/": true},
+	}
+	for _, entry := range entries {
+		r.Add(entry)
+	}
+	return r
+}
+
+// Add indexes another entry. Symlinks only resolve through entries added
+// before Resolve is called.
+func (r *LinkResolver) Add(entry FileEntry) {
+	r.entries[entry.Path] = entry.Metadata
+	if entry.Type == "dir" {
+		r.dirs[entry.Path] = true
+	}
+	for dir := path.Dir(entry.Path); !r.dirs[dir]; dir = path.Dir(dir) {
+		r.dirs[dir] = true
+	}
+}
+
+// Resolve returns the real path p leads to, following symlinks in any of
+// its components the way the kernel would inside a container: relative
+// targets are resolved from the link's directory, absolute ones from the
+// image root, and ".." never leaves the root.
+func (r *LinkResolver) Resolve(p string) (string, error) {
+	resolved := "/"
+	remaining := splitPath(p)
+
+	for hops := 0; len(remaining) > 0; {
+		component := remaining[0]
+		remaining = remaining[1:]
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, component)
+		md, ok := r.entries[next]
+		if ok && md.Type == "symlink" {
+			hops++
+			if hops > maxLinkHops {
+				return "", fmt.Errorf("%w: %s", ErrLinkLoop, p)
+			}
+
+			if strings.HasPrefix(md.Linkname, "/") {
+				resolved = "/"
+			}
+			remaining = append(splitPath(md.Linkname), remaining...)
+			continue
+		}
+
+		if !ok && !r.dirs[next] {
+			return "", fmt.Errorf("%w: %s leads to %s, which isn't in the image", ErrDanglingLink, p, next)
+		}
+		resolved = next
+	}
+
+	return resolved, nil
+}
+
+// splitPath returns the slash-separated components of p
+func splitPath(p string) []string {
+	return strings.Split(strings.Trim(p, "/"), "/")
+}
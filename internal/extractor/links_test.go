@@ -0,0 +1,58 @@
+package extractor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/amartani/oci-extract/internal/output"
+)
+
+func TestLinkResolver(t *testing.T) {
+	entry := func(path, typ, link string) FileEntry {
+		return FileEntry{Metadata: output.Metadata{Path: path, Type: typ, Linkname: link}}
+	}
+	r := NewLinkResolver([]FileEntry{
+		entry("/bin", "symlink", "usr/bin"),
+		entry("/usr/bin/busybox", "reg", ""),
+		entry("/usr/bin/sh", "symlink", "busybox"),
+		entry("/usr/bin/env", "symlink", "/bin/busybox"),
+		entry("/usr/lib/libc.so", "symlink", "../../lib/libc.so.6"),
+		entry("/lib/libc.so.6", "reg", ""),
+		entry("/etc/alternatives/editor", "symlink", "/usr/bin/vim"),
+		entry("/loop/a", "symlink", "b"),
+		entry("/loop/b", "symlink", "a"),
+		entry("/escape", "symlink", "../../../usr/bin/busybox"),
+	})
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr error
+	}{
+		{path: "/usr/bin/sh", want: "/usr/bin/busybox"},
+		{path: "/bin/sh", want: "/usr/bin/busybox"},
+		{path: "/usr/bin/env", want: "/usr/bin/busybox"},
+		{path: "/usr/lib/libc.so", want: "/lib/libc.so.6"},
+		{path: "/bin", want: "/usr/bin"},
+		{path: "/escape", want: "/usr/bin/busybox"},
+		{path: "/etc/alternatives/editor", wantErr: ErrDanglingLink},
+		{path: "/loop/a", wantErr: ErrLinkLoop},
+	}
+
+	for _, tt := range tests {
+		got, err := r.Resolve(tt.path)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Resolve(%s) error = %v, want %v", tt.path, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolve(%s) error = %v", tt.path, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%s) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
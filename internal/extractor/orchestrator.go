@@ -2,213 +2,1224 @@ package extractor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/amartani/oci-extract/internal/detector"
 	"github.com/amartani/oci-extract/internal/estargz"
+	"github.com/amartani/oci-extract/internal/metrics"
+	"github.com/amartani/oci-extract/internal/output"
 	"github.com/amartani/oci-extract/internal/registry"
 	"github.com/amartani/oci-extract/internal/remote"
 	"github.com/amartani/oci-extract/internal/soci"
 	"github.com/amartani/oci-extract/internal/standard"
 	"github.com/amartani/oci-extract/internal/zstd"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
+// ErrNotFound is returned when the requested path isn't in any layer of the image
+var ErrNotFound = errors.New("not found in any layer")
+
+// ErrLayerDigestMismatch is returned when a layer verified with VerifyLayers
+// doesn't match its digest
+var ErrLayerDigestMismatch = errors.New("layer doesn't match its digest")
+
+// ErrFormatNotApplicable is returned when a format forced with ForceFormat
+// can't read the image, as no other format is tried in its place
+var ErrFormatNotApplicable = errors.New("forced format doesn't apply")
+
 // Orchestrator manages the file extraction process
 type Orchestrator struct {
 	client  *registry.Client
 	verbose bool
+
+	// Where verbose output and warnings are written, never stdout, which is
+	// left to the command's results
+	log io.Writer
+
+	// Detected layer formats by digest, so detection runs once per layer.
+	// Detections in progress are shared through formatCalls, outside the lock.
+	formatsMu   sync.Mutex
+	formats     map[v1.Hash]detector.Format
+	formatCalls singleflight.Group
+
+	// Number of parallel range requests used to prefetch a file's spans, 0 disables
+	prefetch int
+
+	// Base-2 logarithm of the largest zstd window accepted, 0 for the default
+	zstdWindowLogMax int
+
+	// Compressed size below which layers are downloaded in full rather than
+	// read in part, 0 to always try seekable formats
+	smallLayerThreshold int64
+
+	// Where blobs of layers downloaded in full are kept, nil to not keep them
+	layerCache *layerCache
+
+	// Formats left out of auto-detection, along with any lookups they need
+	disabled map[detector.Format]bool
+
+	// Annotation keys naming the layer of an index's zTOCs, besides SOCI's
+	indexAnnotations []string
+
+	// The SOCI index to use instead of discovering one, nil to discover it
+	sociIndexDigest *v1.Hash
+
+	// Counters for --metrics-out, nil when not recording
+	metrics *metrics.Metrics
+
+	// Whether layers are hashed in full before their TOCs are trusted, and
+	// the digests of those that were
+	verifyLayers bool
+	verifiedMu   sync.Mutex
+	verified     map[v1.Hash]bool
+
+	// How the range reads of seekable layers were served, summed over readers
+	cacheStatsMu sync.Mutex
+	cacheStats   remote.CacheStats
+}
+
+// NewOrchestrator creates a new extraction orchestrator
+func NewOrchestrator(verbose bool) *Orchestrator {
+	return &Orchestrator{
+		client:   registry.NewClient(),
+		verbose:  verbose,
+		log:      os.Stderr,
+		formats:  make(map[v1.Hash]detector.Format),
+		disabled: make(map[detector.Format]bool),
+	}
+}
+
+// SetLogOutput sets where verbose output and warnings are written, stderr
+// by default
+func (o *Orchestrator) SetLogOutput(w io.Writer) {
+	o.log = w
+}
+
+// DisableFormat keeps auto-detection from trying a seekable format, and from
+// the lookups it needs, such as discovering a SOCI index. A format forced
+// with ForceFormat is still used.
+func (o *Orchestrator) DisableFormat(format detector.Format) {
+	o.disabled[format] = true
+}
+
+// candidates returns the formats to try on a layer detected as format, in
+// order, leaving out disabled ones
+func (o *Orchestrator) candidates(format detector.Format) []detector.Format {
+	return slices.DeleteFunc(detector.Candidates(format), func(f detector.Format) bool {
+		return o.disabled[f]
+	})
+}
+
+// UseContainerd reads images from a containerd content store instead of a
+// registry. Close releases the connection once the orchestrator is done.
+func (o *Orchestrator) UseContainerd(address, namespace string) {
+	o.client.UseContainerd(address, namespace)
+}
+
+// Close releases the connections the orchestrator holds open, such as the
+// one to containerd
+func (o *Orchestrator) Close() error {
+	return o.client.Close()
+}
+
+// detectFormat returns the format of a layer, running detection at most once
+// per layer digest. Failed detections aren't cached so they can be retried.
+// Callers detecting the same layer at once wait for a single detection, while
+// other layers are detected alongside it.
+func (o *Orchestrator) detectFormat(ctx context.Context, layerInfo *registry.EnhancedLayerInfo) (detector.Format, error) {
+	if format, ok := o.cachedFormat(layerInfo.Digest); ok {
+		o.metrics.CacheHit("format")
+		return format, nil
+	}
+
+	v, err, _ := o.formatCalls.Do(layerInfo.Digest.String(), func() (any, error) {
+		// A detection may have finished since the lookup above
+		if format, ok := o.cachedFormat(layerInfo.Digest); ok {
+			return format, nil
+		}
+		return o.runDetection(ctx, layerInfo)
+	})
+	return v.(detector.Format), err
+}
+
+// cachedFormat returns the format detected earlier for the layer with digest h
+func (o *Orchestrator) cachedFormat(h v1.Hash) (detector.Format, bool) {
+	o.formatsMu.Lock()
+	defer o.formatsMu.Unlock()
+	format, ok := o.formats[h]
+	return format, ok
+}
+
+// runDetection detects the format of a layer and caches it by digest
+func (o *Orchestrator) runDetection(ctx context.Context, layerInfo *registry.EnhancedLayerInfo) (detector.Format, error) {
+	o.metrics.CacheMiss("format")
+
+	format, err := detector.DetectFormat(ctx, layerInfo.Layer)
+	if err != nil {
+		return format, err
+	}
+
+	// Some registries mislabel layers, so the compression the content is
+	// actually in wins over the one its media type names
+	if sniffed := o.sniffCompression(ctx, layerInfo); sniffed != detector.FormatUnknown && sniffed != format {
+		if o.verbose {
+			fmt.Fprintf(o.log, "Layer %s is labeled %s but its content is %s\n", layerInfo.Digest, format, sniffed)
+		}
+		format = sniffed
+	}
+
+	o.formatsMu.Lock()
+	o.formats[layerInfo.Digest] = format
+	o.formatsMu.Unlock()
+	return format, nil
+}
+
+// sniffCompression identifies a layer's compression from its first bytes. It
+// returns FormatUnknown when they match no compression or can't be read, in
+// which case the media type is all detection goes by.
+func (o *Orchestrator) sniffCompression(ctx context.Context, layerInfo *registry.EnhancedLayerInfo) detector.Format {
+	header, err := o.readLayerHeader(ctx, layerInfo, detector.MagicLen)
+	if err != nil {
+		if o.verbose {
+			fmt.Fprintf(o.log, "  Failed to read the start of layer %s: %v\n", layerInfo.Digest, err)
+		}
+		return detector.FormatUnknown
+	}
+	return detector.Sniff(header)
+}
+
+// readLayerHeader returns the first n bytes of a layer's blob, with a single
+// range request for layers read from a registry
+func (o *Orchestrator) readLayerHeader(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, n int) ([]byte, error) {
+	if layerInfo.BlobURL == "" {
+		rc, err := layerInfo.Layer.Compressed()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open layer: %w", err)
+		}
+		defer func() { _ = rc.Close() }()
+		return readHeader(rc, n)
+	}
+
+	if o.layerCache != nil {
+		if reader, err := o.layerCache.open(layerInfo.Digest); err == nil {
+			defer func() { _ = reader.Close() }()
+			return readHeader(io.NewSectionReader(reader, 0, reader.Size()), n)
+		}
+	}
+
+	client, err := o.client.BlobHTTPClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return remote.ReadHeader(ctx, layerInfo.BlobURL, client, n)
+}
+
+// readHeader returns the first n bytes of r, or all of it if it is shorter
+func readHeader(r io.Reader, n int) ([]byte, error) {
+	header := make([]byte, n)
+	read, err := io.ReadFull(r, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read layer: %w", err)
+	}
+	return header[:read], nil
+}
+
+// UseProxy sends registry and blob requests through the forward proxy at
+// proxyURL, authenticating with the credentials in it
+func (o *Orchestrator) UseProxy(proxyURL *url.URL) {
+	if o.verbose {
+		fmt.Fprintf(o.log, "Using proxy %s\n", proxyURL.Redacted())
+	}
+	o.client.UseProxy(proxyURL)
+}
+
+// LimitBandwidth caps download speed for both range reads and full layer downloads
+func (o *Orchestrator) LimitBandwidth(bytesPerSec int64) {
+	o.client.LimitBandwidth(bytesPerSec)
+}
+
+// UseCircuitBreaker fails requests to a host fast, for cooldown at a time,
+// once threshold of them failed in a row
+func (o *Orchestrator) UseCircuitBreaker(threshold int, cooldown time.Duration) {
+	o.client.UseCircuitBreaker(threshold, cooldown)
+}
+
+// SetMetrics records bytes fetched, cache lookups, and extraction attempts
+// into m
+func (o *Orchestrator) SetMetrics(m *metrics.Metrics) {
+	o.metrics = m
+	o.client.CountFetched(m)
+}
+
+// SelectManifest sets how images are picked from indexes holding several
+// manifests, by platform and/or annotations
+func (o *Orchestrator) SelectManifest(selector registry.ManifestSelector) {
+	o.client.SelectManifest(selector)
+}
+
+// Platforms returns the platforms of the images in the index imageRef points
+// to, failing with registry.ErrNotIndex for a single image
+func (o *Orchestrator) Platforms(ctx context.Context, imageRef string) ([]v1.Platform, error) {
+	return o.client.Platforms(ctx, imageRef)
+}
+
+// MapRepositories fetches images in relocated repositories from where m
+// maps them
+func (o *Orchestrator) MapRepositories(m registry.RepositoryMap) {
+	o.client.MapRepositories(m)
+}
+
+// UseDockerConfig reads registry credentials from the given docker config
+// file instead of the default one
+func (o *Orchestrator) UseDockerConfig(path string) error {
+	return o.client.UseDockerConfig(path)
+}
+
+// UseAnonymous makes the orchestrator send no registry credentials
+func (o *Orchestrator) UseAnonymous() {
+	o.client.UseAnonymous()
+}
+
+// SetUserAgent sets the User-Agent for registry and blob range requests
+func (o *Orchestrator) SetUserAgent(ua string) {
+	o.client.SetUserAgent(ua)
+}
+
+// SetFollowRedirects sets whether blob range requests follow redirects, e.g.
+// to a CDN. Without them, seekable formats fail on redirected blobs and fall
+// back to downloading layers in full.
+func (o *Orchestrator) SetFollowRedirects(follow bool) {
+	o.client.SetFollowRedirects(follow)
+}
+
+// SetPrefetchConcurrency makes seekable extractions fetch all of a file's
+// compressed spans up front with up to n parallel range requests, rather than
+// one at a time while decompressing. 0 disables prefetching.
+func (o *Orchestrator) SetPrefetchConcurrency(n int) {
+	o.prefetch = n
+}
+
+// SetZstdWindowLogMax sets the base-2 logarithm of the largest window zstd
+// layers may use. Layers compressed with zstd --long need up to 31; 0 selects
+// zstd.DefaultWindowLogMax.
+func (o *Orchestrator) SetZstdWindowLogMax(windowLogMax int) {
+	o.zstdWindowLogMax = windowLogMax
+}
+
+// SetSmallLayerThreshold makes auto-detected extractions download layers
+// smaller than n bytes in full, skipping their TOC or zTOC: for a small
+// layer, the footer, TOC and range requests take more round trips than the
+// whole layer. 0 disables it. A format forced with ForceFormat is still used.
+func (o *Orchestrator) SetSmallLayerThreshold(n int64) {
+	o.smallLayerThreshold = n
+}
+
+// VerifyLayers makes seekable extractions and listings download and hash a
+// layer in full, once per run, before trusting its TOC or zTOC to locate
+// files. It gives up the bandwidth savings of seekable formats for the
+// guarantee that what is read belongs to the layer the manifest names.
+func (o *Orchestrator) VerifyLayers(verify bool) {
+	o.verifyLayers = verify
+}
+
+// SetIndexAnnotations makes zTOCs be matched to layers by any of keys, as
+// well as by soci.LayerDigestAnnotation, for indexes that record the layer
+// digest of each zTOC under annotations of their own
+func (o *Orchestrator) SetIndexAnnotations(keys []string) {
+	o.indexAnnotations = keys
+}
+
+// PinSOCIIndex makes SOCI lookups use the index with digest d, in the
+// image's repository, rather than the newest one among its referrers
+func (o *Orchestrator) PinSOCIIndex(d v1.Hash) {
+	o.sociIndexDigest = &d
+}
+
+// abortsLayerSearch reports whether err from one layer must end a search
+// through the image's layers instead of moving on to the next, since
+// skipping the layer could return an older version of a file. A file whose
+// content doesn't match output.Options.Match was found, and ends it too.
+func abortsLayerSearch(err error) bool {
+	return errors.Is(err, zstd.ErrWindowTooLarge) || errors.Is(err, ErrLayerDigestMismatch) ||
+		errors.Is(err, output.ErrNoMatch) || errors.Is(err, ErrFormatNotApplicable)
+}
+
+// ExtractOptions contains options for file extraction
+type ExtractOptions struct {
+	ImageRef    string
+	FilePath    string
+	OutputPath  string
+	ForceFormat detector.Format
+	Output      output.Options
+
+	// Preflight looks the file up in the TOCs and zTOCs of the image's layers
+	// before any layer is downloaded in full, failing early when it's absent
+	Preflight bool
+
+	// ByName treats FilePath as a file name and extracts the regular file
+	// with that base name from the topmost layer holding one, wherever it is
+	ByName bool
+
+	// ByDigest treats FilePath as the digest of a file's content, such as
+	// sha256:..., and extracts the regular file with that content from the
+	// topmost layer holding one. Only eStargz TOCs record file digests, so
+	// other layers are skipped unless HashFiles is set.
+	ByDigest bool
+
+	// HashFiles lets ByDigest search layers without file digests by reading
+	// them in full and hashing every file
+	HashFiles bool
+
+	// OnExtracted, if set, receives the output path and source metadata of
+	// each extracted file, along with the digest of the layer it came from.
+	// Directory extractions report every regular file they write, below
+	// OutputPath; an upper layer's copy is reported after the one it replaces.
+	OnExtracted func(outputPath string, layer v1.Hash, md output.Metadata)
+
+	// OutputTemplate, if set, names the written file below OutputPath, which
+	// is then a directory, from the file's path and the layer it's found in.
+	// It doesn't apply to directories.
+	OutputTemplate *output.NameTemplate
+
+	// Resume, if set, reports whether outputPath already holds, in full, the
+	// regular file a directory extraction is about to write from layer. Such
+	// files are left in place rather than being removed and written again,
+	// and are still passed to OnExtracted.
+	Resume func(outputPath string, layer v1.Hash) bool
+}
+
+// ExtractResult describes how Extract got a file
+type ExtractResult struct {
+	// Format is the method the file was read with. Directories take every
+	// layer, read in full, so they leave it FormatUnknown.
+	Format detector.Format
+
+	// Layer is the digest of the layer the file came from, zero for
+	// directories
+	Layer v1.Hash
+
+	// OutputPath is where the file was written, which OutputTemplate may
+	// have picked
+	OutputPath string
+
+	// BytesFetched counts the bytes read from registry responses, which is
+	// only done while metrics are recorded (see SetMetrics)
+	BytesFetched int64
+
+	// Duration is how long the extraction took, image lookup included
+	Duration time.Duration
+}
+
+// Extract extracts a file from an OCI image
+func (o *Orchestrator) Extract(ctx context.Context, opts ExtractOptions) (*ExtractResult, error) {
+	defer o.printCacheStats()
+
+	start := time.Now()
+	fetched := o.metrics.Fetched()
+	result, err := o.extract(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result.BytesFetched = o.metrics.Fetched() - fetched
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// extract does the work of Extract
+func (o *Orchestrator) extract(ctx context.Context, opts ExtractOptions) (*ExtractResult, error) {
+	// A trailing slash requests the whole directory
+	if output.IsDirTarget(opts.FilePath) {
+		if opts.OutputTemplate != nil {
+			return nil, fmt.Errorf("output templates only apply to files")
+		}
+		if err := o.ExtractDirTo(ctx, opts, output.NewDirTarget(opts.OutputPath, opts.Output)); err != nil {
+			return nil, err
+		}
+		return &ExtractResult{OutputPath: opts.OutputPath}, nil
+	}
+
+	// Get enhanced image layers with blob URLs
+	enhancedLayers, err := o.getLayers(ctx, opts.ImageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if SOCI index exists for this image
+	sociIndex, err := o.discoverSOCIIndex(ctx, opts.ImageRef, opts.ForceFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.ByName {
+		if opts.FilePath, err = o.resolveName(ctx, enhancedLayers, sociIndex, opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.ByDigest {
+		if opts.FilePath, err = o.resolveDigest(ctx, enhancedLayers, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return o.extractFile(ctx, enhancedLayers, sociIndex, opts)
 }
 
-// NewOrchestrator creates a new extraction orchestrator
-func NewOrchestrator(verbose bool) *Orchestrator {
-	return &Orchestrator{
-		client:  registry.NewClient(),
-		verbose: verbose,
-	}
+// FileTarget is one file of a multi-file extraction and where to write it
+type FileTarget struct {
+	FilePath   string
+	OutputPath string
+}
+
+// ExtractFiles extracts several files from one image, up to parallel at a
+// time. The image's layers and SOCI index are looked up once and shared, as
+// are the connections to the registry. A file that fails doesn't stop the
+// others: the returned slices hold each target's result, or its error if it
+// failed. The error is only set when the image itself can't be read.
+// opts.FilePath and opts.OutputPath are ignored.
+func (o *Orchestrator) ExtractFiles(ctx context.Context, opts ExtractOptions, targets []FileTarget, parallel int) ([]*ExtractResult, []error, error) {
+	defer o.printCacheStats()
+
+	enhancedLayers, err := o.getLayers(ctx, opts.ImageRef)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sociIndex, err := o.discoverSOCIIndex(ctx, opts.ImageRef, opts.ForceFormat)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := make([]*ExtractResult, len(targets))
+	errs := make([]error, len(targets))
+	var g errgroup.Group
+	g.SetLimit(max(parallel, 1))
+	for i, target := range targets {
+		g.Go(func() error {
+			fileOpts := opts
+			fileOpts.FilePath = target.FilePath
+			fileOpts.OutputPath = target.OutputPath
+			results[i], errs[i] = o.extractFile(ctx, enhancedLayers, sociIndex, fileOpts)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	return results, errs, nil
+}
+
+// extractFile extracts the file opts.FilePath from the topmost of
+// enhancedLayers holding it. ErrNotFound is only returned when every layer
+// was read and lacks the file; otherwise the last layer's failure is, so an
+// unreadable layer isn't mistaken for a missing file.
+func (o *Orchestrator) extractFile(ctx context.Context, enhancedLayers []*registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo, opts ExtractOptions) (*ExtractResult, error) {
+	if opts.Preflight {
+		if err := o.preflight(ctx, enhancedLayers, sociIndex, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// The last failure other than the file being absent from a layer
+	var lastErr error
+
+	// Try each layer from the topmost down, as layers are applied bottom first
+	for i := len(enhancedLayers) - 1; i >= 0; i-- {
+		// A cancelled run stops instead of failing on every remaining layer
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		layerInfo := enhancedLayers[i]
+		if o.skipEmptyLayer(layerInfo) {
+			continue
+		}
+
+		if o.verbose {
+			fmt.Fprintf(o.log, "Checking layer %s...\n", layerInfo.Digest)
+		}
+
+		layerOpts := opts
+		if opts.OutputTemplate != nil {
+			var err error
+			if layerOpts.OutputPath, err = templateOutputPath(opts, i, layerInfo); err != nil {
+				return nil, err
+			}
+		}
+
+		// Try extraction
+		format, err := o.extractFromLayer(ctx, layerInfo, sociIndex, layerOpts)
+		if abortsLayerSearch(err) {
+			// Skipping the layer could return an older version of the file
+			return nil, err
+		}
+		if err != nil {
+			if o.verbose {
+				fmt.Fprintf(o.log, "  Failed: %v\n", err)
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				lastErr = err
+			}
+			continue
+		}
+
+		if format != detector.FormatUnknown {
+			return &ExtractResult{Format: format, Layer: layerInfo.Digest, OutputPath: layerOpts.OutputPath}, nil
+		}
+	}
+
+	if lastErr != nil {
+		return nil, fmt.Errorf("failed to extract %s: %w", opts.FilePath, lastErr)
+	}
+	return nil, fmt.Errorf("file %s %w", opts.FilePath, ErrNotFound)
+}
+
+// templateOutputPath returns where opts.OutputTemplate names the file
+// opts.FilePath found in the layer at index i, below opts.OutputPath
+func templateOutputPath(opts ExtractOptions, i int, layerInfo *registry.EnhancedLayerInfo) (string, error) {
+	name, err := opts.OutputTemplate.Execute(output.NewNameFields(opts.FilePath, i, layerInfo.Digest.Hex))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(opts.OutputPath, name), nil
+}
+
+// ExtractAll extracts every layer's version of a file rather than only the
+// topmost one. Each copy is written to <OutputPath>.<layer index>.<short
+// digest>, or where OutputTemplate names it; the written paths are returned
+// from the bottom layer up.
+func (o *Orchestrator) ExtractAll(ctx context.Context, opts ExtractOptions) ([]string, error) {
+	defer o.printCacheStats()
+
+	if output.IsDirTarget(opts.FilePath) {
+		return nil, fmt.Errorf("extracting every layer's version is only supported for files")
+	}
+
+	enhancedLayers, err := o.getLayers(ctx, opts.ImageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	sociIndex, err := o.discoverSOCIIndex(ctx, opts.ImageRef, opts.ForceFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.ByName {
+		if opts.FilePath, err = o.resolveName(ctx, enhancedLayers, sociIndex, opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.ByDigest {
+		if opts.FilePath, err = o.resolveDigest(ctx, enhancedLayers, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Preflight {
+		if err := o.preflight(ctx, enhancedLayers, sociIndex, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// The last failure other than the file being absent from a layer
+	var lastErr error
+
+	var written []string
+	for i, layerInfo := range enhancedLayers {
+		if o.skipEmptyLayer(layerInfo) {
+			continue
+		}
+
+		if o.verbose {
+			fmt.Fprintf(o.log, "Checking layer %s...\n", layerInfo.Digest)
+		}
+
+		layerOpts := opts
+		layerOpts.OutputPath = fmt.Sprintf("%s.%d.%s", opts.OutputPath, i, layerInfo.Digest.Hex[:12])
+		if opts.OutputTemplate != nil {
+			if layerOpts.OutputPath, err = templateOutputPath(opts, i, layerInfo); err != nil {
+				return nil, err
+			}
+		}
+
+		format, err := o.extractFromLayer(ctx, layerInfo, sociIndex, layerOpts)
+		if abortsLayerSearch(err) {
+			// Skipping the layer could return an older version of the file
+			return nil, err
+		}
+		if err != nil {
+			if o.verbose {
+				fmt.Fprintf(o.log, "  Failed: %v\n", err)
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				lastErr = err
+			}
+			continue
+		}
+
+		if format != detector.FormatUnknown {
+			written = append(written, layerOpts.OutputPath)
+		}
+	}
+
+	if lastErr != nil {
+		// A layer that couldn't be read may hold a version of the file
+		return nil, fmt.Errorf("failed to extract %s: %w", opts.FilePath, lastErr)
+	}
+	if len(written) == 0 {
+		return nil, fmt.Errorf("file %s %w", opts.FilePath, ErrNotFound)
+	}
+
+	return written, nil
+}
+
+// preflight checks that a file is in the image using only the layers'
+// indexes: eStargz and zstd:chunked TOCs and SOCI zTOCs. It returns
+// ErrNotFound when every layer has an index and none lists the file. A layer
+// without one could still hold the file, so the result is then inconclusive
+// and nil is returned, as it is when the file is found.
+func (o *Orchestrator) preflight(ctx context.Context, enhancedLayers []*registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo, opts ExtractOptions) error {
+	target := output.DisplayPath(opts.FilePath)
+
+	for i := len(enhancedLayers) - 1; i >= 0; i-- {
+		layerInfo := enhancedLayers[i]
+		if layerInfo.IsEmpty() {
+			// Nothing to find, but nothing to read without an index either
+			continue
+		}
+
+		entries, err := o.indexedEntries(ctx, layerInfo, sociIndex, opts.ForceFormat)
+		if err != nil {
+			if o.verbose {
+				fmt.Fprintf(o.log, "Pre-flight inconclusive, layer %s has no index: %v\n", layerInfo.Digest, err)
+			}
+			return nil
+		}
+
+		for _, md := range entries {
+			if md.Path == target {
+				if o.verbose {
+					fmt.Fprintf(o.log, "Pre-flight found %s in layer %s\n", opts.FilePath, layerInfo.Digest)
+				}
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("file %s %w", opts.FilePath, ErrNotFound)
+}
+
+// indexedEntries lists a layer's entries from its TOC or zTOC, without
+// falling back to downloading the layer
+func (o *Orchestrator) indexedEntries(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo, forceFormat detector.Format) ([]output.Metadata, error) {
+	format := forceFormat
+	if format == detector.FormatUnknown {
+		// Detection failures leave format unknown, so every index is tried
+		format, _ = o.detectFormat(ctx, layerInfo)
+	}
+
+	// Disabled formats are only used when forced. SOCI needs no check, as
+	// sociIndex is nil unless it is enabled or forced.
+	enabled := func(f detector.Format) bool {
+		return forceFormat != detector.FormatUnknown || !o.disabled[f]
+	}
+
+	switch format {
+	case detector.FormatZstd, detector.FormatZstdChunked:
+		if !enabled(detector.FormatZstdChunked) {
+			return nil, fmt.Errorf("zstd:chunked is disabled")
+		}
+		return o.listZstdChunked(ctx, layerInfo)
+	case detector.FormatSOCI:
+		if sociIndex == nil {
+			return nil, fmt.Errorf("no SOCI index available")
+		}
+		return o.listSOCI(ctx, layerInfo, sociIndex)
+	}
+
+	var errs []error
+	if enabled(detector.FormatEStargz) && (format == detector.FormatUnknown || format == detector.FormatStandard || format == detector.FormatEStargz) {
+		entries, err := o.listEStargz(ctx, layerInfo, true)
+		if err == nil {
+			return entries, nil
+		}
+		errs = append(errs, err)
+	}
+	if sociIndex != nil && (format == detector.FormatUnknown || format == detector.FormatStandard) {
+		entries, err := o.listSOCI(ctx, layerInfo, sociIndex)
+		if err == nil {
+			return entries, nil
+		}
+		errs = append(errs, err)
+	}
+	if enabled(detector.FormatZstdChunked) && format == detector.FormatUnknown {
+		entries, err := o.listZstdChunked(ctx, layerInfo)
+		if err == nil {
+			return entries, nil
+		}
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no index available for %s layers", format)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// ExtractDirTo extracts the directory opts.FilePath into target, which the
+// CLI points at opts.OutputPath and Go callers can keep in memory
+func (o *Orchestrator) ExtractDirTo(ctx context.Context, opts ExtractOptions, target output.Target) error {
+	if !output.IsDirTarget(opts.FilePath) {
+		return fmt.Errorf("%s is not a directory path (missing trailing /)", opts.FilePath)
+	}
+
+	enhancedLayers, err := o.getLayers(ctx, opts.ImageRef)
+	if err != nil {
+		return err
+	}
+
+	return o.extractDir(ctx, enhancedLayers, opts, target)
+}
+
+// extractDir extracts a directory tree into target by replaying every layer
+// from bottom to top, so files from lower layers and whiteouts from upper
+// layers merge the same way they would in a container's root filesystem
+func (o *Orchestrator) extractDir(ctx context.Context, enhancedLayers []*registry.EnhancedLayerInfo, opts ExtractOptions, target output.Target) error {
+	switch opts.ForceFormat {
+	case detector.FormatEStargz, detector.FormatSOCI, detector.FormatZstdChunked:
+		return fmt.Errorf("directories are read from whole layers, not as %s: %w", opts.ForceFormat, ErrFormatNotApplicable)
+	}
+
+	// The directory exists if the topmost layer touching it adds rather than
+	// removes it, even when it ends up empty
+	found := false
+	for _, layerInfo := range enhancedLayers {
+		if o.skipEmptyLayer(layerInfo) {
+			continue
+		}
+
+		if o.verbose {
+			fmt.Fprintf(o.log, "Extracting %s from layer %s...\n", opts.FilePath, layerInfo.Digest)
+		}
+
+		count, presence, err := o.extractDirFromLayer(ctx, layerInfo, opts, target)
+		if err != nil {
+			return fmt.Errorf("failed to extract %s from layer %s: %w", opts.FilePath, layerInfo.Digest, err)
+		}
+
+		if o.verbose {
+			fmt.Fprintf(o.log, "  Extracted %d entries\n", count)
+		}
+		switch presence {
+		case output.DirPresent:
+			found = true
+		case output.DirRemoved:
+			found = false
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("directory %s %w", opts.FilePath, ErrNotFound)
+	}
+
+	return nil
+}
+
+// skipEmptyLayer reports whether layerInfo holds no files, in which case
+// it's skipped rather than failing to open as an archive
+func (o *Orchestrator) skipEmptyLayer(layerInfo *registry.EnhancedLayerInfo) bool {
+	if !layerInfo.IsEmpty() {
+		return false
+	}
+	if o.verbose {
+		fmt.Fprintf(o.log, "Skipping empty layer %s\n", layerInfo.Digest)
+	}
+	return true
+}
+
+// extractDirFromLayer streams a single layer into target.
+// Seekable formats are readable as their plain counterparts, and a directory
+// needs every entry anyway, so only the compression matters here.
+func (o *Orchestrator) extractDirFromLayer(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, opts ExtractOptions, target output.Target) (int, output.DirPresence, error) {
+	if opts.Resume != nil {
+		target = &resumingTarget{
+			Target: target,
+			root:   opts.OutputPath,
+			layer:  layerInfo.Digest,
+			done:   opts.Resume,
+		}
+	}
+	if opts.OnExtracted != nil {
+		target = &recordingTarget{
+			Target: target,
+			root:   opts.OutputPath,
+			layer:  layerInfo.Digest,
+			record: opts.OnExtracted,
+		}
+	}
+
+	format := opts.ForceFormat
+	if format == detector.FormatUnknown {
+		var err error
+		format, err = o.detectFormat(ctx, layerInfo)
+		if err != nil && o.verbose {
+			fmt.Fprintf(o.log, "  Format detection failed: %v, assuming gzip\n", err)
+		}
+	}
+
+	start := time.Now()
+	var count int
+	var presence output.DirPresence
+	var err error
+	if format == detector.FormatZstd || format == detector.FormatZstdChunked {
+		format = detector.FormatZstd
+		extractor := zstd.NewExtractor(o.wholeLayer(layerInfo))
+		extractor.SetWindowLogMax(o.zstdWindowLogMax)
+		extractor.SetOutputOptions(opts.Output)
+		count, presence, err = extractor.ExtractDir(ctx, opts.FilePath, target)
+	} else {
+		format = detector.FormatStandard
+		extractor := standard.NewExtractor(o.wholeLayer(layerInfo))
+		extractor.SetOutputOptions(opts.Output)
+		count, presence, err = extractor.ExtractDir(ctx, opts.FilePath, target)
+	}
+
+	o.metrics.ObserveExtraction(format.String(), attemptResult(count > 0, err), time.Since(start))
+	return count, presence, err
+}
+
+// observe runs an attempt to extract with format's method, recording its
+// result and duration in the metrics
+func (o *Orchestrator) observe(format detector.Format, attempt func() (bool, error)) (bool, error) {
+	start := time.Now()
+	extracted, err := attempt()
+	o.metrics.ObserveExtraction(format.String(), attemptResult(extracted, err), time.Since(start))
+	return extracted, err
+}
+
+// attemptResult names the outcome of an extraction attempt for the metrics
+func attemptResult(extracted bool, err error) string {
+	switch {
+	case err != nil:
+		return metrics.ResultFailure
+	case extracted:
+		return metrics.ResultSuccess
+	default:
+		return metrics.ResultAbsent
+	}
+}
+
+// recordingTarget reports the regular files written through a Target to an
+// OnExtracted callback
+type recordingTarget struct {
+	output.Target
+	root   string
+	layer  v1.Hash
+	record func(outputPath string, layer v1.Hash, md output.Metadata)
+}
+
+func (t *recordingTarget) WriteFile(name string, r io.Reader, md output.Metadata) error {
+	if err := t.Target.WriteFile(name, r, md); err != nil {
+		return err
+	}
+
+	t.record(filepath.Join(t.root, filepath.FromSlash(name)), t.layer, md)
+	return nil
+}
+
+// resumingTarget skips writing the regular files a Resume callback reports
+// as already written
+type resumingTarget struct {
+	output.Target
+	root  string
+	layer v1.Hash
+	done  func(outputPath string, layer v1.Hash) bool
+}
+
+// RemoveAll keeps a file that is done, as entries remove whatever is at
+// their path before being written. Whiteouts only apply to lower layers, so
+// one in this layer can't be meant to remove this layer's own file.
+func (t *resumingTarget) RemoveAll(name string) error {
+	if t.done(filepath.Join(t.root, filepath.FromSlash(name)), t.layer) {
+		return nil
+	}
+	return t.Target.RemoveAll(name)
+}
+
+func (t *resumingTarget) WriteFile(name string, r io.Reader, md output.Metadata) error {
+	if t.done(filepath.Join(t.root, filepath.FromSlash(name)), t.layer) {
+		return nil
+	}
+	return t.Target.WriteFile(name, r, md)
+}
+
+// ListOptions contains options for listing files
+type ListOptions struct {
+	ImageRef    string
+	ForceFormat detector.Format
+
+	// AllTypes lists directories, symlinks and other entry types too,
+	// rather than only regular files
+	AllTypes bool
+
+	// EStargzTOCOnly fails eStargz layers whose TOC can't be read, rather
+	// than listing them by downloading them in full
+	EStargzTOCOnly bool
+
+	// OnLayer, if set, is called before each layer is listed with its
+	// 1-based position in listing order, the number of layers and its digest
+	OnLayer func(n, total int, digest v1.Hash)
 }
 
-// ExtractOptions contains options for file extraction
-type ExtractOptions struct {
-	ImageRef    string
-	FilePath    string
-	OutputPath  string
-	ForceFormat detector.Format
+// FileEntry is a file listed from an image, with the layer it comes from
+type FileEntry struct {
+	output.Metadata
+	Layer v1.Hash
 }
 
-// Extract extracts a file from an OCI image
-func (o *Orchestrator) Extract(ctx context.Context, opts ExtractOptions) error {
-	// Get enhanced image layers with blob URLs
-	enhancedLayers, err := o.client.GetEnhancedLayers(ctx, opts.ImageRef)
-	if err != nil {
-		return fmt.Errorf("failed to get image layers: %w", err)
-	}
+// ListResult describes what a listing found
+type ListResult struct {
+	// Entries are the listed entries, sorted by path in byte order so
+	// listings compare equal across runs and formats. ListStream hands them
+	// to its callback in layer order instead and leaves this nil.
+	Entries []FileEntry
 
-	if o.verbose {
-		fmt.Printf("Found %d layers in image\n", len(enhancedLayers))
-	}
+	// Types counts the listed entries by type ("reg", "dir", "symlink", ...)
+	Types map[string]int
 
-	// Check if SOCI index exists for this image
-	var sociIndex *soci.IndexInfo
-	if opts.ForceFormat == detector.FormatSOCI || opts.ForceFormat == detector.FormatUnknown {
-		sociIndex, err = soci.DiscoverSOCIIndex(ctx, opts.ImageRef)
-		if err != nil && o.verbose {
-			fmt.Printf("No SOCI index found: %v\n", err)
-		} else if sociIndex != nil && o.verbose {
-			fmt.Println("Found SOCI index for image")
-		}
-	}
+	// TotalSize is the summed size of the listed regular files
+	TotalSize int64
 
-	// Try to extract from each layer (bottom-up, as layers are applied in order)
-	for i := len(enhancedLayers) - 1; i >= 0; i-- {
-		layerInfo := enhancedLayers[i]
+	// Layers is the number of layers listed, and FailedLayers the number
+	// skipped because none of their formats could list them
+	Layers       int
+	FailedLayers int
 
-		if o.verbose {
-			fmt.Printf("Checking layer %s...\n", layerInfo.Digest)
-		}
+	// BytesFetched counts the bytes read from registry responses, which is
+	// only done while metrics are recorded (see SetMetrics)
+	BytesFetched int64
 
-		// Try extraction
-		extracted, err := o.extractFromLayer(ctx, layerInfo, sociIndex, opts)
-		if err != nil {
-			if o.verbose {
-				fmt.Printf("  Failed: %v\n", err)
-			}
-			continue
-		}
+	// Duration is how long the listing took, image lookup included
+	Duration time.Duration
+}
 
-		if extracted {
-			return nil
-		}
+// List lists all files in an OCI image, sorted by path
+func (o *Orchestrator) List(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	var entries []FileEntry
+
+	result, err := o.ListStream(ctx, opts, func(entry FileEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return fmt.Errorf("file %s not found in any layer", opts.FilePath)
+	slices.SortFunc(entries, CompareEntryPaths)
+	result.Entries = entries
+	return result, nil
 }
 
-// ListOptions contains options for listing files
-type ListOptions struct {
-	ImageRef    string
-	ForceFormat detector.Format
+// CompareEntryPaths orders entries by path, byte by byte rather than by any
+// locale's collation. It's the order List returns entries in.
+func CompareEntryPaths(a, b FileEntry) int {
+	return strings.Compare(a.Path, b.Path)
 }
 
-// List lists all files in an OCI image
-func (o *Orchestrator) List(ctx context.Context, opts ListOptions) ([]string, error) {
+// ListStream lists all files in an OCI image, calling fn for each entry as
+// soon as its layer has been enumerated so callers can output progressively.
+// Paths already emitted for an upper layer are skipped. An error returned by
+// fn stops the listing and is returned as is.
+func (o *Orchestrator) ListStream(ctx context.Context, opts ListOptions, fn func(entry FileEntry) error) (*ListResult, error) {
+	start := time.Now()
+	fetched := o.metrics.Fetched()
+	result := &ListResult{Types: make(map[string]int)}
+	if err := o.listStream(ctx, opts, result, fn); err != nil {
+		return nil, err
+	}
+
+	result.BytesFetched = o.metrics.Fetched() - fetched
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// listStream does the work of ListStream, counting in result what it emits
+func (o *Orchestrator) listStream(ctx context.Context, opts ListOptions, result *ListResult, fn func(entry FileEntry) error) error {
 	// Get enhanced image layers with blob URLs
-	enhancedLayers, err := o.client.GetEnhancedLayers(ctx, opts.ImageRef)
+	enhancedLayers, err := o.getLayers(ctx, opts.ImageRef)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get image layers: %w", err)
+		return err
 	}
 
-	if o.verbose {
-		fmt.Printf("Found %d layers in image\n", len(enhancedLayers))
+	// Check once for a SOCI index rather than once per layer
+	sociIndex, err := o.discoverSOCIIndex(ctx, opts.ImageRef, opts.ForceFormat)
+	if err != nil {
+		return err
 	}
 
-	var allFiles []string
+	// Paths emitted so far (upper layers override lower ones)
+	seen := make(map[string]bool)
 
-	// List files from each layer (bottom-up, as layers are applied in order)
+	// List each layer from the topmost down, as layers are applied bottom first
 	for i := len(enhancedLayers) - 1; i >= 0; i-- {
 		layerInfo := enhancedLayers[i]
 
+		if opts.OnLayer != nil {
+			opts.OnLayer(len(enhancedLayers)-i, len(enhancedLayers), layerInfo.Digest)
+		}
+		if o.skipEmptyLayer(layerInfo) {
+			continue
+		}
 		if o.verbose {
-			fmt.Printf("Listing files in layer %s...\n", layerInfo.Digest)
+			fmt.Fprintf(o.log, "Listing files in layer %s...\n", layerInfo.Digest)
 		}
 
 		// List files from this layer
-		files, err := o.listFromLayer(ctx, layerInfo, opts)
+		entries, err := o.listFromLayer(ctx, layerInfo, sociIndex, opts)
+		if errors.Is(err, ErrLayerDigestMismatch) {
+			return err
+		}
+		if err != nil && opts.ForceFormat != detector.FormatUnknown {
+			// Skipping the layer would leave its files out without a word
+			return err
+		}
 		if err != nil {
 			if o.verbose {
-				fmt.Printf("  Failed to list files: %v\n", err)
+				fmt.Fprintf(o.log, "  Failed to list files: %v\n", err)
 			}
+			result.FailedLayers++
 			continue
 		}
+		result.Layers++
 
-		// Add files to the list (avoiding duplicates from upper layers)
-		fileSet := make(map[string]bool)
-		for _, f := range allFiles {
-			fileSet[f] = true
-		}
-		for _, f := range files {
-			if !fileSet[f] {
-				allFiles = append(allFiles, f)
+		for _, md := range entries {
+			if seen[md.Path] {
+				continue
+			}
+			seen[md.Path] = true
+
+			result.Types[md.Type]++
+			if md.Type == "reg" {
+				result.TotalSize += md.Size
+			}
+			if err := fn(FileEntry{Metadata: md, Layer: layerInfo.Digest}); err != nil {
+				return err
 			}
 		}
 	}
 
-	return allFiles, nil
+	return nil
+}
+
+// listFromLayer lists files from a single layer, keeping only regular files
+// unless opts.AllTypes is set
+func (o *Orchestrator) listFromLayer(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo, opts ListOptions) ([]output.Metadata, error) {
+	entries, err := o.listEntriesFromLayer(ctx, layerInfo, sociIndex, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.AllTypes {
+		return output.RegularFiles(entries), nil
+	}
+	return entries, nil
 }
 
-// listFromLayer lists files from a single layer
-func (o *Orchestrator) listFromLayer(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, opts ListOptions) ([]string, error) {
-	// Detect format if not forced
+// listEntriesFromLayer lists entries of every type from a single layer
+func (o *Orchestrator) listEntriesFromLayer(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo, opts ListOptions) ([]output.Metadata, error) {
+	// Like extraction, try the forced format or those the media type allows
 	format := opts.ForceFormat
+	formats := []detector.Format{format}
 	if format == detector.FormatUnknown {
 		var err error
-		format, err = detector.DetectFormat(ctx, layerInfo.Layer)
-		if err != nil {
-			if o.verbose {
-				fmt.Printf("  Format detection failed: %v, defaulting to standard\n", err)
-			}
-			format = detector.FormatStandard
+		format, err = o.detectFormat(ctx, layerInfo)
+		if err != nil && o.verbose {
+			fmt.Fprintf(o.log, "  Format detection failed: %v, trying every format\n", err)
 		}
+		formats = o.candidates(format)
 	}
 
 	if o.verbose {
-		fmt.Printf("  Detected format: %s\n", format)
+		fmt.Fprintf(o.log, "  Detected format: %s\n", format)
 	}
 
+	// The last listing that failed, reported if a forced format did
+	var attemptErr error
+
 	// Try eStargz listing
-	if format == detector.FormatUnknown || format == detector.FormatEStargz {
+	if slices.Contains(formats, detector.FormatEStargz) {
 		if o.verbose {
-			fmt.Println("  Trying eStargz format...")
+			fmt.Fprintln(o.log, "  Trying eStargz format...")
 		}
 
-		files, err := o.listEStargz(ctx, layerInfo)
-		if err == nil {
-			return files, nil
+		// Reading a forced eStargz layer as a plain tar.gz would be a
+		// different method
+		tocOnly := opts.EStargzTOCOnly || opts.ForceFormat == detector.FormatEStargz
+		files, err := o.listEStargz(ctx, layerInfo, tocOnly)
+		if err == nil || errors.Is(err, ErrLayerDigestMismatch) {
+			return files, err
+		}
+		if opts.EStargzTOCOnly && format == detector.FormatEStargz {
+			return nil, err
 		}
 
 		if o.verbose && err != nil {
-			fmt.Printf("  eStargz listing failed: %v\n", err)
+			fmt.Fprintf(o.log, "  eStargz listing failed: %v\n", err)
 		}
+		attemptErr = err
 	}
 
-	// Try SOCI listing (requires index discovery first)
-	if format == detector.FormatUnknown || format == detector.FormatSOCI {
+	// Try SOCI listing (if index exists)
+	if sociIndex != nil && slices.Contains(formats, detector.FormatSOCI) {
 		if o.verbose {
-			fmt.Println("  Trying SOCI format...")
+			fmt.Fprintln(o.log, "  Trying SOCI format...")
 		}
 
-		sociIndex, err := soci.DiscoverSOCIIndex(ctx, opts.ImageRef)
-		if err == nil && sociIndex != nil {
-			files, err := o.listSOCI(ctx, layerInfo, sociIndex)
-			if err == nil {
-				return files, nil
-			}
+		files, err := o.listSOCI(ctx, layerInfo, sociIndex)
+		if err == nil || errors.Is(err, ErrLayerDigestMismatch) {
+			return files, err
+		}
 
-			if o.verbose && err != nil {
-				fmt.Printf("  SOCI listing failed: %v\n", err)
-			}
+		if o.verbose && err != nil {
+			fmt.Fprintf(o.log, "  SOCI listing failed: %v\n", err)
 		}
+		attemptErr = err
 	}
 
 	// Try zstd:chunked listing
-	if format == detector.FormatUnknown || format == detector.FormatZstd || format == detector.FormatZstdChunked {
+	if slices.Contains(formats, detector.FormatZstdChunked) {
 		if o.verbose {
-			fmt.Println("  Trying zstd:chunked format...")
+			fmt.Fprintln(o.log, "  Trying zstd:chunked format...")
 		}
 
 		files, err := o.listZstdChunked(ctx, layerInfo)
-		if err == nil {
-			return files, nil
+		if err == nil || errors.Is(err, ErrLayerDigestMismatch) {
+			return files, err
 		}
 
 		if o.verbose && err != nil {
-			fmt.Printf("  zstd:chunked listing failed: %v\n", err)
+			fmt.Fprintf(o.log, "  zstd:chunked listing failed: %v\n", err)
 		}
+		attemptErr = err
 	}
 
 	// Try zstd listing
-	if format == detector.FormatUnknown || format == detector.FormatZstd {
+	if slices.Contains(formats, detector.FormatZstd) {
 		if o.verbose {
-			fmt.Println("  Trying zstd format...")
+			fmt.Fprintln(o.log, "  Trying zstd format...")
 		}
 
 		files, err := o.listZstd(ctx, layerInfo)
@@ -217,13 +1228,22 @@ func (o *Orchestrator) listFromLayer(ctx context.Context, layerInfo *registry.En
 		}
 
 		if o.verbose && err != nil {
-			fmt.Printf("  zstd listing failed: %v\n", err)
+			fmt.Fprintf(o.log, "  zstd listing failed: %v\n", err)
+		}
+		attemptErr = err
+	}
+
+	// A forced format is the only one tried
+	if opts.ForceFormat != detector.FormatUnknown && opts.ForceFormat != detector.FormatStandard {
+		if attemptErr == nil {
+			return nil, fmt.Errorf("layer %s can't be listed as %s: %w", layerInfo.Digest, opts.ForceFormat, ErrFormatNotApplicable)
 		}
+		return nil, fmt.Errorf("layer %s can't be listed as %s: %w: %w", layerInfo.Digest, opts.ForceFormat, ErrFormatNotApplicable, attemptErr)
 	}
 
 	// Try standard listing as fallback
 	if o.verbose {
-		fmt.Println("  Using standard format...")
+		fmt.Fprintln(o.log, "  Using standard format...")
 	}
 
 	files, err := o.listStandard(ctx, layerInfo)
@@ -234,20 +1254,23 @@ func (o *Orchestrator) listFromLayer(ctx context.Context, layerInfo *registry.En
 	return files, nil
 }
 
-// listEStargz lists files from an eStargz layer
-func (o *Orchestrator) listEStargz(ctx context.Context, layerInfo *registry.EnhancedLayerInfo) ([]string, error) {
-	// Create RemoteReader for the layer using its blob URL
-	reader, err := remote.NewRemoteReader(layerInfo.BlobURL)
+// listEStargz lists files from an eStargz layer's TOC, or from the whole
+// layer when the TOC can't be read and tocOnly isn't set
+func (o *Orchestrator) listEStargz(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, tocOnly bool) ([]output.Metadata, error) {
+	// Open the layer blob for random access
+	reader, err := o.newLayerReader(ctx, layerInfo)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create remote reader: %w", err)
+		return nil, fmt.Errorf("failed to open layer blob: %w", err)
 	}
-	defer func() { _ = reader.Close() }()
+	defer o.closeLayerReader(reader)
 
 	// Create eStargz extractor
 	extractor := estargz.NewExtractor(reader, layerInfo.Size)
+	extractor.SetTOCDigest(layerInfo.Annotations[estargz.TOCDigestAnnotation])
+	extractor.SetTOCOnly(tocOnly)
 
 	// List files
-	files, err := extractor.ListFiles(ctx)
+	files, err := extractor.ListEntries(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -256,19 +1279,19 @@ func (o *Orchestrator) listEStargz(ctx context.Context, layerInfo *registry.Enha
 }
 
 // listSOCI lists files from a SOCI-indexed layer
-func (o *Orchestrator) listSOCI(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo) ([]string, error) {
+func (o *Orchestrator) listSOCI(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo) ([]output.Metadata, error) {
 	// Get the zTOC for this specific layer
 	ztocBlob, err := soci.GetZtocForLayer(ctx, sociIndex, layerInfo.Digest)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get zTOC for layer: %w", err)
 	}
 
-	// Create RemoteReader for the layer using its blob URL
-	reader, err := remote.NewRemoteReader(layerInfo.BlobURL)
+	// Open the layer blob for random access
+	reader, err := o.newLayerReader(ctx, layerInfo)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create remote reader: %w", err)
+		return nil, fmt.Errorf("failed to open layer blob: %w", err)
 	}
-	defer func() { _ = reader.Close() }()
+	defer o.closeLayerReader(reader)
 
 	// Create SOCI extractor
 	extractor, err := soci.NewExtractor(reader, layerInfo.Size, ztocBlob)
@@ -277,17 +1300,17 @@ func (o *Orchestrator) listSOCI(ctx context.Context, layerInfo *registry.Enhance
 	}
 
 	// List files
-	files := extractor.ListFiles()
+	files := extractor.ListEntries()
 	return files, nil
 }
 
 // listStandard lists files from a standard OCI layer
-func (o *Orchestrator) listStandard(ctx context.Context, layerInfo *registry.EnhancedLayerInfo) ([]string, error) {
+func (o *Orchestrator) listStandard(ctx context.Context, layerInfo *registry.EnhancedLayerInfo) ([]output.Metadata, error) {
 	// Create standard extractor
-	extractor := standard.NewExtractor(layerInfo.Layer)
+	extractor := standard.NewExtractor(o.wholeLayer(layerInfo))
 
 	// List files
-	files, err := extractor.ListFiles(ctx)
+	files, err := extractor.ListEntries(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -296,12 +1319,13 @@ func (o *Orchestrator) listStandard(ctx context.Context, layerInfo *registry.Enh
 }
 
 // listZstd lists files from a zstd-compressed OCI layer
-func (o *Orchestrator) listZstd(ctx context.Context, layerInfo *registry.EnhancedLayerInfo) ([]string, error) {
+func (o *Orchestrator) listZstd(ctx context.Context, layerInfo *registry.EnhancedLayerInfo) ([]output.Metadata, error) {
 	// Create zstd extractor
-	extractor := zstd.NewExtractor(layerInfo.Layer)
+	extractor := zstd.NewExtractor(o.wholeLayer(layerInfo))
+	extractor.SetWindowLogMax(o.zstdWindowLogMax)
 
 	// List files
-	files, err := extractor.ListFiles(ctx)
+	files, err := extractor.ListEntries(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -310,19 +1334,20 @@ func (o *Orchestrator) listZstd(ctx context.Context, layerInfo *registry.Enhance
 }
 
 // listZstdChunked lists files from a zstd:chunked layer
-func (o *Orchestrator) listZstdChunked(ctx context.Context, layerInfo *registry.EnhancedLayerInfo) ([]string, error) {
-	// Create RemoteReader for the layer using its blob URL
-	reader, err := remote.NewRemoteReader(layerInfo.BlobURL)
+func (o *Orchestrator) listZstdChunked(ctx context.Context, layerInfo *registry.EnhancedLayerInfo) ([]output.Metadata, error) {
+	// Open the layer blob for random access
+	reader, err := o.newLayerReader(ctx, layerInfo)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create remote reader: %w", err)
+		return nil, fmt.Errorf("failed to open layer blob: %w", err)
 	}
-	defer func() { _ = reader.Close() }()
+	defer o.closeLayerReader(reader)
 
 	// Create zstd:chunked extractor
 	extractor := zstd.NewChunkedExtractor(reader, layerInfo.Size)
+	extractor.SetWindowLogMax(o.zstdWindowLogMax)
 
 	// List files
-	files, err := extractor.ListFiles(ctx)
+	files, err := extractor.ListEntries(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -330,119 +1355,243 @@ func (o *Orchestrator) listZstdChunked(ctx context.Context, layerInfo *registry.
 	return files, nil
 }
 
-// extractFromLayer attempts to extract a file from a single layer
-func (o *Orchestrator) extractFromLayer(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo, opts ExtractOptions) (bool, error) {
-	// Detect format if not forced
+// extractFromLayer attempts to extract a file from a single layer. It returns
+// the format the file was extracted with, or FormatUnknown and the error of
+// the last format tried if none could extract it. That error wraps
+// fs.ErrNotExist when the layer doesn't hold the file, and
+// ErrFormatNotApplicable when a forced format can't read the layer.
+func (o *Orchestrator) extractFromLayer(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo, opts ExtractOptions) (detector.Format, error) {
+	// eStargz landmarks and TOC are part of the layer format, not the image
+	if output.IsStargzInternal(opts.FilePath) {
+		return detector.FormatUnknown, fmt.Errorf("%s is an eStargz internal entry", opts.FilePath)
+	}
+
+	if opts.OnExtracted != nil {
+		opts.Output.Record = func(md output.Metadata) {
+			opts.OnExtracted(opts.OutputPath, layerInfo.Digest, md)
+		}
+	}
+
+	// A forced format is the only one tried. Otherwise every format the
+	// layer's media type allows is, since it can't tell e.g. eStargz from
+	// plain gzip.
 	format := opts.ForceFormat
+	formats := []detector.Format{format}
 	if format == detector.FormatUnknown {
 		var err error
-		format, err = detector.DetectFormat(ctx, layerInfo.Layer)
-		if err != nil {
+		format, err = o.detectFormat(ctx, layerInfo)
+		if err != nil && o.verbose {
+			fmt.Fprintf(o.log, "  Format detection failed: %v, trying every format\n", err)
+		}
+		formats = o.candidates(format)
+
+		if layerInfo.Size > 0 && layerInfo.Size < o.smallLayerThreshold {
+			formats = slices.DeleteFunc(formats, detector.Format.Seekable)
 			if o.verbose {
-				fmt.Printf("  Format detection failed: %v, trying eStargz anyway\n", err)
+				fmt.Fprintf(o.log, "  Layer is only %d bytes, downloading it in full\n", layerInfo.Size)
 			}
-			format = detector.FormatEStargz
 		}
 	}
 
 	if o.verbose {
-		fmt.Printf("  Detected format: %s\n", format)
+		fmt.Fprintf(o.log, "  Detected format: %s\n", format)
 	}
 
+	// Records a seekable attempt that was expected to work but failed, so the
+	// switch to a full-layer download can be surfaced to the user
+	var seekableFailure *fallbackReason
+
+	// The error of the last format tried, which is what the layer is
+	// reported as when no format extracts the file: if the full download
+	// found the file absent, it is, whatever a seekable format ran into
+	var lastErr error
+
 	// Try eStargz extraction
-	if format == detector.FormatUnknown || format == detector.FormatEStargz {
+	if slices.Contains(formats, detector.FormatEStargz) {
 		if o.verbose {
-			fmt.Println("  Trying eStargz format...")
+			fmt.Fprintln(o.log, "  Trying eStargz format...")
 		}
 
-		extracted, err := o.extractEStargz(ctx, layerInfo, opts)
+		extracted, err := o.observe(detector.FormatEStargz, func() (bool, error) {
+			return o.extractEStargz(ctx, layerInfo, opts)
+		})
 		if err == nil && extracted {
-			return true, nil
+			return detector.FormatEStargz, nil
+		}
+		if abortsLayerSearch(err) {
+			// Downloading the layer in full would fetch the same content
+			return detector.FormatUnknown, err
 		}
 
-		if o.verbose && err != nil {
-			fmt.Printf("  eStargz extraction failed: %v\n", err)
+		if err != nil {
+			lastErr = err
+			if o.verbose {
+				fmt.Fprintf(o.log, "  eStargz extraction failed: %v\n", err)
+			}
+		}
+		if seekableFailed(err) && format == detector.FormatEStargz {
+			seekableFailure = &fallbackReason{method: "eStargz", err: err}
 		}
 	}
 
 	// Try SOCI extraction if index is available
-	if (format == detector.FormatUnknown || format == detector.FormatSOCI) && sociIndex != nil {
+	if slices.Contains(formats, detector.FormatSOCI) && sociIndex != nil {
 		if o.verbose {
-			fmt.Println("  Trying SOCI format...")
+			fmt.Fprintln(o.log, "  Trying SOCI format...")
 		}
 
-		extracted, err := o.extractSOCI(ctx, layerInfo, sociIndex, opts)
+		extracted, err := o.observe(detector.FormatSOCI, func() (bool, error) {
+			return o.extractSOCI(ctx, layerInfo, sociIndex, opts)
+		})
 		if err == nil && extracted {
-			return true, nil
+			return detector.FormatSOCI, nil
+		}
+		if abortsLayerSearch(err) {
+			return detector.FormatUnknown, err
 		}
 
-		if o.verbose && err != nil {
-			fmt.Printf("  SOCI extraction failed: %v\n", err)
+		if err != nil {
+			lastErr = err
+			if o.verbose {
+				fmt.Fprintf(o.log, "  SOCI extraction failed: %v\n", err)
+			}
+		}
+		if seekableFailed(err) {
+			// An index exists for the image, so SOCI was expected to work
+			seekableFailure = &fallbackReason{method: "SOCI", err: err}
 		}
 	}
 
 	// Try zstd:chunked extraction
-	if format == detector.FormatUnknown || format == detector.FormatZstd || format == detector.FormatZstdChunked {
+	if slices.Contains(formats, detector.FormatZstdChunked) {
 		if o.verbose {
-			fmt.Println("  Trying zstd:chunked format...")
+			fmt.Fprintln(o.log, "  Trying zstd:chunked format...")
 		}
 
-		extracted, err := o.extractZstdChunked(ctx, layerInfo, opts)
+		extracted, err := o.observe(detector.FormatZstdChunked, func() (bool, error) {
+			return o.extractZstdChunked(ctx, layerInfo, opts)
+		})
 		if err == nil && extracted {
-			return true, nil
+			return detector.FormatZstdChunked, nil
+		}
+		if abortsLayerSearch(err) {
+			// Reading the layer as plain zstd would fail the same way
+			return detector.FormatUnknown, err
 		}
 
-		if o.verbose && err != nil {
-			fmt.Printf("  zstd:chunked extraction failed: %v\n", err)
+		if err != nil {
+			lastErr = err
+			if o.verbose {
+				fmt.Fprintf(o.log, "  zstd:chunked extraction failed: %v\n", err)
+			}
+		}
+		if seekableFailed(err) && format == detector.FormatZstdChunked {
+			seekableFailure = &fallbackReason{method: "zstd:chunked", err: err}
 		}
 	}
 
 	// Try zstd extraction
-	if format == detector.FormatUnknown || format == detector.FormatZstd {
+	if slices.Contains(formats, detector.FormatZstd) {
 		if o.verbose {
-			fmt.Println("  Trying zstd format...")
+			fmt.Fprintln(o.log, "  Trying zstd format...")
 		}
 
-		extracted, err := o.extractZstd(ctx, layerInfo, opts)
+		o.warnFullDownload(layerInfo, seekableFailure)
+		seekableFailure = nil
+
+		extracted, err := o.observe(detector.FormatZstd, func() (bool, error) {
+			return o.extractZstd(ctx, layerInfo, opts)
+		})
 		if err == nil && extracted {
-			return true, nil
+			return detector.FormatZstd, nil
+		}
+		if abortsLayerSearch(err) {
+			return detector.FormatUnknown, err
 		}
 
-		if o.verbose && err != nil {
-			fmt.Printf("  zstd extraction failed: %v\n", err)
+		if err != nil {
+			lastErr = err
+			if o.verbose {
+				fmt.Fprintf(o.log, "  zstd extraction failed: %v\n", err)
+			}
 		}
 	}
 
 	// Try standard extraction as fallback
-	if format == detector.FormatUnknown || format == detector.FormatStandard {
+	if slices.Contains(formats, detector.FormatStandard) {
 		if o.verbose {
-			fmt.Println("  Trying standard format...")
+			fmt.Fprintln(o.log, "  Trying standard format...")
 		}
 
-		extracted, err := o.extractStandard(ctx, layerInfo, opts)
+		o.warnFullDownload(layerInfo, seekableFailure)
+
+		extracted, err := o.observe(detector.FormatStandard, func() (bool, error) {
+			return o.extractStandard(ctx, layerInfo, opts)
+		})
 		if err == nil && extracted {
-			return true, nil
+			return detector.FormatStandard, nil
+		}
+		if abortsLayerSearch(err) {
+			return detector.FormatUnknown, err
 		}
 
-		if o.verbose && err != nil {
-			fmt.Printf("  Standard extraction failed: %v\n", err)
+		if err != nil {
+			lastErr = err
+			if o.verbose {
+				fmt.Fprintf(o.log, "  Standard extraction failed: %v\n", err)
+			}
+		}
+	}
+
+	// A forced format is the only one tried, so a layer it can't read fails
+	// rather than being searched with another format
+	if opts.ForceFormat != detector.FormatUnknown && opts.ForceFormat != detector.FormatStandard && !errors.Is(lastErr, fs.ErrNotExist) {
+		if lastErr == nil {
+			return detector.FormatUnknown, fmt.Errorf("layer %s can't be read as %s: %w", layerInfo.Digest, opts.ForceFormat, ErrFormatNotApplicable)
 		}
+		return detector.FormatUnknown, fmt.Errorf("layer %s can't be read as %s: %w: %w", layerInfo.Digest, opts.ForceFormat, ErrFormatNotApplicable, lastErr)
+	}
+
+	return detector.FormatUnknown, lastErr
+}
+
+// fallbackReason describes a failed seekable extraction attempt
+type fallbackReason struct {
+	method string
+	err    error
+}
+
+// seekableFailed reports whether err from a seekable attempt is a failure to
+// read an index that applies to the layer, as opposed to the layer having no
+// zTOC or the file not being in the layer, which aren't worth a warning
+func seekableFailed(err error) bool {
+	return err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, soci.ErrNoZtoc)
+}
+
+// warnFullDownload tells the user, even without --verbose, that a seekable
+// extraction failed and the entire layer is about to be downloaded
+func (o *Orchestrator) warnFullDownload(layerInfo *registry.EnhancedLayerInfo, reason *fallbackReason) {
+	if reason == nil {
+		return
 	}
 
-	return false, nil
+	fmt.Fprintf(o.log, "Warning: %s extraction failed for layer %s (%v), downloading entire layer (%.1f MB)\n",
+		reason.method, layerInfo.Digest, reason.err, float64(layerInfo.Size)/(1024*1024))
 }
 
 // extractEStargz extracts from an eStargz layer
 func (o *Orchestrator) extractEStargz(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, opts ExtractOptions) (bool, error) {
-	// Create RemoteReader for the layer using its blob URL
-	reader, err := remote.NewRemoteReader(layerInfo.BlobURL)
+	// Open the layer blob for random access
+	reader, err := o.newLayerReader(ctx, layerInfo)
 	if err != nil {
-		return false, fmt.Errorf("failed to create remote reader: %w", err)
+		return false, fmt.Errorf("failed to open layer blob: %w", err)
 	}
-	defer func() { _ = reader.Close() }()
+	defer o.closeLayerReader(reader)
 
 	// Create eStargz extractor
 	extractor := estargz.NewExtractor(reader, layerInfo.Size)
+	extractor.SetTOCDigest(layerInfo.Annotations[estargz.TOCDigestAnnotation])
+	extractor.SetOutputOptions(opts.Output)
 
 	// Try to extract the file
 	err = extractor.ExtractFile(ctx, opts.FilePath, opts.OutputPath)
@@ -458,12 +1607,12 @@ func (o *Orchestrator) extractSOCI(ctx context.Context, layerInfo *registry.Enha
 		return false, fmt.Errorf("no SOCI index available")
 	}
 
-	// Create RemoteReader for the layer using its blob URL
-	reader, err := remote.NewRemoteReader(layerInfo.BlobURL)
+	// Open the layer blob for random access
+	reader, err := o.newLayerReader(ctx, layerInfo)
 	if err != nil {
-		return false, fmt.Errorf("failed to create remote reader: %w", err)
+		return false, fmt.Errorf("failed to open layer blob: %w", err)
 	}
-	defer func() { _ = reader.Close() }()
+	defer o.closeLayerReader(reader)
 
 	// Get the zTOC for this specific layer
 	ztocBlob, err := soci.GetZtocForLayer(ctx, sociIndex, layerInfo.Digest)
@@ -476,6 +1625,14 @@ func (o *Orchestrator) extractSOCI(ctx context.Context, layerInfo *registry.Enha
 	if err != nil {
 		return false, fmt.Errorf("failed to create SOCI extractor: %w", err)
 	}
+	extractor.SetOutputOptions(opts.Output)
+
+	if o.prefetch > 0 {
+		// ExtractFile reports a zTOC that can't be mapped to ranges
+		if ranges, err := extractor.Ranges(opts.FilePath); err == nil {
+			o.prefetchRanges(ctx, reader, ranges)
+		}
+	}
 
 	err = extractor.ExtractFile(ctx, opts.FilePath, opts.OutputPath)
 	if err != nil {
@@ -488,7 +1645,8 @@ func (o *Orchestrator) extractSOCI(ctx context.Context, layerInfo *registry.Enha
 func (o *Orchestrator) extractStandard(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, opts ExtractOptions) (bool, error) {
 	// Create standard extractor
 	// This downloads and decompresses the entire layer
-	extractor := standard.NewExtractor(layerInfo.Layer)
+	extractor := standard.NewExtractor(o.wholeLayer(layerInfo))
+	extractor.SetOutputOptions(opts.Output)
 
 	// Try to extract the file
 	err := extractor.ExtractFile(ctx, opts.FilePath, opts.OutputPath)
@@ -502,7 +1660,9 @@ func (o *Orchestrator) extractStandard(ctx context.Context, layerInfo *registry.
 // extractZstd extracts from a zstd-compressed OCI layer
 func (o *Orchestrator) extractZstd(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, opts ExtractOptions) (bool, error) {
 	// Create zstd extractor
-	extractor := zstd.NewExtractor(layerInfo.Layer)
+	extractor := zstd.NewExtractor(o.wholeLayer(layerInfo))
+	extractor.SetWindowLogMax(o.zstdWindowLogMax)
+	extractor.SetOutputOptions(opts.Output)
 
 	// Try to extract the file
 	err := extractor.ExtractFile(ctx, opts.FilePath, opts.OutputPath)
@@ -515,15 +1675,22 @@ func (o *Orchestrator) extractZstd(ctx context.Context, layerInfo *registry.Enha
 
 // extractZstdChunked extracts from a zstd:chunked layer
 func (o *Orchestrator) extractZstdChunked(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, opts ExtractOptions) (bool, error) {
-	// Create RemoteReader for the layer using its blob URL
-	reader, err := remote.NewRemoteReader(layerInfo.BlobURL)
+	// Open the layer blob for random access
+	reader, err := o.newLayerReader(ctx, layerInfo)
 	if err != nil {
-		return false, fmt.Errorf("failed to create remote reader: %w", err)
+		return false, fmt.Errorf("failed to open layer blob: %w", err)
 	}
-	defer func() { _ = reader.Close() }()
+	defer o.closeLayerReader(reader)
 
 	// Create zstd:chunked extractor
 	extractor := zstd.NewChunkedExtractor(reader, layerInfo.Size)
+	extractor.SetTOCDigest(layerInfo.Annotations[zstd.ChunkedTOCDigestAnnotation])
+	extractor.SetWindowLogMax(o.zstdWindowLogMax)
+	extractor.SetOutputOptions(opts.Output)
+
+	if o.prefetch > 0 {
+		o.prefetchRanges(ctx, reader, extractor.Ranges(opts.FilePath))
+	}
 
 	// Try to extract the file
 	err = extractor.ExtractFile(ctx, opts.FilePath, opts.OutputPath)
@@ -533,3 +1700,278 @@ func (o *Orchestrator) extractZstdChunked(ctx context.Context, layerInfo *regist
 
 	return true, nil
 }
+
+// prefetchRanges fetches ranges into reader concurrently when it supports it.
+// A failed prefetch is only logged, since the extraction reads the same ranges
+// again on demand.
+func (o *Orchestrator) prefetchRanges(ctx context.Context, reader remote.BlobReader, ranges []remote.Range) {
+	prefetcher, ok := reader.(remote.Prefetcher)
+	if !ok || len(ranges) == 0 {
+		return
+	}
+
+	if o.verbose {
+		fmt.Fprintf(o.log, "  Prefetching %d ranges with %d parallel requests\n", len(ranges), o.prefetch)
+	}
+	if err := prefetcher.Prefetch(ctx, ranges, o.prefetch); err != nil && o.verbose {
+		fmt.Fprintf(o.log, "  Prefetch failed: %v\n", err)
+	}
+}
+
+// newLayerReader opens a layer blob for random access: from local storage for
+// OCI layouts and containerd, otherwise through its blob URL, authenticated
+// with the registry credentials
+func (o *Orchestrator) newLayerReader(ctx context.Context, layerInfo *registry.EnhancedLayerInfo) (remote.BlobReader, error) {
+	if layerInfo.BlobURL == "" {
+		reader, err := o.client.OpenLocalBlob(ctx, layerInfo.Digest, layerInfo.Size)
+		if err != nil {
+			return nil, err
+		}
+		err = o.verifyLayer(layerInfo, func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(reader, 0, reader.Size())), nil
+		})
+		if err != nil {
+			_ = reader.Close()
+			return nil, err
+		}
+		return reader, nil
+	}
+
+	// A layer kept by an earlier whole-layer extraction needs no range
+	// requests, nor verifying, as it was checked when downloaded
+	if o.layerCache != nil {
+		if reader, err := o.layerCache.open(layerInfo.Digest); err == nil {
+			return reader, nil
+		}
+	}
+
+	client, err := o.client.BlobHTTPClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.verifyLayer(layerInfo, func() (io.ReadCloser, error) {
+		return remote.OpenBlob(ctx, layerInfo.BlobURL, client)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := remote.NewRemoteReaderWithClient(ctx, layerInfo.BlobURL, client)
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// closeLayerReader closes a reader opened by newLayerReader, adding how its
+// reads were served to the orchestrator's cache statistics
+func (o *Orchestrator) closeLayerReader(reader remote.BlobReader) {
+	if reporter, ok := reader.(remote.CacheReporter); ok {
+		stats := reporter.CacheStats()
+		o.cacheStatsMu.Lock()
+		o.cacheStats = o.cacheStats.Add(stats)
+		o.cacheStatsMu.Unlock()
+	}
+	_ = reader.Close()
+}
+
+// CacheStats returns how the range reads of seekable extractions and
+// listings have been served by the readers' caches, summed over the layers
+// read so far
+func (o *Orchestrator) CacheStats() remote.CacheStats {
+	o.cacheStatsMu.Lock()
+	defer o.cacheStatsMu.Unlock()
+	return o.cacheStats
+}
+
+// printCacheStats prints the cache statistics in verbose mode, once any range
+// reads were made
+func (o *Orchestrator) printCacheStats() {
+	stats := o.CacheStats()
+	if !o.verbose || stats.Reads() == 0 {
+		return
+	}
+
+	fmt.Fprintf(o.log, "Range read cache: %d hits, %d prefetched, %d misses, %.1f KB saved\n",
+		stats.Hits, stats.PrefetchHits, stats.Misses, float64(stats.BytesSaved)/1024)
+}
+
+// verifyLayer hashes the layer read from open against its digest when
+// VerifyLayers is set, returning an ErrLayerDigestMismatch-wrapping error if
+// they differ. Each layer is only hashed once.
+func (o *Orchestrator) verifyLayer(layerInfo *registry.EnhancedLayerInfo, open func() (io.ReadCloser, error)) error {
+	if !o.verifyLayers {
+		return nil
+	}
+
+	o.verifiedMu.Lock()
+	verified := o.verified[layerInfo.Digest]
+	o.verifiedMu.Unlock()
+	if verified {
+		return nil
+	}
+
+	if layerInfo.Digest.Algorithm != "sha256" {
+		return fmt.Errorf("cannot verify layer %s: unsupported digest algorithm", layerInfo.Digest)
+	}
+
+	if o.verbose {
+		fmt.Fprintf(o.log, "  Verifying layer %s (%.1f MB)...\n", layerInfo.Digest, float64(layerInfo.Size)/(1024*1024))
+	}
+
+	rc, err := open()
+	if err != nil {
+		return fmt.Errorf("failed to read layer to verify it: %w", err)
+	}
+	defer func() { _ = rc.Close() }()
+
+	got, _, err := v1.SHA256(rc)
+	if err != nil {
+		return fmt.Errorf("failed to read layer to verify it: %w", err)
+	}
+	if got != layerInfo.Digest {
+		return fmt.Errorf("%w: layer %s hashes to %s", ErrLayerDigestMismatch, layerInfo.Digest, got)
+	}
+
+	o.verifiedMu.Lock()
+	if o.verified == nil {
+		o.verified = make(map[v1.Hash]bool)
+	}
+	o.verified[layerInfo.Digest] = true
+	o.verifiedMu.Unlock()
+	return nil
+}
+
+// discoverSOCIIndex looks up the SOCI index for the image pinned by the last
+// GetEnhancedLayers call. It returns nil when SOCI doesn't apply or no index
+// exists, since a missing index only means other formats are tried. An error
+// is only returned when SOCI was forced on a platform that can't use it.
+func (o *Orchestrator) discoverSOCIIndex(ctx context.Context, imageRef string, format detector.Format) (*soci.IndexInfo, error) {
+	if format != detector.FormatUnknown && format != detector.FormatSOCI {
+		return nil, nil
+	}
+
+	if format == detector.FormatUnknown && o.disabled[detector.FormatSOCI] {
+		if o.verbose {
+			fmt.Fprintln(o.log, "Skipping SOCI index lookup: SOCI is disabled")
+		}
+		return nil, nil
+	}
+
+	// Looking for an index would fail the same way, and read as if none existed
+	if !soci.Supported {
+		if format == detector.FormatSOCI {
+			return nil, fmt.Errorf("cannot force the SOCI format: %w", soci.ErrNotSupported)
+		}
+		if o.verbose {
+			fmt.Fprintln(o.log, "Skipping SOCI index lookup: SOCI is not supported on this platform")
+		}
+		return nil, nil
+	}
+
+	if o.client.IsLocalSource(imageRef) {
+		if format == detector.FormatSOCI {
+			return nil, fmt.Errorf("cannot force the SOCI format: local images have no SOCI index: %w", ErrFormatNotApplicable)
+		}
+		return nil, nil
+	}
+
+	ref, err := o.client.PinnedReference()
+	if err != nil {
+		if format == detector.FormatSOCI {
+			return nil, fmt.Errorf("cannot force the SOCI format: %w: %w", ErrFormatNotApplicable, err)
+		}
+		if o.verbose {
+			fmt.Fprintf(o.log, "No SOCI index found: %v\n", err)
+		}
+		return nil, nil
+	}
+
+	// A pinned index was asked for, so failing to fetch it is an error
+	if o.sociIndexDigest != nil {
+		sociIndex, err := soci.FetchSOCIIndex(ctx, ref, *o.sociIndexDigest, o.client.RemoteOptions())
+		if err != nil {
+			return nil, err
+		}
+		sociIndex.LayerAnnotations = o.indexAnnotations
+		if o.verbose {
+			fmt.Fprintf(o.log, "Using SOCI index %s\n", o.sociIndexDigest)
+		}
+		return sociIndex, nil
+	}
+
+	sociIndex, err := soci.DiscoverSOCIIndex(ctx, ref, o.client.RemoteOptions())
+	if err != nil {
+		if format == detector.FormatSOCI {
+			return nil, fmt.Errorf("cannot force the SOCI format: no SOCI index found: %w: %w", ErrFormatNotApplicable, err)
+		}
+		if o.verbose {
+			fmt.Fprintf(o.log, "No SOCI index found: %v\n", err)
+		}
+		return nil, nil
+	}
+	sociIndex.LayerAnnotations = o.indexAnnotations
+
+	if o.verbose {
+		fmt.Fprintln(o.log, "Found SOCI index for image")
+	}
+	return sociIndex, nil
+}
+
+// Resolve returns imageRef pinned to the digest it currently points to,
+// or to the selected image's manifest when SelectManifest was called
+func (o *Orchestrator) Resolve(ctx context.Context, imageRef string) (string, error) {
+	digest, err := o.client.Resolve(ctx, imageRef)
+	if err != nil {
+		return "", err
+	}
+
+	return registry.WithDigest(imageRef, digest.String())
+}
+
+// Ping checks that a registry, or the registry of an image reference, is
+// reachable with the configured credentials and, for an image, whether its
+// blobs support the range requests seekable extraction relies on
+func (o *Orchestrator) Ping(ctx context.Context, target string) (*registry.PingReport, error) {
+	return o.client.Ping(ctx, target)
+}
+
+// getLayers fetches the image's layers, bottom layer first, reporting in
+// verbose mode which image the reference resolved to
+func (o *Orchestrator) getLayers(ctx context.Context, imageRef string) ([]*registry.EnhancedLayerInfo, error) {
+	enhancedLayers, err := o.client.GetEnhancedLayers(ctx, imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image layers: %w", err)
+	}
+
+	if o.verbose {
+		o.logResolvedReference(imageRef)
+		fmt.Fprintf(o.log, "Found %d layers in image\n", len(enhancedLayers))
+	}
+
+	return enhancedLayers, nil
+}
+
+// logResolvedReference prints the defaults applied to a registry reference,
+// such as an implied latest tag, and the digest it resolved to
+func (o *Orchestrator) logResolvedReference(imageRef string) {
+	pinned, err := o.client.PinnedReference()
+	if err != nil {
+		return
+	}
+
+	switch ref := o.client.Reference().(type) {
+	case name.Tag:
+		if _, err := name.NewTag(imageRef, name.StrictValidation); err != nil {
+			fmt.Fprintf(o.log, "No tag given, using %s\n", ref.TagStr())
+		}
+		fmt.Fprintf(o.log, "Resolved %s to %s\n", ref.Name(), pinned.DigestStr())
+
+	case name.Digest:
+		base, _, _ := strings.Cut(imageRef, "@")
+		if tag, err := name.NewTag(base, name.StrictValidation); err == nil {
+			fmt.Fprintf(o.log, "Using digest %s; tag %s is ignored\n", ref.DigestStr(), tag.TagStr())
+		}
+	}
+}
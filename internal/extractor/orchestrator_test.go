@@ -0,0 +1,1747 @@
+package extractor
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/amartani/oci-extract/internal/detector"
+	"github.com/amartani/oci-extract/internal/estargz"
+	"github.com/amartani/oci-extract/internal/metrics"
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/amartani/oci-extract/internal/registry"
+	"github.com/amartani/oci-extract/internal/soci"
+	stargz "github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// countingLayer counts calls to MediaType
+type countingLayer struct {
+	v1.Layer
+	calls int
+}
+
+// MediaType implements v1.Layer
+func (l *countingLayer) MediaType() (types.MediaType, error) {
+	l.calls++
+	return l.Layer.MediaType()
+}
+
+// TestDetectFormatCached tests that format detection runs once per layer digest
+func TestDetectFormatCached(t *testing.T) {
+	layer := &countingLayer{Layer: static.NewLayer([]byte("data"), types.OCILayerZStd)}
+	digest, err := layer.Digest()
+	if err != nil {
+		t.Fatalf("failed to get layer digest: %v", err)
+	}
+	layerInfo := &registry.EnhancedLayerInfo{Layer: layer, Digest: digest}
+
+	o := NewOrchestrator(false)
+	for range 3 {
+		format, err := o.detectFormat(context.Background(), layerInfo)
+		if err != nil {
+			t.Fatalf("detectFormat() error = %v", err)
+		}
+		if format != detector.FormatZstd {
+			t.Errorf("detectFormat() = %s, want %s", format, detector.FormatZstd)
+		}
+	}
+
+	if layer.calls != 1 {
+		t.Errorf("MediaType() called %d times, want 1", layer.calls)
+	}
+}
+
+// blockingLayer closes entered when MediaType is called, and blocks it
+// until release is closed
+type blockingLayer struct {
+	v1.Layer
+	entered chan struct{}
+	release chan struct{}
+}
+
+// MediaType implements v1.Layer
+func (l *blockingLayer) MediaType() (types.MediaType, error) {
+	close(l.entered)
+	<-l.release
+	return l.Layer.MediaType()
+}
+
+// TestDetectFormatConcurrent tests that detecting one layer doesn't hold up
+// detecting another
+func TestDetectFormatConcurrent(t *testing.T) {
+	slow := &blockingLayer{Layer: static.NewLayer([]byte("slow"), types.OCILayerZStd), entered: make(chan struct{}), release: make(chan struct{})}
+	fast := static.NewLayer([]byte("fast"), types.OCILayerZStd)
+
+	o := NewOrchestrator(false)
+	var infos []*registry.EnhancedLayerInfo
+	for _, layer := range []v1.Layer{slow, fast} {
+		digest, err := layer.Digest()
+		if err != nil {
+			t.Fatalf("failed to get layer digest: %v", err)
+		}
+		infos = append(infos, &registry.EnhancedLayerInfo{Layer: layer, Digest: digest})
+	}
+
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := o.detectFormat(context.Background(), infos[0])
+		slowDone <- err
+	}()
+	<-slow.entered
+
+	if _, err := o.detectFormat(context.Background(), infos[1]); err != nil {
+		t.Fatalf("detectFormat() error = %v", err)
+	}
+	close(slow.release)
+	if err := <-slowDone; err != nil {
+		t.Fatalf("detectFormat() of the blocked layer error = %v", err)
+	}
+}
+
+// relabeledLayer reports a media type other than its content's, like layers
+// pushed by registries that mislabel them
+type relabeledLayer struct {
+	v1.Layer
+	mediaType types.MediaType
+}
+
+// MediaType implements v1.Layer
+func (l *relabeledLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
+
+// TestExtractMislabeledLayer tests that a gzip layer labeled as zstd is
+// detected from its content and extracted
+func TestExtractMislabeledLayer(t *testing.T) {
+	layer := &relabeledLayer{
+		Layer:     gzipTarLayer(t, map[string]string{"etc/passwd": "root"}),
+		mediaType: types.OCILayerZStd,
+	}
+	imageRef := writeLayoutImage(t, layer)
+	outputPath := filepath.Join(t.TempDir(), "passwd")
+
+	_, err := NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "/etc/passwd",
+		OutputPath: outputPath,
+	})
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if string(data) != "root" {
+		t.Errorf("Extract() wrote %q, want %q", data, "root")
+	}
+}
+
+// gzipTarLayer builds a gzipped tar layer containing the given files
+func gzipTarLayer(t *testing.T, files map[string]string) v1.Layer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		// Names ending in a slash are written as directories
+		if strings.HasSuffix(name, "/") {
+			hdr = &tar.Header{Name: name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		if err := tarWriter.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write tar content: %v", err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %v", err)
+	}
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create layer: %v", err)
+	}
+	return layer
+}
+
+// estargzLayer builds an eStargz layer containing the given files
+func estargzLayer(t *testing.T, files map[string]string) v1.Layer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tarWriter.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write tar content: %v", err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+
+	blob, err := stargz.Build(io.NewSectionReader(bytes.NewReader(buf.Bytes()), 0, int64(buf.Len())))
+	if err != nil {
+		t.Fatalf("failed to build eStargz blob: %v", err)
+	}
+	defer func() { _ = blob.Close() }()
+
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("failed to read eStargz blob: %v", err)
+	}
+	return static.NewLayer(data, types.OCILayer)
+}
+
+// writeLayoutImage writes an image made of layers to a new OCI layout and
+// returns its oci: reference
+func writeLayoutImage(t *testing.T, layers ...v1.Layer) string {
+	t.Helper()
+
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		t.Fatalf("failed to build image: %v", err)
+	}
+
+	dir := t.TempDir()
+	p, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		t.Fatalf("failed to create layout: %v", err)
+	}
+	if err := p.AppendImage(img); err != nil {
+		t.Fatalf("failed to append image: %v", err)
+	}
+
+	return "oci:" + dir
+}
+
+// TestExtractByDigest tests extracting from a registry image named only by
+// digest, without a tag
+func TestExtractByDigest(t *testing.T) {
+	server := httptest.NewServer(ggcrregistry.New())
+	defer server.Close()
+
+	img, err := mutate.AppendLayers(empty.Image, gzipTarLayer(t, map[string]string{"etc/os-release": "ID=test"}))
+	if err != nil {
+		t.Fatalf("failed to build image: %v", err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("failed to get image digest: %v", err)
+	}
+
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/test/image@" + digest.String()
+	ref, err := name.NewDigest(imageRef)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "os-release")
+	_, err = NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "/etc/os-release",
+		OutputPath: outputPath,
+	})
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if string(data) != "ID=test" {
+		t.Errorf("Extract() wrote %q, want %q", data, "ID=test")
+	}
+}
+
+// TestExtractResult tests that Extract reports the format and layer the file
+// came from, and the bytes fetched when metrics are recorded
+func TestExtractResult(t *testing.T) {
+	server := httptest.NewServer(ggcrregistry.New())
+	defer server.Close()
+
+	lower := gzipTarLayer(t, map[string]string{"etc/os-release": "ID=test"})
+	img, err := mutate.AppendLayers(empty.Image, lower, gzipTarLayer(t, map[string]string{"etc/hostname": "test"}))
+	if err != nil {
+		t.Fatalf("failed to build image: %v", err)
+	}
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+	lowerDigest, err := lower.Digest()
+	if err != nil {
+		t.Fatalf("failed to get layer digest: %v", err)
+	}
+
+	o := NewOrchestrator(false)
+	o.SetMetrics(metrics.New())
+	result, err := o.Extract(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "/etc/os-release",
+		OutputPath: filepath.Join(t.TempDir(), "os-release"),
+	})
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+
+	if result.Format != detector.FormatStandard {
+		t.Errorf("Extract() format = %v, want %v", result.Format, detector.FormatStandard)
+	}
+	if result.Layer != lowerDigest {
+		t.Errorf("Extract() layer = %v, want %v", result.Layer, lowerDigest)
+	}
+	if result.BytesFetched <= 0 {
+		t.Errorf("Extract() bytes fetched = %d, want > 0", result.BytesFetched)
+	}
+	if result.Duration <= 0 {
+		t.Errorf("Extract() duration = %v, want > 0", result.Duration)
+	}
+}
+
+// TestListResult tests that List counts what it listed
+func TestListResult(t *testing.T) {
+	imageRef := writeLayoutImage(t,
+		gzipTarLayer(t, map[string]string{"etc/a": "a", "etc/b": "bb"}),
+		gzipTarLayer(t, map[string]string{"etc/c": "ccc"}),
+	)
+
+	result, err := NewOrchestrator(false).List(context.Background(), ListOptions{ImageRef: imageRef})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	var paths []string
+	for _, entry := range result.Entries {
+		paths = append(paths, entry.Path)
+	}
+	slices.Sort(paths)
+	if want := []string{"/etc/a", "/etc/b", "/etc/c"}; !slices.Equal(paths, want) {
+		t.Errorf("List() entries = %v, want %v", paths, want)
+	}
+	if result.Types["reg"] != 3 {
+		t.Errorf("List() types = %v, want 3 reg", result.Types)
+	}
+	if result.TotalSize != 6 {
+		t.Errorf("List() total size = %d, want 6", result.TotalSize)
+	}
+	if result.Layers != 2 || result.FailedLayers != 0 {
+		t.Errorf("List() layers = %d, failed %d, want 2, failed 0", result.Layers, result.FailedLayers)
+	}
+}
+
+// TestListSorted tests that List sorts its entries by path, so the same
+// content lists the same in every format
+func TestListSorted(t *testing.T) {
+	files := map[string]string{"usr/bin/b": "b", "etc/z": "z", "Z": "Z", "etc/a-b": "ab", "etc/a/b": "ab"}
+
+	var listings [][]string
+	for _, layer := range []v1.Layer{gzipTarLayer(t, files), estargzLayer(t, files)} {
+		result, err := NewOrchestrator(false).List(context.Background(), ListOptions{ImageRef: writeLayoutImage(t, layer)})
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		var paths []string
+		for _, entry := range result.Entries {
+			paths = append(paths, entry.Path)
+		}
+		listings = append(listings, paths)
+	}
+
+	want := []string{"/Z", "/etc/a-b", "/etc/a/b", "/etc/z", "/usr/bin/b"}
+	for i, format := range []string{"standard", "eStargz"} {
+		if !slices.Equal(listings[i], want) {
+			t.Errorf("List() of %s layer = %v, want %v", format, listings[i], want)
+		}
+	}
+}
+
+// TestExtractAll tests that every layer's version of a file is written
+func TestExtractAll(t *testing.T) {
+	layers := []v1.Layer{
+		gzipTarLayer(t, map[string]string{"etc/passwd": "v1"}),
+		gzipTarLayer(t, map[string]string{"etc/hosts": "hosts"}),
+		gzipTarLayer(t, map[string]string{"etc/passwd": "v2"}),
+	}
+	imageRef := writeLayoutImage(t, layers...)
+	outputPath := filepath.Join(t.TempDir(), "passwd")
+
+	written, err := NewOrchestrator(false).ExtractAll(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "/etc/passwd",
+		OutputPath: outputPath,
+	})
+	if err != nil {
+		t.Fatalf("ExtractAll() error = %v", err)
+	}
+
+	want := map[int]string{0: "v1", 2: "v2"}
+	if len(written) != len(want) {
+		t.Fatalf("ExtractAll() wrote %v, want %d files", written, len(want))
+	}
+
+	for index, content := range want {
+		digest, err := layers[index].Digest()
+		if err != nil {
+			t.Fatalf("failed to get layer digest: %v", err)
+		}
+		path := fmt.Sprintf("%s.%d.%s", outputPath, index, digest.Hex[:12])
+		if !slices.Contains(written, path) {
+			t.Errorf("ExtractAll() wrote %v, missing %s", written, path)
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", path, err)
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", path, data, content)
+		}
+	}
+}
+
+// TestCompare tests that every applicable method is timed on the layer the
+// file comes from
+func TestCompare(t *testing.T) {
+	layers := []v1.Layer{
+		gzipTarLayer(t, map[string]string{"etc/passwd": "v1"}),
+		gzipTarLayer(t, map[string]string{"etc/passwd": "v2"}),
+	}
+	imageRef := writeLayoutImage(t, layers...)
+
+	report, err := NewOrchestrator(false).Compare(context.Background(), CompareOptions{
+		ImageRef: imageRef,
+		FilePath: "/etc/passwd",
+	})
+	if err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+
+	top, err := layers[1].Digest()
+	if err != nil {
+		t.Fatalf("failed to get layer digest: %v", err)
+	}
+	if report.Layer != top {
+		t.Errorf("Compare() layer = %s, want %s", report.Layer, top)
+	}
+	if report.Detected != detector.FormatStandard {
+		t.Errorf("Compare() detected = %s, want %s", report.Detected, detector.FormatStandard)
+	}
+
+	// A plain gzip layer can't be read as eStargz, only downloaded in full
+	if len(report.Results) != 2 {
+		t.Fatalf("Compare() results = %+v, want eStargz and standard", report.Results)
+	}
+	if result := report.Results[0]; result.Format != detector.FormatEStargz || result.Err == nil {
+		t.Errorf("Compare() first result = %+v, want a failed eStargz attempt", result)
+	}
+	if result := report.Results[1]; result.Format != detector.FormatStandard || result.Err != nil || result.Size != 2 {
+		t.Errorf("Compare() second result = %+v, want 2 bytes from standard", result)
+	}
+	if selected, ok := report.Selected(); !ok || selected != detector.FormatStandard {
+		t.Errorf("Selected() = %s, %v, want %s", selected, ok, detector.FormatStandard)
+	}
+	if !report.Identical() {
+		t.Error("Identical() = false, want true")
+	}
+}
+
+// TestDisableFormat tests that disabled formats aren't tried
+func TestDisableFormat(t *testing.T) {
+	imageRef := writeLayoutImage(t, estargzLayer(t, map[string]string{"etc/passwd": "root"}))
+
+	o := NewOrchestrator(false)
+	o.DisableFormat(detector.FormatEStargz)
+	report, err := o.Compare(context.Background(), CompareOptions{
+		ImageRef: imageRef,
+		FilePath: "/etc/passwd",
+	})
+	if err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+
+	if len(report.Results) != 1 || report.Results[0].Format != detector.FormatStandard {
+		t.Errorf("Compare() results = %+v, want only standard", report.Results)
+	}
+}
+
+// TestDetect tests that seekable layers are told apart from plain ones
+func TestDetect(t *testing.T) {
+	imageRef := writeLayoutImage(t,
+		gzipTarLayer(t, map[string]string{"etc/passwd": "root"}),
+		estargzLayer(t, map[string]string{"etc/hosts": "localhost"}),
+	)
+
+	report, err := NewOrchestrator(false).Detect(context.Background(), imageRef)
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+
+	want := []detector.Format{detector.FormatStandard, detector.FormatEStargz}
+	if len(report.Layers) != len(want) {
+		t.Fatalf("Detect() layers = %+v, want %d", report.Layers, len(want))
+	}
+	for i, layer := range report.Layers {
+		if layer.Format != want[i] {
+			t.Errorf("Detect() layer %d = %s, want %s", i, layer.Format, want[i])
+		}
+	}
+	if _, ok := report.Uniform(); ok {
+		t.Error("Uniform() = true, want false for mixed layers")
+	}
+}
+
+// TestExtractByName tests finding a file by its base name in the topmost
+// layer holding one
+func TestExtractByName(t *testing.T) {
+	imageRef := writeLayoutImage(t,
+		gzipTarLayer(t, map[string]string{"bin/sh": "old"}),
+		gzipTarLayer(t, map[string]string{"usr/local/bin/sh": "new", "etc/hosts": "localhost"}),
+		gzipTarLayer(t, map[string]string{"etc/passwd": "root"}),
+	)
+	outputPath := filepath.Join(t.TempDir(), "sh")
+
+	_, err := NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "sh",
+		OutputPath: outputPath,
+		ByName:     true,
+	})
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if string(data) != "new" {
+		t.Errorf("Extract() wrote %q, want the topmost sh", data)
+	}
+
+	_, err = NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "missing",
+		OutputPath: outputPath,
+		ByName:     true,
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Extract() of a missing name error = %v, want ErrNotFound", err)
+	}
+}
+
+// TestExtractByNameAmbiguous tests that several matches in one layer fail
+func TestExtractByNameAmbiguous(t *testing.T) {
+	imageRef := writeLayoutImage(t, gzipTarLayer(t, map[string]string{"bin/sh": "a", "usr/bin/sh": "b"}))
+
+	_, err := NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "sh",
+		OutputPath: filepath.Join(t.TempDir(), "sh"),
+		ByName:     true,
+	})
+	if !errors.Is(err, ErrAmbiguousName) {
+		t.Errorf("Extract() error = %v, want ErrAmbiguousName", err)
+	}
+}
+
+// TestExtractByFileDigest tests finding a file by the digest of its content,
+// through eStargz TOCs and, when allowed, by hashing the files of other layers
+func TestExtractByFileDigest(t *testing.T) {
+	sum := sha256.Sum256([]byte("payload"))
+	fileDigest := "sha256:" + hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name      string
+		layers    []v1.Layer
+		hashFiles bool
+		wantErr   error
+		wantMsg   string
+	}{
+		{
+			name: "estargz TOC",
+			layers: []v1.Layer{
+				gzipTarLayer(t, map[string]string{"etc/old/data": "stale"}),
+				estargzLayer(t, map[string]string{"srv/new/data": "payload", "srv/other": "other"}),
+			},
+		},
+		{
+			name:    "no TOC",
+			layers:  []v1.Layer{gzipTarLayer(t, map[string]string{"srv/data": "payload"})},
+			wantErr: ErrNotFound,
+			wantMsg: "pass --hash-files",
+		},
+		{
+			name:      "hashed files",
+			layers:    []v1.Layer{gzipTarLayer(t, map[string]string{"srv/data": "payload"})},
+			hashFiles: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputPath := filepath.Join(t.TempDir(), "data")
+			_, err := NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+				ImageRef:   writeLayoutImage(t, tt.layers...),
+				FilePath:   fileDigest,
+				OutputPath: outputPath,
+				ByDigest:   true,
+				HashFiles:  tt.hashFiles,
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !strings.Contains(err.Error(), tt.wantMsg) {
+					t.Errorf("Extract() error = %v, want %v mentioning %q", err, tt.wantErr, tt.wantMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+
+			data, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatalf("failed to read output: %v", err)
+			}
+			if string(data) != "payload" {
+				t.Errorf("Extract() wrote %q, want %q", data, "payload")
+			}
+		})
+	}
+}
+
+// TestExtractFilesParallel tests extracting several files from a registry at
+// once, with the layer read through the blob client the extractions share.
+// Run with -race, it checks they set that client up safely.
+func TestExtractFilesParallel(t *testing.T) {
+	server := httptest.NewServer(ggcrregistry.New())
+	defer server.Close()
+
+	files := make(map[string]string)
+	for i := range 8 {
+		files[fmt.Sprintf("etc/file%d", i)] = fmt.Sprintf("content %d", i)
+	}
+	img, err := mutate.AppendLayers(empty.Image, estargzLayer(t, files))
+	if err != nil {
+		t.Fatalf("failed to build image: %v", err)
+	}
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	dir := t.TempDir()
+	var targets []FileTarget
+	for i := range len(files) {
+		targets = append(targets, FileTarget{FilePath: fmt.Sprintf("/etc/file%d", i), OutputPath: filepath.Join(dir, fmt.Sprintf("file%d", i))})
+	}
+
+	// A forced format skips format detection, which is serialized
+	_, errs, err := NewOrchestrator(false).ExtractFiles(context.Background(), ExtractOptions{ImageRef: imageRef, ForceFormat: detector.FormatEStargz}, targets, len(targets))
+	if err != nil {
+		t.Fatalf("ExtractFiles() error = %v", err)
+	}
+	for i, target := range targets {
+		if errs[i] != nil {
+			t.Errorf("ExtractFiles() error for %s = %v", target.FilePath, errs[i])
+			continue
+		}
+		if data, _ := os.ReadFile(target.OutputPath); string(data) != fmt.Sprintf("content %d", i) {
+			t.Errorf("ExtractFiles() wrote %q for %s", data, target.FilePath)
+		}
+	}
+}
+
+// TestExtractFiles tests that a missing file fails on its own, without
+// stopping the files extracted alongside it
+func TestExtractFiles(t *testing.T) {
+	imageRef := writeLayoutImage(t,
+		gzipTarLayer(t, map[string]string{"etc/a": "old a", "etc/b": "b"}),
+		estargzLayer(t, map[string]string{"etc/a": "a"}),
+	)
+
+	dir := t.TempDir()
+	targets := []FileTarget{
+		{FilePath: "/etc/a", OutputPath: filepath.Join(dir, "a")},
+		{FilePath: "/etc/missing", OutputPath: filepath.Join(dir, "missing")},
+		{FilePath: "/etc/b", OutputPath: filepath.Join(dir, "b")},
+	}
+	_, errs, err := NewOrchestrator(false).ExtractFiles(context.Background(), ExtractOptions{ImageRef: imageRef}, targets, 2)
+	if err != nil {
+		t.Fatalf("ExtractFiles() error = %v", err)
+	}
+
+	if !errors.Is(errs[1], ErrNotFound) {
+		t.Errorf("ExtractFiles() error for %s = %v, want ErrNotFound", targets[1].FilePath, errs[1])
+	}
+	for i, want := range map[int]string{0: "a", 2: "b"} {
+		if errs[i] != nil {
+			t.Errorf("ExtractFiles() error for %s = %v", targets[i].FilePath, errs[i])
+			continue
+		}
+		data, err := os.ReadFile(targets[i].OutputPath)
+		if err != nil {
+			t.Fatalf("failed to read output: %v", err)
+		}
+		if string(data) != want {
+			t.Errorf("ExtractFiles() wrote %q for %s, want %q", data, targets[i].FilePath, want)
+		}
+	}
+}
+
+// TestOutputTemplate tests that files are written where an output template
+// names them, for the topmost layer holding them or for every layer
+func TestOutputTemplate(t *testing.T) {
+	imageRef := writeLayoutImage(t,
+		gzipTarLayer(t, map[string]string{"etc/a": "old a"}),
+		gzipTarLayer(t, map[string]string{"etc/b": "b"}),
+		estargzLayer(t, map[string]string{"etc/a": "a"}),
+	)
+	tmpl, err := output.ParseNameTemplate("{{.Layer}}/{{.Base}}")
+	if err != nil {
+		t.Fatalf("ParseNameTemplate() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	result, err := NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:       imageRef,
+		FilePath:       "/etc/a",
+		OutputPath:     dir,
+		OutputTemplate: tmpl,
+	})
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+	if want := filepath.Join(dir, "2", "a"); result.OutputPath != want {
+		t.Errorf("Extract() output path = %s, want %s", result.OutputPath, want)
+	}
+
+	dir = t.TempDir()
+	written, err := NewOrchestrator(false).ExtractAll(context.Background(), ExtractOptions{
+		ImageRef:       imageRef,
+		FilePath:       "/etc/a",
+		OutputPath:     dir,
+		OutputTemplate: tmpl,
+	})
+	if err != nil {
+		t.Fatalf("ExtractAll() error = %v", err)
+	}
+	want := map[string]string{filepath.Join(dir, "0", "a"): "old a", filepath.Join(dir, "2", "a"): "a"}
+	if len(written) != len(want) {
+		t.Fatalf("ExtractAll() wrote %v, want %d files", written, len(want))
+	}
+	for _, path := range written {
+		if data, err := os.ReadFile(path); err != nil || string(data) != want[path] {
+			t.Errorf("ExtractAll() wrote %q, %v to %s, want %q", data, err, path, want[path])
+		}
+	}
+
+	_, err = NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:       imageRef,
+		FilePath:       "/etc/",
+		OutputPath:     t.TempDir(),
+		OutputTemplate: tmpl,
+	})
+	if err == nil {
+		t.Error("Extract() of a directory with an output template expected error, got nil")
+	}
+}
+
+// TestExtractPermissions tests that every format applies the same
+// permission policy
+func TestExtractPermissions(t *testing.T) {
+	files := map[string]string{"etc/passwd": "root"}
+	layers := []struct {
+		name   string
+		layer  v1.Layer
+		format detector.Format
+	}{
+		{name: "estargz", layer: estargzLayer(t, files), format: detector.FormatEStargz},
+		{name: "standard", layer: gzipTarLayer(t, files), format: detector.FormatStandard},
+	}
+
+	// The test layers record mode 0644
+	policies := []struct {
+		policy output.Permissions
+		want   os.FileMode
+	}{
+		{policy: output.PreserveFromTar, want: 0644},
+		{policy: output.FixedMode(0600), want: 0600},
+	}
+
+	for _, l := range layers {
+		imageRef := writeLayoutImage(t, l.layer)
+		for _, p := range policies {
+			outputPath := filepath.Join(t.TempDir(), "passwd")
+			_, err := NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+				ImageRef:    imageRef,
+				FilePath:    "/etc/passwd",
+				OutputPath:  outputPath,
+				ForceFormat: l.format,
+				Output:      output.Options{Permissions: p.policy},
+			})
+			if err != nil {
+				t.Fatalf("%s: Extract() with %s error = %v", l.name, p.policy, err)
+			}
+
+			info, err := os.Stat(outputPath)
+			if err != nil {
+				t.Fatalf("%s: failed to stat output: %v", l.name, err)
+			}
+			if info.Mode().Perm() != p.want {
+				t.Errorf("%s: Extract() with %s mode = %04o, want %04o", l.name, p.policy, info.Mode().Perm(), p.want)
+			}
+		}
+	}
+}
+
+// TestCompareReportIdentical tests that failed methods don't count as a mismatch
+func TestCompareReportIdentical(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []CompareResult
+		want    bool
+	}{
+		{
+			name: "same bytes",
+			results: []CompareResult{
+				{Format: detector.FormatEStargz, Digest: "aa"},
+				{Format: detector.FormatStandard, Digest: "aa"},
+			},
+			want: true,
+		},
+		{
+			name: "different bytes",
+			results: []CompareResult{
+				{Format: detector.FormatEStargz, Digest: "aa"},
+				{Format: detector.FormatStandard, Digest: "bb"},
+			},
+			want: false,
+		},
+		{
+			name: "failed method",
+			results: []CompareResult{
+				{Format: detector.FormatSOCI, Err: fmt.Errorf("no zTOC")},
+				{Format: detector.FormatStandard, Digest: "aa"},
+			},
+			want: true,
+		},
+		{
+			name:    "nothing succeeded",
+			results: []CompareResult{{Format: detector.FormatStandard, Err: fmt.Errorf("boom")}},
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := &CompareReport{Results: tt.results}
+			if got := report.Identical(); got != tt.want {
+				t.Errorf("Identical() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPreflight tests that a file missing from every layer's TOC is reported
+// before any layer is downloaded, and that layers without an index make the
+// check inconclusive
+func TestPreflight(t *testing.T) {
+	indexed := estargzLayer(t, map[string]string{"etc/hosts": "hosts"})
+	tests := []struct {
+		name     string
+		layers   []v1.Layer
+		filePath string
+		wantErr  error
+	}{
+		{
+			name:     "found",
+			layers:   []v1.Layer{indexed},
+			filePath: "/etc/hosts",
+		},
+		{
+			name:     "missing",
+			layers:   []v1.Layer{indexed},
+			filePath: "/etc/passwd",
+			wantErr:  ErrNotFound,
+		},
+		{
+			name:     "unindexed layer",
+			layers:   []v1.Layer{gzipTarLayer(t, map[string]string{"etc/os-release": "os"}), indexed},
+			filePath: "/etc/passwd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := NewOrchestrator(false)
+			layers, err := o.getLayers(context.Background(), writeLayoutImage(t, tt.layers...))
+			if err != nil {
+				t.Fatalf("getLayers() error = %v", err)
+			}
+
+			err = o.preflight(context.Background(), layers, nil, ExtractOptions{FilePath: tt.filePath})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("preflight() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestForceSOCIUnsupported tests that forcing SOCI fails clearly on platforms
+// without SOCI support, rather than falling back to other formats
+func TestForceSOCIUnsupported(t *testing.T) {
+	if soci.Supported {
+		t.Skip("SOCI is supported on this platform")
+	}
+
+	imageRef := writeLayoutImage(t, gzipTarLayer(t, map[string]string{"etc/passwd": "root"}))
+	_, err := NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:    imageRef,
+		FilePath:    "/etc/passwd",
+		OutputPath:  filepath.Join(t.TempDir(), "passwd"),
+		ForceFormat: detector.FormatSOCI,
+	})
+	if !errors.Is(err, soci.ErrNotSupported) {
+		t.Errorf("Extract() error = %v, want soci.ErrNotSupported", err)
+	}
+}
+
+// TestForceFormatStrict tests that a forced format that can't read the image
+// fails instead of another format being used in its place
+func TestForceFormatStrict(t *testing.T) {
+	imageRef := writeLayoutImage(t, gzipTarLayer(t, map[string]string{"etc/app/passwd": "root"}))
+
+	_, err := NewOrchestrator(false).List(context.Background(), ListOptions{
+		ImageRef:    imageRef,
+		ForceFormat: detector.FormatEStargz,
+	})
+	if !errors.Is(err, ErrFormatNotApplicable) {
+		t.Errorf("List() as eStargz error = %v, want ErrFormatNotApplicable", err)
+	}
+
+	_, err = NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:    imageRef,
+		FilePath:    "/etc/app/",
+		OutputPath:  t.TempDir(),
+		ForceFormat: detector.FormatEStargz,
+	})
+	if !errors.Is(err, ErrFormatNotApplicable) {
+		t.Errorf("Extract() directory as eStargz error = %v, want ErrFormatNotApplicable", err)
+	}
+
+	_, err = NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:    imageRef,
+		FilePath:    "/etc/app/passwd",
+		OutputPath:  filepath.Join(t.TempDir(), "passwd"),
+		ForceFormat: detector.FormatEStargz,
+	})
+	if !errors.Is(err, ErrFormatNotApplicable) {
+		t.Errorf("Extract() as eStargz error = %v, want ErrFormatNotApplicable", err)
+	}
+
+	if !soci.Supported {
+		return
+	}
+	_, err = NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:    imageRef,
+		FilePath:    "/etc/app/passwd",
+		OutputPath:  filepath.Join(t.TempDir(), "passwd"),
+		ForceFormat: detector.FormatSOCI,
+	})
+	if !errors.Is(err, ErrFormatNotApplicable) {
+		t.Errorf("Extract() as SOCI without an index error = %v, want ErrFormatNotApplicable", err)
+	}
+}
+
+// TestExtractDirReportsFiles tests that directory extraction reports each
+// written file with the layer it came from
+func TestExtractDirReportsFiles(t *testing.T) {
+	layers := []v1.Layer{
+		gzipTarLayer(t, map[string]string{"etc/app/a": "a1", "etc/app/b": "b"}),
+		gzipTarLayer(t, map[string]string{"etc/app/a": "a2"}),
+	}
+	imageRef := writeLayoutImage(t, layers...)
+	outputDir := t.TempDir()
+
+	var reported []string
+	_, err := NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "/etc/app/",
+		OutputPath: outputDir,
+		OnExtracted: func(path string, layer v1.Hash, md output.Metadata) {
+			rel, _ := filepath.Rel(outputDir, path)
+			reported = append(reported, fmt.Sprintf("%s@%s", rel, layer.Hex[:12]))
+		},
+	})
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+
+	digests := make([]v1.Hash, len(layers))
+	for i, layer := range layers {
+		if digests[i], err = layer.Digest(); err != nil {
+			t.Fatalf("failed to get layer digest: %v", err)
+		}
+	}
+	want := []string{
+		"a@" + digests[0].Hex[:12],
+		"b@" + digests[0].Hex[:12],
+		"a@" + digests[1].Hex[:12],
+	}
+	if len(reported) != len(want) {
+		t.Fatalf("reported %v, want %v", reported, want)
+	}
+
+	// Files within a layer are reported in archive order
+	slices.Sort(reported[:2])
+	if !slices.Equal(reported, want) {
+		t.Errorf("reported %v, want %v", reported, want)
+	}
+}
+
+// TestExtractDirResume tests that files reported as done are neither
+// removed nor written again, while being reported as extracted
+func TestExtractDirResume(t *testing.T) {
+	lower := gzipTarLayer(t, map[string]string{"etc/app/a": "a1", "etc/app/b": "b"})
+	upper := gzipTarLayer(t, map[string]string{"etc/app/a": "a2"})
+	imageRef := writeLayoutImage(t, lower, upper)
+	lowerDigest, err := lower.Digest()
+	if err != nil {
+		t.Fatalf("failed to get layer digest: %v", err)
+	}
+
+	outputDir := t.TempDir()
+	donePath := filepath.Join(outputDir, "b")
+	if err := os.WriteFile(donePath, []byte("kept"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, "a"), []byte("par"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	var reported []string
+	_, err = NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "/etc/app/",
+		OutputPath: outputDir,
+		Resume: func(path string, layer v1.Hash) bool {
+			return path == donePath && layer == lowerDigest
+		},
+		OnExtracted: func(path string, layer v1.Hash, md output.Metadata) {
+			reported = append(reported, filepath.Base(path))
+		},
+	})
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+
+	for name, want := range map[string]string{"a": "a2", "b": "kept"} {
+		data, err := os.ReadFile(filepath.Join(outputDir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", name, data, want)
+		}
+	}
+
+	if !slices.Contains(reported, "b") {
+		t.Errorf("reported %v, want b included", reported)
+	}
+}
+
+// TestEmptyLayers tests that zero-byte layers and empty descriptors are
+// skipped rather than read as archives
+// TestExtractDirPresence tests that an empty directory is extracted rather
+// than not found, and that a whiteout of a parent removes the directory
+func TestExtractDirPresence(t *testing.T) {
+	lower := gzipTarLayer(t, map[string]string{"etc/app/a": "a", "etc/empty/": ""})
+	removed := gzipTarLayer(t, map[string]string{"etc/.wh.app": ""})
+	recreated := gzipTarLayer(t, map[string]string{"etc/app/c": "c"})
+
+	tests := []struct {
+		name     string
+		layers   []v1.Layer
+		dir      string
+		want     []string
+		notFound bool
+	}{
+		{name: "empty directory", layers: []v1.Layer{lower}, dir: "/etc/empty/"},
+		{name: "missing directory", layers: []v1.Layer{lower}, dir: "/etc/missing/", notFound: true},
+		{name: "parent whiteout", layers: []v1.Layer{lower, removed}, dir: "/etc/app/", notFound: true},
+		{name: "recreated after whiteout", layers: []v1.Layer{lower, removed, recreated}, dir: "/etc/app/", want: []string{"c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputDir := t.TempDir()
+			_, err := NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+				ImageRef:   writeLayoutImage(t, tt.layers...),
+				FilePath:   tt.dir,
+				OutputPath: outputDir,
+			})
+
+			if tt.notFound {
+				if !errors.Is(err, ErrNotFound) {
+					t.Fatalf("Extract() error = %v, want ErrNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+
+			entries, err := os.ReadDir(outputDir)
+			if err != nil {
+				t.Fatalf("failed to read output: %v", err)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Name())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("extracted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyLayers(t *testing.T) {
+	imageRef := writeLayoutImage(t,
+		gzipTarLayer(t, map[string]string{"etc/app/a": "a"}),
+		static.NewLayer(nil, types.OCILayer),
+		static.NewLayer([]byte("{}"), registry.EmptyJSONMediaType),
+	)
+	o := NewOrchestrator(false)
+
+	outputPath := filepath.Join(t.TempDir(), "a")
+	_, err := o.Extract(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "/etc/app/a",
+		OutputPath: outputPath,
+		Preflight:  true,
+	})
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+
+	outputDir := t.TempDir()
+	_, err = o.Extract(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "/etc/app/",
+		OutputPath: outputDir,
+	})
+	if err != nil {
+		t.Fatalf("Extract() directory error = %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(outputDir, "a")); err != nil || string(got) != "a" {
+		t.Errorf("extracted a = %q, %v, want %q", got, err, "a")
+	}
+
+	result, err := o.List(context.Background(), ListOptions{ImageRef: imageRef})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(result.Entries) != 1 || result.Entries[0].Path != "/etc/app/a" {
+		t.Errorf("List() = %v, want [/etc/app/a]", result.Entries)
+	}
+}
+
+// TestEmptyDescriptorLayer tests that the OCI empty descriptor is recognized
+// by its content when pushed under a layer media type, and skipped by diff
+// and detect
+func TestEmptyDescriptorLayer(t *testing.T) {
+	base := gzipTarLayer(t, map[string]string{"etc/app/a": "a"})
+	baseDigest, err := base.Digest()
+	if err != nil {
+		t.Fatalf("failed to get layer digest: %v", err)
+	}
+	imageRef := writeLayoutImage(t, base, static.NewLayer([]byte("{}"), types.OCILayer))
+	o := NewOrchestrator(false)
+
+	changes, err := o.Diff(context.Background(), DiffOptions{
+		ImageRef: imageRef,
+		Layer:    baseDigest.String(),
+		Since:    true,
+	})
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(changes) != 1 || changes[0].Path != "/etc/app/a" || changes[0].Kind != ChangeAdded {
+		t.Errorf("Diff() = %v, want /etc/app/a added", changes)
+	}
+
+	report, err := o.Detect(context.Background(), imageRef)
+	if err != nil {
+		t.Fatalf("Detect() error = %v", err)
+	}
+	if len(report.Layers) != 2 || report.Layers[0].Empty || !report.Layers[1].Empty {
+		t.Errorf("Detect() layers = %+v, want only the second empty", report.Layers)
+	}
+	if format, ok := report.Uniform(); !ok || format != detector.FormatStandard {
+		t.Errorf("Uniform() = %s, %v, want standard, true", format, ok)
+	}
+}
+
+// TestExtractRange tests that a byte range limits the extracted contents,
+// both when seeking through a TOC and when streaming the layer
+func TestExtractRange(t *testing.T) {
+	files := map[string]string{"var/log/app.log": "0123456789abcdef"}
+	tests := []struct {
+		name   string
+		layer  v1.Layer
+		format detector.Format
+	}{
+		{name: "estargz", layer: estargzLayer(t, files), format: detector.FormatEStargz},
+		{name: "standard", layer: gzipTarLayer(t, files), format: detector.FormatStandard},
+	}
+
+	ranges := []struct {
+		r    output.ByteRange
+		want string
+	}{
+		{r: output.ByteRange{Offset: 4, Length: 6}, want: "456789"},
+		{r: output.ByteRange{Offset: 10, Length: -1}, want: "abcdef"},
+		{r: output.ByteRange{Offset: 12, Length: 100}, want: "cdef"},
+		{r: output.ByteRange{Offset: 100, Length: 4}, want: ""},
+	}
+
+	for _, tt := range tests {
+		imageRef := writeLayoutImage(t, tt.layer)
+		for _, rr := range ranges {
+			outputPath := filepath.Join(t.TempDir(), "app.log")
+			_, err := NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+				ImageRef:    imageRef,
+				FilePath:    "/var/log/app.log",
+				OutputPath:  outputPath,
+				ForceFormat: tt.format,
+				Output:      output.Options{Range: &rr.r},
+			})
+			if err != nil {
+				t.Fatalf("%s: Extract(%+v) error = %v", tt.name, rr.r, err)
+			}
+
+			data, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatalf("%s: failed to read output: %v", tt.name, err)
+			}
+			if string(data) != rr.want {
+				t.Errorf("%s: Extract(%+v) wrote %q, want %q", tt.name, rr.r, data, rr.want)
+			}
+		}
+	}
+}
+
+// TestExtractMatch tests that a file whose content doesn't match is neither
+// written nor replaced by an older version from a lower layer
+func TestExtractMatch(t *testing.T) {
+	imageRef := writeLayoutImage(t,
+		gzipTarLayer(t, map[string]string{"etc/os-release": "ID=alpine"}),
+		gzipTarLayer(t, map[string]string{"etc/os-release": "ID=debian"}),
+	)
+
+	outputPath := filepath.Join(t.TempDir(), "os-release")
+	_, err := NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "/etc/os-release",
+		OutputPath: outputPath,
+		Output:     output.Options{Match: regexp.MustCompile(`ID=alpine`)},
+	})
+	if !errors.Is(err, output.ErrNoMatch) {
+		t.Fatalf("Extract() error = %v, want ErrNoMatch", err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("non-matching file written, stat error = %v", err)
+	}
+
+	_, err = NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "/etc/os-release",
+		OutputPath: outputPath,
+		Output:     output.Options{Match: regexp.MustCompile(`^ID=(alpine|debian)$`)},
+	})
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+	if data, _ := os.ReadFile(outputPath); string(data) != "ID=debian" {
+		t.Errorf("Extract() wrote %q, want %q", data, "ID=debian")
+	}
+}
+
+// TestVerifyLayers tests that a layer not matching its digest fails a
+// verified extraction instead of being read through its TOC or skipped
+func TestVerifyLayers(t *testing.T) {
+	upper := estargzLayer(t, map[string]string{"etc/config": "new"})
+	imageRef := writeLayoutImage(t, gzipTarLayer(t, map[string]string{"etc/config": "old"}), upper)
+
+	o := NewOrchestrator(false)
+	o.VerifyLayers(true)
+	opts := ExtractOptions{ImageRef: imageRef, FilePath: "/etc/config", OutputPath: filepath.Join(t.TempDir(), "config")}
+	if _, err := o.Extract(context.Background(), opts); err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+	if data, _ := os.ReadFile(opts.OutputPath); string(data) != "new" {
+		t.Errorf("extracted %q, want %q", data, "new")
+	}
+
+	// Change the gzip header's OS byte, which leaves the layer readable
+	digest, err := upper.Digest()
+	if err != nil {
+		t.Fatalf("failed to get layer digest: %v", err)
+	}
+	blobPath := filepath.Join(strings.TrimPrefix(imageRef, "oci:"), "blobs", digest.Algorithm, digest.Hex)
+	data, err := os.ReadFile(blobPath)
+	if err != nil {
+		t.Fatalf("failed to read layer blob: %v", err)
+	}
+	data[9] ^= 0xff
+	if err := os.WriteFile(blobPath, data, 0644); err != nil {
+		t.Fatalf("failed to write layer blob: %v", err)
+	}
+
+	o = NewOrchestrator(false)
+	o.VerifyLayers(true)
+	opts.OutputPath = filepath.Join(t.TempDir(), "config")
+	if _, err := o.Extract(context.Background(), opts); !errors.Is(err, ErrLayerDigestMismatch) {
+		t.Errorf("Extract() error = %v, want ErrLayerDigestMismatch", err)
+	}
+}
+
+// TestSmallLayerThreshold tests that layers under the threshold are
+// downloaded in full unless their format is forced
+func TestSmallLayerThreshold(t *testing.T) {
+	imageRef := writeLayoutImage(t, estargzLayer(t, map[string]string{"etc/hostname": "test"}))
+	opts := ExtractOptions{ImageRef: imageRef, FilePath: "/etc/hostname"}
+
+	for _, tt := range []struct {
+		threshold int64
+		force     detector.Format
+		want      detector.Format
+	}{
+		{threshold: 0, want: detector.FormatEStargz},
+		{threshold: 1 << 20, want: detector.FormatStandard},
+		{threshold: 1 << 20, force: detector.FormatEStargz, want: detector.FormatEStargz},
+	} {
+		o := NewOrchestrator(false)
+		o.SetSmallLayerThreshold(tt.threshold)
+		opts.ForceFormat = tt.force
+		opts.OutputPath = filepath.Join(t.TempDir(), "hostname")
+		result, err := o.Extract(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("Extract() with threshold %d error = %v", tt.threshold, err)
+		}
+		if result.Format != tt.want {
+			t.Errorf("Extract() with threshold %d, forced %v format = %v, want %v", tt.threshold, tt.force, result.Format, tt.want)
+		}
+		if data, _ := os.ReadFile(opts.OutputPath); string(data) != "test" {
+			t.Errorf("extracted %q, want %q", data, "test")
+		}
+	}
+}
+
+// TestEStargzTOCDigest tests that an eStargz TOC is only trusted when it
+// matches the digest annotated on its layer
+func TestEStargzTOCDigest(t *testing.T) {
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	if err := tarWriter.WriteHeader(&tar.Header{Name: "etc/config", Mode: 0644, Size: 3, Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("failed to write tar header: %v", err)
+	}
+	if _, err := tarWriter.Write([]byte("new")); err != nil {
+		t.Fatalf("failed to write tar content: %v", err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+
+	blob, err := stargz.Build(io.NewSectionReader(bytes.NewReader(buf.Bytes()), 0, int64(buf.Len())))
+	if err != nil {
+		t.Fatalf("failed to build eStargz blob: %v", err)
+	}
+	defer func() { _ = blob.Close() }()
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("failed to read eStargz blob: %v", err)
+	}
+
+	tests := []struct {
+		tocDigest string
+		wantErr   bool
+	}{
+		{tocDigest: "", wantErr: false},
+		{tocDigest: blob.TOCDigest().String(), wantErr: false},
+		{tocDigest: "sha256:" + strings.Repeat("0", 64), wantErr: true},
+	}
+	for _, tt := range tests {
+		e := estargz.NewExtractor(bytes.NewReader(data), int64(len(data)))
+		e.SetTOCDigest(tt.tocDigest)
+		err := e.ExtractFile(context.Background(), "etc/config", filepath.Join(t.TempDir(), "config"))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ExtractFile() with TOC digest %q error = %v, wantErr %v", tt.tocDigest, err, tt.wantErr)
+		}
+	}
+}
+
+// lowestReadAt records the lowest offset read through it
+type lowestReadAt struct {
+	io.ReaderAt
+	lowest int64
+}
+
+// ReadAt implements io.ReaderAt
+func (r *lowestReadAt) ReadAt(p []byte, off int64) (int, error) {
+	r.lowest = min(r.lowest, off)
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+// TestEStargzListFromTOC tests that eStargz layers are listed from their TOC
+// without reading the file contents, and that other gzip layers only fall
+// back to reading the tar stream when that's allowed
+func TestEStargzListFromTOC(t *testing.T) {
+	data, err := io.ReadAll(openLayer(t, estargzLayer(t, map[string]string{"etc/a": "a", "etc/b": "b"}).Compressed))
+	if err != nil {
+		t.Fatalf("failed to read layer: %v", err)
+	}
+
+	reader := &lowestReadAt{ReaderAt: bytes.NewReader(data), lowest: int64(len(data))}
+	e := estargz.NewExtractor(reader, int64(len(data)))
+	e.SetTOCOnly(true)
+	entries, err := e.ListEntries(context.Background())
+	if err != nil {
+		t.Fatalf("ListEntries() error = %v", err)
+	}
+	paths := output.Paths(output.RegularFiles(entries))
+	slices.Sort(paths)
+	if !slices.Equal(paths, []string{"/etc/a", "/etc/b"}) {
+		t.Errorf("ListEntries() = %v, want /etc/a and /etc/b", paths)
+	}
+	// The files come first, the TOC and footer last
+	if reader.lowest == 0 {
+		t.Error("ListEntries() read the layer from its start, want only the TOC")
+	}
+
+	plain, err := io.ReadAll(openLayer(t, gzipTarLayer(t, map[string]string{"etc/a": "a"}).Compressed))
+	if err != nil {
+		t.Fatalf("failed to read layer: %v", err)
+	}
+	e = estargz.NewExtractor(bytes.NewReader(plain), int64(len(plain)))
+	e.SetTOCOnly(true)
+	if _, err := e.ListEntries(context.Background()); err == nil {
+		t.Error("ListEntries() of a layer without a TOC expected error, got nil")
+	}
+	e.SetTOCOnly(false)
+	entries, err = e.ListEntries(context.Background())
+	if err != nil {
+		t.Fatalf("ListEntries() with the tar fallback error = %v", err)
+	}
+	if paths := output.Paths(entries); !slices.Equal(paths, []string{"/etc/a"}) {
+		t.Errorf("ListEntries() with the tar fallback = %v, want /etc/a", paths)
+	}
+}
+
+// pgzipTarLayer builds a gzipped tar layer framed the way pgzip writes
+// them: one gzip member whose deflate stream is cut into blocks of
+// blockSize bytes of input, each ended by a sync flush
+func pgzipTarLayer(t *testing.T, files map[string]string, blockSize int) v1.Layer {
+	t.Helper()
+
+	var tarBuf bytes.Buffer
+	tarWriter := tar.NewWriter(&tarBuf)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tarWriter.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write tar content: %v", err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	for block := range slices.Chunk(tarBuf.Bytes(), blockSize) {
+		if _, err := gzipWriter.Write(block); err != nil {
+			t.Fatalf("failed to write gzip block: %v", err)
+		}
+		if err := gzipWriter.Flush(); err != nil {
+			t.Fatalf("failed to flush gzip block: %v", err)
+		}
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %v", err)
+	}
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create layer: %v", err)
+	}
+	return layer
+}
+
+// TestPgzipLayer tests that a layer compressed with pgzip's block framing is
+// extracted and listed as a standard layer, its last block not being
+// mistaken for an eStargz footer
+func TestPgzipLayer(t *testing.T) {
+	large := strings.Repeat("0123456789abcdef", 1024)
+	files := map[string]string{"etc/hosts": "hosts", "var/lib/data": large}
+	layer := pgzipTarLayer(t, files, 1024)
+
+	data, err := io.ReadAll(openLayer(t, layer.Compressed))
+	if err != nil {
+		t.Fatalf("failed to read layer: %v", err)
+	}
+	e := estargz.NewExtractor(bytes.NewReader(data), int64(len(data)))
+	e.SetTOCOnly(true)
+	if _, err := e.ListEntries(context.Background()); err == nil {
+		t.Error("ListEntries() of a pgzip layer expected error reading its footer, got nil")
+	}
+
+	imageRef := writeLayoutImage(t, layer)
+	for path, want := range files {
+		outputPath := filepath.Join(t.TempDir(), "out")
+		result, err := NewOrchestrator(false).Extract(context.Background(), ExtractOptions{
+			ImageRef:   imageRef,
+			FilePath:   "/" + path,
+			OutputPath: outputPath,
+		})
+		if err != nil {
+			t.Fatalf("Extract(%s) error = %v", path, err)
+		}
+		if result.Format != detector.FormatStandard {
+			t.Errorf("Extract(%s) format = %v, want %v", path, result.Format, detector.FormatStandard)
+		}
+		if got, err := os.ReadFile(outputPath); err != nil || string(got) != want {
+			t.Errorf("Extract(%s) wrote %d bytes, %v, want %d bytes", path, len(got), err, len(want))
+		}
+	}
+
+	result, err := NewOrchestrator(false).List(context.Background(), ListOptions{ImageRef: imageRef, EStargzTOCOnly: true})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if result.Types["reg"] != len(files) {
+		t.Errorf("List() types = %v, want %d reg", result.Types, len(files))
+	}
+}
+
+// openLayer opens a layer's contents with open, failing the test on error
+func openLayer(t *testing.T, open func() (io.ReadCloser, error)) io.Reader {
+	t.Helper()
+
+	rc, err := open()
+	if err != nil {
+		t.Fatalf("failed to open layer: %v", err)
+	}
+	t.Cleanup(func() { _ = rc.Close() })
+	return rc
+}
+
+// TestExtractPlatforms tests extracting a file from every platform's image
+// in an index, with a platform lacking the file not stopping the others
+func TestExtractPlatforms(t *testing.T) {
+	server := httptest.NewServer(ggcrregistry.New())
+	defer server.Close()
+
+	platforms := []v1.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+		{OS: "linux", Architecture: "s390x"},
+	}
+	var adds []mutate.IndexAddendum
+	for _, platform := range platforms {
+		files := map[string]string{"bin/busybox": PlatformDir(platform)}
+		if platform.Architecture == "s390x" {
+			files = map[string]string{"etc/os-release": "ID=test"}
+		}
+		img, err := mutate.AppendLayers(empty.Image, gzipTarLayer(t, files))
+		if err != nil {
+			t.Fatalf("failed to build image: %v", err)
+		}
+		adds = append(adds, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &platform}})
+	}
+
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/test/index:latest"
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	if err := remote.WriteIndex(ref, mutate.AppendManifests(empty.Index, adds...)); err != nil {
+		t.Fatalf("failed to push index: %v", err)
+	}
+
+	outputDir := t.TempDir()
+	results, err := NewOrchestrator(false).ExtractPlatforms(context.Background(), ExtractOptions{
+		ImageRef:   imageRef,
+		FilePath:   "/bin/busybox",
+		OutputPath: outputDir,
+	})
+	if err != nil {
+		t.Fatalf("ExtractPlatforms() error = %v", err)
+	}
+	if len(results) != len(platforms) {
+		t.Fatalf("ExtractPlatforms() returned %d results, want %d", len(results), len(platforms))
+	}
+
+	for i, result := range results {
+		dir := PlatformDir(platforms[i])
+		if platforms[i].Architecture == "s390x" {
+			if !errors.Is(result.Err, ErrNotFound) {
+				t.Errorf("%s error = %v, want ErrNotFound", dir, result.Err)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Errorf("%s error = %v", dir, result.Err)
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(outputDir, dir, "busybox"))
+		if err != nil || string(data) != dir {
+			t.Errorf("%s/busybox = %q, %v, want %q", dir, data, err, dir)
+		}
+	}
+}
+
+// TestExtractTags tests that a file is extracted from each tag's image into
+// a directory named by the tag, and that a tag lacking it doesn't stop others
+func TestExtractTags(t *testing.T) {
+	server := httptest.NewServer(ggcrregistry.New())
+	defer server.Close()
+
+	repository := strings.TrimPrefix(server.URL, "http://") + "/test/app"
+	for tag, files := range map[string]map[string]string{
+		"v1": {"etc/app.conf": "level=1"},
+		"v2": {"etc/app.conf": "level=2"},
+		"v3": {"etc/os-release": "ID=test"},
+	} {
+		img, err := mutate.AppendLayers(empty.Image, gzipTarLayer(t, files))
+		if err != nil {
+			t.Fatalf("failed to build image: %v", err)
+		}
+		ref, err := name.ParseReference(repository + ":" + tag)
+		if err != nil {
+			t.Fatalf("failed to parse reference: %v", err)
+		}
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatalf("failed to push image: %v", err)
+		}
+	}
+
+	outputDir := t.TempDir()
+	results, err := NewOrchestrator(false).ExtractTags(context.Background(), ExtractOptions{
+		ImageRef:   repository,
+		FilePath:   "/etc/app.conf",
+		OutputPath: outputDir,
+	}, []string{"v2", "v1", "v3"})
+	if err != nil {
+		t.Fatalf("ExtractTags() error = %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("ExtractTags() returned %d results, want 3", len(results))
+	}
+
+	for i, want := range []string{"level=2", "level=1"} {
+		if results[i].Err != nil {
+			t.Errorf("%s error = %v", results[i].Tag, results[i].Err)
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(outputDir, results[i].Tag, "app.conf"))
+		if err != nil || string(data) != want {
+			t.Errorf("%s/app.conf = %q, %v, want %q", results[i].Tag, data, err, want)
+		}
+	}
+	if results[2].Tag != "v3" || !errors.Is(results[2].Err, ErrNotFound) {
+		t.Errorf("%s error = %v, want ErrNotFound", results[2].Tag, results[2].Err)
+	}
+}
+
+// TestSeekableFailed tests that a layer without a zTOC, or without the
+// file, isn't reported as a failed seekable extraction
+func TestSeekableFailed(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "success", err: nil, want: false},
+		{name: "file not in layer", err: fmt.Errorf("file /etc/app not found in zTOC: %w", fs.ErrNotExist), want: false},
+		{name: "no zTOC for layer", err: fmt.Errorf("failed to get zTOC for layer: %w", soci.ErrNoZtoc), want: false},
+		{name: "unreadable zTOC", err: errors.New("failed to create SOCI extractor: invalid zTOC"), want: true},
+	}
+
+	for _, tt := range tests {
+		if got := seekableFailed(tt.err); got != tt.want {
+			t.Errorf("seekableFailed(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestLogOutput tests that verbose output is written to the log writer
+func TestLogOutput(t *testing.T) {
+	imageRef := writeLayoutImage(t, gzipTarLayer(t, map[string]string{"etc/app/a": "a"}))
+
+	var log bytes.Buffer
+	o := NewOrchestrator(true)
+	o.SetLogOutput(&log)
+	if _, err := o.List(context.Background(), ListOptions{ImageRef: imageRef}); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	if !strings.Contains(log.String(), "Listing files in layer") {
+		t.Errorf("log output = %q, want the verbose listing progress", log.String())
+	}
+}
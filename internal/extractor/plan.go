@@ -0,0 +1,420 @@
+package extractor
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/amartani/oci-extract/internal/detector"
+	"github.com/amartani/oci-extract/internal/estargz"
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/amartani/oci-extract/internal/registry"
+	"github.com/amartani/oci-extract/internal/remote"
+	"github.com/amartani/oci-extract/internal/soci"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"golang.org/x/sync/errgroup"
+)
+
+// PlanVersion is the version of the Plan format, bumped on incompatible changes
+const PlanVersion = 1
+
+// planFetchConcurrency is how many spans of a plan are fetched at a time
+// when no prefetch concurrency is set
+const planFetchConcurrency = 4
+
+// errNotPlanned is returned for reads of a layer outside of a plan
+var errNotPlanned = errors.New("read outside of the plan's ranges")
+
+// Plan is what extracting one file needs from a registry, worked out ahead of
+// time by Prepare: the layer holding the file, and the byte ranges of that
+// layer holding its compressed content. The layer's eStargz TOC or SOCI zTOC
+// is bundled into the plan, so ExtractPlan only fetches those ranges.
+type Plan struct {
+	Version int `json:"version"`
+
+	// Image is the image pinned to its digest, in the repository the layer
+	// is read from
+	Image    string `json:"image"`
+	FilePath string `json:"path"`
+
+	// Format is how the layer is read, "estargz" or "soci"
+	Format    string  `json:"format"`
+	Layer     v1.Hash `json:"layer"`
+	LayerSize int64   `json:"layerSize"`
+	BlobURL   string  `json:"blobURL"`
+
+	// TOCDigest is the digest an eStargz layer's descriptor records for its
+	// TOC, if any
+	TOCDigest string `json:"tocDigest,omitempty"`
+
+	// Bundle holds the parts of an eStargz layer read besides the file's
+	// content: its TOC and footer
+	Bundle []PlanBlock `json:"bundle,omitempty"`
+
+	// Ztoc is the SOCI zTOC of the layer
+	Ztoc []byte `json:"ztoc,omitempty"`
+
+	// Spans are the byte ranges of the layer ExtractPlan fetches
+	Spans []PlanSpan `json:"spans"`
+}
+
+// PlanBlock is part of a layer stored in a Plan
+type PlanBlock struct {
+	Offset int64  `json:"offset"`
+	Data   []byte `json:"data"`
+}
+
+// PlanSpan is a byte range of a layer
+type PlanSpan struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// FetchSize returns how many bytes ExtractPlan fetches for p
+func (p *Plan) FetchSize() int64 {
+	var n int64
+	for _, span := range p.Spans {
+		n += span.Length
+	}
+	return n
+}
+
+// planFormats are the formats a Plan can read a layer in, by name
+var planFormats = map[string]detector.Format{
+	detector.FormatEStargz.String(): detector.FormatEStargz,
+	detector.FormatSOCI.String():    detector.FormatSOCI,
+}
+
+// PrepareOptions contains options for planning an extraction
+type PrepareOptions struct {
+	ImageRef string
+	FilePath string
+
+	// ForceFormat limits planning to eStargz or SOCI
+	ForceFormat detector.Format
+}
+
+// Prepare plans extracting the file opts.FilePath from the topmost layer
+// holding it, reading only the layers' TOCs and zTOCs. Every layer down to
+// that one needs an eStargz TOC or SOCI zTOC, since a layer without one
+// can't be searched without downloading it.
+func (o *Orchestrator) Prepare(ctx context.Context, opts PrepareOptions) (*Plan, error) {
+	switch opts.ForceFormat {
+	case detector.FormatUnknown, detector.FormatEStargz, detector.FormatSOCI:
+	default:
+		return nil, fmt.Errorf("only eStargz and SOCI layers can be planned, not %s", opts.ForceFormat)
+	}
+	if output.IsDirTarget(opts.FilePath) {
+		return nil, fmt.Errorf("only files can be planned, not directory %s", opts.FilePath)
+	}
+	if o.client.IsLocalSource(opts.ImageRef) {
+		return nil, fmt.Errorf("local images have nothing to fetch; extract from them directly")
+	}
+
+	enhancedLayers, err := o.getLayers(ctx, opts.ImageRef)
+	if err != nil {
+		return nil, err
+	}
+	pinned, err := o.client.PinnedReference()
+	if err != nil {
+		return nil, err
+	}
+
+	sociIndex, err := o.discoverSOCIIndex(ctx, opts.ImageRef, opts.ForceFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	// Plan from the topmost layer down, as layers are applied bottom first
+	for i := len(enhancedLayers) - 1; i >= 0; i-- {
+		layerInfo := enhancedLayers[i]
+		if o.skipEmptyLayer(layerInfo) {
+			continue
+		}
+
+		plan, err := o.planLayer(ctx, layerInfo, sociIndex, opts)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("layer %s can't be planned: %w", layerInfo.Digest, err)
+		}
+
+		plan.Image = pinned.String()
+		plan.FilePath = opts.FilePath
+		if o.verbose {
+			fmt.Fprintf(o.log, "Planned %s from layer %s as %s: %d spans\n", opts.FilePath, layerInfo.Digest, plan.Format, len(plan.Spans))
+		}
+		return plan, nil
+	}
+
+	return nil, fmt.Errorf("file %s %w", opts.FilePath, ErrNotFound)
+}
+
+// planLayer plans extracting opts.FilePath from one layer, through its
+// eStargz TOC or its SOCI zTOC. An error wrapping fs.ErrNotExist means the
+// index doesn't list the file.
+func (o *Orchestrator) planLayer(ctx context.Context, layerInfo *registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo, opts PrepareOptions) (*Plan, error) {
+	format := opts.ForceFormat
+	if format == detector.FormatUnknown {
+		format, _ = o.detectFormat(ctx, layerInfo)
+	}
+
+	reader, err := o.newLayerReader(ctx, layerInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open layer blob: %w", err)
+	}
+	defer o.closeLayerReader(reader)
+
+	plan := &Plan{
+		Version:   PlanVersion,
+		Layer:     layerInfo.Digest,
+		LayerSize: layerInfo.Size,
+		BlobURL:   layerInfo.BlobURL,
+	}
+
+	var errs []error
+	if format != detector.FormatSOCI && (opts.ForceFormat == detector.FormatEStargz || !o.disabled[detector.FormatEStargz]) {
+		err := planEStargz(reader, layerInfo, opts.FilePath, plan)
+		if err == nil {
+			return plan, nil
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		errs = append(errs, err)
+	}
+	if sociIndex != nil && format != detector.FormatEStargz {
+		err := planSOCI(ctx, reader, layerInfo, sociIndex, opts.FilePath, plan)
+		if err == nil {
+			return plan, nil
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no eStargz TOC or SOCI zTOC to plan %s layers with", format)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// planEStargz plans reading filePath from an eStargz layer, bundling the
+// layer's TOC into plan
+func planEStargz(reader io.ReaderAt, layerInfo *registry.EnhancedLayerInfo, filePath string, plan *Plan) error {
+	extractor := estargz.NewExtractor(reader, layerInfo.Size)
+	extractor.SetTOCDigest(layerInfo.Annotations[estargz.TOCDigestAnnotation])
+
+	tocRange, err := extractor.TOCRange()
+	if err != nil {
+		return err
+	}
+	ranges, err := extractor.Ranges(filePath)
+	if err != nil {
+		return err
+	}
+
+	toc := make([]byte, tocRange.Length)
+	if _, err := reader.ReadAt(toc, tocRange.Offset); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read estargz TOC: %w", err)
+	}
+
+	plan.Format = detector.FormatEStargz.String()
+	plan.TOCDigest = layerInfo.Annotations[estargz.TOCDigestAnnotation]
+	plan.Bundle = []PlanBlock{{Offset: tocRange.Offset, Data: toc}}
+	plan.Spans = planSpans(ranges)
+	return nil
+}
+
+// planSOCI plans reading filePath from a SOCI-indexed layer, bundling the
+// layer's zTOC into plan
+func planSOCI(ctx context.Context, reader io.ReaderAt, layerInfo *registry.EnhancedLayerInfo, sociIndex *soci.IndexInfo, filePath string, plan *Plan) error {
+	ztocBlob, err := soci.GetZtocForLayer(ctx, sociIndex, layerInfo.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to get zTOC for layer: %w", err)
+	}
+
+	extractor, err := soci.NewExtractor(reader, layerInfo.Size, ztocBlob)
+	if err != nil {
+		return fmt.Errorf("failed to create SOCI extractor: %w", err)
+	}
+
+	target := output.DisplayPath(filePath)
+	entries := extractor.ListEntries()
+	i := slices.IndexFunc(entries, func(md output.Metadata) bool { return md.Path == target })
+	if i < 0 {
+		return fmt.Errorf("file %s not found in zTOC: %w", filePath, fs.ErrNotExist)
+	}
+	if err := output.CheckRegularFile(filePath, entries[i]); err != nil {
+		return err
+	}
+
+	ranges, err := extractor.Ranges(filePath)
+	if err != nil {
+		return err
+	}
+
+	plan.Format = detector.FormatSOCI.String()
+	plan.Ztoc = ztocBlob
+	plan.Spans = planSpans(ranges)
+	return nil
+}
+
+// planSpans converts the ranges an extractor reads to a plan's spans
+func planSpans(ranges []remote.Range) []PlanSpan {
+	spans := make([]PlanSpan, 0, len(ranges))
+	for _, r := range ranges {
+		spans = append(spans, PlanSpan{Offset: r.Offset, Length: r.Length})
+	}
+	return spans
+}
+
+// ExtractPlan extracts the file plan describes to outputPath, fetching only
+// the spans it lists. Everything else the extraction reads comes from the
+// plan; the image's manifest isn't fetched again.
+func (o *Orchestrator) ExtractPlan(ctx context.Context, plan *Plan, outputPath string, opts output.Options) (*ExtractResult, error) {
+	start := time.Now()
+	fetched := o.metrics.Fetched()
+
+	if plan.Version != PlanVersion {
+		return nil, fmt.Errorf("plan version %d isn't supported, prepare it again", plan.Version)
+	}
+	format, ok := planFormats[plan.Format]
+	if !ok {
+		return nil, fmt.Errorf("plan format %q isn't supported", plan.Format)
+	}
+
+	planned, err := o.fetchPlan(ctx, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case detector.FormatEStargz:
+		extractor := estargz.NewExtractor(planned, plan.LayerSize)
+		extractor.SetTOCDigest(plan.TOCDigest)
+		extractor.SetOutputOptions(opts)
+		err = extractor.ExtractFile(ctx, plan.FilePath, outputPath)
+	case detector.FormatSOCI:
+		var extractor *soci.Extractor
+		extractor, err = soci.NewExtractor(planned, plan.LayerSize, plan.Ztoc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create SOCI extractor: %w", err)
+		}
+		extractor.SetOutputOptions(opts)
+		err = extractor.ExtractFile(ctx, plan.FilePath, outputPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExtractResult{
+		Format:       format,
+		Layer:        plan.Layer,
+		OutputPath:   outputPath,
+		BytesFetched: o.metrics.Fetched() - fetched,
+		Duration:     time.Since(start),
+	}, nil
+}
+
+// fetchPlan fetches the spans of plan, returning a reader of the layer that
+// serves them along with the blocks bundled in the plan
+func (o *Orchestrator) fetchPlan(ctx context.Context, plan *Plan) (*plannedReader, error) {
+	if err := o.client.UseReference(plan.Image); err != nil {
+		return nil, err
+	}
+	client, err := o.client.BlobHTTPClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := remote.NewRemoteReaderWithClient(ctx, plan.BlobURL, client)
+	if err != nil {
+		return nil, err
+	}
+	defer o.closeLayerReader(reader)
+
+	if reader.Size() != plan.LayerSize {
+		return nil, fmt.Errorf("layer %s is %d bytes, but the plan expects %d", plan.Layer, reader.Size(), plan.LayerSize)
+	}
+
+	if o.verbose {
+		fmt.Fprintf(o.log, "Fetching %d spans of layer %s\n", len(plan.Spans), plan.Layer)
+	}
+
+	blocks := make([]PlanBlock, len(plan.Spans))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(o.prefetch, planFetchConcurrency))
+	for i, span := range plan.Spans {
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			data := make([]byte, span.Length)
+			if _, err := reader.ReadAt(data, span.Offset); err != nil && err != io.EOF {
+				return fmt.Errorf("failed to fetch range %d-%d: %w", span.Offset, span.Offset+span.Length-1, err)
+			}
+			blocks[i] = PlanBlock{Offset: span.Offset, Data: data}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return newPlannedReader(append(blocks, plan.Bundle...)), nil
+}
+
+// plannedReader reads a layer from the blocks of a plan, failing reads
+// outside of them
+type plannedReader struct {
+	blocks []PlanBlock // sorted by offset, with adjacent blocks merged
+}
+
+// newPlannedReader returns a reader of blocks, which may overlap
+func newPlannedReader(blocks []PlanBlock) *plannedReader {
+	sorted := slices.Clone(blocks)
+	slices.SortFunc(sorted, func(a, b PlanBlock) int { return cmp.Compare(a.Offset, b.Offset) })
+
+	// A read spanning adjacent blocks, such as a file's SOCI spans, is served
+	// from one block
+	var merged []PlanBlock
+	for _, block := range sorted {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			end := last.Offset + int64(len(last.Data))
+			if block.Offset <= end {
+				if blockEnd := block.Offset + int64(len(block.Data)); blockEnd > end {
+					last.Data = append(slices.Clip(last.Data), block.Data[end-block.Offset:]...)
+				}
+				continue
+			}
+		}
+		merged = append(merged, block)
+	}
+
+	return &plannedReader{blocks: merged}
+}
+
+func (r *plannedReader) ReadAt(p []byte, off int64) (int, error) {
+	// The last block starting at or before off
+	i := sort.Search(len(r.blocks), func(i int) bool { return r.blocks[i].Offset > off }) - 1
+	if i < 0 || off >= r.blocks[i].Offset+int64(len(r.blocks[i].Data)) {
+		return 0, fmt.Errorf("%w: offset %d", errNotPlanned, off)
+	}
+
+	n := copy(p, r.blocks[i].Data[off-r.blocks[i].Offset:])
+	if n < len(p) {
+		// Readers buffering ahead past a span get the part there is
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,137 @@
+package extractor
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amartani/oci-extract/internal/detector"
+	"github.com/amartani/oci-extract/internal/output"
+	stargz "github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// TestPlan tests that a plan prepared from an eStargz image extracts the
+// file from the spans it lists, with a fresh orchestrator
+func TestPlan(t *testing.T) {
+	server := httptest.NewServer(ggcrregistry.New())
+	defer server.Close()
+
+	// Small chunks spread the file over several gzip streams
+	data := strings.Repeat("0123456789", 10)
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	if err := tarWriter.WriteHeader(&tar.Header{Name: "data/blob", Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("failed to write tar header: %v", err)
+	}
+	if _, err := tarWriter.Write([]byte(data)); err != nil {
+		t.Fatalf("failed to write tar content: %v", err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	blob, err := stargz.Build(io.NewSectionReader(bytes.NewReader(buf.Bytes()), 0, int64(buf.Len())), stargz.WithChunkSize(16))
+	if err != nil {
+		t.Fatalf("failed to build eStargz blob: %v", err)
+	}
+	defer func() { _ = blob.Close() }()
+	layerData, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("failed to read eStargz blob: %v", err)
+	}
+
+	img, err := mutate.AppendLayers(empty.Image,
+		static.NewLayer(layerData, types.OCILayer),
+		estargzLayer(t, map[string]string{"etc/hostname": "test"}),
+	)
+	if err != nil {
+		t.Fatalf("failed to build image: %v", err)
+	}
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	plan, err := NewOrchestrator(false).Prepare(context.Background(), PrepareOptions{ImageRef: imageRef, FilePath: "/data/blob"})
+	if err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	if plan.Format != detector.FormatEStargz.String() || len(plan.Spans) == 0 || len(plan.Bundle) == 0 {
+		t.Fatalf("Prepare() = format %q, %d spans, %d bundled blocks, want estargz with both", plan.Format, len(plan.Spans), len(plan.Bundle))
+	}
+	if plan.FetchSize() >= int64(len(layerData)) {
+		t.Errorf("plan fetches %d bytes of a %d byte layer", plan.FetchSize(), len(layerData))
+	}
+
+	// Plans are written to and read from disk between the steps
+	encoded, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatalf("failed to encode plan: %v", err)
+	}
+	var decoded Plan
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("failed to decode plan: %v", err)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "blob")
+	result, err := NewOrchestrator(false).ExtractPlan(context.Background(), &decoded, outputPath, output.Options{})
+	if err != nil {
+		t.Fatalf("ExtractPlan() error = %v", err)
+	}
+	if result.Format != detector.FormatEStargz || result.Layer != plan.Layer {
+		t.Errorf("ExtractPlan() = %+v, want estargz from layer %s", result, plan.Layer)
+	}
+	got, err := os.ReadFile(outputPath)
+	if err != nil || string(got) != data {
+		t.Errorf("ExtractPlan() wrote %q, %v, want %q", got, err, data)
+	}
+
+	if _, err := NewOrchestrator(false).Prepare(context.Background(), PrepareOptions{ImageRef: imageRef, FilePath: "/missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Prepare() of a missing file error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPlannedReader(t *testing.T) {
+	r := newPlannedReader([]PlanBlock{
+		{Offset: 10, Data: []byte("klmno")},
+		{Offset: 0, Data: []byte("abcde")},
+		{Offset: 5, Data: []byte("fghij")},
+		{Offset: 30, Data: []byte("z")},
+	})
+
+	// Adjacent blocks are read as one
+	p := make([]byte, 15)
+	if n, err := r.ReadAt(p, 0); n != 15 || err != nil || string(p) != "abcdefghijklmno" {
+		t.Errorf("ReadAt(0) = %d, %v, %q, want the merged blocks", n, err, p)
+	}
+
+	// Reading ahead past a block gets what there is
+	p = make([]byte, 10)
+	if n, err := r.ReadAt(p, 12); n != 3 || err != io.EOF || string(p[:n]) != "mno" {
+		t.Errorf("ReadAt(12) = %d, %v, %q, want mno and EOF", n, err, p[:n])
+	}
+
+	for _, off := range []int64{15, 29, 31} {
+		if _, err := r.ReadAt(p, off); !errors.Is(err, errNotPlanned) {
+			t.Errorf("ReadAt(%d) error = %v, want errNotPlanned", off, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/amartani/oci-extract/internal/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// PlatformResult is the outcome of extracting a file from the image of one
+// platform of an index
+type PlatformResult struct {
+	Platform v1.Platform
+	Result   *ExtractResult
+	Err      error
+}
+
+// PlatformDir returns the directory name ExtractPlatforms writes a
+// platform's files to, e.g. linux-arm64 or linux-arm-v7
+func PlatformDir(p v1.Platform) string {
+	parts := []string{p.OS, p.Architecture}
+	if p.Variant != "" {
+		parts = append(parts, p.Variant)
+	}
+	return strings.Join(parts, "-")
+}
+
+// ExtractPlatforms extracts opts.FilePath from the image of every platform in
+// the index opts.ImageRef points to, each into its own directory below
+// opts.OutputPath named by PlatformDir: out/linux-arm64/busybox for the file
+// /bin/busybox, or out/linux-arm64 itself for a directory. A platform that
+// fails, e.g. whose image lacks the file, doesn't stop the others: the
+// returned results hold each platform's result or error. The error is only
+// set when the index itself can't be read.
+func (o *Orchestrator) ExtractPlatforms(ctx context.Context, opts ExtractOptions) ([]PlatformResult, error) {
+	platforms, err := o.client.Platforms(ctx, opts.ImageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each platform is picked in turn, then the caller's choice is restored
+	defer o.client.SelectManifest(o.client.Selector())
+
+	results := make([]PlatformResult, 0, len(platforms))
+	for _, platform := range platforms {
+		o.client.SelectManifest(registry.ManifestSelector{Platform: &platform})
+
+		platformOpts := opts
+		platformOpts.OutputPath = filepath.Join(opts.OutputPath, PlatformDir(platform))
+		if !output.IsDirTarget(opts.FilePath) {
+			platformOpts.OutputPath = filepath.Join(platformOpts.OutputPath, path.Base(opts.FilePath))
+		}
+		if err := os.MkdirAll(filepath.Dir(platformOpts.OutputPath), 0755); err != nil {
+			return results, fmt.Errorf("failed to create output directory: %w", err)
+		}
+
+		if o.verbose {
+			fmt.Fprintf(o.log, "Extracting %s for %s\n", opts.FilePath, platform)
+		}
+		result, err := o.Extract(ctx, platformOpts)
+		results = append(results, PlatformResult{Platform: platform, Result: result, Err: err})
+	}
+
+	return results, nil
+}
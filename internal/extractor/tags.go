@@ -0,0 +1,54 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/amartani/oci-extract/internal/registry"
+)
+
+// TagResult is the outcome of extracting a file from the image one tag of a
+// repository points to
+type TagResult struct {
+	Tag    string
+	Result *ExtractResult
+	Err    error
+}
+
+// ExtractTags extracts opts.FilePath from the image each of tags points to in
+// the repository of opts.ImageRef, whose own tag is ignored, each into its
+// own directory below opts.OutputPath named by the tag: out/v1/app.conf for
+// the file /etc/app.conf, or out/v1 itself for a directory. A tag that fails,
+// e.g. whose image lacks the file, doesn't stop the others: the returned
+// results hold each tag's result or error, in the order of tags.
+func (o *Orchestrator) ExtractTags(ctx context.Context, opts ExtractOptions, tags []string) ([]TagResult, error) {
+	results := make([]TagResult, 0, len(tags))
+	for _, tag := range tags {
+		imageRef, err := registry.WithTag(opts.ImageRef, tag)
+		if err != nil {
+			return results, err
+		}
+
+		tagOpts := opts
+		tagOpts.ImageRef = imageRef
+		tagOpts.OutputPath = filepath.Join(opts.OutputPath, tag)
+		if !output.IsDirTarget(opts.FilePath) {
+			tagOpts.OutputPath = filepath.Join(tagOpts.OutputPath, path.Base(opts.FilePath))
+		}
+		if err := os.MkdirAll(filepath.Dir(tagOpts.OutputPath), 0755); err != nil {
+			return results, fmt.Errorf("failed to create output directory: %w", err)
+		}
+
+		if o.verbose {
+			fmt.Fprintf(o.log, "Extracting %s from %s\n", opts.FilePath, imageRef)
+		}
+		result, err := o.Extract(ctx, tagOpts)
+		results = append(results, TagResult{Tag: tag, Result: result, Err: err})
+	}
+
+	return results, nil
+}
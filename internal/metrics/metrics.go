@@ -0,0 +1,224 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics counts what a run fetched and extracted, for writing in the
+// Prometheus text exposition format. A nil *Metrics records nothing, so
+// callers don't need to check whether metrics are enabled.
+type Metrics struct {
+	start   time.Time
+	fetched atomic.Int64
+
+	mu          sync.Mutex
+	caches      map[string]*cacheCounts
+	extractions map[extractionKey]*extractionCounts
+}
+
+// cacheCounts are the lookups in a single cache
+type cacheCounts struct {
+	hits, misses int64
+}
+
+// extractionKey labels extraction attempts
+type extractionKey struct {
+	format, result string
+}
+
+// extractionCounts are the attempts with the same labels
+type extractionCounts struct {
+	count    int64
+	duration time.Duration
+}
+
+// Results of an extraction attempt
+const (
+	ResultSuccess = "success" // The file or directory was written
+	ResultAbsent  = "absent"  // The layer doesn't hold the file
+	ResultFailure = "failure" // The format's method failed
+)
+
+// New creates metrics for a run starting now
+func New() *Metrics {
+	return &Metrics{
+		start:       time.Now(),
+		caches:      make(map[string]*cacheCounts),
+		extractions: make(map[extractionKey]*extractionCounts),
+	}
+}
+
+// AddFetched counts n bytes read from registry responses
+func (m *Metrics) AddFetched(n int64) {
+	if m == nil {
+		return
+	}
+	m.fetched.Add(n)
+}
+
+// Fetched returns the bytes counted by AddFetched so far, 0 for nil metrics
+func (m *Metrics) Fetched() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.fetched.Load()
+}
+
+// CacheHit counts a lookup that cache answered
+func (m *Metrics) CacheHit(cache string) {
+	m.cacheLookup(cache, true)
+}
+
+// CacheMiss counts a lookup that cache couldn't answer
+func (m *Metrics) CacheMiss(cache string) {
+	m.cacheLookup(cache, false)
+}
+
+func (m *Metrics) cacheLookup(cache string, hit bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts, ok := m.caches[cache]
+	if !ok {
+		counts = &cacheCounts{}
+		m.caches[cache] = counts
+	}
+	if hit {
+		counts.hits++
+	} else {
+		counts.misses++
+	}
+}
+
+// ObserveExtraction counts an attempt to extract with format's method, which
+// ended with result after d
+func (m *Metrics) ObserveExtraction(format, result string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := extractionKey{format: format, result: result}
+	counts, ok := m.extractions[key]
+	if !ok {
+		counts = &extractionCounts{}
+		m.extractions[key] = counts
+	}
+	counts.count++
+	counts.duration += d
+}
+
+// WriteText writes the metrics in the Prometheus text exposition format.
+// success reports whether the run succeeded.
+func (m *Metrics) WriteText(w io.Writer, success bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	family(&b, "oci_extract_fetched_bytes_total", "counter", "Bytes read from registry responses.")
+	fmt.Fprintf(&b, "oci_extract_fetched_bytes_total %d\n", m.fetched.Load())
+
+	caches := sortedKeys(m.caches, strings.Compare)
+	family(&b, "oci_extract_cache_hits_total", "counter", "Lookups answered by a cache.")
+	for _, cache := range caches {
+		fmt.Fprintf(&b, "oci_extract_cache_hits_total{cache=%q} %d\n", cache, m.caches[cache].hits)
+	}
+	family(&b, "oci_extract_cache_misses_total", "counter", "Lookups a cache couldn't answer.")
+	for _, cache := range caches {
+		fmt.Fprintf(&b, "oci_extract_cache_misses_total{cache=%q} %d\n", cache, m.caches[cache].misses)
+	}
+
+	keys := sortedKeys(m.extractions, func(a, b extractionKey) int {
+		if c := strings.Compare(a.format, b.format); c != 0 {
+			return c
+		}
+		return strings.Compare(a.result, b.result)
+	})
+	family(&b, "oci_extract_extractions_total", "counter", "Extraction attempts, by format and result.")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "oci_extract_extractions_total{format=%q,result=%q} %d\n", key.format, key.result, m.extractions[key].count)
+	}
+	family(&b, "oci_extract_extraction_duration_seconds_total", "counter", "Time spent in extraction attempts, by format and result.")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "oci_extract_extraction_duration_seconds_total{format=%q,result=%q} %g\n", key.format, key.result, m.extractions[key].duration.Seconds())
+	}
+
+	family(&b, "oci_extract_run_duration_seconds", "gauge", "Duration of the run.")
+	fmt.Fprintf(&b, "oci_extract_run_duration_seconds %g\n", time.Since(m.start).Seconds())
+
+	family(&b, "oci_extract_run_success", "gauge", "Whether the run succeeded (1) or failed (0).")
+	fmt.Fprintf(&b, "oci_extract_run_success %d\n", boolValue(success))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// family writes the HELP and TYPE lines introducing a metric
+func family(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sortedKeys returns the keys of m sorted by cmp, so output is stable
+func sortedKeys[K comparable, V any](m map[K]V, cmp func(a, b K) int) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, cmp)
+	return keys
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Transport is an http.RoundTripper counting the bytes read from response
+// bodies into a Metrics
+type Transport struct {
+	base    http.RoundTripper
+	metrics *Metrics
+}
+
+// NewTransport wraps base so the bytes of every response body read are
+// counted as fetched
+func NewTransport(base http.RoundTripper, m *Metrics) *Transport {
+	return &Transport{base: base, metrics: m}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &countedBody{ReadCloser: resp.Body, metrics: t.metrics}
+	return resp, nil
+}
+
+// countedBody counts every byte it returns
+type countedBody struct {
+	io.ReadCloser
+	metrics *Metrics
+}
+
+// Read implements io.Reader
+func (b *countedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.metrics.AddFetched(int64(n))
+	return n, err
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteText(t *testing.T) {
+	m := New()
+	m.AddFetched(1024)
+	m.CacheHit("layer")
+	m.CacheMiss("layer")
+	m.CacheMiss("layer")
+	m.ObserveExtraction("standard", ResultSuccess, 1500*time.Millisecond)
+	m.ObserveExtraction("estargz", ResultFailure, 250*time.Millisecond)
+	m.ObserveExtraction("standard", ResultSuccess, 500*time.Millisecond)
+
+	var out strings.Builder
+	if err := m.WriteText(&out, true); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+
+	for _, want := range []string{
+		"# TYPE oci_extract_fetched_bytes_total counter\noci_extract_fetched_bytes_total 1024\n",
+		`oci_extract_cache_hits_total{cache="layer"} 1` + "\n",
+		`oci_extract_cache_misses_total{cache="layer"} 2` + "\n",
+		`oci_extract_extractions_total{format="estargz",result="failure"} 1` + "\n" +
+			`oci_extract_extractions_total{format="standard",result="success"} 2` + "\n",
+		`oci_extract_extraction_duration_seconds_total{format="standard",result="success"} 2` + "\n",
+		"# TYPE oci_extract_run_duration_seconds gauge\n",
+		"oci_extract_run_success 1\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("WriteText() output is missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// TestNilMetrics tests that a nil *Metrics can be recorded into
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.AddFetched(1)
+	m.CacheHit("layer")
+	m.CacheMiss("layer")
+	m.ObserveExtraction("standard", ResultSuccess, time.Second)
+}
+
+// TestTransportCountsBodies tests that the bytes of response bodies are
+// counted as they are read
+func TestTransportCountsBodies(t *testing.T) {
+	data := strings.Repeat("x", 3000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, data)
+	}))
+	defer server.Close()
+
+	m := New()
+	client := &http.Client{Transport: NewTransport(http.DefaultTransport, m)}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	if got := m.fetched.Load(); got != int64(len(data)) {
+		t.Errorf("fetched = %d, want %d", got, len(data))
+	}
+}
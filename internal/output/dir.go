@@ -0,0 +1,250 @@
+package output
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+)
+
+const (
+	// whiteoutPrefix marks a file deleted by an upper layer
+	whiteoutPrefix = ".wh."
+
+	// whiteoutOpaque marks a directory whose lower-layer contents are hidden
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// DirPresence is what a layer says about the directory being extracted
+type DirPresence int
+
+const (
+	// DirUnchanged means the layer neither adds nor removes the directory
+	DirUnchanged DirPresence = iota
+
+	// DirPresent means the layer has the directory, or entries below it
+	DirPresent
+
+	// DirRemoved means the layer deletes the directory, or hides it through a
+	// whiteout of one of its parents
+	DirRemoved
+)
+
+// ErrUnsafePath is returned when a layer entry would be written outside of the
+// output directory, through ".." components or a symlink the layers created
+var ErrUnsafePath = errors.New("path escapes the output directory")
+
+// IsDirTarget reports whether a target path requests a directory extraction,
+// which is signalled by a trailing slash (e.g. "/etc/nginx/")
+func IsDirTarget(targetPath string) bool {
+	return strings.HasSuffix(targetPath, "/")
+}
+
+// ParseWhiteout reports whether entry (a slash-separated path) is an OCI
+// whiteout marker. For a regular whiteout, target is the path it deletes; for
+// an opaque marker, target is the directory whose lower contents are hidden.
+func ParseWhiteout(entry string) (target string, opaque bool, ok bool) {
+	dir, base := path.Split(entry)
+	if base == whiteoutOpaque {
+		if dir != "/" {
+			dir = strings.TrimSuffix(dir, "/")
+		}
+		return dir, true, true
+	}
+	if deleted, found := strings.CutPrefix(base, whiteoutPrefix); found {
+		return dir + deleted, false, true
+	}
+	return "", false, false
+}
+
+// IsStargzInternal reports whether a layer entry is one of the files eStargz
+// adds at the root of a layer for its own use (the prefetch landmarks and the
+// TOC), rather than content of the image
+func IsStargzInternal(name string) bool {
+	switch normalizeEntry(name) {
+	case estargz.PrefetchLandmark, estargz.NoPrefetchLandmark, estargz.TOCTarName:
+		return true
+	}
+	return false
+}
+
+// normalizeEntry strips leading "./" and "/" from a tar entry name
+func normalizeEntry(name string) string {
+	name = strings.TrimPrefix(name, "./")
+	return strings.TrimPrefix(name, "/")
+}
+
+// relativeToDir returns the path of entry relative to dir, or false if the
+// entry is not inside dir. Both are normalized, slash-separated paths; an
+// empty dir matches everything.
+func relativeToDir(entry, dir string) (string, bool) {
+	entry = strings.TrimSuffix(entry, "/")
+	if dir == "" {
+		return entry, entry != ""
+	}
+	rel, ok := strings.CutPrefix(entry, dir+"/")
+	return rel, ok && rel != ""
+}
+
+// ExtractDir writes every entry of a layer's tar stream that lives under
+// targetDir into outputDir, returning how many entries were written and
+// whether the layer adds or removes targetDir.
+//
+// OCI whiteouts are applied to outputDir, so calling ExtractDir for each
+// layer from bottom to top reproduces the merged directory tree.
+func ExtractDir(tarReader *tar.Reader, targetDir, outputDir string, opts Options) (int, DirPresence, error) {
+	return ExtractDirTo(tarReader, targetDir, NewDirTarget(outputDir, opts), opts)
+}
+
+// ExtractDirTo is ExtractDir writing through target rather than to a
+// directory on disk. Only opts.Filter and opts.SkipUnsafePaths are used;
+// how metadata is applied is up to the target.
+func ExtractDirTo(tarReader *tar.Reader, targetDir string, target Target, opts Options) (int, DirPresence, error) {
+	normalizedTarget := strings.Trim(targetDir, "/")
+	count := 0
+	presence := DirUnchanged
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, presence, fmt.Errorf("failed to read tar entry: %w", err)
+		}
+
+		entry := normalizeEntry(header.Name)
+		if IsStargzInternal(entry) {
+			continue
+		}
+
+		// A whiteout of the directory or one of its parents hides everything
+		// lower layers had below it
+		if removesDir(entry, normalizedTarget) {
+			if err := target.ClearDir(""); err != nil && !skipUnsafe(err, opts) {
+				return count, presence, fmt.Errorf("failed to apply whiteout %s: %w", entry, err)
+			}
+			presence = DirRemoved
+			continue
+		}
+
+		if strings.TrimSuffix(entry, "/") == normalizedTarget {
+			presence = DirPresent
+			continue
+		}
+
+		rel, ok := relativeToDir(entry, normalizedTarget)
+		if !ok {
+			continue
+		}
+		presence = DirPresent
+
+		// Never write outside of the output directory
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			if opts.SkipUnsafePaths {
+				continue
+			}
+			return count, presence, fmt.Errorf("%w: %s", ErrUnsafePath, header.Name)
+		}
+
+		dir, base := path.Split(rel)
+		dir = strings.TrimSuffix(dir, "/")
+
+		// Apply whiteouts from this layer to what lower layers produced
+		if base == whiteoutOpaque {
+			if err := target.ClearDir(dir); err != nil && !skipUnsafe(err, opts) {
+				return count, presence, fmt.Errorf("failed to apply opaque whiteout in %s: %w", dir, err)
+			}
+			continue
+		}
+		if deleted, ok := strings.CutPrefix(base, whiteoutPrefix); ok {
+			if err := target.RemoveAll(path.Join(dir, deleted)); err != nil && !skipUnsafe(err, opts) {
+				return count, presence, fmt.Errorf("failed to apply whiteout for %s: %w", rel, err)
+			}
+			continue
+		}
+
+		// Filters are checked as entries stream by, so skipped files are never written
+		if !opts.Filter.Allows(rel) {
+			continue
+		}
+
+		if err := writeEntry(tarReader, header, rel, normalizedTarget, target); err != nil {
+			if skipUnsafe(err, opts) {
+				continue
+			}
+			return count, presence, err
+		}
+		count++
+	}
+
+	return count, presence, nil
+}
+
+// removesDir reports whether entry is a whiteout deleting dir or one of its
+// parents, or an opaque marker hiding the lower contents of one of its
+// parents. The root directory can't be removed.
+func removesDir(entry, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	target, opaque, ok := ParseWhiteout(entry)
+	if !ok {
+		return false
+	}
+	if opaque && target == dir {
+		// Only what lower layers had in dir is hidden, which the marker
+		// below dir itself takes care of
+		return false
+	}
+	if target == "" {
+		return opaque
+	}
+	return target == dir || strings.HasPrefix(dir, target+"/")
+}
+
+// skipUnsafe reports whether err is a target refusing an unsafe path that
+// opts allows to be skipped
+func skipUnsafe(err error, opts Options) bool {
+	return opts.SkipUnsafePaths && errors.Is(err, ErrUnsafePath)
+}
+
+// writeEntry materializes a single tar entry at rel within target
+func writeEntry(tarReader *tar.Reader, header *tar.Header, rel, targetDir string, target Target) error {
+	// Entries replace whatever a lower layer left at the same path
+	if header.Typeflag != tar.TypeDir {
+		if err := target.RemoveAll(rel); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", rel, err)
+		}
+	}
+
+	md := MetadataFromTarHeader(header)
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return target.Mkdir(rel, md)
+
+	case tar.TypeReg:
+		return target.WriteFile(rel, tarReader, md)
+
+	case tar.TypeSymlink:
+		return target.Symlink(rel, md)
+
+	case tar.TypeLink:
+		// Hard links can only be recreated when the target was extracted too
+		oldRel, ok := relativeToDir(normalizeEntry(header.Linkname), targetDir)
+		if !ok || !filepath.IsLocal(filepath.FromSlash(oldRel)) {
+			return nil
+		}
+		return target.Link(oldRel, rel)
+
+	default:
+		// Devices, FIFOs, etc. are not extracted
+		return nil
+	}
+}
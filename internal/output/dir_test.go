@@ -0,0 +1,337 @@
+package output
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// testEntry describes a tar entry for buildTar
+type testEntry struct {
+	name     string
+	content  string
+	typeflag byte
+	linkname string
+}
+
+// buildTar creates an in-memory tar stream from entries
+func buildTar(t *testing.T, entries []testEntry) *tar.Reader {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		typeflag := e.typeflag
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		hdr := &tar.Header{
+			Name:     e.name,
+			Mode:     0644,
+			Size:     int64(len(e.content)),
+			Typeflag: typeflag,
+			Linkname: e.linkname,
+		}
+		if typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.content)); err != nil {
+				t.Fatalf("failed to write tar content: %v", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+
+	return tar.NewReader(&buf)
+}
+
+// listTree returns the slash-separated paths of all files under dir
+func listTree(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir || info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk output: %v", err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestIsDirTarget(t *testing.T) {
+	if !IsDirTarget("/etc/nginx/") {
+		t.Error("IsDirTarget(\"/etc/nginx/\") = false, want true")
+	}
+	if IsDirTarget("/etc/nginx") {
+		t.Error("IsDirTarget(\"/etc/nginx\") = true, want false")
+	}
+}
+
+func TestParseWhiteout(t *testing.T) {
+	tests := []struct {
+		entry  string
+		target string
+		opaque bool
+		ok     bool
+	}{
+		{entry: "/etc/.wh.passwd", target: "/etc/passwd", ok: true},
+		{entry: "/etc/nginx/.wh..wh..opq", target: "/etc/nginx", opaque: true, ok: true},
+		{entry: "/.wh..wh..opq", target: "/", opaque: true, ok: true},
+		{entry: "/etc/passwd"},
+	}
+
+	for _, tt := range tests {
+		target, opaque, ok := ParseWhiteout(tt.entry)
+		if target != tt.target || opaque != tt.opaque || ok != tt.ok {
+			t.Errorf("ParseWhiteout(%q) = (%q, %v, %v), want (%q, %v, %v)", tt.entry, target, opaque, ok, tt.target, tt.opaque, tt.ok)
+		}
+	}
+}
+
+func TestIsStargzInternal(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: ".prefetch.landmark", want: true},
+		{name: "./.no.prefetch.landmark", want: true},
+		{name: "/stargz.index.json", want: true},
+		{name: "etc/stargz.index.json", want: false},
+		{name: "etc/passwd", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := IsStargzInternal(tt.name); got != tt.want {
+			t.Errorf("IsStargzInternal(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtractDir(t *testing.T) {
+	outputDir := t.TempDir()
+
+	lower := buildTar(t, []testEntry{
+		{name: "etc/", typeflag: tar.TypeDir},
+		{name: "etc/nginx/", typeflag: tar.TypeDir},
+		{name: "etc/nginx/nginx.conf", content: "lower"},
+		{name: "etc/nginx/mime.types", content: "types"},
+		{name: "etc/nginx/conf.d/default.conf", content: "default"},
+		{name: "etc/nginxfoo", content: "not in dir"},
+		{name: "etc/passwd", content: "root"},
+	})
+	count, _, err := ExtractDir(lower, "/etc/nginx/", outputDir, Options{})
+	if err != nil {
+		t.Fatalf("ExtractDir() error = %v", err)
+	}
+	if count != 3 {
+		t.Errorf("ExtractDir() wrote %d entries, want 3", count)
+	}
+
+	upper := buildTar(t, []testEntry{
+		{name: "./etc/nginx/nginx.conf", content: "upper"},
+		{name: "./etc/nginx/.wh.mime.types", content: ""},
+		{name: "./etc/nginx/conf.d/.wh..wh..opq", content: ""},
+		{name: "./etc/nginx/conf.d/site.conf", content: "site"},
+		{name: "./etc/nginx/current", typeflag: tar.TypeSymlink, linkname: "nginx.conf"},
+	})
+	if _, _, err := ExtractDir(upper, "/etc/nginx/", outputDir, Options{}); err != nil {
+		t.Fatalf("ExtractDir() error = %v", err)
+	}
+
+	got := listTree(t, outputDir)
+	want := []string{"conf.d/site.conf", "current", "nginx.conf"}
+	if len(got) != len(want) {
+		t.Fatalf("ExtractDir() produced %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ExtractDir() produced %v, want %v", got, want)
+			break
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "nginx.conf"))
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if string(data) != "upper" {
+		t.Errorf("nginx.conf = %q, want upper layer content", data)
+	}
+
+	link, err := os.Readlink(filepath.Join(outputDir, "current"))
+	if err != nil || link != "nginx.conf" {
+		t.Errorf("current symlink = %q (%v), want nginx.conf", link, err)
+	}
+}
+
+func TestExtractDirRejectsTraversal(t *testing.T) {
+	root := t.TempDir()
+	outputDir := filepath.Join(root, "out")
+
+	entries := []testEntry{
+		{name: "data/../../escape.txt", content: "bad"},
+		{name: "data/ok.txt", content: "ok"},
+	}
+	if _, _, err := ExtractDir(buildTar(t, entries), "/data/", outputDir, Options{}); !errors.Is(err, ErrUnsafePath) {
+		t.Fatalf("ExtractDir() error = %v, want ErrUnsafePath", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape.txt")); !os.IsNotExist(err) {
+		t.Error("ExtractDir() wrote a file outside the output directory")
+	}
+
+	// With SkipUnsafePaths the entry is skipped and the rest is extracted
+	if _, _, err := ExtractDir(buildTar(t, entries), "/data/", outputDir, Options{SkipUnsafePaths: true}); err != nil {
+		t.Fatalf("ExtractDir() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape.txt")); !os.IsNotExist(err) {
+		t.Error("ExtractDir() wrote a file outside the output directory")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "ok.txt")); err != nil {
+		t.Errorf("ExtractDir() did not write ok.txt: %v", err)
+	}
+}
+
+func TestExtractDirRejectsSymlinkTraversal(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(root, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "keep.txt"), []byte("keep"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	outputDir := filepath.Join(root, "out")
+
+	tests := []struct {
+		name  string
+		entry testEntry
+	}{
+		{name: "write", entry: testEntry{name: "data/link/escape.txt", content: "bad"}},
+		{name: "whiteout", entry: testEntry{name: "data/link/.wh.keep.txt"}},
+		{name: "opaque whiteout", entry: testEntry{name: "data/link/.wh..wh..opq"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := buildTar(t, []testEntry{
+				{name: "data/link", typeflag: tar.TypeSymlink, linkname: outside},
+				tt.entry,
+			})
+			if _, _, err := ExtractDir(tr, "/data/", outputDir, Options{}); !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("ExtractDir() error = %v, want ErrUnsafePath", err)
+			}
+
+			if got := listTree(t, outside); len(got) != 1 || got[0] != "keep.txt" {
+				t.Errorf("directory outside the output = %v, want [keep.txt]", got)
+			}
+		})
+	}
+}
+
+func TestExtractDirFilter(t *testing.T) {
+	outputDir := t.TempDir()
+
+	tr := buildTar(t, []testEntry{
+		{name: "app/main.js", content: "main"},
+		{name: "app/node_modules/dep/index.js", content: "dep"},
+		{name: "app/node_modules/dep/test/spec.js", content: "spec"},
+	})
+	opts := Options{Filter: Filter{Exclude: []string{"node_modules/**/test"}}}
+	if _, _, err := ExtractDir(tr, "/app/", outputDir, opts); err != nil {
+		t.Fatalf("ExtractDir() error = %v", err)
+	}
+
+	got := listTree(t, outputDir)
+	want := []string{"main.js", "node_modules/dep/index.js"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("ExtractDir() produced %v, want %v", got, want)
+	}
+}
+
+func TestExtractDirParentWhiteout(t *testing.T) {
+	tests := []struct {
+		name     string
+		upper    []testEntry
+		want     []string
+		presence DirPresence
+	}{
+		{
+			name:     "whiteout of the directory",
+			upper:    []testEntry{{name: "etc/.wh.nginx"}},
+			presence: DirRemoved,
+		},
+		{
+			name:     "whiteout of a parent",
+			upper:    []testEntry{{name: "./.wh.etc"}},
+			presence: DirRemoved,
+		},
+		{
+			name:     "opaque parent",
+			upper:    []testEntry{{name: "etc/.wh..wh..opq"}},
+			presence: DirRemoved,
+		},
+		{
+			name:     "opaque parent recreating the directory",
+			upper:    []testEntry{{name: "etc/.wh..wh..opq"}, {name: "etc/nginx/", typeflag: tar.TypeDir}},
+			presence: DirPresent,
+		},
+		{
+			name:     "opaque directory",
+			upper:    []testEntry{{name: "etc/nginx/.wh..wh..opq"}, {name: "etc/nginx/site.conf", content: "site"}},
+			want:     []string{"site.conf"},
+			presence: DirPresent,
+		},
+		{
+			name:     "whiteout of a sibling",
+			upper:    []testEntry{{name: "etc/.wh.nginxfoo"}},
+			want:     []string{"nginx.conf"},
+			presence: DirUnchanged,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputDir := t.TempDir()
+
+			lower := buildTar(t, []testEntry{
+				{name: "etc/nginx/", typeflag: tar.TypeDir},
+				{name: "etc/nginx/nginx.conf", content: "lower"},
+			})
+			if _, presence, err := ExtractDir(lower, "/etc/nginx/", outputDir, Options{}); err != nil || presence != DirPresent {
+				t.Fatalf("ExtractDir() = %v, %v, want DirPresent", presence, err)
+			}
+
+			_, presence, err := ExtractDir(buildTar(t, tt.upper), "/etc/nginx/", outputDir, Options{})
+			if err != nil {
+				t.Fatalf("ExtractDir() error = %v", err)
+			}
+			if presence != tt.presence {
+				t.Errorf("ExtractDir() presence = %v, want %v", presence, tt.presence)
+			}
+			if got := listTree(t, outputDir); !slices.Equal(got, tt.want) {
+				t.Errorf("ExtractDir() produced %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
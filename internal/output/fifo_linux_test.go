@@ -0,0 +1,59 @@
+//go:build linux
+
+package output
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestWriteFileFIFO tests that a FIFO at the output path is streamed into
+// and left in place, mode and all
+func TestWriteFileFIFO(t *testing.T) {
+	fifo := filepath.Join(t.TempDir(), "pipe")
+	if err := unix.Mkfifo(fifo, 0600); err != nil {
+		t.Fatalf("failed to create FIFO: %v", err)
+	}
+
+	// Opening the FIFO for writing blocks until it's opened for reading
+	read := make(chan string, 1)
+	go func() {
+		f, err := os.Open(fifo)
+		if err != nil {
+			read <- "open failed: " + err.Error()
+			return
+		}
+		defer func() { _ = f.Close() }()
+		data, _ := io.ReadAll(f)
+		read <- string(data)
+	}()
+
+	var recorded []Metadata
+	opts := Options{
+		Permissions: FixedMode(0755),
+		Record:      func(md Metadata) { recorded = append(recorded, md) },
+	}
+	if err := opts.WriteFile(fifo, strings.NewReader("streamed")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	ApplyMetadata(fifo, Metadata{Path: "etc/data", Type: "reg", Mode: 0644}, opts)
+
+	if got := <-read; got != "streamed" {
+		t.Errorf("read %q from FIFO, want %q", got, "streamed")
+	}
+	info, err := os.Stat(fifo)
+	if err != nil {
+		t.Fatalf("FIFO gone after WriteFile(): %v", err)
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("FIFO mode = %v, want it untouched", info.Mode())
+	}
+	if len(recorded) != 1 {
+		t.Errorf("Record called %d times, want 1", len(recorded))
+	}
+}
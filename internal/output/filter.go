@@ -0,0 +1,98 @@
+package output
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filter selects which entries a directory extraction writes, using
+// .dockerignore-style glob patterns relative to the extracted directory.
+//
+// A pattern matches an entry or any of its parent directories, and "**"
+// matches any number of path segments. An entry is written when it matches
+// no Exclude pattern and, if any Include patterns are given, at least one of
+// them. Exclude always wins over Include.
+type Filter struct {
+	Include []string
+	Exclude []string
+}
+
+// Validate checks that all patterns are well-formed
+func (f Filter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		for _, segment := range splitPattern(pattern) {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Allows reports whether the entry at rel (slash-separated, relative to the
+// extracted directory) should be written
+func (f Filter) Allows(rel string) bool {
+	for _, pattern := range f.Exclude {
+		if matchPattern(pattern, rel) {
+			return false
+		}
+	}
+
+	if len(f.Include) == 0 {
+		return true
+	}
+
+	for _, pattern := range f.Include {
+		if matchPattern(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPattern splits a pattern into path segments, ignoring a leading "./" or "/"
+func splitPattern(pattern string) []string {
+	pattern = strings.TrimPrefix(pattern, "./")
+	pattern = strings.Trim(pattern, "/")
+	return strings.Split(pattern, "/")
+}
+
+// matchPattern reports whether pattern matches rel or one of its parents
+func matchPattern(pattern, rel string) bool {
+	patternSegments := splitPattern(pattern)
+	relSegments := strings.Split(strings.Trim(rel, "/"), "/")
+
+	for i := 1; i <= len(relSegments); i++ {
+		if matchSegments(patternSegments, relSegments[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments against pattern segments, where "**"
+// consumes zero or more segments
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
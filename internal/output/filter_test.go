@@ -0,0 +1,47 @@
+package output
+
+import "testing"
+
+func TestFilterAllows(t *testing.T) {
+	filter := Filter{
+		Include: []string{"conf.d", "*.conf", "html/**/*.html"},
+		Exclude: []string{"conf.d/default.conf", "**/tmp"},
+	}
+
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{rel: "nginx.conf", want: true},
+		{rel: "mime.types", want: false},
+		{rel: "conf.d/site.conf", want: true},
+		{rel: "conf.d/notes.txt", want: true},     // parent directory included
+		{rel: "conf.d/default.conf", want: false}, // exclude wins over include
+		{rel: "html/index.html", want: true},      // ** matches zero segments
+		{rel: "html/a/b/page.html", want: true},   // ** matches several segments
+		{rel: "html/a/style.css", want: false},
+		{rel: "conf.d/tmp/cache.conf", want: false}, // excluded parent directory
+		{rel: "html/tmp/x.html", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := filter.Allows(tt.rel); got != tt.want {
+			t.Errorf("Allows(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestFilterEmptyAllowsAll(t *testing.T) {
+	if !(Filter{}).Allows("any/path") {
+		t.Error("empty Filter should allow every entry")
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	if err := (Filter{Exclude: []string{"[invalid"}}).Validate(); err == nil {
+		t.Error("Validate() expected error for malformed pattern, got nil")
+	}
+	if err := (Filter{Include: []string{"**/*.conf"}}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package output
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// HashFile returns the size and hex-encoded sha256 of an extracted file
+func HashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to open extracted file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to hash extracted file: %w", err)
+	}
+
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// FindByDigest hashes every regular file in a tar archive and returns the
+// paths, normalized for display, of those whose content has digest d
+func FindByDigest(tarReader *tar.Reader, d digest.Digest) ([]string, error) {
+	var paths []string
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar entry: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg || IsStargzInternal(header.Name) {
+			continue
+		}
+
+		got, err := d.Algorithm().FromReader(tarReader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash %s: %w", header.Name, err)
+		}
+		if got == d {
+			paths = append(paths, DisplayPath(header.Name))
+		}
+	}
+
+	return paths, nil
+}
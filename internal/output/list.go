@@ -0,0 +1,75 @@
+package output
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/amartani/oci-extract/internal/pathutil"
+)
+
+// TypeRegular is the Metadata.Type of regular files
+const TypeRegular = "reg"
+
+// ListEntries collects the metadata of every entry in a tar archive, of any
+// type, with paths normalized for display. The root directory and eStargz
+// internals are left out.
+func ListEntries(tarReader *tar.Reader) ([]Metadata, error) {
+	var entries []Metadata
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar entry: %w", err)
+		}
+
+		if IsRootEntry(header.Name) || IsStargzInternal(header.Name) {
+			continue
+		}
+
+		md := MetadataFromTarHeader(header)
+		md.Path = DisplayPath(header.Name)
+		entries = append(entries, md)
+	}
+
+	return entries, nil
+}
+
+// IsRootEntry reports whether a layer entry names the root directory itself
+func IsRootEntry(name string) bool {
+	return normalizeEntry(name) == "" || normalizeEntry(name) == "."
+}
+
+// DisplayPath normalizes an entry name for listings: a leading slash and no
+// trailing one, so directories and files are keyed the same way
+func DisplayPath(name string) string {
+	return strings.TrimSuffix(pathutil.NormalizeForDisplay(name), "/")
+}
+
+// RegularFiles returns the regular files among entries, in order
+func RegularFiles(entries []Metadata) []Metadata {
+	var files []Metadata
+	for _, md := range entries {
+		if md.Type == TypeRegular {
+			files = append(files, md)
+		}
+	}
+	return files
+}
+
+// Paths returns the paths of entries, in order
+func Paths(entries []Metadata) []string {
+	if entries == nil {
+		return nil
+	}
+
+	paths := make([]string, len(entries))
+	for i, md := range entries {
+		paths[i] = md.Path
+	}
+	return paths
+}
@@ -0,0 +1,43 @@
+package output
+
+import (
+	"archive/tar"
+	"reflect"
+	"testing"
+)
+
+func TestListEntries(t *testing.T) {
+	tr := buildTar(t, []testEntry{
+		{name: "stargz.index.json", content: "{}"},
+		{name: ".prefetch.landmark", content: "\x00"},
+		{name: "./", typeflag: tar.TypeDir},
+		{name: "etc/", typeflag: tar.TypeDir},
+		{name: "etc/passwd", content: "root:x:0:0"},
+		{name: "./bin/sh", content: "#!"},
+		{name: "bin/ash", typeflag: tar.TypeSymlink, linkname: "sh"},
+	})
+
+	entries, err := ListEntries(tr)
+	if err != nil {
+		t.Fatalf("ListEntries() error = %v", err)
+	}
+
+	want := []string{"/etc", "/etc/passwd", "/bin/sh", "/bin/ash"}
+	if got := Paths(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("ListEntries() paths = %v, want %v", got, want)
+	}
+	if entries[0].Type != "dir" {
+		t.Errorf("ListEntries() type of /etc = %q, want dir", entries[0].Type)
+	}
+	if entries[1].Size != int64(len("root:x:0:0")) || entries[1].Mode != 0644 {
+		t.Errorf("ListEntries() metadata = %+v, want size %d and mode 0644", entries[1], len("root:x:0:0"))
+	}
+	if entries[3].Type != "symlink" || entries[3].Linkname != "sh" {
+		t.Errorf("ListEntries() symlink = %+v, want symlink to sh", entries[3])
+	}
+
+	files := []string{"/etc/passwd", "/bin/sh"}
+	if got := Paths(RegularFiles(entries)); !reflect.DeepEqual(got, files) {
+		t.Errorf("RegularFiles() paths = %v, want %v", got, files)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package output
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// applyOwner sets the uid/gid of path without following symlinks
+func applyOwner(path string, uid, gid int) error {
+	return os.Lchown(path, uid, gid)
+}
+
+// applyXattr sets an extended attribute on path without following symlinks
+func applyXattr(path, name string, value []byte) error {
+	return unix.Lsetxattr(path, name, value, 0)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package output
+
+// applyOwner is a no-op on non-Linux platforms
+func applyOwner(path string, uid, gid int) error {
+	return nil
+}
+
+// applyXattr is a no-op on non-Linux platforms
+func applyXattr(path, name string, value []byte) error {
+	return nil
+}
@@ -0,0 +1,343 @@
+package output
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+)
+
+// ErrNoMatch is returned when a file's content doesn't match Options.Match
+var ErrNoMatch = errors.New("content doesn't match")
+
+// paxXattrPrefix is the PAX record prefix used for extended attributes
+const paxXattrPrefix = "SCHILY.xattr."
+
+// Options controls how extracted files are written to disk
+type Options struct {
+	// Xattrs applies extended attributes recorded in the layer
+	Xattrs bool
+
+	// PreserveOwner applies the uid/gid recorded in the layer
+	PreserveOwner bool
+
+	// Filter limits which entries a directory extraction writes
+	Filter Filter
+
+	// SkipUnsafePaths makes a directory extraction skip entries that would
+	// be written outside of the output directory, rather than failing
+	SkipUnsafePaths bool
+
+	// Range, if set, limits single file extractions to part of the file
+	Range *ByteRange
+
+	// Permissions decides the mode of extracted regular files
+	Permissions Permissions
+
+	// CopyBuffer is the size in bytes of the buffer file contents are copied
+	// through, 0 for DefaultCopyBuffer
+	CopyBuffer int
+
+	// Record, if set, receives the source metadata of every written file
+	Record func(md Metadata)
+
+	// Match, if set, only lets files whose content matches it be written.
+	// Others fail with ErrNoMatch, leaving nothing at their output path.
+	Match *regexp.Regexp
+}
+
+// Metadata holds the source attributes of a layer entry
+type Metadata struct {
+	Path     string
+	Type     string // "reg", "dir", "symlink", ... as in eStargz and zTOC entries
+	Linkname string // Target of symlinks and hardlinks
+	Mode     int64
+	Size     int64
+	ModTime  time.Time
+	UID      int
+	GID      int
+	Xattrs   map[string][]byte
+}
+
+// MetadataFromTarHeader collects metadata from a tar header
+func MetadataFromTarHeader(header *tar.Header) Metadata {
+	md := MetadataFromPAXRecords(header.Uid, header.Gid, header.PAXRecords)
+	md.Path = header.Name
+	md.Type = tarTypeName(header.Typeflag)
+	md.Linkname = header.Linkname
+	md.Mode = header.Mode
+	md.Size = header.Size
+	md.ModTime = header.ModTime
+	return md
+}
+
+// MetadataFromTOCEntry collects metadata from an eStargz or zstd:chunked TOC entry
+func MetadataFromTOCEntry(entry *estargz.TOCEntry) Metadata {
+	return Metadata{
+		Path:     entry.Name,
+		Type:     entry.Type,
+		Linkname: entry.LinkName,
+		Mode:     entry.Mode,
+		Size:     entry.Size,
+		ModTime:  entry.ModTime(),
+		UID:      entry.UID,
+		GID:      entry.GID,
+		Xattrs:   entry.Xattrs,
+	}
+}
+
+// tarTypeName returns the entry type name eStargz and zTOC use for a typeflag
+func tarTypeName(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg:
+		return "reg"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeFifo:
+		return "fifo"
+	default:
+		return fmt.Sprintf("unknown (%d)", typeflag)
+	}
+}
+
+// typeDescriptions are the human names of entry types, for error messages
+var typeDescriptions = map[string]string{
+	"reg":      "regular file",
+	"dir":      "directory",
+	"symlink":  "symlink",
+	"hardlink": "hard link",
+	"char":     "character device",
+	"block":    "block device",
+	"fifo":     "FIFO",
+}
+
+// TypeDescription returns the human name of an entry type, e.g. "character
+// device" for "char", or the type itself if it has none
+func TypeDescription(typ string) string {
+	if description, ok := typeDescriptions[typ]; ok {
+		return description
+	}
+	return typ
+}
+
+// CheckRegularFile returns why the entry md found at targetPath can't be
+// extracted as a file, or nil if it's a regular file
+func CheckRegularFile(targetPath string, md Metadata) error {
+	switch md.Type {
+	case "reg":
+		return nil
+	case "symlink", "hardlink":
+		return fmt.Errorf("target path %s is a %s to %s, please extract the target instead", targetPath, TypeDescription(md.Type), md.Linkname)
+	default:
+		return fmt.Errorf("target path %s is not a regular file or symlink (type: %s)", targetPath, TypeDescription(md.Type))
+	}
+}
+
+// MetadataFromPAXRecords collects metadata from ownership fields and the
+// SCHILY.xattr.* PAX records of a tar entry
+func MetadataFromPAXRecords(uid, gid int, records map[string]string) Metadata {
+	md := Metadata{UID: uid, GID: gid}
+	for key, value := range records {
+		if name, ok := strings.CutPrefix(key, paxXattrPrefix); ok {
+			if md.Xattrs == nil {
+				md.Xattrs = make(map[string][]byte)
+			}
+			md.Xattrs[name] = []byte(value)
+		}
+	}
+	return md
+}
+
+// DefaultCopyBuffer is the size of the buffer file contents are copied
+// through unless Options.CopyBuffer is set. Larger copies mean fewer, larger
+// writes, which fast disks and network filesystems favor over io.Copy's
+// 32 KiB; one buffer is in use per file being written.
+const DefaultCopyBuffer = 1 << 20
+
+// copyBuffers recycles copy buffers across the files of an extraction
+var copyBuffers sync.Pool
+
+// copyBuffer returns a buffer of o.CopyBuffer bytes, to be put back in
+// copyBuffers once the copy is done
+func (o Options) copyBuffer() *[]byte {
+	size := o.CopyBuffer
+	if size <= 0 {
+		size = DefaultCopyBuffer
+	}
+
+	if buf, ok := copyBuffers.Get().(*[]byte); ok && len(*buf) == size {
+		return buf
+	}
+	buf := make([]byte, size)
+	return &buf
+}
+
+// IsSpecialFile reports whether path is an existing FIFO or device, which
+// extracted content is streamed into rather than replacing it
+func IsSpecialFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&(os.ModeNamedPipe|os.ModeDevice|os.ModeCharDevice) != 0
+}
+
+// WriteFile writes the contents of r to outputPath, creating parent
+// directories as needed. Contents are copied through a buffer of
+// o.CopyBuffer bytes. A FIFO or device at outputPath is written to as is.
+func (o Options) WriteFile(outputPath string, r io.Reader) error {
+	if o.Match != nil {
+		return o.writeMatching(outputPath, r)
+	}
+
+	special := IsSpecialFile(outputPath)
+
+	var outFile *os.File
+	var err error
+	if special {
+		// Another process reads from it, so it's opened for writing only,
+		// without the truncation os.Create would attempt
+		outFile, err = os.OpenFile(outputPath, os.O_WRONLY, 0)
+		if err != nil {
+			return fmt.Errorf("failed to open output file: %w", err)
+		}
+	} else {
+		// Create output directory if needed
+		outputDir := filepath.Dir(outputPath)
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+
+		// Create output file
+		outFile, err = os.Create(outputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+	}
+	defer func() { _ = outFile.Close() }()
+
+	buf := o.copyBuffer()
+	defer copyBuffers.Put(buf)
+
+	// Copy the file contents. Hiding the file's ReadFrom makes io.CopyBuffer
+	// use buf, rather than the 32 KiB buffer os.File falls back to. A partial
+	// copy is removed, so that a file left at outputPath is always complete.
+	if _, err := io.CopyBuffer(struct{ io.Writer }{outFile}, r, *buf); err != nil {
+		_ = outFile.Close()
+		if !special {
+			_ = os.Remove(outputPath)
+		}
+		return fmt.Errorf("failed to copy file contents: %w", err)
+	}
+
+	// Buffered writes, e.g. to a device, can still fail on close
+	return outFile.Close()
+}
+
+// ApplyMetadata applies the mode opts.Permissions picks, ownership and
+// extended attributes to an extracted file as requested by opts, then passes
+// md to opts.Record. Applying is best-effort: failures (typically a lack of
+// privileges) are reported as warnings on stderr.
+func ApplyMetadata(path string, md Metadata, opts Options) {
+	// A FIFO or device belongs to whoever set it up, not to the image
+	if IsSpecialFile(path) {
+		if opts.Record != nil {
+			opts.Record(md)
+		}
+		return
+	}
+
+	// Directories keep the mode they were created with, so entries can
+	// still be written below them
+	if mode, ok := opts.Permissions.Mode(md); ok && md.Type != "dir" {
+		if err := os.Chmod(path, mode); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not set mode of %s to %04o: %v\n", path, mode, err)
+		}
+	}
+
+	if opts.PreserveOwner {
+		if err := applyOwner(path, md.UID, md.GID); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not set owner of %s to %d:%d: %v\n", path, md.UID, md.GID, err)
+		}
+	}
+
+	if opts.Xattrs {
+		for name, value := range md.Xattrs {
+			if err := applyXattr(path, name, value); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not set xattr %s on %s: %v\n", name, path, err)
+			}
+		}
+	}
+
+	if opts.Record != nil {
+		opts.Record(md)
+	}
+}
+
+// writeMatching is WriteFile for o.Match. The content is staged in a
+// temporary file while it's matched, so that outputPath is only written, or
+// replaced, once it's known to match.
+func (o Options) writeMatching(outputPath string, r io.Reader) error {
+	staged, err := os.CreateTemp("", "oci-extract-match-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		_ = staged.Close()
+		_ = os.Remove(staged.Name())
+	}()
+
+	buf := o.copyBuffer()
+	defer copyBuffers.Put(buf)
+
+	// The regexp reads through a recorder, since it takes a read error for
+	// the end of the content
+	source := &errRecorder{r: io.TeeReader(r, staged)}
+	matched := o.Match.MatchReader(bufio.NewReader(source))
+	if source.err != nil {
+		return fmt.Errorf("failed to copy file contents: %w", source.err)
+	}
+	if !matched {
+		return fmt.Errorf("%w %q", ErrNoMatch, o.Match)
+	}
+
+	// Matching stops at the first match, leaving the rest to stage
+	if _, err := io.CopyBuffer(struct{ io.Writer }{staged}, r, *buf); err != nil {
+		return fmt.Errorf("failed to copy file contents: %w", err)
+	}
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read staged file: %w", err)
+	}
+
+	unmatched := o
+	unmatched.Match = nil
+	return unmatched.WriteFile(outputPath, staged)
+}
+
+// errRecorder keeps the first error other than io.EOF that reading r returns
+type errRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errRecorder) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,150 @@
+package output
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestMetadataFromTarHeader(t *testing.T) {
+	header := &tar.Header{
+		Uid: 1000,
+		Gid: 2000,
+		PAXRecords: map[string]string{
+			"SCHILY.xattr.user.comment":        "hello",
+			"SCHILY.xattr.security.capability": "\x01\x00",
+			"mtime":                            "1700000000",
+		},
+	}
+
+	md := MetadataFromTarHeader(header)
+	if md.UID != 1000 || md.GID != 2000 {
+		t.Errorf("MetadataFromTarHeader() owner = %d:%d, want 1000:2000", md.UID, md.GID)
+	}
+	if len(md.Xattrs) != 2 {
+		t.Fatalf("MetadataFromTarHeader() got %d xattrs, want 2", len(md.Xattrs))
+	}
+	if string(md.Xattrs["user.comment"]) != "hello" {
+		t.Errorf("MetadataFromTarHeader() user.comment = %q, want %q", md.Xattrs["user.comment"], "hello")
+	}
+}
+
+func TestCheckRegularFile(t *testing.T) {
+	tests := []struct {
+		typeflag byte
+		want     string
+	}{
+		{typeflag: tar.TypeChar, want: "target path /dev/null is not a regular file or symlink (type: character device)"},
+		{typeflag: tar.TypeDir, want: "target path /dev/null is not a regular file or symlink (type: directory)"},
+		{typeflag: tar.TypeFifo, want: "target path /dev/null is not a regular file or symlink (type: FIFO)"},
+		{typeflag: tar.TypeSymlink, want: "target path /dev/null is a symlink to /null, please extract the target instead"},
+		{typeflag: 'X', want: "target path /dev/null is not a regular file or symlink (type: unknown (88))"},
+	}
+
+	for _, tt := range tests {
+		md := MetadataFromTarHeader(&tar.Header{Name: "dev/null", Typeflag: tt.typeflag, Linkname: "/null"})
+		err := CheckRegularFile("/dev/null", md)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("CheckRegularFile(type %q) = %v, want %q", tt.typeflag, err, tt.want)
+		}
+	}
+
+	md := MetadataFromTarHeader(&tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg})
+	if err := CheckRegularFile("/etc/hosts", md); err != nil {
+		t.Errorf("CheckRegularFile(regular file) = %v, want nil", err)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "nested", "dir", "out.txt")
+
+	if err := (Options{}).WriteFile(outputPath, strings.NewReader("content")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if string(data) != "content" {
+		t.Errorf("WriteFile() wrote %q, want %q", data, "content")
+	}
+}
+
+// BenchmarkWriteFile compares copy buffer sizes for a large file read from
+// a stream, as when extracting from a tar, e.g. with
+// go test -bench WriteFile ./internal/output
+func BenchmarkWriteFile(b *testing.B) {
+	const size = 64 << 20
+	for _, buffer := range []int{32 << 10, 256 << 10, DefaultCopyBuffer, 4 << 20} {
+		b.Run(fmt.Sprintf("buffer=%dKiB", buffer>>10), func(b *testing.B) {
+			opts := Options{CopyBuffer: buffer}
+			outputPath := filepath.Join(b.TempDir(), "large")
+			b.SetBytes(size)
+
+			for b.Loop() {
+				// LimitReader hides the source's WriteTo, like a tar reader
+				r := io.LimitReader(zeroReader{}, size)
+				if err := opts.WriteFile(outputPath, r); err != nil {
+					b.Fatalf("WriteFile() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+// zeroReader is an endless stream of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// TestWriteFileMatch tests that content not matching Options.Match is never
+// written, and doesn't replace what is already at the output path
+func TestWriteFileMatch(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "os-release")
+	opts := Options{Match: regexp.MustCompile(`ID=alpine`)}
+
+	if err := opts.WriteFile(outputPath, strings.NewReader("NAME=Alpine\nID=alpine\nVERSION_ID=3.20\n")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	data, err := os.ReadFile(outputPath)
+	if err != nil || string(data) != "NAME=Alpine\nID=alpine\nVERSION_ID=3.20\n" {
+		t.Errorf("WriteFile() wrote %q, %v, want the whole file", data, err)
+	}
+
+	if err := opts.WriteFile(outputPath, strings.NewReader("ID=debian\n")); !errors.Is(err, ErrNoMatch) {
+		t.Fatalf("WriteFile() error = %v, want ErrNoMatch", err)
+	}
+	if data, _ := os.ReadFile(outputPath); !strings.Contains(string(data), "ID=alpine") {
+		t.Errorf("non-matching WriteFile() replaced the output with %q", data)
+	}
+
+	// A read error isn't taken for the end of a content that doesn't match
+	r := io.MultiReader(strings.NewReader("ID=de"), iotest.ErrReader(errors.New("connection reset")))
+	if err := opts.WriteFile(outputPath, r); err == nil || errors.Is(err, ErrNoMatch) {
+		t.Errorf("WriteFile() error = %v, want the read error", err)
+	}
+}
+
+// TestWriteFilePartial tests that a failed copy leaves no partial file behind
+func TestWriteFilePartial(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "out.txt")
+	r := io.MultiReader(strings.NewReader("part"), iotest.ErrReader(errors.New("connection reset")))
+
+	if err := (Options{}).WriteFile(outputPath, r); err == nil {
+		t.Fatal("WriteFile() succeeded, want error")
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind, stat error = %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package output
+
+import (
+	"fmt"
+	"os"
+)
+
+// permissionKind is how a Permissions policy picks a mode
+type permissionKind int
+
+const (
+	permissionsUmask permissionKind = iota
+	permissionsPreserve
+	permissionsFixed
+)
+
+// Permissions decides the mode of extracted regular files, the same way for
+// every format. The zero value is UseUmask.
+type Permissions struct {
+	kind permissionKind
+	mode os.FileMode
+}
+
+var (
+	// UseUmask leaves files with the mode they are created with, 0666 less
+	// the process umask, whatever the layer recorded
+	UseUmask = Permissions{kind: permissionsUmask}
+
+	// PreserveFromTar applies the permission bits recorded in the layer.
+	// Setuid, setgid and sticky bits are left out.
+	PreserveFromTar = Permissions{kind: permissionsPreserve}
+)
+
+// FixedMode gives every extracted file the permission bits of mode
+func FixedMode(mode os.FileMode) Permissions {
+	return Permissions{kind: permissionsFixed, mode: mode.Perm()}
+}
+
+// Mode returns the mode to give a file with the source metadata md, and false
+// when the mode it was created with is kept
+func (p Permissions) Mode(md Metadata) (os.FileMode, bool) {
+	switch p.kind {
+	case permissionsPreserve:
+		return os.FileMode(md.Mode).Perm(), true
+	case permissionsFixed:
+		return p.mode, true
+	default:
+		return 0, false
+	}
+}
+
+// String describes the policy, e.g. for verbose output
+func (p Permissions) String() string {
+	switch p.kind {
+	case permissionsPreserve:
+		return "preserve from layer"
+	case permissionsFixed:
+		return fmt.Sprintf("fixed %04o", p.mode)
+	default:
+		return "umask"
+	}
+}
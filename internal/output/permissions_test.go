@@ -0,0 +1,79 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPermissions(t *testing.T) {
+	md := Metadata{Type: "reg", Mode: 04750}
+
+	tests := []struct {
+		name   string
+		policy Permissions
+		want   os.FileMode
+		ok     bool
+	}{
+		{name: "umask", policy: UseUmask},
+		{name: "zero value", policy: Permissions{}},
+		{name: "preserve", policy: PreserveFromTar, want: 0750, ok: true},
+		{name: "fixed", policy: FixedMode(0600), want: 0600, ok: true},
+		{name: "fixed drops setuid", policy: FixedMode(os.ModeSetuid | 0755), want: 0755, ok: true},
+	}
+
+	for _, tt := range tests {
+		got, ok := tt.policy.Mode(md)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: Mode() = %04o, %v, want %04o, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestApplyMetadataPermissions tests that the policy sets the mode of files
+// but not of directories
+func TestApplyMetadataPermissions(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := (Options{}).WriteFile(file, strings.NewReader("content")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	before, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("failed to stat directory: %v", err)
+	}
+
+	tests := []struct {
+		policy Permissions
+		mode   int64
+		want   os.FileMode
+	}{
+		{policy: PreserveFromTar, mode: 0750, want: 0750},
+		{policy: FixedMode(0600), mode: 0750, want: 0600},
+		// Left alone, so the previous mode stays
+		{policy: UseUmask, mode: 0755, want: 0600},
+	}
+
+	for _, tt := range tests {
+		opts := Options{Permissions: tt.policy}
+		ApplyMetadata(file, Metadata{Type: "reg", Mode: tt.mode}, opts)
+		ApplyMetadata(dir, Metadata{Type: "dir", Mode: 0500}, opts)
+
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatalf("failed to stat file: %v", err)
+		}
+		if info.Mode().Perm() != tt.want {
+			t.Errorf("%s: file mode = %04o, want %04o", tt.policy, info.Mode().Perm(), tt.want)
+		}
+
+		info, err = os.Stat(dir)
+		if err != nil {
+			t.Fatalf("failed to stat directory: %v", err)
+		}
+		if info.Mode() != before.Mode() {
+			t.Errorf("%s: directory mode = %s, want %s", tt.policy, info.Mode(), before.Mode())
+		}
+	}
+}
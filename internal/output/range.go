@@ -0,0 +1,69 @@
+package output
+
+import (
+	"io"
+)
+
+// ByteRange selects part of a file's contents, e.g. to sample a large file
+type ByteRange struct {
+	Offset int64
+
+	// Length is the number of bytes from Offset, or negative for the rest of
+	// the file
+	Length int64
+}
+
+// Clamp returns the offset and length of the range within a file of size
+// bytes. A range starting past the end selects nothing.
+func (r ByteRange) Clamp(size int64) (int64, int64) {
+	offset := min(r.Offset, size)
+	length := size - offset
+	if r.Length >= 0 {
+		length = min(length, r.Length)
+	}
+	return offset, length
+}
+
+// Section returns the part of a file of size bytes that o.Range selects,
+// or the whole file without a range. Only the selected bytes are read from r,
+// so seekable formats fetch only the spans covering them.
+func (o Options) Section(r io.ReaderAt, size int64) *io.SectionReader {
+	if o.Range == nil {
+		return io.NewSectionReader(r, 0, size)
+	}
+
+	offset, length := o.Range.Clamp(size)
+	return io.NewSectionReader(r, offset, length)
+}
+
+// Limit returns the part of the file read sequentially from r that
+// o.Range selects, or r itself without a range. The bytes before the
+// range are read and discarded.
+func (o Options) Limit(r io.Reader) io.Reader {
+	if o.Range == nil {
+		return r
+	}
+
+	var limited io.Reader = &skipReader{r: r, skip: o.Range.Offset}
+	if o.Range.Length >= 0 {
+		limited = io.LimitReader(limited, o.Range.Length)
+	}
+	return limited
+}
+
+// skipReader discards the first skip bytes of r
+type skipReader struct {
+	r    io.Reader
+	skip int64
+}
+
+func (s *skipReader) Read(p []byte) (int, error) {
+	if s.skip > 0 {
+		n, err := io.CopyN(io.Discard, s.r, s.skip)
+		s.skip -= n
+		if err != nil {
+			return 0, err
+		}
+	}
+	return s.r.Read(p)
+}
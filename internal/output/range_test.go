@@ -0,0 +1,42 @@
+package output
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestByteRange(t *testing.T) {
+	const data = "0123456789"
+	tests := []struct {
+		r    *ByteRange
+		want string
+	}{
+		{r: nil, want: data},
+		{r: &ByteRange{Offset: 0, Length: 4}, want: "0123"},
+		{r: &ByteRange{Offset: 3, Length: -1}, want: "3456789"},
+		{r: &ByteRange{Offset: 8, Length: 10}, want: "89"},
+		{r: &ByteRange{Offset: 20, Length: -1}, want: ""},
+		{r: &ByteRange{Offset: 2, Length: 0}, want: ""},
+	}
+
+	for _, tt := range tests {
+		opts := Options{Range: tt.r}
+
+		section, err := io.ReadAll(opts.Section(strings.NewReader(data), int64(len(data))))
+		if err != nil {
+			t.Fatalf("Section(%+v) read error = %v", tt.r, err)
+		}
+		if string(section) != tt.want {
+			t.Errorf("Section(%+v) = %q, want %q", tt.r, section, tt.want)
+		}
+
+		limited, err := io.ReadAll(opts.Limit(strings.NewReader(data)))
+		if err != nil {
+			t.Fatalf("Limit(%+v) read error = %v", tt.r, err)
+		}
+		if string(limited) != tt.want {
+			t.Errorf("Limit(%+v) = %q, want %q", tt.r, limited, tt.want)
+		}
+	}
+}
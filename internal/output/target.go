@@ -0,0 +1,173 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Target is where ExtractDirTo materializes entries. Names are
+// slash-separated paths relative to the root of the target; "" is the root.
+// Targets refuse names that would resolve outside of the root with an error
+// wrapping ErrUnsafePath.
+type Target interface {
+	// Mkdir creates the directory name and any missing parents
+	Mkdir(name string, md Metadata) error
+
+	// WriteFile creates the regular file name with the contents of r
+	WriteFile(name string, r io.Reader, md Metadata) error
+
+	// Symlink creates name as a symlink to md.Linkname
+	Symlink(name string, md Metadata) error
+
+	// Link creates name as a hard link to the already written oldname
+	Link(oldname, name string) error
+
+	// RemoveAll removes name and anything below it, if present
+	RemoveAll(name string) error
+
+	// ClearDir removes the contents of the directory name, keeping it
+	ClearDir(name string) error
+}
+
+// dirTarget writes entries below a directory on disk
+type dirTarget struct {
+	root string
+	opts Options
+}
+
+// NewDirTarget returns a Target writing below the directory root, applying
+// metadata as requested by opts
+func NewDirTarget(root string, opts Options) Target {
+	return &dirTarget{root: root, opts: opts}
+}
+
+// path returns the location of name on disk
+func (t *dirTarget) path(name string) string {
+	return filepath.Join(t.root, filepath.FromSlash(name))
+}
+
+// checkNoSymlinks fails if name, or any directory leading to it, is a
+// symlink on disk. A layer could otherwise create a symlink to e.g. /etc and
+// then write, or whiteout, entries through it.
+func (t *dirTarget) checkNoSymlinks(name string) error {
+	if name == "" || name == "." {
+		return nil
+	}
+
+	dest := t.root
+	for part := range strings.SplitSeq(name, "/") {
+		dest = filepath.Join(dest, part)
+
+		info, err := os.Lstat(dest)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is below the symlink %s", ErrUnsafePath, name, dest)
+		}
+	}
+
+	return nil
+}
+
+func (t *dirTarget) Mkdir(name string, md Metadata) error {
+	if err := t.checkNoSymlinks(name); err != nil {
+		return err
+	}
+
+	dest := t.path(name)
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dest, err)
+	}
+
+	ApplyMetadata(dest, md, t.opts)
+	return nil
+}
+
+func (t *dirTarget) WriteFile(name string, r io.Reader, md Metadata) error {
+	if err := t.checkNoSymlinks(path.Dir(name)); err != nil {
+		return err
+	}
+
+	dest := t.path(name)
+	if err := t.opts.WriteFile(dest, r); err != nil {
+		return err
+	}
+
+	ApplyMetadata(dest, md, t.opts)
+	return nil
+}
+
+func (t *dirTarget) Symlink(name string, md Metadata) error {
+	if err := t.checkNoSymlinks(path.Dir(name)); err != nil {
+		return err
+	}
+
+	dest := t.path(name)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := os.Symlink(md.Linkname, dest); err != nil {
+		return fmt.Errorf("failed to create symlink %s: %w", dest, err)
+	}
+	return nil
+}
+
+func (t *dirTarget) Link(oldname, name string) error {
+	if err := t.checkNoSymlinks(path.Dir(oldname)); err != nil {
+		return err
+	}
+	if err := t.checkNoSymlinks(path.Dir(name)); err != nil {
+		return err
+	}
+
+	dest := t.path(name)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := os.Link(t.path(oldname), dest); err != nil {
+		return fmt.Errorf("failed to create hard link %s: %w", dest, err)
+	}
+	return nil
+}
+
+func (t *dirTarget) RemoveAll(name string) error {
+	if err := t.checkNoSymlinks(path.Dir(name)); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(t.path(name))
+}
+
+func (t *dirTarget) ClearDir(name string) error {
+	if err := t.checkNoSymlinks(name); err != nil {
+		return err
+	}
+
+	dir := t.path(name)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var _ Target = (*dirTarget)(nil)
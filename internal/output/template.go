@@ -0,0 +1,81 @@
+package output
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// NameFields are the fields an output NameTemplate can use, describing a
+// file found in an image
+type NameFields struct {
+	// Path is the file's path in the image, without its leading slash,
+	// e.g. etc/nginx/nginx.conf
+	Path string
+
+	// Dir and Base split Path into its directory, "." for files at the
+	// root, and its name
+	Dir  string
+	Base string
+
+	// Layer is the position of the layer the file came from in the image,
+	// 0 for the bottom layer
+	Layer int
+
+	// Digest is the hex digest of that layer, without the algorithm
+	Digest string
+}
+
+// NewNameFields describes the file at filePath in the image, found in the
+// layer at position layer with digest hex
+func NewNameFields(filePath string, layer int, hex string) NameFields {
+	p := strings.TrimPrefix(path.Clean("/"+filePath), "/")
+	return NameFields{
+		Path:   p,
+		Dir:    path.Dir(p),
+		Base:   path.Base(p),
+		Layer:  layer,
+		Digest: hex,
+	}
+}
+
+// NameTemplate names extracted files with a text/template over NameFields,
+// such as "{{.Layer}}/{{.Base}}". Names are relative to an output directory
+// and may not leave it.
+type NameTemplate struct {
+	text string
+	tmpl *template.Template
+}
+
+// ParseNameTemplate parses a NameTemplate, checking that it only uses
+// NameFields and names a file for a sample of them
+func ParseNameTemplate(text string) (*NameTemplate, error) {
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output template %q: %w", text, err)
+	}
+
+	t := &NameTemplate{text: text, tmpl: tmpl}
+	sample := NewNameFields("/etc/nginx/nginx.conf", 1, strings.Repeat("0", 64))
+	if _, err := t.Execute(sample); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Execute returns the name t gives the file described by fields, as a
+// relative OS path
+func (t *NameTemplate) Execute(fields NameFields) (string, error) {
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, fields); err != nil {
+		return "", fmt.Errorf("invalid output template %q: %w", t.text, err)
+	}
+
+	name := filepath.FromSlash(b.String())
+	if !filepath.IsLocal(name) || filepath.Clean(name) == "." {
+		return "", fmt.Errorf("output template %q gives %q for %s, which isn't a relative path inside the output directory", t.text, b.String(), fields.Path)
+	}
+	return name, nil
+}
@@ -0,0 +1,55 @@
+package output
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNameTemplate(t *testing.T) {
+	fields := NewNameFields("/etc/nginx/nginx.conf", 2, "0123456789abcdef")
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "{{.Layer}}/{{.Base}}", want: "2/nginx.conf"},
+		{text: "{{.Dir}}/{{slice .Digest 0 4}}-{{.Base}}", want: "etc/nginx/0123-nginx.conf"},
+		{text: "{{.Path}}.{{.Layer}}", want: "etc/nginx/nginx.conf.2"},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := ParseNameTemplate(tt.text)
+		if err != nil {
+			t.Fatalf("ParseNameTemplate(%q) error = %v", tt.text, err)
+		}
+		got, err := tmpl.Execute(fields)
+		if err != nil {
+			t.Fatalf("Execute(%q) error = %v", tt.text, err)
+		}
+		if got != filepath.FromSlash(tt.want) {
+			t.Errorf("Execute(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNameTemplateInvalid(t *testing.T) {
+	for _, text := range []string{
+		"{{.Base",        // malformed
+		"{{.Size}}",      // unknown field
+		"",               // empty name
+		"/{{.Path}}",     // absolute
+		"../{{.Base}}",   // outside the output directory
+		"{{.Dir}}/../..", // outside the output directory
+	} {
+		if _, err := ParseNameTemplate(text); err == nil {
+			t.Errorf("ParseNameTemplate(%q) expected error, got nil", text)
+		}
+	}
+}
+
+func TestNewNameFieldsRoot(t *testing.T) {
+	fields := NewNameFields("/hosts", 0, "")
+	if fields.Path != "hosts" || fields.Dir != "." || fields.Base != "hosts" {
+		t.Errorf("NewNameFields(/hosts) = %+v, want path hosts, dir ., base hosts", fields)
+	}
+}
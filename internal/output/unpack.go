@@ -0,0 +1,231 @@
+package output
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxUnpackDepth is how many gzip streams Unpack peels off one inside the
+// other, so an archive compressed over and over can't keep it going
+const maxUnpackDepth = 4
+
+// ErrNotArchive is returned by Unpack for a file that isn't a tar, gzip or
+// zip archive
+var ErrNotArchive = errors.New("not a tar, gzip or zip archive")
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+	tarMagic  = []byte("ustar")
+)
+
+// tarMagicOffset is where the ustar magic is in a tar header
+const tarMagicOffset = 257
+
+// ArchiveBase returns name without the archive extension Unpack recognizes,
+// e.g. app for app.tar.gz
+func ArchiveBase(name string) string {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip", ".gz"} {
+		if base, ok := strings.CutSuffix(name, ext); ok && base != "" {
+			return base
+		}
+	}
+	return name
+}
+
+// Unpack unpacks the archive at archivePath into outputDir, returning how
+// many entries were written. Archives are recognized by their content: zip,
+// tar, and gzip streams around either, or around a single file, which is
+// written decompressed. Entries can't be written outside of outputDir, and
+// metadata is applied as requested by opts.
+func Unpack(archivePath, outputDir string, opts Options) (int, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat archive: %w", err)
+	}
+
+	target := NewDirTarget(outputDir, opts)
+	name := filepath.Base(archivePath)
+
+	// A zip archive is read through its central directory, at its end
+	magic := make([]byte, len(zipMagic))
+	if n, _ := io.ReadFull(f, magic); bytes.Equal(magic[:n], zipMagic) {
+		return unpackZip(f, info.Size(), target, opts)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	return unpackStream(f, name, target, opts, 0)
+}
+
+// unpackStream unpacks the tar or gzip stream r, named name, into target.
+// depth counts the gzip streams already peeled off.
+func unpackStream(r io.Reader, name string, target Target, opts Options, depth int) (int, error) {
+	br := bufio.NewReaderSize(r, 1024)
+	// A short stream just has a short header
+	header, _ := br.Peek(tarMagicOffset + len(tarMagic))
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		if depth >= maxUnpackDepth {
+			return 0, fmt.Errorf("%s is compressed more than %d times over", name, maxUnpackDepth)
+		}
+		gzipReader, err := gzip.NewReader(br)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		defer func() { _ = gzipReader.Close() }()
+
+		inner := strings.TrimSuffix(name, ".gz")
+		if base, ok := strings.CutSuffix(name, ".tgz"); ok {
+			inner = base + ".tar"
+		}
+		return unpackStream(gzipReader, inner, target, opts, depth+1)
+
+	case len(header) > tarMagicOffset && bytes.HasPrefix(header[tarMagicOffset:], tarMagic),
+		strings.HasSuffix(name, ".tar"):
+		return unpackTar(tar.NewReader(br), target, opts)
+
+	case depth > 0:
+		// A single compressed file
+		if err := target.WriteFile(name, br, Metadata{Path: name, Type: "reg", Mode: 0644}); err != nil {
+			return 0, err
+		}
+		return 1, nil
+
+	default:
+		return 0, fmt.Errorf("%s: %w", name, ErrNotArchive)
+	}
+}
+
+// unpackTar writes every entry of a tar archive into target
+func unpackTar(tarReader *tar.Reader, target Target, opts Options) (int, error) {
+	count := 0
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to read tar entry: %w", err)
+		}
+
+		rel, ok, err := unpackName(header.Name, opts)
+		if err != nil {
+			return count, err
+		}
+		if !ok {
+			continue
+		}
+
+		if err := writeEntry(tarReader, header, rel, "", target); err != nil {
+			if skipUnsafe(err, opts) {
+				continue
+			}
+			return count, err
+		}
+		count++
+	}
+}
+
+// unpackZip writes every entry of the zip archive r, of size bytes, into target
+func unpackZip(r io.ReaderAt, size int64, target Target, opts Options) (int, error) {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read zip archive: %w", err)
+	}
+
+	count := 0
+	for _, f := range zipReader.File {
+		rel, ok, err := unpackName(f.Name, opts)
+		if err != nil {
+			return count, err
+		}
+		if !ok {
+			continue
+		}
+
+		if err := writeZipEntry(f, rel, target); err != nil {
+			if skipUnsafe(err, opts) {
+				continue
+			}
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// writeZipEntry materializes a single zip entry at rel within target
+func writeZipEntry(f *zip.File, rel string, target Target) error {
+	md := Metadata{
+		Path:    rel,
+		Mode:    int64(f.Mode().Perm()),
+		Size:    int64(f.UncompressedSize64),
+		ModTime: f.Modified,
+	}
+
+	if f.Mode().IsDir() {
+		md.Type = "dir"
+		return target.Mkdir(rel, md)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", f.Name, err)
+	}
+	defer func() { _ = rc.Close() }()
+
+	if err := target.RemoveAll(rel); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", rel, err)
+	}
+
+	if f.Mode()&fs.ModeSymlink != 0 {
+		// A symlink's target is stored as its content
+		linkname, err := io.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return fmt.Errorf("failed to read symlink %s: %w", f.Name, err)
+		}
+		md.Type = "symlink"
+		md.Linkname = string(linkname)
+		return target.Symlink(rel, md)
+	}
+
+	md.Type = "reg"
+	return target.WriteFile(rel, rc, md)
+}
+
+// unpackName returns the slash-separated path an archive entry is written
+// to, or false if it's skipped: the archive's root, or, if opts allows
+// skipping them, an entry outside of the output directory
+func unpackName(name string, opts Options) (string, bool, error) {
+	rel := strings.TrimSuffix(normalizeEntry(name), "/")
+	if rel == "" || rel == "." {
+		return "", false, nil
+	}
+
+	if !filepath.IsLocal(filepath.FromSlash(rel)) {
+		if opts.SkipUnsafePaths {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	return rel, true, nil
+}
@@ -0,0 +1,182 @@
+package output
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// tarBytes returns a tar archive of files, keyed by path
+func tarBytes(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write tar content: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// gzipBytes compresses data with gzip
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatalf("failed to write gzip data: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// zipBytes returns a zip archive of files, keyed by path
+func zipBytes(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write zip content: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// unpackBytes writes data to a file named name and unpacks it into a new
+// directory, returning the directory
+func unpackBytes(t *testing.T, name string, data []byte) (string, error) {
+	t.Helper()
+
+	archivePath := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(archivePath, data, 0644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	outputDir := t.TempDir()
+	_, err := Unpack(archivePath, outputDir, Options{})
+	return outputDir, err
+}
+
+func TestUnpack(t *testing.T) {
+	files := map[string]string{
+		"bin/app":         "binary",
+		"./etc/app.conf":  "config",
+		"share/README.md": "docs",
+	}
+	want := []string{"bin/app", "etc/app.conf", "share/README.md"}
+
+	tarData := tarBytes(t, files)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "app.tar", data: tarData},
+		{name: "app.tar.gz", data: gzipBytes(t, tarData)},
+		{name: "app.tgz", data: gzipBytes(t, tarData)},
+		{name: "app.zip", data: zipBytes(t, files)},
+		// Detected by content, whatever the name
+		{name: "app.bin", data: gzipBytes(t, tarData)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputDir, err := unpackBytes(t, tt.name, tt.data)
+			if err != nil {
+				t.Fatalf("Unpack() error = %v", err)
+			}
+			if got := listTree(t, outputDir); !reflect.DeepEqual(got, want) {
+				t.Errorf("unpacked %v, want %v", got, want)
+			}
+			content, err := os.ReadFile(filepath.Join(outputDir, "etc", "app.conf"))
+			if err != nil || string(content) != "config" {
+				t.Errorf("etc/app.conf = %q, %v, want %q", content, err, "config")
+			}
+		})
+	}
+}
+
+func TestUnpackCompressedFile(t *testing.T) {
+	outputDir, err := unpackBytes(t, "notes.txt.gz", gzipBytes(t, []byte("hello")))
+	if err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(outputDir, "notes.txt"))
+	if err != nil || string(content) != "hello" {
+		t.Errorf("notes.txt = %q, %v, want %q", content, err, "hello")
+	}
+}
+
+func TestUnpackNotArchive(t *testing.T) {
+	if _, err := unpackBytes(t, "notes.txt", []byte("hello")); !errors.Is(err, ErrNotArchive) {
+		t.Errorf("Unpack() error = %v, want ErrNotArchive", err)
+	}
+}
+
+func TestUnpackUnsafePath(t *testing.T) {
+	files := map[string]string{"../escape": "evil"}
+	for name, data := range map[string][]byte{
+		"evil.tar": tarBytes(t, files),
+		"evil.zip": zipBytes(t, files),
+	} {
+		if _, err := unpackBytes(t, name, data); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("Unpack(%s) error = %v, want ErrUnsafePath", name, err)
+		}
+	}
+}
+
+func TestUnpackDepthLimit(t *testing.T) {
+	data := tarBytes(t, map[string]string{"file": "content"})
+	for range maxUnpackDepth {
+		data = gzipBytes(t, data)
+	}
+	if _, err := unpackBytes(t, "deep.tar.gz", data); err != nil {
+		t.Fatalf("Unpack() of %d gzip streams error = %v", maxUnpackDepth, err)
+	}
+
+	if _, err := unpackBytes(t, "deeper.tar.gz", gzipBytes(t, data)); err == nil {
+		t.Error("Unpack() past the depth limit expected error, got nil")
+	}
+}
+
+func TestArchiveBase(t *testing.T) {
+	tests := map[string]string{
+		"app.tar.gz": "app",
+		"app.tgz":    "app",
+		"app.tar":    "app",
+		"app.zip":    "app",
+		"notes.gz":   "notes",
+		"app":        "app",
+		".tar":       ".tar",
+	}
+	for name, want := range tests {
+		if got := ArchiveBase(name); got != want {
+			t.Errorf("ArchiveBase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
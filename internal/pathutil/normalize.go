@@ -1,6 +1,11 @@
 package pathutil
 
-import "strings"
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
 
 // NormalizeForDisplay normalizes a file path for display in list output.
 // It ensures the path starts with "/" for consistency and familiar UX.
@@ -20,3 +25,20 @@ func NormalizeForDisplay(path string) string {
 
 	return path
 }
+
+// ErrRelativePath is returned by CheckStrict for a path without a leading "/"
+var ErrRelativePath = errors.New("path must be absolute")
+
+// CheckStrict returns an error unless path is already in the form paths in
+// an image are compared in: absolute and clean, like "/etc/hostname", or
+// "/etc/nginx/" for a directory. It rejects the relative paths, such as
+// "etc/hostname", that NormalizeForDisplay otherwise accepts.
+func CheckStrict(p string) error {
+	if !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("%w: %s (did you mean %s?)", ErrRelativePath, p, NormalizeForDisplay(p))
+	}
+	if clean := path.Clean(p); p != "/" && clean != strings.TrimSuffix(p, "/") {
+		return fmt.Errorf("path %s is not clean (did you mean %s?)", p, clean)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package pathutil
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckStrict(t *testing.T) {
+	for _, p := range []string{"/", "/etc/hostname", "/etc/nginx/"} {
+		if err := CheckStrict(p); err != nil {
+			t.Errorf("CheckStrict(%q) error = %v", p, err)
+		}
+	}
+
+	for _, p := range []string{"etc/hostname", "./etc/hostname", "hostname"} {
+		if err := CheckStrict(p); !errors.Is(err, ErrRelativePath) {
+			t.Errorf("CheckStrict(%q) error = %v, want ErrRelativePath", p, err)
+		}
+	}
+
+	for _, p := range []string{"/etc//hostname", "/etc/./hostname", "/etc/../etc/hostname", "//etc/hostname"} {
+		if err := CheckStrict(p); err == nil {
+			t.Errorf("CheckStrict(%q) expected error, got nil", p)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// maxBurst bounds how many bytes a single read may consume at once
+const maxBurst = 256 * 1024
+
+// bandwidthUnits maps size suffixes to their multiplier in bytes
+var bandwidthUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1000,
+	"kb":  1000,
+	"kib": 1 << 10,
+	"m":   1000 * 1000,
+	"mb":  1000 * 1000,
+	"mib": 1 << 20,
+	"g":   1000 * 1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1 << 30,
+}
+
+// ParseBandwidth parses a bandwidth such as "10MB/s", "512KiB" or "1.5M" into
+// bytes per second. Decimal units (KB, MB, GB) are powers of 1000 and binary
+// units (KiB, MiB, GiB) are powers of 1024.
+func ParseBandwidth(s string) (int64, error) {
+	return parseBytes(s, strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s"), "bandwidth")
+}
+
+// ParseSize parses a size such as "4GiB" or "500MB" into bytes, with the
+// units of ParseBandwidth
+func ParseSize(s string) (int64, error) {
+	return parseBytes(s, strings.ToLower(strings.TrimSpace(s)), "size")
+}
+
+// parseBytes parses value, the normalized form of s, as a positive number of
+// bytes with an optional unit. what names the quantity in errors.
+func parseBytes(s, value, what string) (int64, error) {
+	split := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	number, unit := value, ""
+	if split >= 0 {
+		number, unit = value[:split], strings.TrimSpace(value[split:])
+	}
+
+	multiplier, ok := bandwidthUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid %s %q: unknown unit %q", what, s, unit)
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", what, s, err)
+	}
+
+	bytes := int64(n * float64(multiplier))
+	if bytes <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be positive", what, s)
+	}
+
+	return bytes, nil
+}
+
+// Transport is an http.RoundTripper that caps how fast response bodies are
+// read. All requests through the same Transport share one token bucket.
+type Transport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+// NewTransport wraps base so response bodies are read at no more than
+// bytesPerSec bytes per second
+func NewTransport(base http.RoundTripper, bytesPerSec int64) *Transport {
+	burst := int(min(bytesPerSec, maxBurst))
+	return &Transport{
+		base:    base,
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSec), burst),
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = &limitedBody{
+		ReadCloser: resp.Body,
+		ctx:        req.Context(),
+		limiter:    t.limiter,
+	}
+	return resp, nil
+}
+
+// limitedBody waits for tokens for every byte it returns
+type limitedBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+// Read implements io.Reader
+func (b *limitedBody) Read(p []byte) (int, error) {
+	// Never ask for more tokens than the bucket can hold
+	if len(p) > b.limiter.Burst() {
+		p = p[:b.limiter.Burst()]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := b.limiter.WaitN(b.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
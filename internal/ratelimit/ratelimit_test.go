@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "10MB/s", want: 10 * 1000 * 1000},
+		{input: "512KiB", want: 512 * 1024},
+		{input: "1.5M", want: 1500 * 1000},
+		{input: "2 GiB/s", want: 2 << 30},
+		{input: "4096", want: 4096},
+		{input: "10XB", wantErr: true},
+		{input: "MB", wantErr: true},
+		{input: "0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseBandwidth(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseBandwidth(%q) expected error, got nil", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseBandwidth(%q) error = %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseBandwidth(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	if got, err := ParseSize("4GiB"); err != nil || got != 4<<30 {
+		t.Errorf("ParseSize(%q) = %d, %v, want %d", "4GiB", got, err, int64(4<<30))
+	}
+	for _, s := range []string{"10MB/s", "-1", "lots"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("ParseSize(%q) expected error, got nil", s)
+		}
+	}
+}
+
+// TestTransportLimitsBodies tests that response bodies are read at the capped rate
+func TestTransportLimitsBodies(t *testing.T) {
+	data := strings.Repeat("x", 3000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, data)
+	}))
+	defer server.Close()
+
+	// 1000 bytes/s with a 1000 byte burst: 3000 bytes take about two seconds
+	client := &http.Client{Transport: NewTransport(http.DefaultTransport, 1000)}
+
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if string(body) != data {
+		t.Errorf("body length = %d, want %d", len(body), len(data))
+	}
+
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Errorf("read took %v, expected the limiter to slow it to about 2s", elapsed)
+	}
+}
@@ -0,0 +1,222 @@
+package registry
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+const (
+	// ociArchiveTransportPrefix is the skopeo transport prefix for tarred OCI layouts
+	ociArchiveTransportPrefix = "oci-archive:"
+
+	// dockerArchiveTransportPrefix is the skopeo transport prefix for docker save archives
+	dockerArchiveTransportPrefix = "docker-archive:"
+
+	// stdinArchivePath is the archive path that reads the archive from stdin
+	stdinArchivePath = "-"
+)
+
+// ErrStdinTooLarge is returned when an archive on stdin exceeds the size
+// given to UnpackArchive
+var ErrStdinTooLarge = errors.New("archive on stdin exceeds the maximum size")
+
+// IsArchiveReference reports whether an image reference uses the
+// oci-archive: or docker-archive: transport
+func IsArchiveReference(imageRef string) bool {
+	return strings.HasPrefix(imageRef, ociArchiveTransportPrefix) ||
+		strings.HasPrefix(imageRef, dockerArchiveTransportPrefix)
+}
+
+// parseArchiveReference splits an oci-archive:<path>[:<tag>] or
+// docker-archive:<path>[:<name:tag>] reference into its transport prefix,
+// archive path and the optional reference within the archive
+func parseArchiveReference(imageRef string) (string, string, string, error) {
+	prefix := ociArchiveTransportPrefix
+	if strings.HasPrefix(imageRef, dockerArchiveTransportPrefix) {
+		prefix = dockerArchiveTransportPrefix
+	}
+
+	path, ref, _ := strings.Cut(strings.TrimPrefix(imageRef, prefix), ":")
+	if path == "" {
+		return "", "", "", fmt.Errorf("invalid archive reference %s: missing path", imageRef)
+	}
+
+	return prefix, path, ref, nil
+}
+
+// UnpackArchive unpacks the image archive named by an oci-archive: or
+// docker-archive: reference into a temporary OCI layout, and returns an oci:
+// reference to it along with a function removing it. A path of - reads the
+// archive from stdin, up to maxStdinSize bytes.
+//
+// Stdin can't be seeked, so it's never read in place: an OCI archive is
+// unpacked to disk as it's read, and a docker archive, whose manifest comes
+// last, is first saved whole, taking about twice its size in temporary space.
+// Nothing is held in memory.
+func UnpackArchive(imageRef string, stdin io.Reader, maxStdinSize int64) (string, func(), error) {
+	prefix, path, ref, err := parseArchiveReference(imageRef)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "oci-extract-archive-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+
+	var archive io.Reader
+	if path == stdinArchivePath {
+		archive = &cappedReader{r: stdin, remaining: maxStdinSize}
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+		archive = f
+	}
+
+	layoutDir := filepath.Join(dir, "layout")
+	if prefix == ociArchiveTransportPrefix {
+		err = untar(archive, layoutDir)
+	} else {
+		err = convertDockerArchive(archive, path, ref, dir, layoutDir)
+		// The layout only holds the selected image, so the name is used up
+		ref = ""
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to unpack %s: %w", imageRef, err)
+	}
+
+	layoutRef := layoutTransportPrefix + layoutDir
+	if ref != "" {
+		layoutRef += ":" + ref
+	}
+	return layoutRef, cleanup, nil
+}
+
+// convertDockerArchive writes the image ref, or the only image, of a docker
+// save archive to a new OCI layout at layoutDir. Archives read from stdin are
+// saved below dir first, as the tarball reader needs to seek.
+func convertDockerArchive(archive io.Reader, path, ref, dir, layoutDir string) error {
+	if path == stdinArchivePath {
+		path = filepath.Join(dir, "docker-archive.tar")
+		if err := writeFile(archive, path); err != nil {
+			return fmt.Errorf("failed to save archive: %w", err)
+		}
+		defer func() { _ = os.Remove(path) }()
+	}
+
+	var tag *name.Tag
+	if ref != "" {
+		t, err := name.NewTag(ref)
+		if err != nil {
+			return fmt.Errorf("invalid image name %s: %w", ref, err)
+		}
+		tag = &t
+	}
+
+	img, err := tarball.ImageFromPath(path, tag)
+	if err != nil {
+		return fmt.Errorf("failed to read docker archive: %w", err)
+	}
+
+	p, err := layout.Write(layoutDir, empty.Index)
+	if err != nil {
+		return fmt.Errorf("failed to create oci layout: %w", err)
+	}
+	if err := p.AppendImage(img); err != nil {
+		return fmt.Errorf("failed to write image to oci layout: %w", err)
+	}
+	return nil
+}
+
+// writeFile copies r to a new file at path
+func writeFile(r io.Reader, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// untar writes the directories and regular files of a tar stream below dir.
+// Other entries aren't part of an OCI layout, so they are skipped.
+func untar(r io.Reader, dir string) error {
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive entry: %w", err)
+		}
+
+		// Entries must stay inside the layout
+		entry := filepath.FromSlash(strings.TrimPrefix(header.Name, "./"))
+		if entry == "" || entry == "." {
+			continue
+		}
+		if !filepath.IsLocal(entry) {
+			return fmt.Errorf("archive entry %s is outside the archive", header.Name)
+		}
+		target := filepath.Join(dir, entry)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", header.Name, err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for %s: %w", header.Name, err)
+			}
+			if err := writeFile(tarReader, target); err != nil {
+				return fmt.Errorf("failed to write %s: %w", header.Name, err)
+			}
+		}
+	}
+}
+
+// cappedReader fails with ErrStdinTooLarge rather than reading more than
+// remaining bytes
+type cappedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		// Only an archive that is actually larger fails
+		var probe [1]byte
+		if n, _ := c.r.Read(probe[:]); n > 0 {
+			return 0, ErrStdinTooLarge
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	return n, err
+}
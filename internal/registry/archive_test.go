@@ -0,0 +1,126 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// tarDir archives the files below dir, as skopeo does for oci-archive:
+func tarDir(t *testing.T, dir string) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	if err := tarWriter.AddFS(os.DirFS(dir)); err != nil {
+		t.Fatalf("failed to archive %s: %v", dir, err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	return &buf
+}
+
+func TestUnpackOCIArchiveFromStdin(t *testing.T) {
+	dir, digests := writeTestLayout(t, "v1", "v2")
+	archive := tarDir(t, dir)
+
+	ref, cleanup, err := UnpackArchive("oci-archive:-:v2", bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatalf("UnpackArchive() error = %v", err)
+	}
+
+	img, err := NewClient().GetImage(context.Background(), ref)
+	if err != nil {
+		t.Fatalf("GetImage(%s) error = %v", ref, err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("failed to get image digest: %v", err)
+	}
+	if digest != digests["v2"] {
+		t.Errorf("image digest = %s, want %s", digest, digests["v2"])
+	}
+
+	path, _, err := parseLayoutReference(ref)
+	if err != nil {
+		t.Fatalf("parseLayoutReference(%s) error = %v", ref, err)
+	}
+	cleanup()
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("layout %s left behind after cleanup", path)
+	}
+
+	// One byte short of the archive is too small
+	if _, _, err := UnpackArchive("oci-archive:-", bytes.NewReader(archive.Bytes()), int64(archive.Len()-1)); !errors.Is(err, ErrStdinTooLarge) {
+		t.Errorf("UnpackArchive() over the size cap error = %v, want ErrStdinTooLarge", err)
+	}
+}
+
+func TestUnpackDockerArchiveFromStdin(t *testing.T) {
+	img, err := random.Image(64, 2)
+	if err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+	tag, err := name.NewTag("example.com/test/image:v1")
+	if err != nil {
+		t.Fatalf("failed to parse tag: %v", err)
+	}
+	var archive bytes.Buffer
+	if err := tarball.Write(tag, img, &archive); err != nil {
+		t.Fatalf("failed to write docker archive: %v", err)
+	}
+
+	ref, cleanup, err := UnpackArchive("docker-archive:-:"+tag.String(), &archive, 1<<30)
+	if err != nil {
+		t.Fatalf("UnpackArchive() error = %v", err)
+	}
+	defer cleanup()
+
+	got, err := NewClient().GetImage(context.Background(), ref)
+	if err != nil {
+		t.Fatalf("GetImage(%s) error = %v", ref, err)
+	}
+	gotConfig, err := got.ConfigName()
+	if err != nil {
+		t.Fatalf("failed to get config digest: %v", err)
+	}
+	wantConfig, err := img.ConfigName()
+	if err != nil {
+		t.Fatalf("failed to get config digest: %v", err)
+	}
+	if gotConfig != wantConfig {
+		t.Errorf("config digest = %s, want %s", gotConfig, wantConfig)
+	}
+}
+
+func TestUnpackArchiveRejectsEscapingEntries(t *testing.T) {
+	var archive bytes.Buffer
+	tarWriter := tar.NewWriter(&archive)
+	if err := tarWriter.WriteHeader(&tar.Header{Name: "../escaped", Mode: 0644, Size: 1, Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("failed to write tar header: %v", err)
+	}
+	if _, err := tarWriter.Write([]byte("x")); err != nil {
+		t.Fatalf("failed to write tar content: %v", err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "image.tar")
+	if err := os.WriteFile(path, archive.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	if _, _, err := UnpackArchive("oci-archive:"+path, nil, 0); err == nil {
+		t.Error("UnpackArchive() with an escaping entry expected error, got nil")
+	}
+}
@@ -2,19 +2,47 @@ package registry
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
 
+	"github.com/amartani/oci-extract/internal/metrics"
+	"github.com/amartani/oci-extract/internal/ratelimit"
+	remoteio "github.com/amartani/oci-extract/internal/remote"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
+// ErrSchema1 is returned for images whose manifest is a Docker v2 schema1
+// manifest, whose layer model isn't supported
+var ErrSchema1 = errors.New("schema1 manifests are not supported; re-push the image in OCI/schema2 format")
+
+// ErrNotIndex is returned by Platforms for a reference to a single image
+var ErrNotIndex = errors.New("not a multi-platform index")
+
 // Client handles OCI registry operations
 type Client struct {
-	authOpts []remote.Option
-	imageRef string // Store the image reference for URL construction
-	ref      name.Reference
+	authOpts   []remote.Option
+	imageRef   string // Store the image reference for URL construction
+	ref        name.Reference
+	pinned     name.Digest // Digest the reference resolved to in GetImage
+	containerd *containerdSource
+	blobClient *http.Client      // Authenticated client for blob URLs, created on demand
+	blobMu     sync.Mutex        // Guards blobClient, which parallel extractions share
+	transport  http.RoundTripper // Base transport for registry and blob requests
+	userAgent  string            // User-Agent for registry and blob requests, empty for the default
+	selector   ManifestSelector  // Picks the image when a reference points at an index
+	keychain   authn.Keychain    // Source of registry credentials
+	redirects  bool              // Whether blob requests follow redirects, e.g. to a CDN
+	repoMap    RepositoryMap     // Where relocated repositories are fetched from
 }
 
 // NewClient creates a new registry client with authentication
@@ -23,29 +51,364 @@ func NewClient() *Client {
 		authOpts: []remote.Option{
 			remote.WithAuthFromKeychain(authn.DefaultKeychain),
 		},
+		transport: remote.DefaultTransport,
+		keychain:  authn.DefaultKeychain,
+		redirects: true,
 	}
 }
 
-// GetImage fetches an image from a registry
-func (c *Client) GetImage(ctx context.Context, imageRef string) (v1.Image, error) {
+// UseDockerConfig reads registry credentials from the docker config file at
+// path, or the config.json in it if path is a directory, instead of the
+// default $DOCKER_CONFIG or ~/.docker/config.json
+func (c *Client) UseDockerConfig(path string) error {
+	keychain, err := loadConfigKeychain(path)
+	if err != nil {
+		return err
+	}
+
+	c.keychain = keychain
+	c.authOpts = append(c.authOpts, remote.WithAuthFromKeychain(keychain))
+	c.blobClient = nil
+	return nil
+}
+
+// UseAnonymous makes the client send no registry credentials at all, for
+// manifest and blob requests alike, skipping the docker config and its
+// credential helpers
+func (c *Client) UseAnonymous() {
+	c.keychain = anonymousKeychain{}
+	c.authOpts = append(c.authOpts, remote.WithAuthFromKeychain(c.keychain))
+	c.blobClient = nil
+}
+
+// LimitBandwidth caps how fast the client downloads from registries, shared
+// across manifest, layer, and blob range requests
+func (c *Client) LimitBandwidth(bytesPerSec int64) {
+	c.transport = ratelimit.NewTransport(c.transport, bytesPerSec)
+	c.authOpts = append(c.authOpts, remote.WithTransport(c.transport))
+	c.blobClient = nil
+}
+
+// UseCircuitBreaker stops the client sending requests to a registry or blob
+// host once threshold of them failed in a row, failing them fast for
+// cooldown before trying the host again
+func (c *Client) UseCircuitBreaker(threshold int, cooldown time.Duration) {
+	c.transport = remoteio.NewBreakerTransport(c.transport, threshold, cooldown)
+	c.authOpts = append(c.authOpts, remote.WithTransport(c.transport))
+	c.blobClient = nil
+}
+
+// CountFetched counts the bytes the client downloads from registries into
+// m, across manifest, layer, and blob range requests
+func (c *Client) CountFetched(m *metrics.Metrics) {
+	c.transport = metrics.NewTransport(c.transport, m)
+	c.authOpts = append(c.authOpts, remote.WithTransport(c.transport))
+	c.blobClient = nil
+}
+
+// SetUserAgent sets the User-Agent sent with every registry request,
+// including blob range requests, so they show up alike in server logs
+func (c *Client) SetUserAgent(ua string) {
+	c.userAgent = ua
+	c.authOpts = append(c.authOpts, remote.WithUserAgent(ua))
+	c.blobClient = nil
+}
+
+// SetFollowRedirects sets whether blob requests follow redirects. Followed
+// redirects are sent without registry credentials, which signed CDN URLs
+// reject.
+func (c *Client) SetFollowRedirects(follow bool) {
+	c.redirects = follow
+	c.blobClient = nil
+}
+
+// SelectManifest sets how an image is picked when a reference points at an
+// index holding several manifests. The zero selector picks the default
+// platform, linux/amd64.
+func (c *Client) SelectManifest(selector ManifestSelector) {
+	c.selector = selector
+}
+
+// Selector returns how the client picks an image out of an index
+func (c *Client) Selector() ManifestSelector {
+	return c.selector
+}
+
+// MapRepositories makes the client fetch images in the repositories m maps
+// from their destinations, as if they had been referenced there
+func (c *Client) MapRepositories(m RepositoryMap) {
+	c.repoMap = m
+}
+
+// parseReference parses a registry reference, relocated by the client's
+// repository map
+func (c *Client) parseReference(imageRef string) (name.Reference, error) {
 	ref, err := name.ParseReference(imageRef)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse image reference %s: %w", imageRef, err)
 	}
+	return c.repoMap.Map(ref)
+}
+
+// RemoteOptions returns the go-containerregistry options the client uses, for
+// packages that make registry requests of their own
+func (c *Client) RemoteOptions() []remote.Option {
+	return slices.Clone(c.authOpts)
+}
+
+// UseContainerd makes the client read images from a containerd content
+// store instead of a registry
+func (c *Client) UseContainerd(address, namespace string) {
+	c.containerd = &containerdSource{
+		address:   address,
+		namespace: namespace,
+	}
+}
+
+// Close releases the connection to containerd, if UseContainerd opened one
+func (c *Client) Close() error {
+	if c.containerd == nil {
+		return nil
+	}
+	return c.containerd.close()
+}
+
+// IsLocalSource reports whether imageRef is read from local storage (an OCI
+// layout or containerd) rather than a registry. Local layers have no blob URL.
+func (c *Client) IsLocalSource(imageRef string) bool {
+	return c.containerd != nil || IsLayoutReference(imageRef)
+}
+
+// GetImage fetches an image from a registry, from a local OCI layout when
+// the reference uses the oci: transport, or from containerd when configured
+func (c *Client) GetImage(ctx context.Context, imageRef string) (v1.Image, error) {
+	c.blobClient = nil
+
+	if c.IsLocalSource(imageRef) {
+		c.imageRef = imageRef
+		c.ref = nil
+		if IsLayoutReference(imageRef) {
+			return getLayoutImage(imageRef, c.selector)
+		}
+		if !c.selector.IsZero() {
+			return nil, fmt.Errorf("selecting a manifest is not supported for containerd images")
+		}
+		return c.containerd.getImage(ctx, imageRef)
+	}
+
+	ref, err := c.parseReference(imageRef)
+	if err != nil {
+		return nil, err
+	}
 
 	// Store the reference for later use
 	c.imageRef = imageRef
 	c.ref = ref
 
-	img, err := remote.Image(ref, c.authOpts...)
+	img, err := c.remoteImage(ctx, ref)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch image %s: %w", imageRef, err)
 	}
 
+	// Pin the digest so later lookups see the same image even if the tag moves
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get digest of %s: %w", imageRef, err)
+	}
+	c.pinned = ref.Context().Digest(digest.String())
+
 	return img, nil
 }
 
-// GetLayers returns all layers from an image
+// UseReference makes the client read the blobs of the registry image
+// imageRef, as after GetImage, without fetching its manifest again, e.g. for
+// a layer whose URL was recorded earlier
+func (c *Client) UseReference(imageRef string) error {
+	ref, err := c.parseReference(imageRef)
+	if err != nil {
+		return err
+	}
+
+	c.blobClient = nil
+	c.imageRef = imageRef
+	c.ref = ref
+	return nil
+}
+
+// remoteOptions returns the client's options bound to ctx, so cancelling it
+// aborts the requests made with them, including those for the layers of an
+// image fetched with them
+func (c *Client) remoteOptions(ctx context.Context) []remote.Option {
+	return append(slices.Clone(c.authOpts), remote.WithContext(ctx))
+}
+
+// remoteImage fetches the image ref points to, picking it with the client's
+// selector when ref is an index
+func (c *Client) remoteImage(ctx context.Context, ref name.Reference) (v1.Image, error) {
+	opts := c.remoteOptions(ctx)
+
+	if len(c.selector.Annotations) == 0 {
+		if c.selector.Platform != nil {
+			opts = append(opts, remote.WithPlatform(*c.selector.Platform))
+		}
+		return getRemoteImage(ref, opts)
+	}
+
+	// remote.Image only selects by platform, so walk the index ourselves
+	idx, err := remote.Index(ref, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("selecting by annotation requires an index: %w", err)
+	}
+
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index manifest: %w", err)
+	}
+
+	desc, err := c.selector.Select(manifest.Manifests)
+	if err != nil {
+		return nil, err
+	}
+
+	return getRemoteImage(ref.Context().Digest(desc.Digest.String()), opts)
+}
+
+// getRemoteImage does what remote.Image does, but checks the media type of
+// the manifest it fetches first, so a schema1 manifest fails with ErrSchema1
+// rather than an unsupported media type error
+func getRemoteImage(ref name.Reference, opts []remote.Option) (v1.Image, error) {
+	desc, err := remote.Get(ref, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if desc.MediaType == types.DockerManifestSchema1 || desc.MediaType == types.DockerManifestSchema1Signed {
+		return nil, fmt.Errorf("%s is a %s manifest: %w", ref, desc.MediaType, ErrSchema1)
+	}
+	return desc.Image()
+}
+
+// Resolve returns the digest a registry reference points to without fetching
+// any layers. With a manifest selector set, an index is resolved to the
+// selected image's manifest; otherwise the digest of the top-level manifest
+// is returned.
+func (c *Client) Resolve(ctx context.Context, imageRef string) (v1.Hash, error) {
+	if c.IsLocalSource(imageRef) {
+		return v1.Hash{}, fmt.Errorf("%s is not a registry reference", imageRef)
+	}
+
+	ref, err := c.parseReference(imageRef)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	if !c.selector.IsZero() {
+		img, err := c.remoteImage(ctx, ref)
+		if err != nil {
+			return v1.Hash{}, fmt.Errorf("failed to fetch image %s for %s: %w", imageRef, c.selector, err)
+		}
+		return img.Digest()
+	}
+
+	// HEAD is enough to learn the digest, but not every registry answers it
+	opts := c.remoteOptions(ctx)
+	if desc, err := remote.Head(ref, opts...); err == nil {
+		return desc.Digest, nil
+	}
+
+	desc, err := remote.Get(ref, opts...)
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("failed to fetch manifest for %s: %w", imageRef, err)
+	}
+	return desc.Digest, nil
+}
+
+// Platforms returns the platforms of the images in the index a registry
+// reference points to, in index order. Entries without a platform, or for
+// unknown/unknown like build attestations, are left out. A reference to a
+// single image fails with ErrNotIndex.
+func (c *Client) Platforms(ctx context.Context, imageRef string) ([]v1.Platform, error) {
+	if c.IsLocalSource(imageRef) {
+		return nil, fmt.Errorf("listing platforms is only supported for registry images")
+	}
+
+	ref, err := c.parseReference(imageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := remote.Get(ref, c.remoteOptions(ctx)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest for %s: %w", imageRef, err)
+	}
+	if !desc.MediaType.IsIndex() {
+		return nil, fmt.Errorf("%s is %w", imageRef, ErrNotIndex)
+	}
+	idx, err := desc.ImageIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch index %s: %w", imageRef, err)
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index manifest: %w", err)
+	}
+
+	var platforms []v1.Platform
+	for _, desc := range manifest.Manifests {
+		if desc.Platform == nil || desc.Platform.OS == "unknown" {
+			continue
+		}
+		if !slices.ContainsFunc(platforms, func(p v1.Platform) bool { return p.Equals(*desc.Platform) }) {
+			platforms = append(platforms, *desc.Platform)
+		}
+	}
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("%s lists no platform images", imageRef)
+	}
+	return platforms, nil
+}
+
+// Reference returns the registry reference parsed by the last GetImage call,
+// or nil for local sources
+func (c *Client) Reference() name.Reference {
+	return c.ref
+}
+
+// PinnedReference returns the digest reference that the last GetImage call
+// resolved the image reference to
+func (c *Client) PinnedReference() (name.Digest, error) {
+	if c.ref == nil {
+		return name.Digest{}, fmt.Errorf("no image reference available - call GetImage first")
+	}
+
+	return c.pinned, nil
+}
+
+// OpenLocalBlob opens a layer blob from the local OCI layout or containerd
+// content store for random access, since local layers have no blob URL
+func (c *Client) OpenLocalBlob(ctx context.Context, h v1.Hash, size int64) (remoteio.BlobReader, error) {
+	if c.containerd != nil {
+		return c.containerd.openBlob(ctx, h, size)
+	}
+
+	if !IsLayoutReference(c.imageRef) {
+		return nil, fmt.Errorf("%s is not a local image", c.imageRef)
+	}
+
+	path, err := layoutBlobPath(c.imageRef, h)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := remoteio.OpenLocalFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// GetLayers returns all layers from an image in the order they're applied
+// in, bottom layer first, so the last layer holding a path is the one whose
+// version of it the image has
 func (c *Client) GetLayers(ctx context.Context, imageRef string) ([]v1.Layer, error) {
 	img, err := c.GetImage(ctx, imageRef)
 	if err != nil {
@@ -57,7 +420,40 @@ func (c *Client) GetLayers(ctx context.Context, imageRef string) ([]v1.Layer, er
 		return nil, fmt.Errorf("failed to get layers: %w", err)
 	}
 
-	return layers, nil
+	return manifestOrder(img, layers)
+}
+
+// manifestOrder returns layers, as img.Layers() gave them, in the order of
+// img's manifest, which is what defines the order layers are applied in.
+// go-containerregistry's images list their layers that way already, but
+// nothing in the v1.Image interface promises it.
+func manifestOrder(img v1.Image, layers []v1.Layer) ([]v1.Layer, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+	if len(manifest.Layers) != len(layers) {
+		return nil, fmt.Errorf("image has %d layers but its manifest lists %d", len(layers), len(manifest.Layers))
+	}
+
+	byDigest := make(map[v1.Hash]v1.Layer, len(layers))
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer digest: %w", err)
+		}
+		byDigest[digest] = layer
+	}
+
+	ordered := make([]v1.Layer, len(manifest.Layers))
+	for i, desc := range manifest.Layers {
+		layer, ok := byDigest[desc.Digest]
+		if !ok {
+			return nil, fmt.Errorf("layer %s of the manifest is not in the image", desc.Digest)
+		}
+		ordered[i] = layer
+	}
+	return ordered, nil
 }
 
 // GetLayerURL returns the direct URL for a layer blob
@@ -71,35 +467,113 @@ func (c *Client) GetLayerURL(layer v1.Layer) (string, error) {
 		return "", fmt.Errorf("no image reference available - call GetImage first")
 	}
 
-	// Construct the blob URL: registry/v2/repository/blobs/digest
+	// Construct the blob URL: registry/v2/repository/blobs/digest, over
+	// plain HTTP for the registries go-containerregistry treats as insecure
 	repo := c.ref.Context()
-	registry := repo.Registry.Name()
-	repoName := repo.RepositoryStr()
+
+	blobURL := fmt.Sprintf("%s://%s/v2/%s/blobs/%s", repo.Scheme(), c.blobHost(), repo.RepositoryStr(), digest.String())
+	return blobURL, nil
+}
+
+// blobHost returns the registry host that blob URLs point at
+func (c *Client) blobHost() string {
+	registry := c.ref.Context().Registry.Name()
 
 	// For Docker Hub, use registry-1.docker.io
 	if registry == "index.docker.io" {
 		registry = "registry-1.docker.io"
 	}
 
-	blobURL := fmt.Sprintf("https://%s/v2/%s/blobs/%s", registry, repoName, digest.String())
-	return blobURL, nil
+	return registry
+}
+
+// baseTransport returns the transport registry requests are sent through,
+// before authentication is added. It sends the same User-Agent as
+// remote.WithUserAgent does for go-containerregistry's requests.
+func (c *Client) baseTransport() http.RoundTripper {
+	if c.userAgent == "" {
+		return c.transport
+	}
+	return transport.NewUserAgent(c.transport, c.userAgent)
+}
+
+// BlobHTTPClient returns an HTTP client for fetching blob URLs that attaches
+// the same credentials (including bearer tokens) used for the manifest fetch.
+// Tokens are scoped to pulling the image's repository, as registries such as
+// Harbor and Artifactory require, and a 401 challenge naming another scope
+// fetches a token for it too. GetImage drops the client, so the scope always
+// names the repository of the last image fetched, after repository mapping,
+// as go-containerregistry's own token for its manifest does. It is safe to
+// call concurrently.
+func (c *Client) BlobHTTPClient(ctx context.Context) (*http.Client, error) {
+	c.blobMu.Lock()
+	defer c.blobMu.Unlock()
+
+	if c.blobClient != nil {
+		return c.blobClient, nil
+	}
+
+	if c.ref == nil {
+		return nil, fmt.Errorf("no image reference available - call GetImage first")
+	}
+
+	repo := c.ref.Context()
+	auth, err := c.keychain.Resolve(repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve credentials for %s: %w", repo, err)
+	}
+
+	// Tokens are only attached to requests for the transport's registry, so
+	// it must match the host used in blob URLs
+	reg, err := name.NewRegistry(c.blobHost())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse registry %s: %w", c.blobHost(), err)
+	}
+
+	rt, err := transport.NewWithContext(ctx, reg, auth, c.baseTransport(), []string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate with %s: %w", reg, err)
+	}
+
+	c.blobClient = &http.Client{Transport: remoteio.NewRedirectTransport(rt, c.baseTransport(), c.redirects)}
+	return c.blobClient, nil
 }
 
 // LayerInfo contains metadata about a layer
 type LayerInfo struct {
-	Digest    v1.Hash
-	Size      int64
-	MediaType string
-	BlobURL   string // The direct URL to download the layer
+	Digest      v1.Hash
+	Size        int64
+	MediaType   string
+	BlobURL     string            // The direct URL to download the layer (empty for local layouts)
+	Annotations map[string]string // Annotations on the layer's descriptor, such as its TOC digest
 }
 
 // EnhancedLayerInfo contains a layer with its metadata and download URL
 type EnhancedLayerInfo struct {
-	Layer     v1.Layer
-	Digest    v1.Hash
-	Size      int64
-	MediaType string
-	BlobURL   string
+	Layer       v1.Layer
+	Digest      v1.Hash
+	Size        int64
+	MediaType   string
+	BlobURL     string
+	Annotations map[string]string
+}
+
+// EmptyJSONMediaType is the media type of the OCI empty descriptor, the
+// two-byte "{}" blob artifacts use in place of a layer or config
+const EmptyJSONMediaType = "application/vnd.oci.empty.v1+json"
+
+// emptyJSONDigest is the digest of "{}", the empty descriptor's content.
+// Some tools push it as a layer under a layer media type.
+var emptyJSONDigest = v1.Hash{
+	Algorithm: "sha256",
+	Hex:       "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+}
+
+// IsEmpty reports whether the layer holds no files: its blob is zero bytes,
+// or it's the empty descriptor, by media type or content. Such layers aren't
+// archives, so reading them as one fails.
+func (l *EnhancedLayerInfo) IsEmpty() bool {
+	return l.Size == 0 || l.MediaType == EmptyJSONMediaType || l.Digest == emptyJSONDigest
 }
 
 // GetLayerInfo returns metadata about a layer
@@ -119,20 +593,32 @@ func (c *Client) GetLayerInfo(layer v1.Layer) (*LayerInfo, error) {
 		return nil, fmt.Errorf("failed to get media type: %w", err)
 	}
 
-	blobURL, err := c.GetLayerURL(layer)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get blob URL: %w", err)
+	// Layers read from local storage have no blob URL
+	var blobURL string
+	if !c.IsLocalSource(c.imageRef) {
+		blobURL, err = c.GetLayerURL(layer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get blob URL: %w", err)
+		}
+	}
+
+	// Only descriptors from a manifest carry annotations
+	var annotations map[string]string
+	if desc, err := partial.Descriptor(layer); err == nil {
+		annotations = desc.Annotations
 	}
 
 	return &LayerInfo{
-		Digest:    digest,
-		Size:      size,
-		MediaType: string(mediaType),
-		BlobURL:   blobURL,
+		Digest:      digest,
+		Size:        size,
+		MediaType:   string(mediaType),
+		BlobURL:     blobURL,
+		Annotations: annotations,
 	}, nil
 }
 
-// GetEnhancedLayers returns all layers with their metadata and download URLs
+// GetEnhancedLayers returns all layers with their metadata and download URLs,
+// bottom layer first as GetLayers does
 func (c *Client) GetEnhancedLayers(ctx context.Context, imageRef string) ([]*EnhancedLayerInfo, error) {
 	layers, err := c.GetLayers(ctx, imageRef)
 	if err != nil {
@@ -147,11 +633,12 @@ func (c *Client) GetEnhancedLayers(ctx context.Context, imageRef string) ([]*Enh
 		}
 
 		enhancedLayers = append(enhancedLayers, &EnhancedLayerInfo{
-			Layer:     layer,
-			Digest:    info.Digest,
-			Size:      info.Size,
-			MediaType: info.MediaType,
-			BlobURL:   info.BlobURL,
+			Layer:       layer,
+			Digest:      info.Digest,
+			Size:        info.Size,
+			MediaType:   info.MediaType,
+			BlobURL:     info.BlobURL,
+			Annotations: info.Annotations,
 		})
 	}
 
@@ -0,0 +1,430 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	remoteio "github.com/amartani/oci-extract/internal/remote"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// pushRandomImage pushes a random image to ref and returns its digest string
+func pushRandomImage(t *testing.T, ref string) string {
+	t.Helper()
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+
+	tag, err := name.NewTag(ref)
+	if err != nil {
+		t.Fatalf("failed to parse tag %s: %v", ref, err)
+	}
+
+	if err := remote.Write(tag, img); err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("failed to get image digest: %v", err)
+	}
+	return digest.String()
+}
+
+// TestPinnedReference tests that the pinned digest survives the tag moving
+func TestPinnedReference(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	ref := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	first := pushRandomImage(t, ref)
+
+	client := NewClient()
+	if _, err := client.PinnedReference(); err == nil {
+		t.Error("PinnedReference() expected error before GetImage, got nil")
+	}
+
+	if _, err := client.GetImage(context.Background(), ref); err != nil {
+		t.Fatalf("GetImage() error = %v", err)
+	}
+
+	// Move the tag to a different image
+	pushRandomImage(t, ref)
+
+	pinned, err := client.PinnedReference()
+	if err != nil {
+		t.Fatalf("PinnedReference() error = %v", err)
+	}
+	if pinned.DigestStr() != first {
+		t.Errorf("PinnedReference() digest = %s, want %s", pinned.DigestStr(), first)
+	}
+	if pinned.Context().RepositoryStr() != "test/image" {
+		t.Errorf("PinnedReference() repository = %s, want test/image", pinned.Context().RepositoryStr())
+	}
+}
+
+// TestGetEnhancedLayersByDigest tests that an image named only by digest,
+// without a tag, is pinned to that digest and has fetchable blob URLs
+func TestGetEnhancedLayersByDigest(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	repo := strings.TrimPrefix(server.URL, "http://") + "/test/image"
+	digest := pushRandomImage(t, repo+":latest")
+
+	client := NewClient()
+	layers, err := client.GetEnhancedLayers(context.Background(), repo+"@"+digest)
+	if err != nil {
+		t.Fatalf("GetEnhancedLayers() error = %v", err)
+	}
+	if len(layers) != 1 {
+		t.Fatalf("GetEnhancedLayers() returned %d layers, want 1", len(layers))
+	}
+
+	if _, ok := client.Reference().(name.Digest); !ok {
+		t.Errorf("Reference() = %T, want name.Digest", client.Reference())
+	}
+	pinned, err := client.PinnedReference()
+	if err != nil {
+		t.Fatalf("PinnedReference() error = %v", err)
+	}
+	if pinned.DigestStr() != digest {
+		t.Errorf("PinnedReference() digest = %s, want %s", pinned.DigestStr(), digest)
+	}
+
+	blobClient, err := client.BlobHTTPClient(context.Background())
+	if err != nil {
+		t.Fatalf("BlobHTTPClient() error = %v", err)
+	}
+	resp, err := blobClient.Get(layers[0].BlobURL)
+	if err != nil {
+		t.Fatalf("blob request error = %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET %s status = %d, want 200", layers[0].BlobURL, resp.StatusCode)
+	}
+}
+
+// reversedImage lists its layers top first, against its manifest's order
+type reversedImage struct {
+	v1.Image
+}
+
+// Layers implements v1.Image
+func (img reversedImage) Layers() ([]v1.Layer, error) {
+	layers, err := img.Image.Layers()
+	slices.Reverse(layers)
+	return layers, err
+}
+
+// TestManifestOrder tests that layers are put in the order of the manifest
+// rather than the order img.Layers() gives them in
+func TestManifestOrder(t *testing.T) {
+	img, err := random.Image(64, 3)
+	if err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+	reversed := reversedImage{img}
+	layers, err := reversed.Layers()
+	if err != nil {
+		t.Fatalf("Layers() error = %v", err)
+	}
+
+	ordered, err := manifestOrder(reversed, layers)
+	if err != nil {
+		t.Fatalf("manifestOrder() error = %v", err)
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		t.Fatalf("Manifest() error = %v", err)
+	}
+	for i, layer := range ordered {
+		digest, err := layer.Digest()
+		if err != nil {
+			t.Fatalf("Digest() error = %v", err)
+		}
+		if digest != manifest.Layers[i].Digest {
+			t.Errorf("manifestOrder()[%d] = %s, want %s", i, digest, manifest.Layers[i].Digest)
+		}
+	}
+}
+
+// TestGetEnhancedLayersFromIndex tests that the layers of an image reached
+// through an index come bottom layer first, as listed in its manifest
+func TestGetEnhancedLayersFromIndex(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	img, err := random.Image(64, 3)
+	if err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+	idx := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{
+		Add: img,
+		Descriptor: v1.Descriptor{
+			Platform: &v1.Platform{OS: "linux", Architecture: "amd64"},
+		},
+	})
+
+	ref := strings.TrimPrefix(server.URL, "http://") + "/test/index:latest"
+	tag, err := name.NewTag(ref)
+	if err != nil {
+		t.Fatalf("failed to parse tag: %v", err)
+	}
+	if err := remote.WriteIndex(tag, idx); err != nil {
+		t.Fatalf("failed to push index: %v", err)
+	}
+
+	layers, err := NewClient().GetEnhancedLayers(context.Background(), ref)
+	if err != nil {
+		t.Fatalf("GetEnhancedLayers() error = %v", err)
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		t.Fatalf("Manifest() error = %v", err)
+	}
+	if len(layers) != len(manifest.Layers) {
+		t.Fatalf("GetEnhancedLayers() returned %d layers, want %d", len(layers), len(manifest.Layers))
+	}
+	for i, layer := range layers {
+		if layer.Digest != manifest.Layers[i].Digest {
+			t.Errorf("GetEnhancedLayers()[%d] = %s, want %s", i, layer.Digest, manifest.Layers[i].Digest)
+		}
+	}
+}
+
+// TestCancelledContext tests that registry requests are made with the
+// caller's context, so cancelling it aborts them
+func TestCancelledContext(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	ref := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	pushRandomImage(t, ref)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	client := NewClient()
+	if _, err := client.GetImage(ctx, ref); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetImage() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := client.Resolve(ctx, ref); !errors.Is(err, context.Canceled) {
+		t.Errorf("Resolve() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+// TestSetUserAgent tests that registry and blob requests send the same User-Agent
+func TestSetUserAgent(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		agents []string
+	)
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents = append(agents, r.UserAgent())
+		mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	ref := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	pushRandomImage(t, ref)
+
+	mu.Lock()
+	agents = nil
+	mu.Unlock()
+
+	client := NewClient()
+	client.SetUserAgent("oci-extract/test")
+	if _, err := client.GetImage(context.Background(), ref); err != nil {
+		t.Fatalf("GetImage() error = %v", err)
+	}
+
+	blobClient, err := client.BlobHTTPClient(context.Background())
+	if err != nil {
+		t.Fatalf("BlobHTTPClient() error = %v", err)
+	}
+	resp, err := blobClient.Get(server.URL + "/v2/")
+	if err != nil {
+		t.Fatalf("blob request error = %v", err)
+	}
+	_ = resp.Body.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(agents) == 0 {
+		t.Fatal("no requests recorded")
+	}
+	for _, agent := range agents {
+		if !strings.HasPrefix(agent, "oci-extract/test") {
+			t.Errorf("User-Agent = %q, want prefix oci-extract/test", agent)
+		}
+	}
+}
+
+// TestBlobHTTPClientScopedToken tests blob requests against a registry that,
+// like Harbor, only accepts bearer tokens scoped to the repository, including
+// after the client moves on to another repository
+func TestBlobHTTPClientScopedToken(t *testing.T) {
+	handler := registry.New()
+
+	// Images are pushed without auth, then read through the scoped server
+	open := httptest.NewServer(handler)
+	defer open.Close()
+	repos := []string{"test/image", "test/other"}
+	for _, repo := range repos {
+		pushRandomImage(t, strings.TrimPrefix(open.URL, "http://")+"/"+repo+":latest")
+	}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"token": "token-for-" + strings.Join(r.URL.Query()["scope"], ","),
+			})
+			return
+		}
+
+		// The ping needs any token, everything else one for the repository
+		// the request is for
+		auth := r.Header.Get("Authorization")
+		repo := strings.TrimPrefix(r.URL.Path, "/v2/")
+		for _, endpoint := range []string{"/blobs/", "/manifests/", "/referrers/", "/tags/"} {
+			repo, _, _ = strings.Cut(repo, endpoint)
+		}
+		scope := "repository:" + repo + ":pull"
+		if auth == "" || r.URL.Path != "/v2/" && !strings.Contains(auth, "token-for-"+scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="%s"`, server.URL, scope))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	for _, repo := range repos {
+		layers, err := client.GetEnhancedLayers(context.Background(), strings.TrimPrefix(server.URL, "http://")+"/"+repo+":latest")
+		if err != nil {
+			t.Fatalf("GetEnhancedLayers(%s) error = %v", repo, err)
+		}
+
+		resp, err := http.Head(layers[0].BlobURL)
+		if err != nil {
+			t.Fatalf("unauthenticated HEAD error = %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("unauthenticated HEAD status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+		}
+
+		blobClient, err := client.BlobHTTPClient(context.Background())
+		if err != nil {
+			t.Fatalf("BlobHTTPClient() error = %v", err)
+		}
+		probe, err := remoteio.ProbeBlob(context.Background(), layers[0].BlobURL, blobClient)
+		if err != nil {
+			t.Fatalf("ProbeBlob() in %s error = %v", repo, err)
+		}
+		if probe.Size != layers[0].Size {
+			t.Errorf("ProbeBlob() in %s size = %d, want %d", repo, probe.Size, layers[0].Size)
+		}
+	}
+}
+
+// TestUseAnonymous tests that an anonymous client sends no credentials to a
+// registry the docker config has some for
+func TestUseAnonymous(t *testing.T) {
+	handler := registry.New()
+	open := httptest.NewServer(handler)
+	defer open.Close()
+	pushRandomImage(t, strings.TrimPrefix(open.URL, "http://")+"/test/image:latest")
+
+	var mu sync.Mutex
+	var sent []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		sent = append(sent, auth)
+		mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	dir := t.TempDir()
+	config := `{"auths": {"` + host + `": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("user:pass")) + `"}}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	imageRef := host + "/test/image:latest"
+	if _, err := NewClient().GetEnhancedLayers(context.Background(), imageRef); err != nil {
+		t.Fatalf("GetEnhancedLayers() with the docker config error = %v", err)
+	}
+
+	mu.Lock()
+	sent = nil
+	mu.Unlock()
+
+	client := NewClient()
+	client.UseAnonymous()
+	if _, err := client.GetEnhancedLayers(context.Background(), imageRef); err == nil {
+		t.Error("GetEnhancedLayers() without credentials expected error, got nil")
+	}
+	if len(sent) != 0 {
+		t.Errorf("anonymous client sent credentials %v", sent)
+	}
+}
+
+// TestSchema1 tests that a schema1 manifest fails with ErrSchema1
+func TestSchema1(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/test/old/manifests/latest":
+			w.Header().Set("Content-Type", string(types.DockerManifestSchema1Signed))
+			_, _ = w.Write([]byte(`{"schemaVersion": 1, "name": "test/old", "tag": "latest", "fsLayers": []}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/test/old:latest"
+	if _, err := NewClient().GetImage(context.Background(), imageRef); !errors.Is(err, ErrSchema1) {
+		t.Errorf("GetImage() error = %v, want ErrSchema1", err)
+	}
+}
@@ -0,0 +1,227 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/platforms"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// containerdSource reads images from a containerd content store
+type containerdSource struct {
+	address   string
+	namespace string
+
+	mu     sync.Mutex
+	client *containerd.Client // nil until connected, or once closed
+}
+
+// connect lazily opens the containerd connection and returns it, with a
+// context scoped to the configured namespace
+func (s *containerdSource) connect(ctx context.Context) (context.Context, *containerd.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		client, err := containerd.New(s.address, containerd.WithDefaultNamespace(s.namespace))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to containerd at %s: %w", s.address, err)
+		}
+		s.client = client
+	}
+
+	return namespaces.WithNamespace(ctx, s.namespace), s.client, nil
+}
+
+// close closes the containerd connection, if one was opened. A later use
+// connects again.
+func (s *containerdSource) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	s.client = nil
+	return err
+}
+
+// getImage loads an image from the content store. The reference is looked up
+// as given and, failing that, in its normalized form (alpine -> docker.io/library/alpine:latest).
+func (s *containerdSource) getImage(ctx context.Context, imageRef string) (v1.Image, error) {
+	ctx, client, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	imageStore := client.ImageService()
+	img, err := imageStore.Get(ctx, imageRef)
+	if err != nil {
+		named, parseErr := docker.ParseDockerRef(imageRef)
+		if parseErr != nil {
+			return nil, fmt.Errorf("failed to find image %s in containerd namespace %s: %w", imageRef, s.namespace, err)
+		}
+		img, err = imageStore.Get(ctx, named.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to find image %s in containerd namespace %s: %w", imageRef, s.namespace, err)
+		}
+	}
+
+	store := client.ContentStore()
+	desc, err := resolvePlatformManifest(ctx, store, img.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	rawManifest, err := content.ReadBlob(ctx, store, desc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", desc.Digest, err)
+	}
+
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", desc.Digest, err)
+	}
+
+	rawConfig, err := content.ReadBlob(ctx, store, manifest.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image config %s: %w", manifest.Config.Digest, err)
+	}
+
+	return partial.CompressedToImage(&containerdImage{
+		ctx:         ctx,
+		store:       store,
+		mediaType:   types.MediaType(desc.MediaType),
+		rawManifest: rawManifest,
+		rawConfig:   rawConfig,
+		layers:      manifest.Layers,
+	})
+}
+
+// resolvePlatformManifest walks from an image target through any indexes to
+// the manifest for the host platform
+func resolvePlatformManifest(ctx context.Context, store content.Store, desc ocispec.Descriptor) (ocispec.Descriptor, error) {
+	matcher := platforms.Default()
+
+	for images.IsIndexType(desc.MediaType) {
+		data, err := content.ReadBlob(ctx, store, desc)
+		if err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("failed to read index %s: %w", desc.Digest, err)
+		}
+
+		var index ocispec.Index
+		if err := json.Unmarshal(data, &index); err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("failed to parse index %s: %w", desc.Digest, err)
+		}
+
+		found := false
+		for _, m := range index.Manifests {
+			if m.Platform == nil || matcher.Match(*m.Platform) {
+				desc = m
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ocispec.Descriptor{}, fmt.Errorf("no manifest for the host platform in index %s", desc.Digest)
+		}
+	}
+
+	return desc, nil
+}
+
+// containerdImage implements partial.CompressedImageCore over the content store
+type containerdImage struct {
+	ctx         context.Context
+	store       content.Store
+	mediaType   types.MediaType
+	rawManifest []byte
+	rawConfig   []byte
+	layers      []ocispec.Descriptor
+}
+
+func (i *containerdImage) RawConfigFile() ([]byte, error) {
+	return i.rawConfig, nil
+}
+
+func (i *containerdImage) MediaType() (types.MediaType, error) {
+	return i.mediaType, nil
+}
+
+func (i *containerdImage) RawManifest() ([]byte, error) {
+	return i.rawManifest, nil
+}
+
+func (i *containerdImage) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	for _, desc := range i.layers {
+		if desc.Digest.String() == h.String() {
+			return &containerdLayer{ctx: i.ctx, store: i.store, desc: desc}, nil
+		}
+	}
+	return nil, fmt.Errorf("layer %s not found in manifest", h)
+}
+
+// openBlob opens a blob in the content store for random access
+func (s *containerdSource) openBlob(ctx context.Context, h v1.Hash, size int64) (content.ReaderAt, error) {
+	ctx, client, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	desc := ocispec.Descriptor{
+		Digest: digest.Digest(h.String()),
+		Size:   size,
+	}
+
+	ra, err := client.ContentStore().ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob %s: %w", h, err)
+	}
+
+	return ra, nil
+}
+
+// containerdLayer implements partial.CompressedLayer over a content store blob
+type containerdLayer struct {
+	ctx   context.Context
+	store content.Store
+	desc  ocispec.Descriptor
+}
+
+func (l *containerdLayer) Digest() (v1.Hash, error) {
+	return v1.NewHash(l.desc.Digest.String())
+}
+
+func (l *containerdLayer) Compressed() (io.ReadCloser, error) {
+	ra, err := l.store.ReaderAt(l.ctx, l.desc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob %s: %w", l.desc.Digest, err)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{content.NewReader(ra), ra}, nil
+}
+
+func (l *containerdLayer) Size() (int64, error) {
+	return l.desc.Size, nil
+}
+
+func (l *containerdLayer) MediaType() (types.MediaType, error) {
+	return types.MediaType(l.desc.MediaType), nil
+}
@@ -0,0 +1,133 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/platforms"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// writeBlob stores data in the content store and returns its descriptor
+func writeBlob(t *testing.T, store content.Store, mediaType string, data []byte) ocispec.Descriptor {
+	t.Helper()
+
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	if err := content.WriteBlob(context.Background(), store, desc.Digest.String(), bytes.NewReader(data), desc); err != nil {
+		t.Fatalf("failed to write blob: %v", err)
+	}
+	return desc
+}
+
+// marshalJSON encodes v or fails the test
+func marshalJSON(t *testing.T, v any) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	return data
+}
+
+func TestContainerdImageAdapter(t *testing.T) {
+	ctx := context.Background()
+	store, err := local.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create content store: %v", err)
+	}
+
+	layerData := []byte("layer contents")
+	layerDesc := writeBlob(t, store, ocispec.MediaTypeImageLayerGzip, layerData)
+	configDesc := writeBlob(t, store, ocispec.MediaTypeImageConfig, []byte(`{"rootfs":{"type":"layers","diff_ids":[]}}`))
+
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	}
+	manifest.SchemaVersion = 2
+	manifestDesc := writeBlob(t, store, ocispec.MediaTypeImageManifest, marshalJSON(t, manifest))
+
+	// Wrap the manifest in an index for the host platform
+	host := platforms.DefaultSpec()
+	manifestDesc.Platform = &host
+	index := ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifestDesc},
+	}
+	index.SchemaVersion = 2
+	indexDesc := writeBlob(t, store, ocispec.MediaTypeImageIndex, marshalJSON(t, index))
+
+	desc, err := resolvePlatformManifest(ctx, store, indexDesc)
+	if err != nil {
+		t.Fatalf("resolvePlatformManifest() error = %v", err)
+	}
+	if desc.Digest != manifestDesc.Digest {
+		t.Fatalf("resolvePlatformManifest() = %s, want %s", desc.Digest, manifestDesc.Digest)
+	}
+
+	rawManifest, err := content.ReadBlob(ctx, store, desc)
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+
+	img, err := partial.CompressedToImage(&containerdImage{
+		ctx:         ctx,
+		store:       store,
+		mediaType:   types.OCIManifestSchema1,
+		rawManifest: rawManifest,
+		layers:      manifest.Layers,
+	})
+	if err != nil {
+		t.Fatalf("CompressedToImage() error = %v", err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatalf("Layers() error = %v", err)
+	}
+	if len(layers) != 1 {
+		t.Fatalf("Layers() got %d layers, want 1", len(layers))
+	}
+
+	rc, err := layers[0].Compressed()
+	if err != nil {
+		t.Fatalf("Compressed() error = %v", err)
+	}
+	defer func() { _ = rc.Close() }()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("failed to read layer: %v", err)
+	}
+	if !bytes.Equal(data, layerData) {
+		t.Errorf("Compressed() returned %q, want %q", data, layerData)
+	}
+}
+
+// TestClientCloseUnconnected tests that closing a client that never
+// connected to containerd, or never used it, succeeds
+func TestClientCloseUnconnected(t *testing.T) {
+	c := NewClient()
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() without containerd error = %v", err)
+	}
+
+	c.UseContainerd("/run/containerd/containerd.sock", "default")
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() before connecting error = %v", err)
+	}
+}
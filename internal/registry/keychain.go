@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// configKeychain resolves credentials from a single docker config file, the
+// way authn.DefaultKeychain does for the one in $DOCKER_CONFIG or ~/.docker
+type configKeychain struct {
+	cf *configfile.ConfigFile
+}
+
+// loadConfigKeychain reads the docker config file at path, or the
+// config.json in it when path is a directory like $DOCKER_CONFIG
+func loadConfigKeychain(path string) (authn.Keychain, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, config.ConfigFileName)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open docker config: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	cf, err := config.LoadFromReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load docker config %s: %w", path, err)
+	}
+	return &configKeychain{cf: cf}, nil
+}
+
+// Resolve looks up credentials for the repository, then for its registry,
+// falling back to anonymous access when the config has neither
+func (k *configKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	var cfg, empty types.AuthConfig
+	for _, key := range []string{target.String(), target.RegistryStr()} {
+		// Docker Hub credentials are stored under their legacy key
+		if key == name.DefaultRegistry {
+			key = authn.DefaultAuthKey
+		}
+
+		var err error
+		cfg, err = k.cf.GetAuthConfig(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get credentials for %s: %w", key, err)
+		}
+
+		// GetAuthConfig fills in the address even when nothing was found
+		cfg.ServerAddress = ""
+		if cfg != empty {
+			break
+		}
+	}
+
+	if cfg == empty {
+		return authn.Anonymous, nil
+	}
+
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		Auth:          cfg.Auth,
+		IdentityToken: cfg.IdentityToken,
+		RegistryToken: cfg.RegistryToken,
+	}), nil
+}
+
+// anonymousKeychain resolves every registry to anonymous access, without
+// reading any docker config or running credential helpers
+type anonymousKeychain struct{}
+
+// Resolve returns anonymous access for any target
+func (anonymousKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	return authn.Anonymous, nil
+}
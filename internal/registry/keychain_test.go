@@ -0,0 +1,56 @@
+package registry
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestConfigKeychain(t *testing.T) {
+	dir := t.TempDir()
+	auth := base64.StdEncoding.EncodeToString([]byte("ci-user:ci-pass"))
+	config := `{"auths": {"registry.example.com": {"auth": "` + auth + `"}}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	// Both the file and its directory, as in $DOCKER_CONFIG, are accepted
+	for _, path := range []string{filepath.Join(dir, "config.json"), dir} {
+		keychain, err := loadConfigKeychain(path)
+		if err != nil {
+			t.Fatalf("loadConfigKeychain(%s) error = %v", path, err)
+		}
+
+		repo, err := name.NewRepository("registry.example.com/myorg/myimage")
+		if err != nil {
+			t.Fatalf("failed to parse repository: %v", err)
+		}
+		authenticator, err := keychain.Resolve(repo)
+		if err != nil {
+			t.Fatalf("Resolve() error = %v", err)
+		}
+		cfg, err := authenticator.Authorization()
+		if err != nil {
+			t.Fatalf("Authorization() error = %v", err)
+		}
+		if cfg.Username != "ci-user" || cfg.Password != "ci-pass" {
+			t.Errorf("Resolve() credentials = %s:%s, want ci-user:ci-pass", cfg.Username, cfg.Password)
+		}
+
+		other, err := name.NewRegistry("ghcr.io")
+		if err != nil {
+			t.Fatalf("failed to parse registry: %v", err)
+		}
+		if authenticator, err := keychain.Resolve(other); err != nil || authenticator != authn.Anonymous {
+			t.Errorf("Resolve(ghcr.io) = %v, %v, want anonymous", authenticator, err)
+		}
+	}
+
+	if _, err := loadConfigKeychain(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("loadConfigKeychain() expected error for a missing file, got nil")
+	}
+}
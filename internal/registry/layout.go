@@ -0,0 +1,125 @@
+package registry
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+)
+
+const (
+	// layoutTransportPrefix is the skopeo transport prefix for OCI layout directories
+	layoutTransportPrefix = "oci:"
+
+	// refNameAnnotation is the index annotation skopeo uses to record an image's tag
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+// defaultPlatform matches the platform remote.Image resolves indexes to
+var defaultPlatform = v1.Platform{
+	Architecture: "amd64",
+	OS:           "linux",
+}
+
+// IsLayoutReference reports whether an image reference uses the oci: transport
+func IsLayoutReference(imageRef string) bool {
+	return strings.HasPrefix(imageRef, layoutTransportPrefix)
+}
+
+// parseLayoutReference splits an oci:<path>[:<tag>] reference into its
+// layout directory and tag. The tag is empty when none was given.
+func parseLayoutReference(imageRef string) (string, string, error) {
+	ref := strings.TrimPrefix(imageRef, layoutTransportPrefix)
+
+	path, tag, _ := strings.Cut(ref, ":")
+	if path == "" {
+		return "", "", fmt.Errorf("invalid oci layout reference %s: missing path", imageRef)
+	}
+
+	return path, tag, nil
+}
+
+// getLayoutImage loads an image from an OCI layout directory such as the
+// ones produced by `skopeo copy ... oci:<path>:<tag>`. Without a tag, a
+// selector with annotations picks among the layout's manifests.
+func getLayoutImage(imageRef string, selector ManifestSelector) (v1.Image, error) {
+	path, tag, err := parseLayoutReference(imageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open oci layout %s: %w", path, err)
+	}
+
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oci layout index: %w", err)
+	}
+
+	var desc v1.Descriptor
+	if tag == "" && len(selector.Annotations) > 0 {
+		desc, err = ManifestSelector{Annotations: selector.Annotations}.Select(manifest.Manifests)
+
+		// The annotations picked the entry, only the platform is left for its index
+		selector.Annotations = nil
+	} else {
+		desc, err = selectLayoutManifest(manifest.Manifests, tag)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", imageRef, err)
+	}
+
+	// A tag may point at a multi-platform index rather than an image
+	if desc.MediaType.IsIndex() {
+		child, err := idx.ImageIndex(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image index %s: %w", desc.Digest, err)
+		}
+
+		childManifest, err := child.IndexManifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image index %s: %w", desc.Digest, err)
+		}
+
+		m, err := selector.Select(childManifest.Manifests)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select image in index %s: %w", desc.Digest, err)
+		}
+		return child.Image(m.Digest)
+	}
+
+	return idx.Image(desc.Digest)
+}
+
+// selectLayoutManifest picks the index entry annotated with the given tag.
+// With no tag, the layout must contain exactly one manifest.
+func selectLayoutManifest(manifests []v1.Descriptor, tag string) (v1.Descriptor, error) {
+	if tag == "" {
+		if len(manifests) != 1 {
+			return v1.Descriptor{}, fmt.Errorf("layout contains %d manifests, specify a tag", len(manifests))
+		}
+		return manifests[0], nil
+	}
+
+	for _, desc := range manifests {
+		if desc.Annotations[refNameAnnotation] == tag {
+			return desc, nil
+		}
+	}
+
+	return v1.Descriptor{}, fmt.Errorf("tag %s not found in layout", tag)
+}
+
+// layoutBlobPath returns where the layout referenced by imageRef stores a blob
+func layoutBlobPath(imageRef string, digest v1.Hash) (string, error) {
+	path, _, err := parseLayoutReference(imageRef)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(path, "blobs", digest.Algorithm, digest.Hex), nil
+}
@@ -0,0 +1,181 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+// writeTestLayout creates an OCI layout containing one random image per tag
+func writeTestLayout(t *testing.T, tags ...string) (string, map[string]v1.Hash) {
+	t.Helper()
+
+	dir := t.TempDir()
+	p, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		t.Fatalf("failed to create layout: %v", err)
+	}
+
+	digests := make(map[string]v1.Hash)
+	for _, tag := range tags {
+		img, err := random.Image(64, 2)
+		if err != nil {
+			t.Fatalf("failed to create image: %v", err)
+		}
+
+		if err := p.AppendImage(img, layout.WithAnnotations(map[string]string{refNameAnnotation: tag})); err != nil {
+			t.Fatalf("failed to append image: %v", err)
+		}
+
+		digest, err := img.Digest()
+		if err != nil {
+			t.Fatalf("failed to get image digest: %v", err)
+		}
+		digests[tag] = digest
+	}
+
+	return dir, digests
+}
+
+func TestParseLayoutReference(t *testing.T) {
+	tests := []struct {
+		ref     string
+		path    string
+		tag     string
+		wantErr bool
+	}{
+		{ref: "oci:/tmp/layout:latest", path: "/tmp/layout", tag: "latest"},
+		{ref: "oci:/tmp/layout", path: "/tmp/layout"},
+		{ref: "oci:relative/dir:v1.0", path: "relative/dir", tag: "v1.0"},
+		{ref: "oci:", wantErr: true},
+		{ref: "oci::latest", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		path, tag, err := parseLayoutReference(tt.ref)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseLayoutReference(%q) expected error, got nil", tt.ref)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseLayoutReference(%q) error = %v", tt.ref, err)
+			continue
+		}
+		if path != tt.path || tag != tt.tag {
+			t.Errorf("parseLayoutReference(%q) = (%q, %q), want (%q, %q)", tt.ref, path, tag, tt.path, tt.tag)
+		}
+	}
+}
+
+func TestGetEnhancedLayersFromLayout(t *testing.T) {
+	dir, digests := writeTestLayout(t, "v1", "v2")
+
+	client := NewClient()
+	img, err := client.GetImage(context.Background(), "oci:"+dir+":v2")
+	if err != nil {
+		t.Fatalf("GetImage() error = %v", err)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("failed to get image digest: %v", err)
+	}
+	if digest != digests["v2"] {
+		t.Errorf("GetImage() selected %s, want %s", digest, digests["v2"])
+	}
+
+	layers, err := client.GetEnhancedLayers(context.Background(), "oci:"+dir+":v2")
+	if err != nil {
+		t.Fatalf("GetEnhancedLayers() error = %v", err)
+	}
+	if len(layers) != 2 {
+		t.Fatalf("GetEnhancedLayers() got %d layers, want 2", len(layers))
+	}
+	for _, layer := range layers {
+		if layer.BlobURL != "" {
+			t.Errorf("expected empty blob URL for layout layer, got %s", layer.BlobURL)
+		}
+	}
+}
+
+func TestGetImageFromLayoutErrors(t *testing.T) {
+	dir, _ := writeTestLayout(t, "v1", "v2")
+	client := NewClient()
+
+	if _, err := client.GetImage(context.Background(), "oci:"+dir+":missing"); err == nil {
+		t.Error("GetImage() expected error for unknown tag, got nil")
+	}
+
+	if _, err := client.GetImage(context.Background(), "oci:"+dir); err == nil {
+		t.Error("GetImage() expected error for untagged reference to multi-image layout, got nil")
+	}
+}
+
+// TestOpenLocalBlobFromLayout tests random access to layout layer blobs
+func TestOpenLocalBlobFromLayout(t *testing.T) {
+	dir, _ := writeTestLayout(t, "v1")
+
+	client := NewClient()
+	layers, err := client.GetEnhancedLayers(context.Background(), "oci:"+dir+":v1")
+	if err != nil {
+		t.Fatalf("GetEnhancedLayers() error = %v", err)
+	}
+
+	layer := layers[0]
+	reader, err := client.OpenLocalBlob(context.Background(), layer.Digest, layer.Size)
+	if err != nil {
+		t.Fatalf("OpenLocalBlob() error = %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	if reader.Size() != layer.Size {
+		t.Errorf("Size() = %d, want %d", reader.Size(), layer.Size)
+	}
+
+	rc, err := layer.Layer.Compressed()
+	if err != nil {
+		t.Fatalf("failed to read layer: %v", err)
+	}
+	defer func() { _ = rc.Close() }()
+	want, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("failed to read layer: %v", err)
+	}
+
+	got := make([]byte, 16)
+	if _, err := reader.ReadAt(got, 8); err != nil {
+		t.Fatalf("ReadAt() error = %v", err)
+	}
+	if !bytes.Equal(got, want[8:24]) {
+		t.Errorf("ReadAt() = %x, want %x", got, want[8:24])
+	}
+}
+
+// TestGetImageFromLayoutByAnnotation tests picking an untagged layout's image
+// with a manifest selector
+func TestGetImageFromLayoutByAnnotation(t *testing.T) {
+	dir, digests := writeTestLayout(t, "v1", "v2")
+
+	client := NewClient()
+	client.SelectManifest(ManifestSelector{Annotations: map[string]string{refNameAnnotation: "v1"}})
+	img, err := client.GetImage(context.Background(), "oci:"+dir)
+	if err != nil {
+		t.Fatalf("GetImage() error = %v", err)
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("failed to get image digest: %v", err)
+	}
+	if digest != digests["v1"] {
+		t.Errorf("GetImage() selected %s, want %s", digest, digests["v1"])
+	}
+}
@@ -0,0 +1,168 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	remoteio "github.com/amartani/oci-extract/internal/remote"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// PingReport describes how a registry responded to the checks made by Ping
+type PingReport struct {
+	// Registry is the host whose /v2/ endpoint was checked
+	Registry string
+
+	// V2Status is the status of an anonymous GET /v2/: 200 for registries
+	// open to anyone, 401 when they require authentication
+	V2Status int
+
+	// Anonymous is whether no credentials were found for the registry, so
+	// authentication used an anonymous token
+	Anonymous bool
+
+	// AuthErr is why authenticating with the registry failed, nil on success
+	AuthErr error
+
+	// Image is the pinged image reference, empty when only a registry was given
+	Image string
+
+	// ImageErr is why fetching the image's manifest failed, nil on success
+	ImageErr error
+
+	// Blob is the layer probed for range support, nil if none was probed
+	Blob *EnhancedLayerInfo
+
+	// BlobProbe is the outcome of the range support probe on Blob, nil when
+	// it failed with BlobErr
+	BlobProbe *remoteio.BlobProbe
+	BlobErr   error
+}
+
+// OK reports whether every check Ping made succeeded
+func (r *PingReport) OK() bool {
+	return r.AuthErr == nil && r.ImageErr == nil && r.BlobErr == nil
+}
+
+// Ping checks that target, a registry host such as ghcr.io or an image
+// reference, is reachable and accepts the configured credentials. For an
+// image it also fetches the manifest and probes its smallest layer for range
+// request support, which seekable extraction depends on. Failed checks are
+// recorded in the report; an error is only returned when the registry can't
+// be reached at all.
+func (c *Client) Ping(ctx context.Context, target string) (*PingReport, error) {
+	if c.IsLocalSource(target) {
+		return nil, fmt.Errorf("%s is not read from a registry", target)
+	}
+
+	reg, ref, err := parsePingTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	if ref != nil {
+		// Check the registry the image would be fetched from
+		if ref, err = c.repoMap.Map(ref); err != nil {
+			return nil, err
+		}
+		reg = ref.Context().Registry
+	}
+
+	report := &PingReport{Registry: reg.RegistryStr()}
+
+	report.V2Status, err = c.pingV2(ctx, reg)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		resource authn.Resource = reg
+		scopes   []string
+	)
+	if ref != nil {
+		resource = ref.Context()
+		scopes = []string{ref.Context().Scope(transport.PullScope)}
+	}
+
+	auth, err := c.keychain.Resolve(resource)
+	if err != nil {
+		report.AuthErr = fmt.Errorf("failed to resolve credentials for %s: %w", resource, err)
+		return report, nil
+	}
+	report.Anonymous = auth == authn.Anonymous
+
+	if _, err := transport.NewWithContext(ctx, reg, auth, c.baseTransport(), scopes); err != nil {
+		report.AuthErr = err
+		return report, nil
+	}
+
+	if ref == nil {
+		return report, nil
+	}
+
+	report.Image = target
+	layers, err := c.GetEnhancedLayers(ctx, target)
+	if err != nil {
+		report.ImageErr = err
+		return report, nil
+	}
+	if len(layers) == 0 {
+		return report, nil
+	}
+
+	// Probing is a HEAD request, but a small blob keeps a fallback cheap
+	report.Blob = layers[0]
+	for _, layer := range layers[1:] {
+		if layer.Size < report.Blob.Size {
+			report.Blob = layer
+		}
+	}
+
+	client, err := c.BlobHTTPClient(ctx)
+	if err != nil {
+		report.BlobErr = err
+		return report, nil
+	}
+	report.BlobProbe, report.BlobErr = remoteio.ProbeBlob(ctx, report.Blob.BlobURL, client)
+
+	return report, nil
+}
+
+// parsePingTarget splits target into the registry to check and, when it
+// names an image, its reference. Like docker, a target without a slash is a
+// registry only if it looks like a host: it contains a dot or a port, or is
+// localhost. Tags don't parse as ports, so alpine:3.19 is still an image.
+func parsePingTarget(target string) (name.Registry, name.Reference, error) {
+	if !strings.Contains(target, "/") && (strings.ContainsAny(target, ".:") || target == "localhost") {
+		if reg, err := name.NewRegistry(target, name.StrictValidation); err == nil {
+			return reg, nil, nil
+		}
+	}
+
+	ref, err := name.ParseReference(target)
+	if err != nil {
+		return name.Registry{}, nil, fmt.Errorf("failed to parse %s as a registry or image reference: %w", target, err)
+	}
+	return ref.Context().Registry, ref, nil
+}
+
+// pingV2 sends an anonymous GET to the registry's /v2/ endpoint and returns
+// the response status
+func (c *Client) pingV2(ctx context.Context, reg name.Registry) (int, error) {
+	url := fmt.Sprintf("%s://%s/v2/", reg.Scheme(), reg.RegistryStr())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := (&http.Client{Transport: c.baseTransport()}).Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to reach %s: %w", remoteio.ErrNetwork, url, err)
+	}
+	_ = resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,76 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+)
+
+func TestParsePingTarget(t *testing.T) {
+	tests := []struct {
+		target   string
+		registry string
+		isImage  bool
+	}{
+		{target: "ghcr.io", registry: "ghcr.io"},
+		{target: "localhost:5000", registry: "localhost:5000"},
+		{target: "localhost", registry: "localhost"},
+		{target: "alpine", registry: "index.docker.io", isImage: true},
+		{target: "alpine:3.19", registry: "index.docker.io", isImage: true},
+		{target: "ghcr.io/myorg/myimage:latest", registry: "ghcr.io", isImage: true},
+	}
+
+	for _, tt := range tests {
+		reg, ref, err := parsePingTarget(tt.target)
+		if err != nil {
+			t.Errorf("parsePingTarget(%q) error = %v", tt.target, err)
+			continue
+		}
+		if reg.RegistryStr() != tt.registry {
+			t.Errorf("parsePingTarget(%q) registry = %s, want %s", tt.target, reg.RegistryStr(), tt.registry)
+		}
+		if (ref != nil) != tt.isImage {
+			t.Errorf("parsePingTarget(%q) reference = %v, want image %v", tt.target, ref, tt.isImage)
+		}
+	}
+}
+
+// TestPingRegistry tests pinging an open registry without an image
+func TestPingRegistry(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	report, err := NewClient().Ping(context.Background(), host)
+	if err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+
+	if report.Registry != host {
+		t.Errorf("Registry = %s, want %s", report.Registry, host)
+	}
+	if report.V2Status != http.StatusOK {
+		t.Errorf("V2Status = %d, want %d", report.V2Status, http.StatusOK)
+	}
+	if !report.OK() {
+		t.Errorf("OK() = false, auth error: %v", report.AuthErr)
+	}
+	if report.Image != "" || report.Blob != nil {
+		t.Errorf("registry ping checked an image: %+v", report)
+	}
+}
+
+// TestPingUnreachable tests that a registry that can't be reached is an error
+func TestPingUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	host := strings.TrimPrefix(server.URL, "http://")
+	server.Close()
+
+	if _, err := NewClient().Ping(context.Background(), host); err == nil {
+		t.Error("Ping() expected error for a closed server, got nil")
+	}
+}
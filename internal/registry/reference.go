@@ -0,0 +1,60 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// WithTag returns imageRef with its tag (explicit or the implied latest)
+// replaced by tag. Digest references can't be retagged.
+func WithTag(imageRef, tag string) (string, error) {
+	if IsLayoutReference(imageRef) {
+		path, _, err := parseLayoutReference(imageRef)
+		if err != nil {
+			return "", err
+		}
+		return layoutTransportPrefix + path + ":" + tag, nil
+	}
+
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference %s: %w", imageRef, err)
+	}
+
+	current, ok := ref.(name.Tag)
+	if !ok {
+		return "", fmt.Errorf("cannot override the tag of digest reference %s", imageRef)
+	}
+
+	// An implied latest tag doesn't appear in imageRef, so there is nothing to trim
+	retagged := strings.TrimSuffix(imageRef, ":"+current.TagStr()) + ":" + tag
+	if _, err := name.NewTag(retagged); err != nil {
+		return "", fmt.Errorf("invalid tag %s: %w", tag, err)
+	}
+
+	return retagged, nil
+}
+
+// WithDigest returns imageRef pinned to digest, dropping any tag so the
+// result always names the same manifest
+func WithDigest(imageRef, digest string) (string, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference %s: %w", imageRef, err)
+	}
+
+	// Both forms may carry a tag: repo:tag and repo:tag@sha256:...
+	base, _, _ := strings.Cut(imageRef, "@")
+	if tag, err := name.NewTag(base); err == nil {
+		base = strings.TrimSuffix(base, ":"+tag.TagStr())
+	}
+
+	pinned := base + "@" + digest
+	if _, err := name.NewDigest(pinned); err != nil {
+		return "", fmt.Errorf("invalid digest %s for %s: %w", digest, ref.Context(), err)
+	}
+
+	return pinned, nil
+}
@@ -0,0 +1,74 @@
+package registry
+
+import "testing"
+
+func TestWithTag(t *testing.T) {
+	tests := []struct {
+		ref     string
+		tag     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "alpine", tag: "3.20", want: "alpine:3.20"},
+		{ref: "alpine:latest", tag: "3.20", want: "alpine:3.20"},
+		{ref: "localhost:5000/app", tag: "v2", want: "localhost:5000/app:v2"},
+		{ref: "ghcr.io/org/app:v1", tag: "v2", want: "ghcr.io/org/app:v2"},
+		{ref: "oci:/tmp/layout:v1", tag: "v2", want: "oci:/tmp/layout:v2"},
+		{ref: "oci:/tmp/layout", tag: "v2", want: "oci:/tmp/layout:v2"},
+		{ref: "alpine@sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", tag: "v2", wantErr: true},
+		{ref: "alpine", tag: "bad tag", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := WithTag(tt.ref, tt.tag)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("WithTag(%q, %q) expected error, got nil", tt.ref, tt.tag)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("WithTag(%q, %q) error = %v", tt.ref, tt.tag, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("WithTag(%q, %q) = %q, want %q", tt.ref, tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestWithDigest(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	other := "sha256:" + "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "alpine", want: "alpine@" + digest},
+		{ref: "alpine:3.20", want: "alpine@" + digest},
+		{ref: "localhost:5000/app", want: "localhost:5000/app@" + digest},
+		{ref: "localhost:5000/app:v1", want: "localhost:5000/app@" + digest},
+		{ref: "ghcr.io/org/app:v1@" + other, want: "ghcr.io/org/app@" + digest},
+		{ref: "alpine@" + other, want: "alpine@" + digest},
+		{ref: "Not A Reference", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := WithDigest(tt.ref, digest)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("WithDigest(%q) expected error, got nil", tt.ref)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("WithDigest(%q) error = %v", tt.ref, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("WithDigest(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// RepositoryMap relocates images: a reference to a repository under a mapped
+// source is fetched from the destination instead, keeping the rest of its
+// path, its tag and its digest. This lets images mirrored under a different
+// registry or path be read by their original names.
+type RepositoryMap []repositoryMapping
+
+// repositoryMapping maps one source prefix to its destination. Both are
+// normalized names: a registry, or a registry and repository path.
+type repositoryMapping struct {
+	from, to string
+}
+
+// ParseRepositoryMap parses src=dst pairs, as given to --repo-map, into a
+// RepositoryMap. Either side is a registry host, such as ghcr.io, or a
+// repository, such as ghcr.io/acme or alpine. When several sources match a
+// reference, the longest wins.
+func ParseRepositoryMap(pairs []string) (RepositoryMap, error) {
+	var m RepositoryMap
+	for _, pair := range pairs {
+		src, dst, ok := strings.Cut(pair, "=")
+		if !ok || src == "" || dst == "" {
+			return nil, fmt.Errorf("invalid mapping %q, expected src=dst", pair)
+		}
+
+		from, fromRegistry, err := repositoryPrefix(src)
+		if err != nil {
+			return nil, err
+		}
+		to, toRegistry, err := repositoryPrefix(dst)
+		if err != nil {
+			return nil, err
+		}
+		if toRegistry && !fromRegistry {
+			return nil, fmt.Errorf("invalid mapping %q, a repository must map to a repository", pair)
+		}
+		m = append(m, repositoryMapping{from: from, to: to})
+	}
+
+	slices.SortStableFunc(m, func(a, b repositoryMapping) int {
+		return len(b.from) - len(a.from)
+	})
+	return m, nil
+}
+
+// repositoryPrefix normalizes a registry host or repository into the form of
+// name.Repository.Name, e.g. docker.io/alpine to index.docker.io/library/alpine,
+// and reports whether it's a registry alone
+func repositoryPrefix(s string) (string, bool, error) {
+	// A registry alone looks like a host, as with ping targets
+	if !strings.Contains(s, "/") && (strings.ContainsAny(s, ".:") || s == "localhost") {
+		reg, err := name.NewRegistry(s, name.StrictValidation)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid registry %s: %w", s, err)
+		}
+		return reg.Name(), true, nil
+	}
+
+	repo, err := name.NewRepository(s)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid repository %s: %w", s, err)
+	}
+	return repo.Name(), false, nil
+}
+
+// Map returns ref relocated by the first mapping whose source contains its
+// repository, or ref unchanged when none does
+func (m RepositoryMap) Map(ref name.Reference) (name.Reference, error) {
+	repo := ref.Context().Name()
+
+	for _, mapping := range m {
+		rest, ok := strings.CutPrefix(repo, mapping.from)
+		if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+			continue
+		}
+
+		mapped, err := name.NewRepository(mapping.to + rest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map %s to %s: %w", repo, mapping.to, err)
+		}
+
+		switch r := ref.(type) {
+		case name.Digest:
+			return mapped.Digest(r.DigestStr()), nil
+		case name.Tag:
+			return mapped.Tag(r.TagStr()), nil
+		default:
+			return nil, fmt.Errorf("unsupported reference %s", ref)
+		}
+	}
+
+	return ref, nil
+}
@@ -0,0 +1,88 @@
+package registry
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+)
+
+func TestRepositoryMap(t *testing.T) {
+	m, err := ParseRepositoryMap([]string{
+		"docker.io=mirror.example.com/hub",
+		"ghcr.io/acme=registry.internal/acme",
+		"ghcr.io/acme/app=registry.internal/apps/app",
+	})
+	if err != nil {
+		t.Fatalf("ParseRepositoryMap() error = %v", err)
+	}
+
+	digest := "sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "alpine:3.19", want: "mirror.example.com/hub/library/alpine:3.19"},
+		{ref: "ghcr.io/acme/tool", want: "registry.internal/acme/tool:latest"},
+		{ref: "ghcr.io/acme/app:v1", want: "registry.internal/apps/app:v1"},
+		{ref: "ghcr.io/acme/app/sub@" + digest, want: "registry.internal/apps/app/sub@" + digest},
+		{ref: "ghcr.io/acme-other/app:v1", want: "ghcr.io/acme-other/app:v1"},
+		{ref: "quay.io/acme/app:v1", want: "quay.io/acme/app:v1"},
+	}
+
+	for _, tt := range tests {
+		ref, err := name.ParseReference(tt.ref)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", tt.ref, err)
+		}
+		got, err := m.Map(ref)
+		if err != nil {
+			t.Errorf("Map(%s) error = %v", tt.ref, err)
+			continue
+		}
+		if got.Name() != tt.want {
+			t.Errorf("Map(%s) = %s, want %s", tt.ref, got.Name(), tt.want)
+		}
+	}
+}
+
+func TestParseRepositoryMapInvalid(t *testing.T) {
+	for _, pair := range []string{
+		"ghcr.io",
+		"=mirror.example.com",
+		"ghcr.io/acme/app=mirror.example.com",
+		"ghcr.io/Acme=mirror.example.com/acme",
+	} {
+		if _, err := ParseRepositoryMap([]string{pair}); err == nil {
+			t.Errorf("ParseRepositoryMap(%q) expected error, got nil", pair)
+		}
+	}
+}
+
+// TestMapRepositories tests that an image is fetched, and its blob URLs
+// built, from where its repository is mapped
+func TestMapRepositories(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	pushRandomImage(t, host+"/mirror/app:v1")
+
+	m, err := ParseRepositoryMap([]string{"ghcr.io/acme/app=" + host + "/mirror/app"})
+	if err != nil {
+		t.Fatalf("ParseRepositoryMap() error = %v", err)
+	}
+	client := NewClient()
+	client.MapRepositories(m)
+
+	layers, err := client.GetEnhancedLayers(context.Background(), "ghcr.io/acme/app:v1")
+	if err != nil {
+		t.Fatalf("GetEnhancedLayers() error = %v", err)
+	}
+	if want := server.URL + "/v2/mirror/app/blobs/"; !strings.HasPrefix(layers[0].BlobURL, want) {
+		t.Errorf("BlobURL = %s, want prefix %s", layers[0].BlobURL, want)
+	}
+}
@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ManifestSelector picks an image out of an index that holds several
+// manifests, by platform and/or by the annotations on their descriptors
+type ManifestSelector struct {
+	Platform    *v1.Platform
+	Annotations map[string]string
+}
+
+// IsZero reports whether the selector has no criteria, in which case indexes
+// resolve to the default platform
+func (s ManifestSelector) IsZero() bool {
+	return s.Platform == nil && len(s.Annotations) == 0
+}
+
+// Matches reports whether desc satisfies every criterion of the selector.
+// Without any, only descriptors for the default platform match.
+func (s ManifestSelector) Matches(desc v1.Descriptor) bool {
+	platform := s.Platform
+	if s.IsZero() {
+		platform = &defaultPlatform
+	}
+	if platform != nil && (desc.Platform == nil || !desc.Platform.Satisfies(*platform)) {
+		return false
+	}
+
+	for key, value := range s.Annotations {
+		if got, ok := desc.Annotations[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns the first of manifests that the selector matches
+func (s ManifestSelector) Select(manifests []v1.Descriptor) (v1.Descriptor, error) {
+	for _, desc := range manifests {
+		if s.Matches(desc) {
+			return desc, nil
+		}
+	}
+
+	return v1.Descriptor{}, fmt.Errorf("no manifest matching %s", s)
+}
+
+// String describes the selector's criteria, e.g. for error messages
+func (s ManifestSelector) String() string {
+	if s.IsZero() {
+		return "platform " + defaultPlatform.String()
+	}
+
+	var criteria []string
+	if s.Platform != nil {
+		criteria = append(criteria, "platform "+s.Platform.String())
+	}
+	for _, key := range slices.Sorted(maps.Keys(s.Annotations)) {
+		criteria = append(criteria, fmt.Sprintf("annotation %s=%s", key, s.Annotations[key]))
+	}
+	return strings.Join(criteria, ", ")
+}
+
+// ParseAnnotations parses key=value pairs, as given to --manifest-annotation,
+// into an annotation map
+func ParseAnnotations(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	annotations := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid annotation %q, expected key=value", pair)
+		}
+		annotations[key] = value
+	}
+	return annotations, nil
+}
@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestManifestSelectorMatches(t *testing.T) {
+	amd64 := &v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := &v1.Platform{OS: "linux", Architecture: "arm64"}
+
+	slim := v1.Descriptor{Platform: amd64, Annotations: map[string]string{"variant": "slim"}}
+	full := v1.Descriptor{Platform: arm64, Annotations: map[string]string{"variant": "full"}}
+	bare := v1.Descriptor{Annotations: map[string]string{"variant": "slim"}}
+
+	tests := []struct {
+		name     string
+		selector ManifestSelector
+		desc     v1.Descriptor
+		want     bool
+	}{
+		{name: "default platform", desc: slim, want: true},
+		{name: "default platform mismatch", desc: full, want: false},
+		{name: "platform", selector: ManifestSelector{Platform: arm64}, desc: full, want: true},
+		{name: "annotation", selector: ManifestSelector{Annotations: map[string]string{"variant": "full"}}, desc: full, want: true},
+		{name: "annotation without platform", selector: ManifestSelector{Annotations: map[string]string{"variant": "slim"}}, desc: bare, want: true},
+		{name: "annotation mismatch", selector: ManifestSelector{Annotations: map[string]string{"variant": "full"}}, desc: slim, want: false},
+		{name: "platform and annotation", selector: ManifestSelector{Platform: arm64, Annotations: map[string]string{"variant": "slim"}}, desc: slim, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.selector.Matches(tt.desc); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAnnotations(t *testing.T) {
+	got, err := ParseAnnotations([]string{"org.opencontainers.image.ref.name=slim", "empty="})
+	if err != nil {
+		t.Fatalf("ParseAnnotations() error = %v", err)
+	}
+	if len(got) != 2 || got["org.opencontainers.image.ref.name"] != "slim" || got["empty"] != "" {
+		t.Errorf("ParseAnnotations() = %v", got)
+	}
+
+	for _, pair := range []string{"novalue", "=value"} {
+		if _, err := ParseAnnotations([]string{pair}); err == nil {
+			t.Errorf("ParseAnnotations(%q) expected error", pair)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned, along with ErrNetwork, for requests to a host
+// that kept failing, until its cooldown has passed
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerTransport is an http.RoundTripper that stops sending requests to a
+// host once threshold of them failed in a row, with a transport error or a
+// transient status, failing them fast with ErrCircuitOpen instead of adding
+// to a struggling registry's load. After cooldown a single request is let
+// through to probe the host; if it succeeds, the host is used again.
+type BreakerTransport struct {
+	base      http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostBreaker
+}
+
+// hostBreaker is the state of a BreakerTransport for one host
+type hostBreaker struct {
+	failures  int       // Failures in a row
+	openUntil time.Time // When a probe may be sent, once failures reached the threshold
+	probing   bool      // Whether the probe is in flight
+}
+
+// NewBreakerTransport wraps base with a circuit breaker per host, opened by
+// threshold failures in a row for cooldown at a time
+func NewBreakerTransport(base http.RoundTripper, threshold int, cooldown time.Duration) *BreakerTransport {
+	return &BreakerTransport{
+		base:      base,
+		threshold: threshold,
+		cooldown:  cooldown,
+		hosts:     make(map[string]*hostBreaker),
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *BreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := t.allow(host); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The caller gave up, which says nothing about the host
+		t.release(host)
+	case err != nil:
+		t.record(host, false)
+	default:
+		t.record(host, !isTransientStatus(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// allow returns an error if requests to host are failed fast, marking the
+// request as the probe if the host's cooldown has passed
+func (t *BreakerTransport) allow(host string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.hosts[host]
+	if b == nil || b.failures < t.threshold {
+		return nil
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return fmt.Errorf("%w: %w for %s after %d failed requests in a row", ErrNetwork, ErrCircuitOpen, host, b.failures)
+	}
+	b.probing = true
+	return nil
+}
+
+// record counts the outcome of a request to host
+func (t *BreakerTransport) record(host string, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.hosts[host]
+	if b == nil {
+		b = &hostBreaker{}
+		t.hosts[host] = b
+	}
+	b.probing = false
+
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= t.threshold {
+		b.openUntil = time.Now().Add(t.cooldown)
+	}
+}
+
+// release lets another request probe host, without counting the outcome of
+// the request that was sent
+func (t *BreakerTransport) release(host string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if b := t.hosts[host]; b != nil {
+		b.probing = false
+	}
+}
@@ -0,0 +1,94 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBreakerTransport tests that a failing host is failed fast once the
+// threshold is reached, instead of being retried
+func TestBreakerTransport(t *testing.T) {
+	retryBackoff = 0
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewBreakerTransport(http.DefaultTransport, 2, time.Hour)}
+	_, err := NewRemoteReaderWithClient(context.Background(), server.URL, client)
+	if !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, ErrNetwork) {
+		t.Errorf("Expected ErrCircuitOpen and ErrNetwork, got: %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Expected 2 requests before the breaker opened, got %d", got)
+	}
+
+	// Later requests don't reach the server at all
+	if _, err := NewRemoteReaderWithClient(context.Background(), server.URL, client); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got: %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Expected no more requests while open, got %d", got)
+	}
+}
+
+// TestBreakerTransportRecovers tests that a host is tried again after the
+// cooldown, and used again once it answers
+func TestBreakerTransportRecovers(t *testing.T) {
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	transport := NewBreakerTransport(http.DefaultTransport, 1, 10*time.Millisecond)
+	get := func() error {
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		resp, err := transport.RoundTrip(req)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(); err != nil {
+		t.Fatalf("Expected the first failure to reach the server, got: %v", err)
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got: %v", err)
+	}
+
+	healthy.Store(true)
+	time.Sleep(20 * time.Millisecond)
+	for range 2 {
+		if err := get(); err != nil {
+			t.Errorf("Expected the recovered host to be used, got: %v", err)
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	if got := jitter(0); got != 0 {
+		t.Errorf("jitter(0) = %v, want 0", got)
+	}
+	for range 100 {
+		if got := jitter(time.Second); got < 0 || got > time.Second {
+			t.Fatalf("jitter(1s) = %v, want within [0, 1s]", got)
+		}
+	}
+}
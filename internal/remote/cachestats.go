@@ -0,0 +1,50 @@
+package remote
+
+import "sync/atomic"
+
+// CacheStats counts how the reads of a RemoteReader were served, telling
+// whether its caches save range requests
+type CacheStats struct {
+	Hits         int64 // Reads served by the small-read cache
+	PrefetchHits int64 // Reads served by ranges fetched with Prefetch
+	Misses       int64 // Reads that needed a range request
+	BytesSaved   int64 // Bytes of the reads served without a request
+}
+
+// CacheReporter is implemented by readers that count how their reads were
+// served
+type CacheReporter interface {
+	CacheStats() CacheStats
+}
+
+var _ CacheReporter = (*RemoteReader)(nil)
+
+// Add returns the sum of s and other
+func (s CacheStats) Add(other CacheStats) CacheStats {
+	return CacheStats{
+		Hits:         s.Hits + other.Hits,
+		PrefetchHits: s.PrefetchHits + other.PrefetchHits,
+		Misses:       s.Misses + other.Misses,
+		BytesSaved:   s.BytesSaved + other.BytesSaved,
+	}
+}
+
+// Reads returns the number of reads counted
+func (s CacheStats) Reads() int64 {
+	return s.Hits + s.PrefetchHits + s.Misses
+}
+
+// cacheCounters accumulates CacheStats from concurrent reads
+type cacheCounters struct {
+	hits, prefetchHits, misses, bytesSaved atomic.Int64
+}
+
+// CacheStats returns how the reader's reads have been served so far
+func (r *RemoteReader) CacheStats() CacheStats {
+	return CacheStats{
+		Hits:         r.counters.hits.Load(),
+		PrefetchHits: r.counters.prefetchHits.Load(),
+		Misses:       r.counters.misses.Load(),
+		BytesSaved:   r.counters.bytesSaved.Load(),
+	}
+}
@@ -0,0 +1,54 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// BlobReader provides random access to a layer blob. RemoteReader implements
+// it over HTTP Range requests and LocalReader over local files, so
+// the seekable extractors work the same for registries and local sources.
+type BlobReader interface {
+	io.ReaderAt
+	Size() int64
+	Close() error
+}
+
+var (
+	_ BlobReader = (*RemoteReader)(nil)
+	_ BlobReader = (*LocalReader)(nil)
+)
+
+// LocalReader implements BlobReader for data already on this machine
+type LocalReader struct {
+	io.ReaderAt
+	size   int64
+	closer io.Closer
+}
+
+// OpenLocalFile opens a blob stored in a local file, such as one in an OCI layout
+func OpenLocalFile(path string) (*LocalReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	return &LocalReader{ReaderAt: f, size: info.Size(), closer: f}, nil
+}
+
+// Size returns the total size of the blob
+func (r *LocalReader) Size() int64 {
+	return r.size
+}
+
+// Close releases the underlying file
+func (r *LocalReader) Close() error {
+	return r.closer.Close()
+}
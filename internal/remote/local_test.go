@@ -0,0 +1,48 @@
+package remote
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLocalReader tests random access to a blob in a local file
+func TestLocalReader(t *testing.T) {
+	data := []byte("header...body...FOOTER")
+
+	path := filepath.Join(t.TempDir(), "blob")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write blob: %v", err)
+	}
+
+	reader, err := OpenLocalFile(path)
+	if err != nil {
+		t.Fatalf("OpenLocalFile() error = %v", err)
+	}
+
+	if reader.Size() != int64(len(data)) {
+		t.Errorf("Size() = %d, want %d", reader.Size(), len(data))
+	}
+
+	buf := make([]byte, 4)
+	n, err := reader.ReadAt(buf, 9)
+	if err != nil {
+		t.Errorf("ReadAt() error = %v", err)
+	}
+	if string(buf[:n]) != "body" {
+		t.Errorf("ReadAt() = %q, want %q", buf[:n], "body")
+	}
+
+	if _, err := reader.ReadAt(buf, int64(len(data))); err != io.EOF {
+		t.Errorf("ReadAt() past end error = %v, want io.EOF", err)
+	}
+
+	if err := reader.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+
+	if _, err := OpenLocalFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("OpenLocalFile() expected error for missing file, got nil")
+	}
+}
@@ -0,0 +1,31 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+)
+
+// rangeBody returns the payload of a range response and its Content-Range.
+// Some servers answer even a single range with a multipart/byteranges body,
+// whose first part carries the requested bytes; the others are ignored, as
+// only one range is ever requested.
+func rangeBody(resp *http.Response) (io.Reader, string, error) {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		return resp.Body, resp.Header.Get("Content-Range"), nil
+	}
+
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, "", fmt.Errorf("multipart/byteranges response without a boundary")
+	}
+
+	part, err := multipart.NewReader(resp.Body, boundary).NextPart()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read multipart/byteranges response: %w", err)
+	}
+	return part, part.Header.Get("Content-Range"), nil
+}
@@ -0,0 +1,60 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+)
+
+// TestRemoteReaderMultipartRange tests reading a single range answered with
+// a multipart/byteranges body
+func TestRemoteReaderMultipartRange(t *testing.T) {
+	data := []byte("Hello, World! This is test data for remote reader.")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+			return
+		}
+
+		var start, end int
+		_, _ = fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		end = min(end, len(data)-1)
+
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+		w.WriteHeader(http.StatusPartialContent)
+
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {"application/octet-stream"},
+			"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", start, end, len(data))},
+		})
+		if err != nil {
+			t.Errorf("failed to create part: %v", err)
+			return
+		}
+		_, _ = part.Write(data[start : end+1])
+		_ = mw.Close()
+	}))
+	defer server.Close()
+
+	reader, err := NewRemoteReaderWithClient(context.Background(), server.URL, &http.Client{})
+	if err != nil {
+		t.Fatalf("Failed to create RemoteReader: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	buf := make([]byte, 5)
+	n, err := reader.ReadAt(buf, 7)
+	if err != nil {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	if string(buf[:n]) != "World" {
+		t.Errorf("ReadAt returned %q, want %q", buf[:n], "World")
+	}
+}
@@ -0,0 +1,84 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Range is a byte range of a blob
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// Prefetcher is implemented by readers that can fetch ranges concurrently
+// ahead of the reads that need them, hiding per-request latency
+type Prefetcher interface {
+	Prefetch(ctx context.Context, ranges []Range, concurrency int) error
+}
+
+var _ Prefetcher = (*RemoteReader)(nil)
+
+// prefetchedRange holds the data fetched for one Range
+type prefetchedRange struct {
+	offset int64
+	data   []byte
+}
+
+// Prefetch fetches ranges with up to concurrency parallel requests and keeps
+// them in memory, so later ReadAt calls inside a range need no round trip.
+// Ranges are kept for the lifetime of the reader.
+func (r *RemoteReader) Prefetch(ctx context.Context, ranges []Range, concurrency int) error {
+	fetched := make([]prefetchedRange, len(ranges))
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(concurrency, 1))
+	for i, rg := range ranges {
+		g.Go(func() error {
+			data := make([]byte, rg.Length)
+			n, err := r.readRange(ctx, data, rg.Offset)
+			if err != nil {
+				return fmt.Errorf("failed to prefetch range %d-%d: %w", rg.Offset, rg.Offset+rg.Length-1, err)
+			}
+			fetched[i] = prefetchedRange{offset: rg.Offset, data: data[:n]}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	r.prefetchMu.Lock()
+	defer r.prefetchMu.Unlock()
+
+	r.prefetched = append(r.prefetched, fetched...)
+	sort.Slice(r.prefetched, func(i, j int) bool {
+		return r.prefetched[i].offset < r.prefetched[j].offset
+	})
+	return nil
+}
+
+// readPrefetched serves p from a single prefetched range covering it
+func (r *RemoteReader) readPrefetched(p []byte, off int64) (int, bool) {
+	r.prefetchMu.RLock()
+	defer r.prefetchMu.RUnlock()
+
+	// Find the last range starting at or before off
+	i := sort.Search(len(r.prefetched), func(i int) bool {
+		return r.prefetched[i].offset > off
+	}) - 1
+	if i < 0 {
+		return 0, false
+	}
+
+	pr := r.prefetched[i]
+	start := off - pr.offset
+	if start+int64(len(p)) > int64(len(pr.data)) {
+		return 0, false
+	}
+
+	return copy(p, pr.data[start:]), true
+}
@@ -1,54 +1,234 @@
 package remote
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 )
 
+// ErrAuth is returned when the server rejects a request with 401 or 403
+var ErrAuth = errors.New("blob requires authentication; ensure credentials are configured (e.g. docker login)")
+
+// checkAuthStatus returns an ErrAuth-wrapping error for auth-related statuses
+func checkAuthStatus(method string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w: %s request failed with status: %d", ErrAuth, method, resp.StatusCode)
+	}
+	return nil
+}
+
 // RemoteReader implements io.ReaderAt for remote HTTP resources using Range requests
 type RemoteReader struct {
 	URL    string
 	Client *http.Client
 	size   int64
 
-	// Simple cache for small reads
-	cacheMu    sync.RWMutex
-	cacheStart int64
-	cacheEnd   int64 // Tracks the end of valid cached data
-	cacheData  []byte
-	cacheSize  int
-	cacheValid bool // Tracks if cache contains valid data
+	// Simple cache for small reads: the data of the last one that went to the
+	// server. Each read stores a buffer of its own that is never written to
+	// again, so concurrent reads can copy from it outside the lock while a
+	// miss replaces it.
+	cacheMu   sync.RWMutex
+	cached    *prefetchedRange // nil until the first small read
+	cacheSize int
+
+	// Ranges fetched ahead of time by Prefetch, sorted by offset
+	prefetchMu sync.RWMutex
+	prefetched []prefetchedRange
+
+	// How reads were served, for CacheStats
+	counters cacheCounters
 }
 
-// NewRemoteReader creates a new RemoteReader for the given URL
-func NewRemoteReader(url string) (*RemoteReader, error) {
-	client := &http.Client{}
+// NewRemoteReaderWithClient creates a new RemoteReader that issues requests
+// through client, e.g. one whose transport attaches registry credentials.
+// Transient failures while learning the size are retried; errors wrap
+// ErrAuth, ErrRangeUnsupported or ErrNetwork so callers can tell them apart.
+func NewRemoteReaderWithClient(ctx context.Context, url string, client *http.Client) (*RemoteReader, error) {
+	probe, err := ProbeBlob(ctx, url, client)
+	if err != nil {
+		return nil, err
+	}
+
+	if !probe.RangeSupported {
+		return nil, ErrRangeUnsupported
+	}
 
+	return &RemoteReader{
+		URL:       url,
+		Client:    client,
+		size:      probe.Size,
+		cacheSize: 1024 * 1024, // 1MB cache
+	}, nil
+}
+
+// BlobProbe is what a HEAD request tells about a blob URL
+type BlobProbe struct {
+	// Size of the blob, or -1 if the server doesn't report it
+	Size int64
+
+	// RangeSupported is whether the server advertises byte range requests
+	RangeSupported bool
+
+	// Host is the server that answered, after following any redirects, e.g.
+	// to a CDN
+	Host string
+}
+
+// ProbeBlob checks whether url can be read with range requests, the way
+// NewRemoteReaderWithClient does before any read. Errors wrap ErrAuth or
+// ErrNetwork like those of NewRemoteReaderWithClient.
+func ProbeBlob(ctx context.Context, url string, client *http.Client) (*BlobProbe, error) {
 	// Get the content length
-	resp, err := client.Head(url)
+	resp, err := doWithRetry(ctx, client, "HEAD", func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to HEAD %s: %w", url, err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	if err := checkAuthStatus("HEAD", resp); err != nil {
+		return nil, err
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("HEAD request failed with status: %d", resp.StatusCode)
 	}
 
-	// Check if server supports range requests
-	if resp.Header.Get("Accept-Ranges") != "bytes" {
-		return nil, fmt.Errorf("server does not support range requests")
+	probe := &BlobProbe{
+		Size:           resp.ContentLength,
+		RangeSupported: resp.Header.Get("Accept-Ranges") == "bytes",
+		Host:           resp.Request.URL.Host,
 	}
 
-	return &RemoteReader{
-		URL:       url,
-		Client:    client,
-		size:      resp.ContentLength,
-		cacheSize: 1024 * 1024, // 1MB cache
-		cacheData: make([]byte, 1024*1024),
-	}, nil
+	// Some registries and CDNs only report the length on range responses
+	if probe.RangeSupported && probe.Size < 0 {
+		probe.Size, err = probeSize(ctx, url, client)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return probe, nil
+}
+
+// probeSize learns the size of a resource from the Content-Range header of a
+// single-byte range request. It returns -1 if the server doesn't report it.
+func probeSize(ctx context.Context, url string, client *http.Client) (int64, error) {
+	resp, err := doWithRetry(ctx, client, "size probe", func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		return req, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute size probe request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if err := checkAuthStatus("size probe", resp); err != nil {
+		return 0, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		_, contentRange, err := rangeBody(resp)
+		if err != nil {
+			return 0, err
+		}
+		return parseContentRangeTotal(contentRange), nil
+	case http.StatusOK:
+		// Range ignored, so the length (if any) is of the whole resource
+		return resp.ContentLength, nil
+	default:
+		return 0, fmt.Errorf("size probe request failed with status: %d", resp.StatusCode)
+	}
+}
+
+// ReadHeader returns the first n bytes of url, or all of it if it is
+// shorter, with a single range request. Servers that ignore the range are
+// only read up to n bytes. Errors wrap ErrAuth or ErrNetwork.
+func ReadHeader(ctx context.Context, url string, client *http.Client, n int) ([]byte, error) {
+	resp, err := doWithRetry(ctx, client, "header", func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute header request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if err := checkAuthStatus("header", resp); err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("header request failed with status: %d", resp.StatusCode)
+	}
+
+	body, _, err := rangeBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, n)
+	read, err := io.ReadFull(body, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return header[:read], nil
+}
+
+// OpenBlob streams the whole of url with a single request, e.g. to hash it.
+// It isn't retried, as the per-attempt timeout would cut large blobs short.
+// Errors wrap ErrAuth or ErrNetwork.
+func OpenBlob(ctx context.Context, url string, client *http.Client) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: blob request failed: %w", ErrNetwork, err)
+	}
+
+	if err := checkAuthStatus("blob", resp); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("blob request failed with status: %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// parseContentRangeTotal returns the complete length from a Content-Range
+// header such as "bytes 0-0/1234", or -1 if it is missing or unknown ("*")
+func parseContentRangeTotal(header string) int64 {
+	_, total, ok := strings.Cut(header, "/")
+	if !ok {
+		return -1
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(total), 10, 64)
+	if err != nil || size < 0 {
+		return -1
+	}
+
+	return size
 }
 
 // ReadAt implements io.ReaderAt
@@ -57,27 +237,55 @@ func (r *RemoteReader) ReadAt(p []byte, off int64) (n int, err error) {
 		return 0, fmt.Errorf("negative offset")
 	}
 
-	if off >= r.size {
+	// A negative size means the server didn't report Content-Length
+	if r.size >= 0 && off >= r.size {
 		return 0, io.EOF
 	}
 
 	// Check cache first
 	r.cacheMu.RLock()
-	if r.cacheValid && off >= r.cacheStart && off+int64(len(p)) <= r.cacheEnd {
-		cacheOffset := off - r.cacheStart
-		n = copy(p, r.cacheData[cacheOffset:cacheOffset+int64(len(p))])
-		r.cacheMu.RUnlock()
+	cached := r.cached
+	r.cacheMu.RUnlock()
+	if cached != nil && off >= cached.offset && off+int64(len(p)) <= cached.offset+int64(len(cached.data)) {
+		n = copy(p, cached.data[off-cached.offset:])
+		r.counters.hits.Add(1)
+		r.counters.bytesSaved.Add(int64(n))
 		return n, nil
 	}
-	r.cacheMu.RUnlock()
 
+	// Serve reads covered by an earlier Prefetch
+	if n, ok := r.readPrefetched(p, off); ok {
+		r.counters.prefetchHits.Add(1)
+		r.counters.bytesSaved.Add(int64(n))
+		return n, nil
+	}
+
+	r.counters.misses.Add(1)
+	n, err = r.readRange(context.Background(), p, off)
+	if err != nil {
+		return n, err
+	}
+
+	// Update cache if this was a small read
+	if n > 0 && n <= r.cacheSize {
+		cached := &prefetchedRange{offset: off, data: slices.Clone(p[:n])}
+		r.cacheMu.Lock()
+		r.cached = cached
+		r.cacheMu.Unlock()
+	}
+
+	return n, nil
+}
+
+// readRange fills p with a single range request starting at off
+func (r *RemoteReader) readRange(ctx context.Context, p []byte, off int64) (int, error) {
 	// Prepare range request
 	end := off + int64(len(p)) - 1
-	if end >= r.size {
+	if r.size >= 0 && end >= r.size {
 		end = r.size - 1
 	}
 
-	req, err := http.NewRequest("GET", r.URL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", r.URL, nil)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -90,30 +298,29 @@ func (r *RemoteReader) ReadAt(p []byte, off int64) (n int, err error) {
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	if err := checkAuthStatus("range", resp); err != nil {
+		return 0, err
+	}
+
 	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
 		return 0, fmt.Errorf("range request failed with status: %d", resp.StatusCode)
 	}
 
 	// Read response body
-	n, err = io.ReadFull(resp.Body, p)
+	body, _, err := rangeBody(resp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(body, p)
 	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		return n, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	// Update cache if this was a small read
-	if n > 0 && n <= r.cacheSize {
-		r.cacheMu.Lock()
-		r.cacheStart = off
-		r.cacheEnd = off + int64(n)
-		copy(r.cacheData, p[:n])
-		r.cacheValid = true
-		r.cacheMu.Unlock()
-	}
-
 	return n, nil
 }
 
-// Size returns the total size of the remote resource
+// Size returns the total size of the remote resource, or -1 if the server
+// didn't report it
 func (r *RemoteReader) Size() int64 {
 	return r.size
 }
@@ -1,11 +1,17 @@
 package remote
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // TestRemoteReader tests basic functionality of RemoteReader
@@ -54,7 +60,7 @@ func TestRemoteReader(t *testing.T) {
 	defer server.Close()
 
 	// Create a RemoteReader
-	reader, err := NewRemoteReader(server.URL)
+	reader, err := NewRemoteReaderWithClient(context.Background(), server.URL, &http.Client{})
 	if err != nil {
 		t.Fatalf("Failed to create RemoteReader: %v", err)
 	}
@@ -122,7 +128,7 @@ func TestRemoteReaderCache(t *testing.T) {
 	}))
 	defer server.Close()
 
-	reader, err := NewRemoteReader(server.URL)
+	reader, err := NewRemoteReaderWithClient(context.Background(), server.URL, &http.Client{})
 	if err != nil {
 		t.Fatalf("Failed to create RemoteReader: %v", err)
 	}
@@ -143,6 +149,60 @@ func TestRemoteReaderCache(t *testing.T) {
 	}
 }
 
+// TestRemoteReaderConcurrentReadAt tests that concurrent reads, hitting and
+// missing the cache, each get their own bytes. Run with -race.
+func TestRemoteReaderConcurrentReadAt(t *testing.T) {
+	testData := make([]byte, 64*1024)
+	for i := range testData {
+		testData[i] = byte(i * 7)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(testData)))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		var start, end int64
+		_, _ = fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(testData)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(testData[start : end+1])
+	}))
+	defer server.Close()
+
+	reader, err := NewRemoteReaderWithClient(context.Background(), server.URL, &http.Client{})
+	if err != nil {
+		t.Fatalf("Failed to create RemoteReader: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				// Repeated offsets hit the cache another goroutine filled
+				off := int64((g*131 + i%5*4099) % (len(testData) - 512))
+				buf := make([]byte, 256+g*32)
+				n, err := reader.ReadAt(buf, off)
+				if err != nil {
+					t.Errorf("ReadAt(%d) failed: %v", off, err)
+					return
+				}
+				if !bytes.Equal(buf[:n], testData[off:off+int64(n)]) || n != len(buf) {
+					t.Errorf("ReadAt(%d) returned the wrong %d bytes", off, n)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 // TestRemoteReaderNoRangeSupport tests handling of servers without range support
 func TestRemoteReaderNoRangeSupport(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -154,8 +214,206 @@ func TestRemoteReaderNoRangeSupport(t *testing.T) {
 	}))
 	defer server.Close()
 
-	_, err := NewRemoteReader(server.URL)
-	if err == nil {
-		t.Error("Expected error for server without range support")
+	_, err := NewRemoteReaderWithClient(context.Background(), server.URL, &http.Client{})
+	if !errors.Is(err, ErrRangeUnsupported) {
+		t.Errorf("Expected ErrRangeUnsupported for server without range support, got: %v", err)
+	}
+}
+
+// newRangeTestServer serves data with range requests, optionally reporting
+// Content-Length on HEAD
+func newRangeTestServer(t *testing.T, data []byte, contentLength bool) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := int64(len(data))
+
+		if r.Method == http.MethodHead {
+			w.Header().Set("Accept-Ranges", "bytes")
+			if contentLength {
+				w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+			} else {
+				// Force chunked encoding so no Content-Length is sent
+				w.Header().Set("Transfer-Encoding", "chunked")
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		var start, end int64
+		_, _ = fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		if end >= size {
+			end = size - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(data[start : end+1])
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// TestRemoteReaderAuthError tests that 401/403 responses surface as ErrAuth
+func TestRemoteReaderAuthError(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+
+		_, err := NewRemoteReaderWithClient(context.Background(), server.URL, &http.Client{})
+		if !errors.Is(err, ErrAuth) {
+			t.Errorf("status %d: expected ErrAuth, got: %v", status, err)
+		}
+
+		server.Close()
+	}
+}
+
+// TestRemoteReaderSizeFromContentRange tests learning the size from a range
+// response when HEAD omits Content-Length
+func TestRemoteReaderSizeFromContentRange(t *testing.T) {
+	data := []byte("header...body...FOOTER")
+	server := newRangeTestServer(t, data, false)
+
+	reader, err := NewRemoteReaderWithClient(context.Background(), server.URL, &http.Client{})
+	if err != nil {
+		t.Fatalf("Failed to create RemoteReader: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	if reader.Size() != int64(len(data)) {
+		t.Errorf("Size() = %d, want %d", reader.Size(), len(data))
+	}
+
+	buf := make([]byte, 6)
+	n, err := reader.ReadAt(buf, int64(len(data)-6))
+	if err != nil {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	if string(buf[:n]) != "FOOTER" {
+		t.Errorf("ReadAt returned %q, want %q", buf[:n], "FOOTER")
+	}
+}
+
+func TestParseContentRangeTotal(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int64
+	}{
+		{header: "bytes 0-0/1234", want: 1234},
+		{header: "bytes 0-0/*", want: -1},
+		{header: "", want: -1},
+		{header: "bytes 0-0/abc", want: -1},
+	}
+
+	for _, tt := range tests {
+		if got := parseContentRangeTotal(tt.header); got != tt.want {
+			t.Errorf("parseContentRangeTotal(%q) = %d, want %d", tt.header, got, tt.want)
+		}
+	}
+}
+
+// TestRemoteReaderPrefetch tests that reads inside prefetched ranges need no requests
+func TestRemoteReaderPrefetch(t *testing.T) {
+	testData := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	var rangeRequests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(testData)))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		rangeRequests.Add(1)
+		var start, end int64
+		_, _ = fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(testData)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(testData[start : end+1])
+	}))
+	defer server.Close()
+
+	reader, err := NewRemoteReaderWithClient(context.Background(), server.URL, &http.Client{})
+	if err != nil {
+		t.Fatalf("Failed to create RemoteReader: %v", err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	ranges := []Range{{Offset: 20, Length: 10}, {Offset: 0, Length: 10}, {Offset: 10, Length: 10}}
+	if err := reader.Prefetch(context.Background(), ranges, 2); err != nil {
+		t.Fatalf("Prefetch failed: %v", err)
+	}
+	if got := rangeRequests.Load(); got != 3 {
+		t.Errorf("Expected 3 prefetch requests, got %d", got)
+	}
+
+	// Reads inside a single prefetched range are served from memory
+	for _, off := range []int64{0, 12, 25} {
+		buf := make([]byte, 4)
+		if _, err := reader.ReadAt(buf, off); err != nil {
+			t.Fatalf("ReadAt(%d) failed: %v", off, err)
+		}
+		if string(buf) != string(testData[off:off+4]) {
+			t.Errorf("ReadAt(%d) = %q, want %q", off, buf, testData[off:off+4])
+		}
+	}
+	if got := rangeRequests.Load(); got != 3 {
+		t.Errorf("Expected no requests for prefetched reads, got %d", got-3)
+	}
+
+	// A read crossing two ranges goes to the server
+	buf := make([]byte, 4)
+	if _, err := reader.ReadAt(buf, 8); err != nil {
+		t.Fatalf("ReadAt(8) failed: %v", err)
+	}
+	if string(buf) != "89ab" {
+		t.Errorf("ReadAt(8) = %q, want %q", buf, "89ab")
+	}
+	if got := rangeRequests.Load(); got != 4 {
+		t.Errorf("Expected one request for a read across ranges, got %d", got-3)
+	}
+
+	// The crossing read is now cached
+	if _, err := reader.ReadAt(buf[:2], 9); err != nil {
+		t.Fatalf("ReadAt(9) failed: %v", err)
+	}
+	want := CacheStats{Hits: 1, PrefetchHits: 3, Misses: 1, BytesSaved: 3*4 + 2}
+	if got := reader.CacheStats(); got != want {
+		t.Errorf("CacheStats() = %+v, want %+v", got, want)
+	}
+}
+
+// TestReadHeader tests reading the start of a resource, whether or not the
+// server honors the range
+func TestReadHeader(t *testing.T) {
+	data := []byte("header...body")
+
+	for _, honorRange := range []bool{true, false} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if honorRange {
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+				return
+			}
+			_, _ = w.Write(data)
+		}))
+
+		got, err := ReadHeader(context.Background(), server.URL, server.Client(), 6)
+		if err != nil {
+			t.Errorf("ReadHeader() honorRange=%v error = %v", honorRange, err)
+		} else if string(got) != "header" {
+			t.Errorf("ReadHeader() honorRange=%v = %q, want %q", honorRange, got, "header")
+		}
+
+		got, err = ReadHeader(context.Background(), server.URL, server.Client(), 64)
+		if err != nil {
+			t.Errorf("ReadHeader() past the end honorRange=%v error = %v", honorRange, err)
+		} else if string(got) != string(data) {
+			t.Errorf("ReadHeader() past the end honorRange=%v = %q, want %q", honorRange, got, data)
+		}
+
+		server.Close()
 	}
 }
@@ -0,0 +1,76 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrRedirect is returned for blob requests redirected elsewhere, when
+// redirects aren't followed
+var ErrRedirect = errors.New("blob request redirected; redirects are not followed (--follow-redirects=false)")
+
+// maxRedirects bounds how many redirects a single request follows, as many
+// as net/http's client does
+const maxRedirects = 10
+
+// RedirectTransport is an http.RoundTripper for blob requests that follows
+// redirects itself, rather than leaving them to http.Client. Registries often
+// redirect blobs to a signed CDN URL, which rejects the registry's
+// credentials, so redirected requests keep their headers, including Range,
+// but are sent through a transport without credentials.
+type RedirectTransport struct {
+	authed http.RoundTripper
+	plain  http.RoundTripper
+	follow bool
+}
+
+// NewRedirectTransport sends requests through authed, which attaches
+// registry credentials, and follows redirects through plain. When follow is
+// false, redirects fail with ErrRedirect instead.
+func NewRedirectTransport(authed, plain http.RoundTripper, follow bool) *RedirectTransport {
+	return &RedirectTransport{authed: authed, plain: plain, follow: follow}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RedirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.authed.RoundTrip(req)
+
+	for hops := 0; err == nil && isRedirect(resp.StatusCode); hops++ {
+		location, locErr := resp.Location()
+		if locErr != nil {
+			// Nothing to follow, so the response is the answer
+			return resp, nil
+		}
+		_ = resp.Body.Close()
+
+		if !t.follow {
+			return nil, fmt.Errorf("%w: %s redirected to %s", ErrRedirect, req.URL.Redacted(), location.Redacted())
+		}
+		if hops == maxRedirects {
+			return nil, fmt.Errorf("%s stopped after %d redirects", req.URL.Redacted(), maxRedirects)
+		}
+
+		next := req.Clone(req.Context())
+		next.URL = location
+		next.Host = ""
+		next.Header.Del("Authorization")
+
+		resp, err = t.plain.RoundTrip(next)
+	}
+
+	return resp, err
+}
+
+// isRedirect reports whether status redirects to its Location
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
+
+var _ http.RoundTripper = (*RedirectTransport)(nil)
@@ -0,0 +1,85 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// bearerTransport adds a registry token to requests for one host
+type bearerTransport struct {
+	host string
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == t.host {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer registry-token")
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// TestRedirectTransport tests that redirected range requests keep their
+// range and drop registry credentials
+func TestRedirectTransport(t *testing.T) {
+	data := []byte("Hello, World! This is test data for remote reader.")
+
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Signed URLs reject any other authorization
+		if r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			t.Errorf("CDN request without a range: %q", r.Header.Get("Range"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(data[start : end+1])
+	}))
+	defer cdn.Close()
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer registry-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, cdn.URL+"/blob?signature=abc", http.StatusTemporaryRedirect)
+	}))
+	defer registry.Close()
+
+	authed := &bearerTransport{host: strings.TrimPrefix(registry.URL, "http://")}
+
+	client := &http.Client{Transport: NewRedirectTransport(authed, http.DefaultTransport, true)}
+	reader, err := NewRemoteReaderWithClient(context.Background(), registry.URL+"/v2/test/blobs/sha256:abc", client)
+	if err != nil {
+		t.Fatalf("Failed to create RemoteReader: %v", err)
+	}
+
+	buf := make([]byte, 5)
+	n, err := reader.ReadAt(buf, 7)
+	if err != nil {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	if string(buf[:n]) != "World" {
+		t.Errorf("ReadAt returned %q, want %q", buf[:n], "World")
+	}
+
+	client = &http.Client{Transport: NewRedirectTransport(authed, http.DefaultTransport, false)}
+	if _, err := NewRemoteReaderWithClient(context.Background(), registry.URL+"/v2/test/blobs/sha256:abc", client); !errors.Is(err, ErrRedirect) {
+		t.Errorf("NewRemoteReaderWithClient() without redirects error = %v, want ErrRedirect", err)
+	}
+}
@@ -0,0 +1,107 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// ErrNetwork is returned when a server can't be reached, or keeps failing
+// with transient errors, after all retries
+var ErrNetwork = errors.New("network error")
+
+// ErrRangeUnsupported is returned when a server doesn't support range requests
+var ErrRangeUnsupported = errors.New("server does not support range requests")
+
+const (
+	// maxAttempts bounds how often a request is sent before giving up
+	maxAttempts = 3
+
+	// requestTimeout bounds a single attempt, including reading the headers
+	requestTimeout = 30 * time.Second
+)
+
+// retryBackoff bounds the delay before the first retry; the bound doubles for
+// each further retry. A variable so tests don't have to wait.
+var retryBackoff = 250 * time.Millisecond
+
+// doWithRetry sends the request built by newReq, retrying transport errors
+// and transient statuses (429 and 5xx) with exponential backoff. Each delay
+// is drawn at random up to the backoff, so that many readers failing at once
+// don't all retry at once too. Requests failed fast by a BreakerTransport
+// aren't retried. Each attempt gets its own timeout, which stays in effect
+// until the returned response body is closed. Failures left after the last
+// attempt wrap ErrNetwork.
+func doWithRetry(ctx context.Context, client *http.Client, method string, newReq func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	backoff := retryBackoff
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+
+		req, err := newReq(attemptCtx)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		resp, err := client.Do(req)
+		switch {
+		case err == nil && !isTransientStatus(resp.StatusCode):
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		case err == nil:
+			_ = resp.Body.Close()
+			err = fmt.Errorf("%s request failed with status: %d", method, resp.StatusCode)
+		}
+		cancel()
+
+		// The caller gave up, so there is nothing to retry for
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// Nor would a refused redirect or a host known to be failing go
+		// differently
+		if errors.Is(err, ErrRedirect) || errors.Is(err, ErrCircuitOpen) {
+			return nil, err
+		}
+		if attempt == maxAttempts {
+			return nil, fmt.Errorf("%w: %s request failed after %d attempts: %w", ErrNetwork, method, attempt, err)
+		}
+
+		select {
+		case <-time.After(jitter(backoff)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// jitter returns a random delay of at most d, for full-jitter backoff
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d + 1)
+}
+
+// isTransientStatus reports whether a response status is worth retrying
+func isTransientStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// cancelOnClose releases an attempt's timeout once its body has been read
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
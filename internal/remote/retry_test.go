@@ -0,0 +1,81 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// TestRemoteReaderRetriesHead tests that transient HEAD failures are retried
+func TestRemoteReaderRetriesHead(t *testing.T) {
+	retryBackoff = 0
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < maxAttempts {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	reader, err := NewRemoteReaderWithClient(context.Background(), server.URL, &http.Client{})
+	if err != nil {
+		t.Fatalf("Expected HEAD to succeed after retries, got: %v", err)
+	}
+	if reader.Size() != 100 {
+		t.Errorf("Size mismatch: expected 100, got %d", reader.Size())
+	}
+	if got := requests.Load(); got != maxAttempts {
+		t.Errorf("Expected %d requests, got %d", maxAttempts, got)
+	}
+}
+
+// TestRemoteReaderNetworkError tests that failures left after retries surface as ErrNetwork
+func TestRemoteReaderNetworkError(t *testing.T) {
+	retryBackoff = 0
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	_, err := NewRemoteReaderWithClient(context.Background(), server.URL, &http.Client{})
+	if !errors.Is(err, ErrNetwork) {
+		t.Errorf("Expected ErrNetwork, got: %v", err)
+	}
+	if got := requests.Load(); got != maxAttempts {
+		t.Errorf("Expected %d requests, got %d", maxAttempts, got)
+	}
+
+	// An unreachable server is a network error too
+	server.Close()
+	if _, err := NewRemoteReaderWithClient(context.Background(), server.URL, &http.Client{}); !errors.Is(err, ErrNetwork) {
+		t.Errorf("Expected ErrNetwork for a closed server, got: %v", err)
+	}
+}
+
+// TestRemoteReaderAuthNotRetried tests that auth failures aren't retried
+func TestRemoteReaderAuthNotRetried(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	if _, err := NewRemoteReaderWithClient(context.Background(), server.URL, &http.Client{}); !errors.Is(err, ErrAuth) {
+		t.Errorf("Expected ErrAuth, got: %v", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Expected a single request, got %d", got)
+	}
+}
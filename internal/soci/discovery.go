@@ -3,11 +3,13 @@
 package soci
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"slices"
+	"time"
 
-	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
@@ -19,53 +21,72 @@ const (
 
 	// SOCIIndexAnnotation is the annotation key for SOCI indices
 	SOCIIndexAnnotation = "com.amazon.aws.soci.index"
+
+	// createdAnnotation is the OCI annotation recording when an artifact,
+	// such as a SOCI index, was created
+	createdAnnotation = "org.opencontainers.image.created"
 )
 
+// Supported reports whether SOCI can be used on this platform
+const Supported = true
+
 // IndexInfo contains information about a SOCI index
 type IndexInfo struct {
 	Descriptor v1.Descriptor
 	Reference  name.Reference
+
+	// LayerAnnotations are further annotation keys that name the layer a
+	// zTOC descriptor indexes, for index schemes other than SOCI's. They
+	// are tried in order, before LayerDigestAnnotation.
+	LayerAnnotations []string
+
+	// Options used to fetch the index, reused for its zTOCs
+	options []remote.Option
 }
 
-// DiscoverSOCIIndex finds the SOCI index for an image
-func DiscoverSOCIIndex(ctx context.Context, imageRef string) (*IndexInfo, error) {
-	ref, err := name.ParseReference(imageRef)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse reference: %w", err)
-	}
+// remoteOptions returns the options for registry requests about the index,
+// bound to ctx
+func (info *IndexInfo) remoteOptions(ctx context.Context) []remote.Option {
+	return withContext(ctx, info.options)
+}
 
-	// Get the image to find its digest
-	img, err := remote.Image(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch image: %w", err)
-	}
+// withContext returns options with ctx added, leaving options unchanged
+func withContext(ctx context.Context, options []remote.Option) []remote.Option {
+	return append(slices.Clone(options), remote.WithContext(ctx))
+}
 
-	digest, err := img.Digest()
+// DiscoverSOCIIndex finds the SOCI index for an image pinned by digest.
+// opts are the options the image itself was fetched with, e.g. from
+// registry.Client.RemoteOptions; every registry request for the index and
+// its zTOCs uses them, so SOCI lookups authenticate and reach the registry
+// the same way the image fetch did.
+func DiscoverSOCIIndex(ctx context.Context, ref name.Digest, opts []remote.Option) (*IndexInfo, error) {
+	digest, err := v1.NewHash(ref.DigestStr())
 	if err != nil {
-		return nil, fmt.Errorf("failed to get image digest: %w", err)
+		return nil, fmt.Errorf("failed to parse image digest: %w", err)
 	}
 
+	options := slices.Clone(opts)
+
 	// Try using the Referrers API (OCI 1.1)
-	indexInfo, err := findViaReferrersAPI(ctx, ref, digest)
+	indexInfo, err := findViaReferrersAPI(ctx, ref, digest, options)
 	if err == nil {
 		return indexInfo, nil
 	}
 
 	// Fallback: Try the tag-based approach
-	return findViaTagReference(ctx, ref, digest)
+	return findViaTagReference(ctx, ref, digest, options)
 }
 
 // findViaReferrersAPI uses the OCI Referrers API to find SOCI indices
-func findViaReferrersAPI(ctx context.Context, ref name.Reference, digest v1.Hash) (*IndexInfo, error) {
-	// Construct a proper Digest reference from the repository and hash
-	repo := ref.Context()
-	digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", repo.String(), digest.String()))
-	if err != nil {
-		return nil, fmt.Errorf("failed to construct digest reference: %w", err)
-	}
+func findViaReferrersAPI(ctx context.Context, ref name.Reference, digest v1.Hash, options []remote.Option) (*IndexInfo, error) {
+	// References are derived from the repository rather than reparsed from
+	// strings, so they keep its registry options (e.g. plain HTTP) and work
+	// the same whether the image was named by tag or by digest
+	digestRef := ref.Context().Digest(digest.String())
 
 	// Query the referrers API
-	index, err := remote.Referrers(digestRef, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	index, err := remote.Referrers(digestRef, withContext(ctx, options)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query referrers: %w", err)
 	}
@@ -75,41 +96,69 @@ func findViaReferrersAPI(ctx context.Context, ref name.Reference, digest v1.Hash
 		return nil, fmt.Errorf("failed to get index manifest: %w", err)
 	}
 
-	// Look for SOCI index artifact
-	for _, desc := range manifest.Manifests {
-		if desc.ArtifactType == SOCIIndexMediaType {
-			return &IndexInfo{
-				Descriptor: desc,
-				Reference:  ref,
-			}, nil
+	desc := newestSOCIIndex(manifest.Manifests)
+	if desc == nil {
+		return nil, fmt.Errorf("no SOCI index found in referrers")
+	}
+
+	return &IndexInfo{
+		Descriptor: *desc,
+		Reference:  ref,
+		options:    options,
+	}, nil
+}
+
+// newestSOCIIndex returns the newest SOCI index among the referrers of an
+// image, nil if none is. An image may have several, e.g. built by different
+// versions of the SOCI tooling. The newest is the one with the latest
+// creation annotation; indexes without one are older than those with one,
+// and among equals the later descriptor wins.
+func newestSOCIIndex(referrers []v1.Descriptor) *v1.Descriptor {
+	var newest *v1.Descriptor
+	var newestCreated time.Time
+	for i, desc := range referrers {
+		// Registries without artifactType support only have the media type
+		if desc.ArtifactType != SOCIIndexMediaType && desc.MediaType != SOCIIndexMediaType {
+			continue
 		}
 
-		// Also check media type as fallback
-		if desc.MediaType == SOCIIndexMediaType {
-			return &IndexInfo{
-				Descriptor: desc,
-				Reference:  ref,
-			}, nil
+		created, _ := time.Parse(time.RFC3339, desc.Annotations[createdAnnotation])
+		if newest == nil || !created.Before(newestCreated) {
+			newest = &referrers[i]
+			newestCreated = created
 		}
 	}
+	return newest
+}
+
+// FetchSOCIIndex returns the SOCI index with digest indexDigest in the
+// repository of ref, for using a given index rather than discovering one.
+// opts are as for DiscoverSOCIIndex.
+func FetchSOCIIndex(ctx context.Context, ref name.Reference, indexDigest v1.Hash, opts []remote.Option) (*IndexInfo, error) {
+	options := slices.Clone(opts)
+
+	desc, err := remote.Get(ref.Context().Digest(indexDigest.String()), withContext(ctx, options)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch SOCI index %s: %w", indexDigest, err)
+	}
 
-	return nil, fmt.Errorf("no SOCI index found in referrers")
+	return &IndexInfo{
+		Descriptor: desc.Descriptor,
+		Reference:  ref,
+		options:    options,
+	}, nil
 }
 
 // findViaTagReference tries to find SOCI index using tag-based naming
-func findViaTagReference(ctx context.Context, ref name.Reference, digest v1.Hash) (*IndexInfo, error) {
+func findViaTagReference(ctx context.Context, ref name.Reference, digest v1.Hash, options []remote.Option) (*IndexInfo, error) {
 	// SOCI indices are often tagged as sha256-<digest>.soci
 	sociTag := fmt.Sprintf("sha256-%s.soci", digest.Hex)
 
 	// Construct the SOCI index reference
-	repo := ref.Context()
-	sociRef, err := name.NewTag(fmt.Sprintf("%s:%s", repo.String(), sociTag))
-	if err != nil {
-		return nil, fmt.Errorf("failed to construct SOCI tag: %w", err)
-	}
+	sociRef := ref.Context().Tag(sociTag)
 
 	// Try to fetch the SOCI index
-	desc, err := remote.Get(sociRef, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	desc, err := remote.Get(sociRef, withContext(ctx, options)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch SOCI index via tag: %w", err)
 	}
@@ -117,20 +166,17 @@ func findViaTagReference(ctx context.Context, ref name.Reference, digest v1.Hash
 	return &IndexInfo{
 		Descriptor: desc.Descriptor,
 		Reference:  sociRef,
+		options:    options,
 	}, nil
 }
 
 // GetSOCIIndex fetches and returns the SOCI index manifest
 func GetSOCIIndex(ctx context.Context, info *IndexInfo) (*v1.IndexManifest, error) {
 	// Fetch the SOCI index using the descriptor's digest
-	repo := info.Reference.Context()
-	digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", repo.String(), info.Descriptor.Digest.String()))
-	if err != nil {
-		return nil, fmt.Errorf("failed to construct digest reference: %w", err)
-	}
+	digestRef := info.Reference.Context().Digest(info.Descriptor.Digest.String())
 
 	// Fetch the SOCI index as an OCI Image Index
-	idx, err := remote.Index(digestRef, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	idx, err := remote.Index(digestRef, info.remoteOptions(ctx)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch SOCI index: %w", err)
 	}
@@ -152,51 +198,66 @@ func GetZtocForLayer(ctx context.Context, info *IndexInfo, layerDigest v1.Hash)
 		return nil, err
 	}
 
-	// Find the zTOC descriptor for the layer
-	// SOCI index manifests contain descriptors for zTOC blobs
-	// Each zTOC is annotated with the layer digest it corresponds to
-	var ztocDescriptor *v1.Descriptor
-	for i, desc := range indexManifest.Manifests {
-		// Check annotations for layer digest reference
-		if desc.Annotations != nil {
-			if digest, ok := desc.Annotations["com.amazon.aws.soci.layer.digest"]; ok {
-				if digest == layerDigest.String() {
-					ztocDescriptor = &indexManifest.Manifests[i]
-					break
-				}
-			}
-		}
-	}
-
+	ztocDescriptor := findZtocDescriptor(indexManifest, layerDigest, info.LayerAnnotations)
 	if ztocDescriptor == nil {
-		return nil, fmt.Errorf("no zTOC found for layer %s", layerDigest)
+		return nil, fmt.Errorf("%w %s", ErrNoZtoc, layerDigest)
 	}
 
 	// Fetch the zTOC blob
-	repo := info.Reference.Context()
-	ztocRef, err := name.NewDigest(fmt.Sprintf("%s@%s", repo.String(), ztocDescriptor.Digest.String()))
-	if err != nil {
-		return nil, fmt.Errorf("failed to construct zTOC reference: %w", err)
-	}
+	ztocRef := info.Reference.Context().Digest(ztocDescriptor.Digest.String())
 
 	// Fetch the zTOC blob
-	layer, err := remote.Layer(ztocRef, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	layer, err := remote.Layer(ztocRef, info.remoteOptions(ctx)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch zTOC blob: %w", err)
 	}
 
-	// Read the zTOC blob
-	rc, err := layer.Uncompressed()
+	// Read the zTOC blob as stored, since that's what its descriptor describes
+	rc, err := layer.Compressed()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get uncompressed zTOC: %w", err)
+		return nil, fmt.Errorf("failed to get zTOC: %w", err)
 	}
 	defer func() { _ = rc.Close() }()
 
-	// Read all the zTOC data
-	ztocData, err := io.ReadAll(rc)
+	// Read all the zTOC data, and no more than its descriptor allows
+	ztocData, err := io.ReadAll(io.LimitReader(rc, ztocDescriptor.Size+1))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read zTOC data: %w", err)
 	}
+	if err := verifyZtoc(ztocData, ztocDescriptor); err != nil {
+		return nil, err
+	}
 
 	return ztocData, nil
 }
+
+// findZtocDescriptor returns the descriptor of the zTOC for a layer in an
+// index manifest, which holds one descriptor per zTOC annotated with the
+// digest of its layer: under one of keys, or else LayerDigestAnnotation
+func findZtocDescriptor(indexManifest *v1.IndexManifest, layerDigest v1.Hash, keys []string) *v1.Descriptor {
+	for _, key := range append(slices.Clone(keys), LayerDigestAnnotation) {
+		for i, desc := range indexManifest.Manifests {
+			if desc.Annotations[key] == layerDigest.String() {
+				return &indexManifest.Manifests[i]
+			}
+		}
+	}
+	return nil
+}
+
+// verifyZtoc checks zTOC data against the size and digest of its descriptor
+// in the SOCI index, so a zTOC is never trusted to locate files unless it's
+// the one the index names
+func verifyZtoc(data []byte, desc *v1.Descriptor) error {
+	if int64(len(data)) != desc.Size {
+		return fmt.Errorf("zTOC %s is %d bytes, expected %d", desc.Digest, len(data), desc.Size)
+	}
+	got, _, err := v1.SHA256(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to hash zTOC: %w", err)
+	}
+	if got != desc.Digest {
+		return fmt.Errorf("zTOC digest mismatch: got %s, expected %s", got, desc.Digest)
+	}
+	return nil
+}
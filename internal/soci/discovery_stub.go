@@ -4,10 +4,10 @@ package soci
 
 import (
 	"context"
-	"errors"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 )
 
 const (
@@ -18,25 +18,36 @@ const (
 	SOCIIndexAnnotation = "com.amazon.aws.soci.index"
 )
 
-var errSOCINotSupported = errors.New("SOCI support is only available on Linux")
+// Supported reports whether SOCI can be used on this platform
+const Supported = false
 
 // IndexInfo contains information about a SOCI index
 type IndexInfo struct {
 	Descriptor v1.Descriptor
 	Reference  name.Reference
+
+	// LayerAnnotations are further annotation keys that name the layer a
+	// zTOC descriptor indexes, for index schemes other than SOCI's. They
+	// are tried in order, before LayerDigestAnnotation.
+	LayerAnnotations []string
 }
 
 // DiscoverSOCIIndex returns an error on non-Linux platforms
-func DiscoverSOCIIndex(ctx context.Context, imageRef string) (*IndexInfo, error) {
-	return nil, errSOCINotSupported
+func DiscoverSOCIIndex(ctx context.Context, ref name.Digest, opts []remote.Option) (*IndexInfo, error) {
+	return nil, ErrNotSupported
+}
+
+// FetchSOCIIndex returns an error on non-Linux platforms
+func FetchSOCIIndex(ctx context.Context, ref name.Reference, indexDigest v1.Hash, opts []remote.Option) (*IndexInfo, error) {
+	return nil, ErrNotSupported
 }
 
 // GetSOCIIndex returns an error on non-Linux platforms
 func GetSOCIIndex(ctx context.Context, info *IndexInfo) (*v1.IndexManifest, error) {
-	return nil, errSOCINotSupported
+	return nil, ErrNotSupported
 }
 
 // GetZtocForLayer returns an error on non-Linux platforms
 func GetZtocForLayer(ctx context.Context, info *IndexInfo, layerDigest v1.Hash) ([]byte, error) {
-	return nil, errSOCINotSupported
+	return nil, ErrNotSupported
 }
@@ -0,0 +1,195 @@
+//go:build linux
+
+package soci
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// headerTransport adds a header to every request, standing in for the
+// credentials a registry client is configured with
+type headerTransport struct {
+	base  http.RoundTripper
+	value string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Registry-Token", t.value)
+	return t.base.RoundTrip(req)
+}
+
+// TestDiscoverSOCIIndexUsesOptions tests that discovery sends its requests
+// with the options it is given, e.g. the client's authenticated transport
+func TestDiscoverSOCIIndexUsesOptions(t *testing.T) {
+	handler := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Registry-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	opts := []remote.Option{remote.WithTransport(&headerTransport{base: http.DefaultTransport, value: "secret"})}
+	repo := strings.TrimPrefix(server.URL, "http://") + "/test/image"
+
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("failed to get image digest: %v", err)
+	}
+	ref, err := name.NewDigest(repo + "@" + digest.String())
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	if err := remote.Write(ref, img, opts...); err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	// An index tagged the way SOCI tags them stands in for a SOCI index
+	index, err := random.Index(64, 1, 1)
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	sociTag, err := name.NewTag(repo + ":sha256-" + digest.Hex + ".soci")
+	if err != nil {
+		t.Fatalf("failed to parse tag: %v", err)
+	}
+	if err := remote.WriteIndex(sociTag, index, opts...); err != nil {
+		t.Fatalf("failed to push index: %v", err)
+	}
+
+	if _, err := DiscoverSOCIIndex(context.Background(), ref, nil); err == nil {
+		t.Error("DiscoverSOCIIndex() without options succeeded, want an error from the registry")
+	}
+
+	info, err := DiscoverSOCIIndex(context.Background(), ref, opts)
+	if err != nil {
+		t.Fatalf("DiscoverSOCIIndex() error = %v", err)
+	}
+	if _, err := GetSOCIIndex(context.Background(), info); err != nil {
+		t.Errorf("GetSOCIIndex() error = %v, want the discovery options reused", err)
+	}
+
+	// The same index, pinned by digest
+	indexDigest, err := index.Digest()
+	if err != nil {
+		t.Fatalf("failed to get index digest: %v", err)
+	}
+	pinned, err := FetchSOCIIndex(context.Background(), ref, indexDigest, opts)
+	if err != nil {
+		t.Fatalf("FetchSOCIIndex() error = %v", err)
+	}
+	if pinned.Descriptor.Digest != indexDigest {
+		t.Errorf("FetchSOCIIndex() descriptor digest = %s, want %s", pinned.Descriptor.Digest, indexDigest)
+	}
+	if _, err := GetSOCIIndex(context.Background(), pinned); err != nil {
+		t.Errorf("GetSOCIIndex() of the pinned index error = %v", err)
+	}
+}
+
+func TestNewestSOCIIndex(t *testing.T) {
+	index := func(n int, created string) v1.Descriptor {
+		desc := v1.Descriptor{ArtifactType: SOCIIndexMediaType, Size: int64(n)}
+		if created != "" {
+			desc.Annotations = map[string]string{createdAnnotation: created}
+		}
+		return desc
+	}
+	signature := v1.Descriptor{ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json", Size: 99}
+
+	tests := []struct {
+		name      string
+		referrers []v1.Descriptor
+		wantSize  int64 // 0 for no index
+	}{
+		{name: "none", referrers: []v1.Descriptor{signature}},
+		{name: "only one", referrers: []v1.Descriptor{signature, index(1, "")}, wantSize: 1},
+		{
+			name:      "latest created",
+			referrers: []v1.Descriptor{index(1, "2024-05-01T00:00:00Z"), index(2, "2025-01-01T00:00:00Z"), index(3, "2024-12-31T00:00:00Z")},
+			wantSize:  2,
+		},
+		{name: "created beats undated", referrers: []v1.Descriptor{index(1, "2024-05-01T00:00:00Z"), index(2, "")}, wantSize: 1},
+		{name: "last undated", referrers: []v1.Descriptor{index(1, ""), index(2, ""), signature}, wantSize: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desc := newestSOCIIndex(tt.referrers)
+			switch {
+			case desc == nil && tt.wantSize != 0:
+				t.Errorf("newestSOCIIndex() = nil, want the index of size %d", tt.wantSize)
+			case desc != nil && desc.Size != tt.wantSize:
+				t.Errorf("newestSOCIIndex() picked the index of size %d, want %d", desc.Size, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestVerifyZtoc(t *testing.T) {
+	data := []byte("ztoc")
+	digest, size, err := v1.SHA256(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := &v1.Descriptor{Digest: digest, Size: size}
+
+	if err := verifyZtoc(data, desc); err != nil {
+		t.Errorf("verifyZtoc() error = %v", err)
+	}
+	if err := verifyZtoc([]byte("zto"), desc); err == nil {
+		t.Error("verifyZtoc() with truncated data expected error, got nil")
+	}
+	if err := verifyZtoc([]byte("ZTOC"), desc); err == nil {
+		t.Error("verifyZtoc() with tampered data expected error, got nil")
+	}
+}
+
+func TestFindZtocDescriptor(t *testing.T) {
+	layer := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}
+	other := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("b", 64)}
+	manifest := &v1.IndexManifest{Manifests: []v1.Descriptor{
+		{Size: 1, Annotations: map[string]string{"com.example.index.layer": other.String()}},
+		{Size: 2, Annotations: map[string]string{"com.example.index.layer": layer.String()}},
+		{Size: 3, Annotations: map[string]string{LayerDigestAnnotation: layer.String()}},
+	}}
+
+	tests := []struct {
+		name     string
+		keys     []string
+		layer    v1.Hash
+		wantSize int64 // 0 for no descriptor
+	}{
+		{name: "SOCI annotation", layer: layer, wantSize: 3},
+		{name: "custom annotation first", keys: []string{"com.example.index.layer"}, layer: layer, wantSize: 2},
+		{name: "custom annotation only", keys: []string{"com.example.index.layer"}, layer: other, wantSize: 1},
+		{name: "not annotated", layer: other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desc := findZtocDescriptor(manifest, tt.layer, tt.keys)
+			switch {
+			case desc == nil && tt.wantSize != 0:
+				t.Errorf("findZtocDescriptor() = nil, want the descriptor of size %d", tt.wantSize)
+			case desc != nil && desc.Size != tt.wantSize:
+				t.Errorf("findZtocDescriptor() found the descriptor of size %d, want %d", desc.Size, tt.wantSize)
+			}
+		})
+	}
+}
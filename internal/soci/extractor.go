@@ -7,18 +7,21 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"io/fs"
 
+	"github.com/amartani/oci-extract/internal/output"
 	"github.com/amartani/oci-extract/internal/pathutil"
+	"github.com/amartani/oci-extract/internal/remote"
 	"github.com/awslabs/soci-snapshotter/ztoc"
+	"github.com/awslabs/soci-snapshotter/ztoc/compression"
 )
 
 // Extractor handles file extraction from SOCI-indexed layers
 type Extractor struct {
-	reader io.ReaderAt
-	size   int64
-	ztoc   *ztoc.Ztoc
+	reader     io.ReaderAt
+	size       int64
+	ztoc       *ztoc.Ztoc
+	outputOpts output.Options
 }
 
 // NewExtractor creates a new SOCI extractor
@@ -37,40 +40,161 @@ func NewExtractor(reader io.ReaderAt, size int64, ztocBlob []byte) (*Extractor,
 	}, nil
 }
 
-// ExtractFile extracts a specific file using the zTOC information
+// SetOutputOptions configures how extracted files are written
+func (e *Extractor) SetOutputOptions(opts output.Options) {
+	e.outputOpts = opts
+}
+
+// ExtractFile extracts a specific file using the zTOC information. With a
+// byte range in the output options, only the spans covering it are read.
 func (e *Extractor) ExtractFile(ctx context.Context, targetPath string, outputPath string) error {
+	if e.lookup(targetPath) == nil {
+		return fmt.Errorf("file %s not found in zTOC: %w", targetPath, fs.ErrNotExist)
+	}
+
 	// Convert ReaderAt to SectionReader for Ztoc.ExtractFile
 	sr := io.NewSectionReader(e.reader, 0, e.size)
 
-	// Use the built-in Ztoc ExtractFile method
-	data, err := e.ztoc.ExtractFile(sr, targetPath)
+	var (
+		data []byte
+		err  error
+	)
+	if e.outputOpts.Range == nil {
+		// Use the built-in Ztoc ExtractFile method
+		data, err = e.ztoc.ExtractFile(sr, targetPath)
+	} else {
+		data, err = e.extractRange(sr, targetPath, *e.outputOpts.Range)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to extract file %s: %w", targetPath, err)
 	}
 
-	// Create output directory if needed
-	outputDir := filepath.Dir(outputPath)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	// Write the file contents
+	if err := e.outputOpts.WriteFile(outputPath, bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	// Apply metadata from the zTOC entry, if present
+	if entry := e.lookup(targetPath); entry != nil {
+		output.ApplyMetadata(outputPath, metadataFromZtocEntry(*entry), e.outputOpts)
+	}
+
+	return nil
+}
+
+// extractRange decompresses the part of targetPath that r selects, reading
+// only the compressed spans that cover it
+func (e *Extractor) extractRange(sr *io.SectionReader, targetPath string, r output.ByteRange) ([]byte, error) {
+	entry := e.lookup(targetPath)
+	if entry == nil {
+		return nil, fmt.Errorf("file not found in zTOC")
+	}
+
+	offset, length := r.Clamp(int64(entry.UncompressedSize))
+	if length == 0 {
+		return nil, nil
+	}
+
+	zinfo, err := e.ztoc.Zinfo()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zTOC checkpoints: %w", err)
+	}
+	defer zinfo.Close()
+
+	uncompressedOffset := entry.UncompressedOffset + compression.Offset(offset)
+	spanStart, spanEnd := spanRange(zinfo, uncompressedOffset, compression.Offset(length))
+
+	start := zinfo.StartCompressedOffset(spanStart)
+	end := zinfo.EndCompressedOffset(spanEnd, e.ztoc.CompressedArchiveSize)
+	buf := make([]byte, end-start)
+	if _, err := sr.ReadAt(buf, int64(start)); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read compressed spans: %w", err)
+	}
+
+	return zinfo.ExtractDataFromBuffer(buf, compression.Offset(length), uncompressedOffset, spanStart)
+}
+
+// Ranges returns the compressed spans ExtractFile reads for targetPath, one
+// range per span, so they can be prefetched before extraction. With a byte
+// range in the output options, only the spans covering it are returned.
+func (e *Extractor) Ranges(targetPath string) ([]remote.Range, error) {
+	entry := e.lookup(targetPath)
+	if entry == nil || entry.UncompressedSize == 0 {
+		return nil, nil
 	}
 
-	// Write to output file
-	if err := os.WriteFile(outputPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write output file: %w", err)
+	offset, length := entry.UncompressedOffset, entry.UncompressedSize
+	if e.outputOpts.Range != nil {
+		start, n := e.outputOpts.Range.Clamp(int64(entry.UncompressedSize))
+		if n == 0 {
+			return nil, nil
+		}
+		offset, length = offset+compression.Offset(start), compression.Offset(n)
 	}
 
+	zinfo, err := e.ztoc.Zinfo()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zTOC checkpoints: %w", err)
+	}
+	defer zinfo.Close()
+
+	spanStart, spanEnd := spanRange(zinfo, offset, length)
+
+	var ranges []remote.Range
+	for id := spanStart; id <= spanEnd; id++ {
+		start := zinfo.StartCompressedOffset(id)
+		end := zinfo.EndCompressedOffset(id, e.ztoc.CompressedArchiveSize)
+		ranges = append(ranges, remote.Range{Offset: int64(start), Length: int64(end - start)})
+	}
+
+	return ranges, nil
+}
+
+// spanRange returns the first and last spans holding the length bytes at
+// offset in the uncompressed archive
+func spanRange(zinfo compression.Zinfo, offset, length compression.Offset) (compression.SpanID, compression.SpanID) {
+	return zinfo.UncompressedOffsetToSpanID(offset), zinfo.UncompressedOffsetToSpanID(offset + length)
+}
+
+// lookup returns the zTOC entry for targetPath, or nil if there is none
+func (e *Extractor) lookup(targetPath string) *ztoc.FileMetadata {
+	for i := range e.ztoc.FileMetadata {
+		if pathutil.NormalizeForDisplay(e.ztoc.FileMetadata[i].Name) == pathutil.NormalizeForDisplay(targetPath) {
+			return &e.ztoc.FileMetadata[i]
+		}
+	}
 	return nil
 }
 
 // ListFiles lists all files in the zTOC
 func (e *Extractor) ListFiles() []string {
-	var files []string
+	return output.Paths(output.RegularFiles(e.ListEntries()))
+}
+
+// ListEntries lists the metadata of all entries in the zTOC, of any type
+func (e *Extractor) ListEntries() []output.Metadata {
+	var entries []output.Metadata
 	for _, entry := range e.ztoc.FileMetadata {
-		// Only include regular files
-		if entry.Type == "reg" {
-			// Normalize path for consistent display (ensure leading slash)
-			files = append(files, pathutil.NormalizeForDisplay(entry.Name))
+		if output.IsRootEntry(entry.Name) {
+			continue
 		}
+
+		md := metadataFromZtocEntry(entry)
+		// Normalize path for consistent display (ensure leading slash)
+		md.Path = output.DisplayPath(entry.Name)
+		entries = append(entries, md)
 	}
-	return files
+	return entries
+}
+
+// metadataFromZtocEntry collects metadata from a zTOC file entry
+func metadataFromZtocEntry(entry ztoc.FileMetadata) output.Metadata {
+	md := output.MetadataFromPAXRecords(entry.UID, entry.GID, entry.PAXHeaders)
+	md.Path = entry.Name
+	md.Type = entry.Type
+	md.Linkname = entry.Linkname
+	md.Mode = entry.Mode
+	md.Size = int64(entry.UncompressedSize)
+	md.ModTime = entry.ModTime
+	return md
 }
@@ -5,6 +5,9 @@ package soci
 import (
 	"context"
 	"io"
+
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/amartani/oci-extract/internal/remote"
 )
 
 // Extractor handles file extraction from SOCI-indexed layers
@@ -15,15 +18,28 @@ type Extractor struct {
 
 // NewExtractor returns an error on non-Linux platforms
 func NewExtractor(reader io.ReaderAt, size int64, ztocBlob []byte) (*Extractor, error) {
-	return nil, errSOCINotSupported
+	return nil, ErrNotSupported
 }
 
+// SetOutputOptions is a no-op on non-Linux platforms
+func (e *Extractor) SetOutputOptions(opts output.Options) {}
+
 // ExtractFile returns an error on non-Linux platforms
 func (e *Extractor) ExtractFile(ctx context.Context, targetPath string, outputPath string) error {
-	return errSOCINotSupported
+	return ErrNotSupported
+}
+
+// Ranges returns an error on non-Linux platforms
+func (e *Extractor) Ranges(targetPath string) ([]remote.Range, error) {
+	return nil, ErrNotSupported
 }
 
 // ListFiles returns an empty list on non-Linux platforms
 func (e *Extractor) ListFiles() []string {
 	return nil
 }
+
+// ListEntries returns an empty list on non-Linux platforms
+func (e *Extractor) ListEntries() []output.Metadata {
+	return nil
+}
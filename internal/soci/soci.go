@@ -0,0 +1,15 @@
+package soci
+
+import "errors"
+
+// LayerDigestAnnotation is the annotation on each zTOC descriptor of a SOCI
+// index naming the digest of the layer the zTOC indexes
+const LayerDigestAnnotation = "com.amazon.aws.soci.layer.digest"
+
+// ErrNotSupported is returned by SOCI operations on platforms other than
+// Linux, where the zTOC library isn't available
+var ErrNotSupported = errors.New("SOCI support is only available on Linux")
+
+// ErrNoZtoc is returned when a SOCI index has no zTOC for a layer, which
+// happens for layers too small to be worth indexing
+var ErrNoZtoc = errors.New("no zTOC for layer")
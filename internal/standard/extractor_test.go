@@ -6,8 +6,11 @@ import (
 	"compress/gzip"
 	"context"
 	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/amartani/oci-extract/internal/output"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 )
@@ -158,3 +161,90 @@ func TestExtractFileNotFound(t *testing.T) {
 		t.Error("ExtractFile() expected error for non-existent file, got nil")
 	}
 }
+
+// TestExtractFileRecordsMetadata tests that the matched tar entry is reported
+func TestExtractFileRecordsMetadata(t *testing.T) {
+	layer := createTestLayer(t, map[string]string{"etc/config": "value"})
+	extractor := NewExtractor(layer)
+
+	var recorded []output.Metadata
+	extractor.SetOutputOptions(output.Options{
+		Record: func(md output.Metadata) {
+			recorded = append(recorded, md)
+		},
+	})
+
+	outputPath := t.TempDir() + "/config"
+	if err := extractor.ExtractFile(context.Background(), "/etc/config", outputPath); err != nil {
+		t.Fatalf("ExtractFile() error = %v", err)
+	}
+
+	if len(recorded) != 1 {
+		t.Fatalf("Record called %d times, want 1", len(recorded))
+	}
+	md := recorded[0]
+	if md.Path != "etc/config" || md.Type != "reg" || md.Mode != 0600 || md.Size != 5 {
+		t.Errorf("recorded metadata = %+v, want etc/config reg 0600 size 5", md)
+	}
+}
+
+// TestMultiMemberGzip tests that entries past the first gzip member of a
+// layer are read, including an entry split across two members
+func TestMultiMemberGzip(t *testing.T) {
+	var tarBuf bytes.Buffer
+	tarWriter := tar.NewWriter(&tarBuf)
+	for _, name := range []string{"first.txt", "second.txt"} {
+		content := name + " content"
+		if err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write tar content: %v", err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+
+	// Split the tar stream inside the first file's header, so each member
+	// holds part of an entry
+	var buf bytes.Buffer
+	data := tarBuf.Bytes()
+	for _, member := range [][]byte{data[:100], data[100:]} {
+		gzipWriter := gzip.NewWriter(&buf)
+		if _, err := gzipWriter.Write(member); err != nil {
+			t.Fatalf("failed to write gzip member: %v", err)
+		}
+		if err := gzipWriter.Close(); err != nil {
+			t.Fatalf("failed to close gzip writer: %v", err)
+		}
+	}
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create layer: %v", err)
+	}
+	extractor := NewExtractor(layer)
+
+	files, err := extractor.ListFiles(context.Background())
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("ListFiles() = %v, want first.txt and second.txt", files)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "second.txt")
+	if err := extractor.ExtractFile(context.Background(), "second.txt", outputPath); err != nil {
+		t.Fatalf("ExtractFile() error = %v", err)
+	}
+	got, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if string(got) != "second.txt content" {
+		t.Errorf("ExtractFile() wrote %q, want %q", got, "second.txt content")
+	}
+}
@@ -5,20 +5,29 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"io/fs"
 	"strings"
 
-	"github.com/amartani/oci-extract/internal/pathutil"
+	"github.com/amartani/oci-extract/internal/output"
+	"github.com/amartani/oci-extract/internal/remote"
 	"github.com/containerd/stargz-snapshotter/estargz"
-	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
 )
 
+// ChunkedTOCDigestAnnotation is the layer descriptor annotation recording the
+// digest of a zstd:chunked layer's TOC
+const ChunkedTOCDigestAnnotation = "io.github.containers.zstd-chunked.manifest-checksum"
+
 // ChunkedExtractor handles file extraction from zstd:chunked (stargz-zstd) layers
 // zstd:chunked is a seekable format similar to eStargz but using zstd compression
 type ChunkedExtractor struct {
-	reader io.ReaderAt
-	size   int64
+	reader     io.ReaderAt
+	size       int64
+	outputOpts output.Options
+	tocDigest  digest.Digest
+
+	// windowLogMax bounds the zstd window, 0 meaning DefaultWindowLogMax
+	windowLogMax int
 }
 
 // NewChunkedExtractor creates a new zstd:chunked extractor
@@ -29,51 +38,67 @@ func NewChunkedExtractor(reader io.ReaderAt, size int64) *ChunkedExtractor {
 	}
 }
 
+// SetOutputOptions configures how extracted files are written
+func (e *ChunkedExtractor) SetOutputOptions(opts output.Options) {
+	e.outputOpts = opts
+}
+
+// SetWindowLogMax sets the base-2 logarithm of the largest zstd window the
+// layer may use; 0 selects DefaultWindowLogMax
+func (e *ChunkedExtractor) SetWindowLogMax(windowLogMax int) {
+	e.windowLogMax = windowLogMax
+}
+
+// SetTOCDigest makes the extractor check the layer's TOC against tocDigest,
+// as recorded in its ChunkedTOCDigestAnnotation, before trusting it. A TOC
+// that doesn't match is ignored and the layer streamed instead. An empty
+// digest skips the check.
+func (e *ChunkedExtractor) SetTOCDigest(tocDigest string) {
+	e.tocDigest = digest.Digest(tocDigest)
+}
+
+// openTOC opens the layer's TOC, verified when a TOC digest is set
+func (e *ChunkedExtractor) openTOC() (*estargz.Reader, error) {
+	r, err := estargz.Open(io.NewSectionReader(e.reader, 0, e.size))
+	if err != nil {
+		return nil, err
+	}
+	if e.tocDigest != "" {
+		if _, err := r.VerifyTOC(e.tocDigest); err != nil {
+			return nil, fmt.Errorf("failed to verify TOC: %w", err)
+		}
+	}
+	return r, nil
+}
+
 // ExtractFile extracts a specific file from a zstd:chunked layer
 func (e *ChunkedExtractor) ExtractFile(ctx context.Context, targetPath string, outputPath string) error {
-	// Convert ReaderAt to SectionReader
-	sr := io.NewSectionReader(e.reader, 0, e.size)
-
 	// Try to open as estargz first (it may support zstd:chunked)
-	r, err := estargz.Open(sr)
+	r, err := e.openTOC()
 	if err == nil {
 		// Successfully opened as stargz format, try to extract
-		_, ok := r.Lookup(targetPath)
+		entry, ok := r.Lookup(targetPath)
 		if ok {
 			fileReader, err := r.OpenFile(targetPath)
 			if err == nil {
-				// Create output directory if needed
-				outputDir := filepath.Dir(outputPath)
-				if err := os.MkdirAll(outputDir, 0755); err != nil {
-					return fmt.Errorf("failed to create output directory: %w", err)
-				}
-
-				// Create output file
-				outFile, err := os.Create(outputPath)
-				if err != nil {
-					return fmt.Errorf("failed to create output file: %w", err)
-				}
-				defer func() { _ = outFile.Close() }()
-
-				// Copy the file contents
-				_, err = io.Copy(outFile, fileReader)
-				if err != nil {
-					return fmt.Errorf("failed to copy file contents: %w", err)
+				// Write the file contents
+				if err := e.outputOpts.WriteFile(outputPath, e.outputOpts.Section(fileReader, fileReader.Size())); err != nil {
+					return err
 				}
 
+				output.ApplyMetadata(outputPath, output.MetadataFromTOCEntry(entry), e.outputOpts)
 				return nil
 			}
 		}
 	}
 
 	// Fall back to standard zstd tar extraction
-	// Reset reader to start
-	sr = io.NewSectionReader(e.reader, 0, e.size)
+	sr := io.NewSectionReader(e.reader, 0, e.size)
 
 	// Create zstd reader
-	zstdReader, err := zstd.NewReader(sr)
+	zstdReader, err := newDecoder(sr, e.windowLogMax)
 	if err != nil {
-		return fmt.Errorf("failed to create zstd reader: %w", err)
+		return err
 	}
 	defer zstdReader.Close()
 
@@ -99,45 +124,70 @@ func (e *ChunkedExtractor) ExtractFile(ctx context.Context, targetPath string, o
 
 		// Check if this is our target file
 		if normalizedEntry == normalizedTarget {
-			// Found the file!
-			// Handle regular files and symlinks
-			if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeSymlink && header.Typeflag != tar.TypeLink {
-				return fmt.Errorf("target path %s is not a regular file or symlink (type: %d)", targetPath, header.Typeflag)
+			// Found the file! Only regular files can be extracted; a link
+			// names its target instead
+			md := output.MetadataFromTarHeader(header)
+			if err := output.CheckRegularFile(targetPath, md); err != nil {
+				return err
 			}
 
-			// If it's a symlink, return an error with the link target
-			if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink {
-				return fmt.Errorf("target path %s is a symlink to %s, please extract the target instead", targetPath, header.Linkname)
+			// Write the file contents
+			if err := e.outputOpts.WriteFile(outputPath, e.outputOpts.Limit(tarReader)); err != nil {
+				return err
 			}
 
-			// Create output directory if needed
-			outputDir := filepath.Dir(outputPath)
-			if err := os.MkdirAll(outputDir, 0755); err != nil {
-				return fmt.Errorf("failed to create output directory: %w", err)
-			}
+			output.ApplyMetadata(outputPath, md, e.outputOpts)
+			return nil
+		}
+	}
 
-			// Create output file
-			outFile, err := os.Create(outputPath)
-			if err != nil {
-				return fmt.Errorf("failed to create output file: %w", err)
-			}
-			defer func() { _ = outFile.Close() }()
+	return fmt.Errorf("file %s not found in layer: %w", targetPath, fs.ErrNotExist)
+}
 
-			// Copy the file contents
-			_, err = io.Copy(outFile, tarReader)
-			if err != nil {
-				return fmt.Errorf("failed to copy file contents: %w", err)
-			}
+// Ranges returns the compressed chunks ExtractFile reads for targetPath, one
+// range per chunk, so they can be prefetched before extraction. With a byte
+// range in the output options, only the chunks covering it are returned.
+// Layers without a usable TOC have no ranges since they're streamed instead.
+func (e *ChunkedExtractor) Ranges(targetPath string) []remote.Range {
+	r, err := e.openTOC()
+	if err != nil {
+		return nil
+	}
 
-			return nil
+	entry, ok := r.Lookup(targetPath)
+	if !ok {
+		return nil
+	}
+
+	start, length := int64(0), entry.Size
+	if e.outputOpts.Range != nil {
+		start, length = e.outputOpts.Range.Clamp(entry.Size)
+	}
+
+	var ranges []remote.Range
+	for off := start; off < start+length; {
+		chunk, ok := r.ChunkEntryForOffset(targetPath, off)
+		if !ok || chunk.ChunkSize <= 0 {
+			break
 		}
+		ranges = append(ranges, remote.Range{Offset: chunk.Offset, Length: chunk.NextOffset() - chunk.Offset})
+		off = chunk.ChunkOffset + chunk.ChunkSize
 	}
 
-	return fmt.Errorf("file %s not found in layer", targetPath)
+	return ranges
 }
 
 // ListFiles lists all files in a zstd:chunked layer
 func (e *ChunkedExtractor) ListFiles(ctx context.Context) ([]string, error) {
+	entries, err := e.ListEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return output.Paths(output.RegularFiles(entries)), nil
+}
+
+// ListEntries lists the metadata of all entries in a zstd:chunked layer, of any type
+func (e *ChunkedExtractor) ListEntries(ctx context.Context) ([]output.Metadata, error) {
 	// zstd:chunked is backward-compatible with tar.zstd, so we can read it as a standard tar archive
 	// This is less efficient than using the TOC but works correctly
 
@@ -145,33 +195,11 @@ func (e *ChunkedExtractor) ListFiles(ctx context.Context) ([]string, error) {
 	sr := io.NewSectionReader(e.reader, 0, e.size)
 
 	// Create zstd reader
-	zstdReader, err := zstd.NewReader(sr)
+	zstdReader, err := newDecoder(sr, e.windowLogMax)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		return nil, err
 	}
 	defer zstdReader.Close()
 
-	// Create tar reader
-	tarReader := tar.NewReader(zstdReader)
-
-	var files []string
-
-	// Iterate through tar archive
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to read tar entry: %w", err)
-		}
-
-		// Only include regular files
-		if header.Typeflag == tar.TypeReg {
-			// Normalize path for consistent display (ensure leading slash)
-			files = append(files, pathutil.NormalizeForDisplay(header.Name))
-		}
-	}
-
-	return files, nil
+	return output.ListEntries(tar.NewReader(zstdReader))
 }
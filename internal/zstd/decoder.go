@@ -0,0 +1,54 @@
+package zstd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultWindowLogMax is the default base-2 logarithm of the largest window a
+// layer may use, 2 GiB. It covers layers compressed with zstd --long=31, the
+// largest window the zstd CLI produces without --zstd=wlog.
+const DefaultWindowLogMax = 31
+
+// MinWindowLog and MaxWindowLog bound the window logarithms the decoder accepts
+const (
+	MinWindowLog = 10
+	MaxWindowLog = 41
+)
+
+// ErrWindowTooLarge is returned when a layer was compressed with a window
+// larger than the configured maximum
+var ErrWindowTooLarge = errors.New("zstd window size exceeds the configured maximum")
+
+// decoder decompresses a zstd stream, reporting frames that need a window
+// larger than 1<<windowLogMax as ErrWindowTooLarge
+type decoder struct {
+	*zstd.Decoder
+	windowLogMax int
+}
+
+// newDecoder creates a decoder reading from r. A windowLogMax of 0 selects
+// DefaultWindowLogMax.
+func newDecoder(r io.Reader, windowLogMax int) (*decoder, error) {
+	if windowLogMax == 0 {
+		windowLogMax = DefaultWindowLogMax
+	}
+
+	d, err := zstd.NewReader(r, zstd.WithDecoderMaxWindow(1<<windowLogMax))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+	}
+	return &decoder{Decoder: d, windowLogMax: windowLogMax}, nil
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	n, err := d.Decoder.Read(p)
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		err = fmt.Errorf("%w (2^%d bytes); raise it with --zstd-window-log-max, e.g. --zstd-window-log-max=%d",
+			ErrWindowTooLarge, d.windowLogMax, d.windowLogMax+1)
+	}
+	return n, err
+}
@@ -0,0 +1,51 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// TestDecoderWindowLogMax tests that frames with a window above the limit
+// fail with ErrWindowTooLarge, and decode once the limit is raised
+func TestDecoderWindowLogMax(t *testing.T) {
+	data := bytes.Repeat([]byte("oci-extract large window "), 16<<10)
+
+	var compressed bytes.Buffer
+	enc, err := zstd.NewWriter(&compressed, zstd.WithWindowSize(1<<20))
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	if _, err := enc.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	d, err := newDecoder(bytes.NewReader(compressed.Bytes()), 17)
+	if err != nil {
+		t.Fatalf("newDecoder: %v", err)
+	}
+	_, err = io.ReadAll(d)
+	d.Close()
+	if !errors.Is(err, ErrWindowTooLarge) {
+		t.Fatalf("ReadAll with a 2^17 window = %v, want ErrWindowTooLarge", err)
+	}
+
+	d, err = newDecoder(bytes.NewReader(compressed.Bytes()), 20)
+	if err != nil {
+		t.Fatalf("newDecoder: %v", err)
+	}
+	defer d.Close()
+	got, err := io.ReadAll(d)
+	if err != nil {
+		t.Fatalf("ReadAll with a 2^20 window: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("decoded %d bytes, want %d", len(got), len(data))
+	}
+}
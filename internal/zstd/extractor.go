@@ -5,18 +5,21 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"io/fs"
 	"strings"
 
-	"github.com/amartani/oci-extract/internal/pathutil"
+	"github.com/amartani/oci-extract/internal/output"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
 )
 
 // Extractor handles file extraction from standard zstd-compressed OCI layers
 type Extractor struct {
-	layer v1.Layer
+	layer      v1.Layer
+	outputOpts output.Options
+
+	// windowLogMax bounds the zstd window, 0 meaning DefaultWindowLogMax
+	windowLogMax int
 }
 
 // NewExtractor creates a new standard zstd layer extractor
@@ -26,6 +29,17 @@ func NewExtractor(layer v1.Layer) *Extractor {
 	}
 }
 
+// SetOutputOptions configures how extracted files are written
+func (e *Extractor) SetOutputOptions(opts output.Options) {
+	e.outputOpts = opts
+}
+
+// SetWindowLogMax sets the base-2 logarithm of the largest zstd window the
+// layer may use; 0 selects DefaultWindowLogMax
+func (e *Extractor) SetWindowLogMax(windowLogMax int) {
+	e.windowLogMax = windowLogMax
+}
+
 // ExtractFile extracts a specific file from a zstd-compressed OCI layer
 // This downloads and decompresses the entire layer using zstd
 func (e *Extractor) ExtractFile(ctx context.Context, targetPath string, outputPath string) error {
@@ -37,9 +51,9 @@ func (e *Extractor) ExtractFile(ctx context.Context, targetPath string, outputPa
 	defer func() { _ = rc.Close() }()
 
 	// Create zstd reader
-	zstdReader, err := zstd.NewReader(rc)
+	zstdReader, err := newDecoder(rc, e.windowLogMax)
 	if err != nil {
-		return fmt.Errorf("failed to create zstd reader: %w", err)
+		return err
 	}
 	defer zstdReader.Close()
 
@@ -65,45 +79,59 @@ func (e *Extractor) ExtractFile(ctx context.Context, targetPath string, outputPa
 
 		// Check if this is our target file
 		if normalizedEntry == normalizedTarget {
-			// Found the file!
-			// Handle regular files and symlinks
-			if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeSymlink && header.Typeflag != tar.TypeLink {
-				return fmt.Errorf("target path %s is not a regular file or symlink (type: %d)", targetPath, header.Typeflag)
+			// Found the file! Only regular files can be extracted; a link
+			// names its target instead
+			md := output.MetadataFromTarHeader(header)
+			if err := output.CheckRegularFile(targetPath, md); err != nil {
+				return err
 			}
 
-			// If it's a symlink, return an error with the link target
-			if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink {
-				return fmt.Errorf("target path %s is a symlink to %s, please extract the target instead", targetPath, header.Linkname)
+			// Write the file contents
+			if err := e.outputOpts.WriteFile(outputPath, e.outputOpts.Limit(tarReader)); err != nil {
+				return err
 			}
 
-			// Create output directory if needed
-			outputDir := filepath.Dir(outputPath)
-			if err := os.MkdirAll(outputDir, 0755); err != nil {
-				return fmt.Errorf("failed to create output directory: %w", err)
-			}
+			output.ApplyMetadata(outputPath, md, e.outputOpts)
+			return nil
+		}
+	}
 
-			// Create output file
-			outFile, err := os.Create(outputPath)
-			if err != nil {
-				return fmt.Errorf("failed to create output file: %w", err)
-			}
-			defer func() { _ = outFile.Close() }()
+	return fmt.Errorf("file %s not found in layer: %w", targetPath, fs.ErrNotExist)
+}
 
-			// Copy the file contents
-			_, err = io.Copy(outFile, tarReader)
-			if err != nil {
-				return fmt.Errorf("failed to copy file contents: %w", err)
-			}
+// ExtractDir extracts every entry under targetDir from a zstd-compressed OCI layer into
+// target, applying whiteouts. It returns the number of entries written and
+// whether the layer adds or removes targetDir.
+func (e *Extractor) ExtractDir(ctx context.Context, targetDir string, target output.Target) (int, output.DirPresence, error) {
+	// Get the compressed layer data
+	rc, err := e.layer.Compressed()
+	if err != nil {
+		return 0, output.DirUnchanged, fmt.Errorf("failed to get compressed layer: %w", err)
+	}
+	defer func() { _ = rc.Close() }()
 
-			return nil
-		}
+	// Create zstd reader
+	zstdReader, err := newDecoder(rc, e.windowLogMax)
+	if err != nil {
+		return 0, output.DirUnchanged, err
 	}
+	defer zstdReader.Close()
 
-	return fmt.Errorf("file %s not found in layer", targetPath)
+	return output.ExtractDirTo(tar.NewReader(zstdReader), targetDir, target, e.outputOpts)
 }
 
 // ListFiles lists all files in a zstd-compressed OCI layer
 func (e *Extractor) ListFiles(ctx context.Context) ([]string, error) {
+	entries, err := e.ListEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return output.Paths(output.RegularFiles(entries)), nil
+}
+
+// FindByDigest returns the paths of the regular files in a zstd-compressed
+// OCI layer whose content has digest d, hashing each of them
+func (e *Extractor) FindByDigest(ctx context.Context, d digest.Digest) ([]string, error) {
 	// Get the compressed layer data
 	rc, err := e.layer.Compressed()
 	if err != nil {
@@ -112,33 +140,30 @@ func (e *Extractor) ListFiles(ctx context.Context) ([]string, error) {
 	defer func() { _ = rc.Close() }()
 
 	// Create zstd reader
-	zstdReader, err := zstd.NewReader(rc)
+	zstdReader, err := newDecoder(rc, e.windowLogMax)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		return nil, err
 	}
 	defer zstdReader.Close()
 
-	// Create tar reader
-	tarReader := tar.NewReader(zstdReader)
-
-	var files []string
+	return output.FindByDigest(tar.NewReader(zstdReader), d)
+}
 
-	// Iterate through tar archive
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to read tar entry: %w", err)
-		}
+// ListEntries lists the metadata of all entries in a zstd-compressed OCI layer, of any type
+func (e *Extractor) ListEntries(ctx context.Context) ([]output.Metadata, error) {
+	// Get the compressed layer data
+	rc, err := e.layer.Compressed()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get compressed layer: %w", err)
+	}
+	defer func() { _ = rc.Close() }()
 
-		// Only include regular files
-		if header.Typeflag == tar.TypeReg {
-			// Normalize path for consistent display (ensure leading slash)
-			files = append(files, pathutil.NormalizeForDisplay(header.Name))
-		}
+	// Create zstd reader
+	zstdReader, err := newDecoder(rc, e.windowLogMax)
+	if err != nil {
+		return nil, err
 	}
+	defer zstdReader.Close()
 
-	return files, nil
+	return output.ListEntries(tar.NewReader(zstdReader))
 }
@@ -0,0 +1,203 @@
+package ociextract
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxSymlinkHops bounds how many symlinks memFS.Open follows for one name
+const maxSymlinkHops = 40
+
+// memFile is an entry of a memFS: a regular file, directory or symlink, whose
+// data is the link target for symlinks
+type memFile struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+	sys     any
+}
+
+// memFS is an in-memory fs.FS keyed by slash-separated names relative to its
+// root. Directories without an entry of their own are synthesized from the
+// names below them. Open follows a symlink that is the last element of a
+// name, when its target is relative and stays inside the tree.
+type memFS map[string]*memFile
+
+var (
+	_ fs.FS         = memFS(nil)
+	_ fs.ReadLinkFS = memFS(nil)
+)
+
+// Open implements fs.FS
+func (m memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	target := name
+	for range maxSymlinkHops {
+		file, ok := m[target]
+		if !ok || file.mode&fs.ModeSymlink == 0 {
+			break
+		}
+		link := string(file.data)
+		if path.IsAbs(link) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		target = path.Join(path.Dir(target), link)
+		if !fs.ValidPath(target) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+
+	info, err := m.lstat(target)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	// Report the name that was opened, as os.DirFS does
+	info.name = path.Base(name)
+
+	switch {
+	case info.IsDir():
+		return &memDir{info: info, entries: m.readDir(target)}, nil
+	case info.Mode()&fs.ModeSymlink != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memOpenFile{info: info, Reader: bytes.NewReader(m[target].data)}, nil
+}
+
+// ReadLink implements fs.ReadLinkFS
+func (m memFS) ReadLink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	file, ok := m[name]
+	if !ok {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrNotExist}
+	}
+	if file.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return string(file.data), nil
+}
+
+// Lstat implements fs.ReadLinkFS
+func (m memFS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := m.lstat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// lstat describes name without following symlinks
+func (m memFS) lstat(name string) (*memInfo, error) {
+	if file, ok := m[name]; ok {
+		return &memInfo{name: path.Base(name), file: file}, nil
+	}
+
+	// The root and parents of other entries exist without an entry
+	synthesized := &memInfo{name: path.Base(name), file: &memFile{mode: fs.ModeDir | 0755}}
+	if name == "." {
+		return synthesized, nil
+	}
+	prefix := name + "/"
+	for p := range m {
+		if strings.HasPrefix(p, prefix) {
+			return synthesized, nil
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+// readDir lists the entries directly below dir, sorted by name
+func (m memFS) readDir(dir string) []fs.DirEntry {
+	prefix := dir + "/"
+	if dir == "." {
+		prefix = ""
+	}
+
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	for p := range m {
+		rest, ok := strings.CutPrefix(p, prefix)
+		if !ok || rest == "" {
+			continue
+		}
+		child, _, _ := strings.Cut(rest, "/")
+		if seen[child] {
+			continue
+		}
+		seen[child] = true
+
+		info, err := m.lstat(prefix + child)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries
+}
+
+// memInfo is the fs.FileInfo of a memFS entry
+type memInfo struct {
+	name string
+	file *memFile
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return int64(len(i.file.data)) }
+func (i *memInfo) Mode() fs.FileMode  { return i.file.mode }
+func (i *memInfo) ModTime() time.Time { return i.file.modTime }
+func (i *memInfo) IsDir() bool        { return i.file.mode.IsDir() }
+func (i *memInfo) Sys() any           { return i.file.sys }
+
+// memOpenFile is an open regular file of a memFS
+type memOpenFile struct {
+	info *memInfo
+	*bytes.Reader
+}
+
+func (f *memOpenFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memOpenFile) Close() error               { return nil }
+
+// memDir is an open directory of a memFS
+type memDir struct {
+	info    *memInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	rest = rest[:min(n, len(rest))]
+	d.offset += len(rest)
+	return rest, nil
+}
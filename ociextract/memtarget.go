@@ -0,0 +1,89 @@
+package ociextract
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/amartani/oci-extract/internal/output"
+)
+
+// memTarget is an output.Target that keeps entries in a memFS, so an
+// extraction can be inspected without touching the disk. Each file's source
+// Metadata is available from its Sys().
+type memTarget struct {
+	files memFS
+}
+
+var _ output.Target = (*memTarget)(nil)
+
+// newMemTarget creates an empty in-memory target
+func newMemTarget() *memTarget {
+	return &memTarget{files: make(memFS)}
+}
+
+func (t *memTarget) Mkdir(name string, md output.Metadata) error {
+	// The root always exists
+	if name == "" {
+		return nil
+	}
+
+	t.files[name] = &memFile{
+		mode:    fs.ModeDir | fs.FileMode(md.Mode).Perm(),
+		modTime: md.ModTime,
+		sys:     md,
+	}
+	return nil
+}
+
+func (t *memTarget) WriteFile(name string, r io.Reader, md output.Metadata) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read contents of %s: %w", name, err)
+	}
+
+	t.files[name] = &memFile{
+		data:    data,
+		mode:    fs.FileMode(md.Mode).Perm(),
+		modTime: md.ModTime,
+		sys:     md,
+	}
+	return nil
+}
+
+func (t *memTarget) Symlink(name string, md output.Metadata) error {
+	t.files[name] = &memFile{
+		data:    []byte(md.Linkname),
+		mode:    fs.ModeSymlink | 0777,
+		modTime: md.ModTime,
+		sys:     md,
+	}
+	return nil
+}
+
+func (t *memTarget) Link(oldname, name string) error {
+	file, ok := t.files[oldname]
+	if !ok {
+		// Like a hard link to a file outside the extracted directory
+		return nil
+	}
+
+	link := *file
+	t.files[name] = &link
+	return nil
+}
+
+func (t *memTarget) RemoveAll(name string) error {
+	delete(t.files, name)
+	return t.ClearDir(name)
+}
+
+func (t *memTarget) ClearDir(name string) error {
+	for p := range t.files {
+		if name == "" || strings.HasPrefix(p, name+"/") {
+			delete(t.files, p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package ociextract
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/amartani/oci-extract/internal/output"
+)
+
+type testEntry struct {
+	name     string
+	content  string
+	typeflag byte
+	linkname string
+}
+
+// buildTar creates an in-memory tar stream from entries
+func buildTar(t *testing.T, entries []testEntry) *tar.Reader {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		typeflag := e.typeflag
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: typeflag, Linkname: e.linkname}
+		if typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatalf("failed to write tar content: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	return tar.NewReader(&buf)
+}
+
+func TestExtractDirToMemTarget(t *testing.T) {
+	target := newMemTarget()
+
+	lower := buildTar(t, []testEntry{
+		{name: "etc/nginx/", typeflag: tar.TypeDir},
+		{name: "etc/nginx/nginx.conf", content: "lower"},
+		{name: "etc/nginx/mime.types", content: "types"},
+		{name: "etc/nginx/conf.d/default.conf", content: "default"},
+		{name: "etc/passwd", content: "root"},
+	})
+	if _, _, err := output.ExtractDirTo(lower, "/etc/nginx/", target, output.Options{}); err != nil {
+		t.Fatalf("ExtractDirTo() error = %v", err)
+	}
+
+	upper := buildTar(t, []testEntry{
+		{name: "etc/nginx/nginx.conf", content: "upper"},
+		{name: "etc/nginx/.wh.mime.types"},
+		{name: "etc/nginx/conf.d/.wh..wh..opq"},
+		{name: "etc/nginx/conf.d/site.conf", content: "site"},
+		{name: "etc/nginx/current", typeflag: tar.TypeSymlink, linkname: "nginx.conf"},
+		{name: "etc/nginx/same.conf", typeflag: tar.TypeLink, linkname: "etc/nginx/nginx.conf"},
+	})
+	if _, _, err := output.ExtractDirTo(upper, "/etc/nginx/", target, output.Options{}); err != nil {
+		t.Fatalf("ExtractDirTo() error = %v", err)
+	}
+
+	fsys := target.files
+	if err := fstest.TestFS(fsys, "conf.d/site.conf", "current", "nginx.conf", "same.conf"); err != nil {
+		t.Errorf("memFS doesn't behave as an fs.FS: %v", err)
+	}
+
+	var got []string
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			got = append(got, p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk FS: %v", err)
+	}
+	want := []string{"conf.d/site.conf", "current", "nginx.conf", "same.conf"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FS contains %v, want %v", got, want)
+	}
+
+	for _, name := range []string{"nginx.conf", "same.conf"} {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil || string(data) != "upper" {
+			t.Errorf("%s = %q (%v), want upper layer content", name, data, err)
+		}
+	}
+
+	link, err := fs.ReadLink(fsys, "current")
+	if err != nil || link != "nginx.conf" {
+		t.Errorf("current symlink = %q (%v), want nginx.conf", link, err)
+	}
+
+	info, err := fs.Stat(fsys, "conf.d/site.conf")
+	if err != nil {
+		t.Fatalf("failed to stat site.conf: %v", err)
+	}
+	if md, ok := info.Sys().(Metadata); !ok || md.Path != "etc/nginx/conf.d/site.conf" {
+		t.Errorf("site.conf Sys() = %#v, want source metadata", info.Sys())
+	}
+}
@@ -0,0 +1,31 @@
+// Package ociextract exposes oci-extract's extraction to Go programs
+package ociextract
+
+import (
+	"context"
+	"io"
+	"io/fs"
+
+	"github.com/amartani/oci-extract/internal/extractor"
+	"github.com/amartani/oci-extract/internal/output"
+)
+
+// Metadata is the source metadata of an extracted entry, returned by the
+// Sys method of its fs.FileInfo
+type Metadata = output.Metadata
+
+// ExtractDirToFS extracts the directory dir, a path ending in /, from the
+// image imageRef into memory instead of the disk, returning the merged tree
+// of every layer rooted at that directory. Images are read as the CLI reads
+// them, with credentials from the default keychain.
+func ExtractDirToFS(ctx context.Context, imageRef, dir string) (fs.FS, error) {
+	o := extractor.NewOrchestrator(false)
+	o.SetLogOutput(io.Discard)
+	defer func() { _ = o.Close() }()
+
+	target := newMemTarget()
+	if err := o.ExtractDirTo(ctx, extractor.ExtractOptions{ImageRef: imageRef, FilePath: dir}, target); err != nil {
+		return nil, err
+	}
+	return target.files, nil
+}
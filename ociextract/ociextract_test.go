@@ -0,0 +1,95 @@
+package ociextract
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/fs"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// gzipTarLayer builds a gzipped tar layer containing the given files
+func gzipTarLayer(t *testing.T, files map[string]string) v1.Layer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tarWriter.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write tar content: %v", err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %v", err)
+	}
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create layer: %v", err)
+	}
+	return layer
+}
+
+// TestExtractDirToFS tests that a directory merged from every layer is
+// readable from the returned fs.FS, with each file's source metadata
+func TestExtractDirToFS(t *testing.T) {
+	img, err := mutate.AppendLayers(empty.Image,
+		gzipTarLayer(t, map[string]string{"etc/app/a.conf": "lower", "etc/app/b.conf": "b", "etc/passwd": "root"}),
+		gzipTarLayer(t, map[string]string{"etc/app/a.conf": "upper", "etc/app/.wh.b.conf": ""}),
+	)
+	if err != nil {
+		t.Fatalf("failed to build image: %v", err)
+	}
+	dir := t.TempDir()
+	p, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		t.Fatalf("failed to create layout: %v", err)
+	}
+	if err := p.AppendImage(img); err != nil {
+		t.Fatalf("failed to append image: %v", err)
+	}
+
+	fsys, err := ExtractDirToFS(context.Background(), "oci:"+dir, "/etc/app/")
+	if err != nil {
+		t.Fatalf("ExtractDirToFS() error = %v", err)
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatalf("failed to read root: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "a.conf" {
+		t.Errorf("root lists %v, want only a.conf", entries)
+	}
+
+	data, err := fs.ReadFile(fsys, "a.conf")
+	if err != nil || string(data) != "upper" {
+		t.Errorf("a.conf = %q (%v), want upper layer content", data, err)
+	}
+
+	info, err := fs.Stat(fsys, "a.conf")
+	if err != nil {
+		t.Fatalf("failed to stat a.conf: %v", err)
+	}
+	if md, ok := info.Sys().(Metadata); !ok || md.Path != "etc/app/a.conf" {
+		t.Errorf("a.conf Sys() = %#v, want source metadata", info.Sys())
+	}
+}
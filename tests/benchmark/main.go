@@ -2,46 +2,90 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
-)
 
-const (
-	defaultRegistry = "ghcr.io"
-	defaultOwner    = "amartani"
-	defaultImageTag = "latest"
+	"github.com/amartani/oci-extract/tests/testenv"
 )
 
 var (
-	runs     int
-	registry string
-	owner    string
-	imageTag string
-	verbose  bool
+	runs    int
+	env     testenv.Config
+	verbose bool
+	jsonOut bool
+
+	// clearCache is a shell command run before each cold oci-extract run,
+	// e.g. to drop the OS page cache
+	clearCache string
+
+	// progress receives the lines reporting each benchmark as it runs. With
+	// --json it's stderr, leaving stdout to the results.
+	progress io.Writer = os.Stdout
 )
 
+// testCase is a file to extract from one of the test images
+type testCase struct {
+	method   string
+	format   string
+	imageTag string
+	file     string
+	desc     string
+	args     []string // Extra oci-extract flags
+}
+
 type benchmarkResult struct {
 	method   string
 	format   string
 	file     string
+	cache    string // "cold" or "warm" for oci-extract, empty for docker
 	duration time.Duration
 	err      error
 }
 
+// MarshalJSON implements json.Marshaler, as the fields are unexported
+func (r benchmarkResult) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Method          string  `json:"method"`
+		Format          string  `json:"format"`
+		File            string  `json:"file"`
+		Cache           string  `json:"cache,omitempty"`
+		DurationSeconds float64 `json:"duration_seconds"`
+		Error           string  `json:"error,omitempty"`
+	}{
+		Method:          r.method,
+		Format:          r.format,
+		File:            r.file,
+		Cache:           r.cache,
+		DurationSeconds: r.duration.Seconds(),
+	}
+	if r.err != nil {
+		out.Error = r.err.Error()
+	}
+	return json.Marshal(out)
+}
+
 func main() {
 	flag.IntVar(&runs, "runs", 1, "Number of times to run each benchmark")
-	flag.StringVar(&registry, "registry", defaultRegistry, "Container registry")
-	flag.StringVar(&owner, "owner", defaultOwner, "Repository owner")
-	flag.StringVar(&imageTag, "tag", defaultImageTag, "Image tag")
+	// Flags default to the environment the integration tests read
+	env = testenv.FromEnv()
+	flag.StringVar(&env.Registry, "registry", env.Registry, "Container registry")
+	flag.StringVar(&env.Owner, "owner", env.Owner, "Repository owner")
+	flag.StringVar(&env.ImageTag, "tag", env.ImageTag, "Image tag")
 	flag.BoolVar(&verbose, "verbose", false, "Verbose output")
+	flag.BoolVar(&jsonOut, "json", false, "Print results as JSON instead of a summary table")
+	flag.StringVar(&clearCache, "clear-cache", "", "Shell command to run before each cold oci-extract run, e.g. 'sync && echo 3 | sudo tee /proc/sys/vm/drop_caches'")
 	flag.Parse()
 
-	imageBase := fmt.Sprintf("%s/%s/oci-extract-test", registry, owner)
+	if jsonOut {
+		progress = os.Stderr
+	}
 
 	// Find oci-extract binary
 	binaryPath := findBinary()
@@ -51,20 +95,14 @@ func main() {
 	}
 
 	if verbose {
-		fmt.Printf("Using oci-extract binary: %s\n", binaryPath)
-		fmt.Printf("Test image base: %s\n", imageBase)
-		fmt.Printf("Test image tag: %s\n", imageTag)
-		fmt.Printf("Runs per test: %d\n\n", runs)
+		fmt.Fprintf(progress, "Using oci-extract binary: %s\n", binaryPath)
+		fmt.Fprintf(progress, "Test image base: %s\n", env.Base())
+		fmt.Fprintf(progress, "Test image tag: %s\n", env.ImageTag)
+		fmt.Fprintf(progress, "Runs per test: %d\n\n", runs)
 	}
 
 	// Define test cases
-	testCases := []struct {
-		method   string
-		format   string
-		imageTag string
-		file     string
-		desc     string
-	}{
+	testCases := []testCase{
 		// Small file tests
 		{
 			method:   "docker",
@@ -87,6 +125,14 @@ func main() {
 			file:     "/testdata/small.txt",
 			desc:     "Small file (eStargz format)",
 		},
+		{
+			method:   "oci-extract",
+			format:   "estargz-full",
+			imageTag: "estargz",
+			file:     "/testdata/small.txt",
+			desc:     "Small file (eStargz layer downloaded in full)",
+			args:     []string{"--small-layer-threshold", "1MiB"},
+		},
 		{
 			method:   "oci-extract",
 			format:   "soci",
@@ -153,17 +199,27 @@ func main() {
 		},
 	}
 
-	fmt.Println("Running Extraction Performance Benchmark")
-	fmt.Println(strings.Repeat("=", 80))
-	fmt.Println()
+	fmt.Fprintln(progress, "Running Extraction Performance Benchmark")
+	fmt.Fprintln(progress, strings.Repeat("=", 80))
+	fmt.Fprintln(progress)
 
 	// Check if docker is available
 	dockerAvailable := checkDocker()
 	if !dockerAvailable {
-		fmt.Println("Warning: docker not found, skipping docker pull benchmarks")
-		fmt.Println()
+		fmt.Fprintln(progress, "Warning: docker not found, skipping docker pull benchmarks")
+		fmt.Fprintln(progress)
 	}
 
+	// oci-extract keeps layers it downloads in full here. Each case runs
+	// cold, with the directory emptied and --clear-cache run first, then
+	// warm, reading what the cold runs left behind.
+	cacheDir, err := os.MkdirTemp("", "oci-extract-bench-cache-*")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to create cache dir: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() { _ = os.RemoveAll(cacheDir) }()
+
 	var results []benchmarkResult
 
 	for _, tc := range testCases {
@@ -171,75 +227,144 @@ func main() {
 			continue
 		}
 
-		image := fmt.Sprintf("%s:%s", imageBase, tc.imageTag)
+		caches := []string{""}
+		if tc.method == "oci-extract" {
+			caches = []string{"cold", "warm"}
+		}
+		for _, cache := range caches {
+			results = append(results, runCase(binaryPath, cacheDir, tc, cache))
+		}
+	}
 
-		if verbose {
-			fmt.Printf("Running: %s\n", tc.desc)
-			fmt.Printf("  Image: %s\n", image)
-			fmt.Printf("  File: %s\n", tc.file)
-		} else {
-			fmt.Printf("%-50s ", tc.desc+"...")
+	if jsonOut {
+		if err := printJSON(os.Stdout, results, runs); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to write results: %v\n", err)
+			os.Exit(1)
 		}
+		return
+	}
 
-		var totalDuration time.Duration
-		var lastErr error
+	// Print summary
+	fmt.Println()
+	printSummary(results, runs)
+}
 
-		for i := 0; i < runs; i++ {
-			if verbose && runs > 1 {
-				fmt.Printf("  Run %d/%d...\n", i+1, runs)
-			}
+// runCase runs one benchmark case runs times and returns its average time.
+// cache is "cold" or "warm" for oci-extract, whose layer cache is cacheDir.
+func runCase(binaryPath, cacheDir string, tc testCase, cache string) benchmarkResult {
+	image := env.Image(tc.imageTag)
+	desc, args := tc.desc, tc.args
+	if cache != "" {
+		desc += " [" + cache + "]"
+		args = append([]string{"--keep-layer", cacheDir}, args...)
+	}
 
-			var duration time.Duration
-			var err error
+	if verbose {
+		fmt.Fprintf(progress, "Running: %s\n", desc)
+		fmt.Fprintf(progress, "  Image: %s\n", image)
+		fmt.Fprintf(progress, "  File: %s\n", tc.file)
+	} else {
+		fmt.Fprintf(progress, "%-60s ", desc+"...")
+	}
 
-			if tc.method == "docker" {
-				duration, err = benchmarkDocker(image, tc.file)
-			} else {
-				duration, err = benchmarkOCIExtract(binaryPath, image, tc.file)
-			}
+	var totalDuration time.Duration
+	var lastErr error
 
-			if err != nil {
+	for i := 0; i < runs; i++ {
+		if verbose && runs > 1 {
+			fmt.Fprintf(progress, "  Run %d/%d...\n", i+1, runs)
+		}
+
+		if cache == "cold" {
+			if err := clearCaches(cacheDir); err != nil {
 				lastErr = err
-				if verbose {
-					fmt.Printf("  Error: %v\n", err)
-				}
 				break
 			}
+		}
+
+		var duration time.Duration
+		var err error
 
-			totalDuration += duration
+		if tc.method == "docker" {
+			duration, err = benchmarkDocker(image, tc.file)
+		} else {
+			duration, err = benchmarkOCIExtract(binaryPath, image, tc.file, args...)
+		}
 
+		if err != nil {
+			lastErr = err
 			if verbose {
-				fmt.Printf("  Time: %v\n", duration)
+				fmt.Fprintf(progress, "  Error: %v\n", err)
 			}
+			break
 		}
 
-		avgDuration := totalDuration
-		if runs > 1 && lastErr == nil {
-			avgDuration = totalDuration / time.Duration(runs)
+		totalDuration += duration
+
+		if verbose {
+			fmt.Fprintf(progress, "  Time: %v\n", duration)
 		}
+	}
 
-		results = append(results, benchmarkResult{
-			method:   tc.method,
-			format:   tc.format,
-			file:     tc.file,
-			duration: avgDuration,
-			err:      lastErr,
-		})
-
-		if !verbose {
-			if lastErr != nil {
-				fmt.Printf("FAILED: %v\n", lastErr)
-			} else {
-				fmt.Printf("%.3fs\n", avgDuration.Seconds())
-			}
+	avgDuration := totalDuration
+	if runs > 1 && lastErr == nil {
+		avgDuration = totalDuration / time.Duration(runs)
+	}
+
+	if !verbose {
+		if lastErr != nil {
+			fmt.Fprintf(progress, "FAILED: %v\n", lastErr)
 		} else {
-			fmt.Println()
+			fmt.Fprintf(progress, "%.3fs\n", avgDuration.Seconds())
 		}
+	} else {
+		fmt.Fprintln(progress)
 	}
 
-	// Print summary
-	fmt.Println()
-	printSummary(results, runs)
+	return benchmarkResult{
+		method:   tc.method,
+		format:   tc.format,
+		file:     tc.file,
+		cache:    cache,
+		duration: avgDuration,
+		err:      lastErr,
+	}
+}
+
+// clearCaches empties the layer cache directory and runs the --clear-cache
+// command, if any, before a cold run
+func clearCaches(cacheDir string) error {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return fmt.Errorf("failed to read cache dir: %w", err)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(cacheDir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to clear cache dir: %w", err)
+		}
+	}
+
+	if clearCache == "" {
+		return nil
+	}
+	cmd := exec.Command("sh", "-c", clearCache)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("--clear-cache command failed: %w\nStderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// printJSON writes the results, with the number of runs each duration is
+// averaged over, as a JSON document
+func printJSON(w io.Writer, results []benchmarkResult, runs int) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Runs    int               `json:"runs"`
+		Results []benchmarkResult `json:"results"`
+	}{Runs: runs, Results: results})
 }
 
 func findBinary() string {
@@ -268,7 +393,7 @@ func checkDocker() bool {
 	return err == nil
 }
 
-func benchmarkOCIExtract(binaryPath, image, filePath string) (time.Duration, error) {
+func benchmarkOCIExtract(binaryPath, image, filePath string, args ...string) (time.Duration, error) {
 	tmpDir, err := os.MkdirTemp("", "oci-extract-bench-*")
 	if err != nil {
 		return 0, fmt.Errorf("failed to create temp dir: %w", err)
@@ -278,7 +403,7 @@ func benchmarkOCIExtract(binaryPath, image, filePath string) (time.Duration, err
 	outputPath := filepath.Join(tmpDir, filepath.Base(filePath))
 
 	start := time.Now()
-	cmd := exec.Command(binaryPath, "extract", image, filePath, "-o", outputPath)
+	cmd := exec.Command(binaryPath, append([]string{"extract", image, filePath, "-o", outputPath}, args...)...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
@@ -385,7 +510,7 @@ func printSummary(results []benchmarkResult, runs int) {
 		fmt.Println(strings.Repeat("-", 80))
 
 		// Print header
-		fmt.Printf("%-20s %-15s %-15s\n", "Method", "Format", "Time")
+		fmt.Printf("%-20s %-17s %-6s %-15s\n", "Method", "Format", "Cache", "Time")
 		fmt.Println(strings.Repeat("-", 80))
 
 		// Find docker baseline time
@@ -418,7 +543,7 @@ func printSummary(results []benchmarkResult, runs int) {
 				}
 			}
 
-			fmt.Printf("%-20s %-15s %-15s%s\n", method, r.format, timeStr, speedup)
+			fmt.Printf("%-20s %-17s %-6s %-15s%s\n", method, r.format, r.cache, timeStr, speedup)
 		}
 
 		fmt.Println()
@@ -445,8 +570,9 @@ func printSummary(results []benchmarkResult, runs int) {
 		var dockerTime, standardTime, estargzTime, sociTime, zstdTime, zstdChunkedTime time.Duration
 		dockerOk, standardOk, estargzOk, sociOk, zstdOk, zstdChunkedOk := false, false, false, false, false, false
 
+		// Compared cold, like docker pull after the image is removed
 		for _, r := range group {
-			if r.err != nil {
+			if r.err != nil || r.cache == "warm" {
 				continue
 			}
 			if r.method == "docker" {
@@ -525,4 +651,33 @@ func printSummary(results []benchmarkResult, runs int) {
 
 		fmt.Println()
 	}
+
+	// Print how much the layer and OS page caches save
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Println("CACHE COMPARISON")
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Println()
+
+	for _, file := range files {
+		cold := make(map[string]benchmarkResult)
+		for _, r := range fileGroups[file] {
+			if r.cache == "cold" && r.err == nil {
+				cold[r.format] = r
+			}
+		}
+		if len(cold) == 0 {
+			continue
+		}
+
+		fmt.Printf("%s:\n", file)
+		for _, r := range fileGroups[file] {
+			c, ok := cold[r.format]
+			if r.cache != "warm" || r.err != nil || !ok {
+				continue
+			}
+			fmt.Printf("  %-17s cold %.3fs, warm %.3fs (%.2fx faster warm)\n",
+				r.format+":", c.duration.Seconds(), r.duration.Seconds(), float64(c.duration)/float64(r.duration))
+		}
+		fmt.Println()
+	}
 }
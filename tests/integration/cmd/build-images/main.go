@@ -10,30 +10,19 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-)
 
-const (
-	defaultRegistry = "ghcr.io"
-	defaultOwner    = "amartani"
-	defaultImageTag = "latest"
+	"github.com/amartani/oci-extract/tests/testenv"
 )
 
-var (
-	registry  string
-	imageBase string
-	imageTag  string
-)
+var env testenv.Config
 
 func main() {
 	// Get configuration from environment
-	registry = getEnv("REGISTRY", defaultRegistry)
-	owner := getEnv("GITHUB_REPOSITORY_OWNER", defaultOwner)
-	imageBase = getEnv("TEST_IMAGE_BASE", fmt.Sprintf("%s/%s/oci-extract-test", registry, owner))
-	imageTag = getEnv("TEST_IMAGE_TAG", defaultImageTag)
+	env = testenv.FromEnv()
 
-	fmt.Printf("Registry: %s\n", registry)
-	fmt.Printf("Image base: %s\n", imageBase)
-	fmt.Printf("Image tag: %s\n", imageTag)
+	fmt.Printf("Registry: %s\n", env.Registry)
+	fmt.Printf("Image base: %s\n", env.Base())
+	fmt.Printf("Image tag: %s\n", env.ImageTag)
 
 	// Generate test data
 	if err := generateTestData(); err != nil {
@@ -74,14 +63,6 @@ func main() {
 	fmt.Println("\n✅ All test images built and pushed successfully!")
 }
 
-// getEnv gets an environment variable or returns a default value
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
 // runCommand prints and executes a command
 func runCommand(name string, args ...string) error {
 	fmt.Printf("$ %s %s\n", name, strings.Join(args, " "))
@@ -124,16 +105,16 @@ func buildTestImages() error {
 			name:    "base",
 			context: "test-images/base",
 			tags: []string{
-				fmt.Sprintf("%s:standard", imageBase),
-				fmt.Sprintf("%s:standard-%s", imageBase, imageTag),
+				env.Image("standard"),
+				env.Image("standard-" + env.ImageTag),
 			},
 		},
 		{
 			name:    "multilayer",
 			context: "test-images/multilayer",
 			tags: []string{
-				fmt.Sprintf("%s:multilayer-standard", imageBase),
-				fmt.Sprintf("%s:multilayer-standard-%s", imageBase, imageTag),
+				env.Image("multilayer-standard"),
+				env.Image("multilayer-standard-" + env.ImageTag),
 			},
 		},
 	}
@@ -198,12 +179,12 @@ func convertToEstargz() error {
 		target string
 	}{
 		{
-			source: fmt.Sprintf("%s:standard", imageBase),
-			target: fmt.Sprintf("%s:estargz", imageBase),
+			source: env.Image("standard"),
+			target: env.Image("estargz"),
 		},
 		{
-			source: fmt.Sprintf("%s:multilayer-standard", imageBase),
-			target: fmt.Sprintf("%s:multilayer-estargz", imageBase),
+			source: env.Image("multilayer-standard"),
+			target: env.Image("multilayer-estargz"),
 		},
 	}
 
@@ -230,7 +211,7 @@ func convertToEstargz() error {
 		}
 
 		// Also tag with image tag
-		targetWithTag := fmt.Sprintf("%s-%s", img.target, imageTag)
+		targetWithTag := fmt.Sprintf("%s-%s", img.target, env.ImageTag)
 		if err := runCommand("sudo", nerdctlPath, "tag", img.target, targetWithTag); err != nil {
 			return fmt.Errorf("failed to tag %s: %w", img.target, err)
 		}
@@ -265,8 +246,8 @@ func createSociIndices() error {
 	fmt.Printf("Using soci: %s\n", sociPath)
 
 	images := []string{
-		fmt.Sprintf("%s:standard", imageBase),
-		fmt.Sprintf("%s:multilayer-standard", imageBase),
+		env.Image("standard"),
+		env.Image("multilayer-standard"),
 	}
 
 	for _, img := range images {
@@ -312,12 +293,12 @@ func convertToZstd() error {
 		target string
 	}{
 		{
-			source: fmt.Sprintf("%s:standard", imageBase),
-			target: fmt.Sprintf("%s:zstd", imageBase),
+			source: env.Image("standard"),
+			target: env.Image("zstd"),
 		},
 		{
-			source: fmt.Sprintf("%s:multilayer-standard", imageBase),
-			target: fmt.Sprintf("%s:multilayer-zstd", imageBase),
+			source: env.Image("multilayer-standard"),
+			target: env.Image("multilayer-zstd"),
 		},
 	}
 
@@ -344,7 +325,7 @@ func convertToZstd() error {
 		}
 
 		// Also tag with image tag
-		targetWithTag := fmt.Sprintf("%s-%s", img.target, imageTag)
+		targetWithTag := fmt.Sprintf("%s-%s", img.target, env.ImageTag)
 		if err := runCommand("sudo", nerdctlPath, "tag", img.target, targetWithTag); err != nil {
 			return fmt.Errorf("failed to tag %s: %w", img.target, err)
 		}
@@ -383,12 +364,12 @@ func convertToZstdChunked() error {
 		target string
 	}{
 		{
-			source: fmt.Sprintf("%s:standard", imageBase),
-			target: fmt.Sprintf("%s:zstd-chunked", imageBase),
+			source: env.Image("standard"),
+			target: env.Image("zstd-chunked"),
 		},
 		{
-			source: fmt.Sprintf("%s:multilayer-standard", imageBase),
-			target: fmt.Sprintf("%s:multilayer-zstd-chunked", imageBase),
+			source: env.Image("multilayer-standard"),
+			target: env.Image("multilayer-zstd-chunked"),
 		},
 	}
 
@@ -415,7 +396,7 @@ func convertToZstdChunked() error {
 		}
 
 		// Also tag with image tag
-		targetWithTag := fmt.Sprintf("%s-%s", img.target, imageTag)
+		targetWithTag := fmt.Sprintf("%s-%s", img.target, env.ImageTag)
 		if err := runCommand("sudo", nerdctlPath, "tag", img.target, targetWithTag); err != nil {
 			return fmt.Errorf("failed to tag %s: %w", img.target, err)
 		}
@@ -6,6 +6,7 @@ package integration
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -14,18 +15,12 @@ import (
 	"strings"
 	"testing"
 	"time"
-)
 
-const (
-	defaultRegistry = "ghcr.io"
-	defaultOwner    = "amartani"
-	defaultImageTag = "latest"
+	"github.com/amartani/oci-extract/tests/testenv"
 )
 
 var (
-	registry   string
-	imageBase  string
-	imageTag   string
+	env        testenv.Config
 	binaryPath string
 	binaryFlag = flag.String("binary", "", "Path to oci-extract binary (auto-detected if not specified)")
 )
@@ -36,10 +31,7 @@ func TestMain(m *testing.M) {
 	flag.Parse()
 
 	// Get configuration from environment
-	registry = getEnv("REGISTRY", defaultRegistry)
-	owner := getEnv("GITHUB_REPOSITORY_OWNER", defaultOwner)
-	imageBase = getEnv("TEST_IMAGE_BASE", fmt.Sprintf("%s/%s/oci-extract-test", registry, owner))
-	imageTag = getEnv("TEST_IMAGE_TAG", defaultImageTag)
+	env = testenv.FromEnv()
 
 	// Get oci-extract binary path (from flag or auto-detect)
 	if *binaryFlag != "" {
@@ -54,8 +46,8 @@ func TestMain(m *testing.M) {
 	}
 
 	fmt.Printf("Using oci-extract binary: %s\n", binaryPath)
-	fmt.Printf("Test image base: %s\n", imageBase)
-	fmt.Printf("Test image tag: %s\n", imageTag)
+	fmt.Printf("Test image base: %s\n", env.Base())
+	fmt.Printf("Test image tag: %s\n", env.ImageTag)
 	fmt.Println("Note: Tests assume prebuilt images exist in the registry.")
 	fmt.Println("      To build images, run: go run ./tests/integration/cmd/build-images")
 
@@ -65,14 +57,6 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
-// getEnv gets an environment variable or returns a default value
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
 // findBinary locates the oci-extract binary
 func findBinary() string {
 	// Binary name with platform-specific extension
@@ -142,7 +126,7 @@ func TestExtractSmallFile(t *testing.T) {
 			if format == "soci" {
 				imageFormat = "standard"
 			}
-			image := fmt.Sprintf("%s:%s", imageBase, imageFormat)
+			image := env.Image(imageFormat)
 
 			content, err := extractFile(t, image, "/testdata/small.txt")
 			if err != nil {
@@ -168,7 +152,7 @@ func TestExtractNestedFile(t *testing.T) {
 			if format == "soci" {
 				imageFormat = "standard"
 			}
-			image := fmt.Sprintf("%s:%s", imageBase, imageFormat)
+			image := env.Image(imageFormat)
 
 			content, err := extractFile(t, image, "/testdata/nested/deep/file.txt")
 			if err != nil {
@@ -194,7 +178,7 @@ func TestExtractJSONFile(t *testing.T) {
 			if format == "soci" {
 				imageFormat = "standard"
 			}
-			image := fmt.Sprintf("%s:%s", imageBase, imageFormat)
+			image := env.Image(imageFormat)
 
 			content, err := extractFile(t, image, "/testdata/medium.json")
 			if err != nil {
@@ -234,7 +218,7 @@ func TestExtractLargeFile(t *testing.T) {
 			if format == "soci" {
 				imageFormat = "standard"
 			}
-			image := fmt.Sprintf("%s:%s", imageBase, imageFormat)
+			image := env.Image(imageFormat)
 
 			content, err := extractFile(t, image, "/testdata/large.bin")
 			if err != nil {
@@ -269,7 +253,7 @@ func TestExtractMultiLayer(t *testing.T) {
 			if format == "multilayer-soci" {
 				imageFormat = "multilayer-standard"
 			}
-			image := fmt.Sprintf("%s:%s", imageBase, imageFormat)
+			image := env.Image(imageFormat)
 
 			// Test file from layer 1
 			content, err := extractFile(t, image, "/layer1/file.txt")
@@ -303,7 +287,7 @@ func TestExtractMultiLayer(t *testing.T) {
 
 // TestExtractNonExistentFile tests error handling for missing files
 func TestExtractNonExistentFile(t *testing.T) {
-	image := fmt.Sprintf("%s:standard", imageBase)
+	image := env.Image("standard")
 
 	outputPath := filepath.Join(t.TempDir(), "nonexistent.txt")
 
@@ -311,13 +295,19 @@ func TestExtractNonExistentFile(t *testing.T) {
 	err := cmd.Run()
 
 	if err == nil {
-		t.Error("Expected error for non-existent file, but extraction succeeded")
+		t.Fatal("Expected error for non-existent file, but extraction succeeded")
+	}
+
+	// A missing file has its own exit code, distinct from fetch/auth failures
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 2 {
+		t.Errorf("Expected exit code 2 for non-existent file, got: %v", err)
 	}
 }
 
 // TestExtractWithVerbose tests verbose output
 func TestExtractWithVerbose(t *testing.T) {
-	image := fmt.Sprintf("%s:standard", imageBase)
+	image := env.Image("standard")
 	outputPath := filepath.Join(t.TempDir(), "small.txt")
 
 	cmd := exec.Command(binaryPath, "extract", image, "/testdata/small.txt", "-o", outputPath, "--verbose")
@@ -342,7 +332,7 @@ func TestExtractWithVerbose(t *testing.T) {
 
 // BenchmarkExtractSmallFile benchmarks small file extraction
 func BenchmarkExtractSmallFile(b *testing.B) {
-	image := fmt.Sprintf("%s:estargz", imageBase)
+	image := env.Image("estargz")
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -357,7 +347,7 @@ func BenchmarkExtractSmallFile(b *testing.B) {
 
 // BenchmarkExtractLargeFile benchmarks large file extraction
 func BenchmarkExtractLargeFile(b *testing.B) {
-	image := fmt.Sprintf("%s:estargz", imageBase)
+	image := env.Image("estargz")
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -382,7 +372,7 @@ func TestPerformanceComparison(t *testing.T) {
 	results := make(map[string]time.Duration)
 
 	for _, format := range formats {
-		image := fmt.Sprintf("%s:%s", imageBase, format)
+		image := env.Image(format)
 
 		start := time.Now()
 		_, err := extractFile(t, image, filePath)
@@ -426,19 +416,19 @@ func TestExtractWithSOCIIndex(t *testing.T) {
 	}{
 		{
 			name:     "small_file_with_soci",
-			image:    fmt.Sprintf("%s:standard", imageBase),
+			image:    env.Image("standard"),
 			filePath: "/testdata/small.txt",
 			expected: "Hello from OCI-Extract integration test!",
 		},
 		{
 			name:     "nested_file_with_soci",
-			image:    fmt.Sprintf("%s:standard", imageBase),
+			image:    env.Image("standard"),
 			filePath: "/testdata/nested/deep/file.txt",
 			expected: "Nested file test - testing deep path extraction",
 		},
 		{
 			name:     "multilayer_with_soci",
-			image:    fmt.Sprintf("%s:multilayer-standard", imageBase),
+			image:    env.Image("multilayer-standard"),
 			filePath: "/layer1/file.txt",
 			expected: "Layer 1 content",
 		},
@@ -466,7 +456,7 @@ func TestSOCIIndexDetection(t *testing.T) {
 		t.Skip("Skipping SOCI detection test in short mode")
 	}
 
-	image := fmt.Sprintf("%s:standard", imageBase)
+	image := env.Image("standard")
 	outputPath := filepath.Join(t.TempDir(), "test.txt")
 
 	// Run with verbose to see if SOCI index is detected
@@ -504,7 +494,7 @@ func TestListFiles(t *testing.T) {
 
 	for _, format := range formats {
 		t.Run(format, func(t *testing.T) {
-			image := fmt.Sprintf("%s:%s", imageBase, format)
+			image := env.Image(format)
 
 			cmd := exec.Command(binaryPath, "list", image)
 			var stdout, stderr bytes.Buffer
@@ -536,7 +526,7 @@ func TestListFiles(t *testing.T) {
 
 // TestListFilesVerbose tests the list command with verbose output
 func TestListFilesVerbose(t *testing.T) {
-	image := fmt.Sprintf("%s:standard", imageBase)
+	image := env.Image("standard")
 
 	cmd := exec.Command(binaryPath, "list", image, "--verbose")
 	var stdout, stderr bytes.Buffer
@@ -560,7 +550,7 @@ func TestListFilesVerbose(t *testing.T) {
 
 // TestListMultiLayer tests listing files from multi-layer images
 func TestListMultiLayer(t *testing.T) {
-	image := fmt.Sprintf("%s:multilayer-standard", imageBase)
+	image := env.Image("multilayer-standard")
 
 	cmd := exec.Command(binaryPath, "list", image)
 	var stdout, stderr bytes.Buffer
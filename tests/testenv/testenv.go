@@ -0,0 +1,69 @@
+// Package testenv resolves which registry images the integration tests, the
+// tool building their images and the benchmark use, so that a fork only
+// needs to set environment variables to run them against its own registry.
+//
+// The variables are:
+//   - REGISTRY: container registry (default: ghcr.io)
+//   - GITHUB_REPOSITORY_OWNER: owner of the images (default: amartani)
+//   - TEST_IMAGE_BASE: full image name, overriding the two above
+//     (default: <registry>/<owner>/oci-extract-test)
+//   - TEST_IMAGE_TAG: tag of the build to use (default: latest)
+package testenv
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	DefaultRegistry = "ghcr.io"
+	DefaultOwner    = "amartani"
+	DefaultImageTag = "latest"
+)
+
+// Config locates the test images
+type Config struct {
+	Registry string
+	Owner    string
+
+	// ImageBase is the image name without a tag. When empty, it's the
+	// oci-extract-test repository of Owner in Registry.
+	ImageBase string
+
+	// ImageTag identifies a build of the images, appended to the tags
+	// of the images build-images pushes
+	ImageTag string
+}
+
+// FromEnv reads the configuration from the environment, using the defaults
+// for variables that aren't set
+func FromEnv() Config {
+	return Config{
+		Registry:  getEnv("REGISTRY", DefaultRegistry),
+		Owner:     getEnv("GITHUB_REPOSITORY_OWNER", DefaultOwner),
+		ImageBase: os.Getenv("TEST_IMAGE_BASE"),
+		ImageTag:  getEnv("TEST_IMAGE_TAG", DefaultImageTag),
+	}
+}
+
+// Base returns the image name without a tag
+func (c Config) Base() string {
+	if c.ImageBase != "" {
+		return c.ImageBase
+	}
+	return fmt.Sprintf("%s/%s/oci-extract-test", c.Registry, c.Owner)
+}
+
+// Image returns the reference of a test image variant, e.g. "estargz" or
+// "multilayer-standard"
+func (c Config) Image(variant string) string {
+	return fmt.Sprintf("%s:%s", c.Base(), variant)
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}